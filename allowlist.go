@@ -0,0 +1,106 @@
+package milter
+
+import (
+	"strings"
+	"sync"
+)
+
+// AllowList is a [Middleware] that accepts messages of allowed envelope senders at [Milter.MailFrom].
+// Like with [DomainAllowList] the wrapped [Milter] does not get the MailFrom call (and all later calls of this message)
+// for an allowed sender, the MTA gets a [RespAccept] response instead.
+// Use [NewAllowListMiddleware] to create it and share one AllowList between all connections.
+//
+// A sender is allowed when its address is in the email list or its domain is in the domain list.
+// A domain entry of the form *.example.com allows all subdomains of example.com (but not example.com itself).
+// Addresses and domains get compared case-insensitive, domains also without a trailing dot.
+// The null sender <> is never allowed.
+//
+// You can change the lists with [AllowList.Reload] while the server is running.
+type AllowList struct {
+	mu        sync.RWMutex
+	emails    map[string]struct{}
+	domains   map[string]struct{}
+	wildcards map[string]struct{}
+}
+
+// NewAllowListMiddleware creates a new [AllowList] of the sender addresses emails and the sender domains domains.
+func NewAllowListMiddleware(emails, domains []string) *AllowList {
+	a := &AllowList{}
+	a.Reload(emails, domains)
+	return a
+}
+
+// Reload replaces the allow lists of a with emails and domains. Running transactions are not affected,
+// the new lists get used for all following MAIL FROM commands of all connections.
+func (a *AllowList) Reload(emails, domains []string) {
+	e := make(map[string]struct{}, len(emails))
+	for _, email := range emails {
+		if email = normalizeEmail(email); email != "" {
+			e[email] = struct{}{}
+		}
+	}
+	d := make(map[string]struct{}, len(domains))
+	w := make(map[string]struct{})
+	for _, domain := range domains {
+		domain = normalizeHostname(domain)
+		if strings.HasPrefix(domain, "*.") {
+			if domain = domain[2:]; domain != "" {
+				w[domain] = struct{}{}
+			}
+		} else if domain != "" {
+			d[domain] = struct{}{}
+		}
+	}
+	a.mu.Lock()
+	a.emails, a.domains, a.wildcards = e, d, w
+	a.mu.Unlock()
+}
+
+// normalizeEmail returns the lower-cased address email without angle brackets and white space
+func normalizeEmail(email string) string {
+	return strings.ToLower(RemoveAngle(strings.TrimSpace(email)))
+}
+
+// Allowed returns true when the envelope sender from is on the allow list.
+func (a *AllowList) Allowed(from string) bool {
+	from = normalizeEmail(from)
+	at := strings.LastIndexByte(from, '@')
+	if at < 0 {
+		return false
+	}
+	domain := normalizeHostname(from[at+1:])
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if _, ok := a.emails[from]; ok {
+		return true
+	}
+	if _, ok := a.domains[domain]; ok {
+		return true
+	}
+	for dot := strings.IndexByte(domain, '.'); dot >= 0; dot = strings.IndexByte(domain, '.') {
+		domain = domain[dot+1:]
+		if _, ok := a.wildcards[domain]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap wraps next so that messages of allowed senders get accepted before next gets called.
+func (a *AllowList) Wrap(next Milter) Milter {
+	return &allowListMilter{Milter: next, list: a}
+}
+
+type allowListMilter struct {
+	Milter
+	list *AllowList
+}
+
+func (l *allowListMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if l.list.Allowed(from) {
+		return RespAccept, nil
+	}
+	return l.Milter.MailFrom(from, esmtpArgs, m)
+}
+
+var _ Middleware = (*AllowList)(nil)
@@ -0,0 +1,103 @@
+package milter
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAllowList_Allowed(t *testing.T) {
+	a := NewAllowListMiddleware(
+		[]string{"Boss@Example.NET", "<ceo@example.org>", ""},
+		[]string{"example.com", "*.Example.DE.", " *.sub.example.org ", "*.", ""},
+	)
+	tests := []struct {
+		from string
+		want bool
+	}{
+		{"user@example.com", true},
+		{"user@EXAMPLE.COM.", true},
+		{"<user@example.com>", true},
+		{"user@mail.example.com", false},
+		{"boss@example.net", true},
+		{"BOSS@EXAMPLE.NET", true},
+		{"other@example.net", false},
+		{"ceo@example.org", true},
+		{"user@mail.example.de", true},
+		{"user@a.b.example.de", true},
+		{"user@example.de", false},
+		{"user@otherexample.de", false},
+		{"user@mail.sub.example.org", true},
+		{"user@sub.example.org", false},
+		{"example.com", false},
+		{"", false},
+		{"<>", false},
+	}
+	for _, tt := range tests {
+		if got := a.Allowed(tt.from); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestAllowList_Reload(t *testing.T) {
+	a := NewAllowListMiddleware([]string{"user@example.com"}, nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.Allowed("user@example.com")
+		}
+	}()
+	a.Reload(nil, []string{"*.example.net"})
+	wg.Wait()
+	if a.Allowed("user@example.com") {
+		t.Error("Allowed(user@example.com) = true after Reload")
+	}
+	if !a.Allowed("user@mail.example.net") {
+		t.Error("Allowed(user@mail.example.net) = false after Reload")
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	t.Parallel()
+	a := NewAllowListMiddleware([]string{"boss@example.net"}, []string{"*.example.com"})
+	tests := []struct {
+		name string
+		from string
+		want ActionType
+	}{
+		{"allowed email", "Boss@example.net", ActionAccept},
+		{"allowed subdomain", "user@mail.example.com", ActionAccept},
+		{"not allowed", "user@example.net", ActionContinue},
+		{"null sender", "", ActionContinue},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mailCalled := false
+			mm := MockMilter{
+				ConnResp: RespContinue,
+				HeloResp: RespContinue,
+				MailResp: RespContinue,
+				MailMod: func(m *Modifier) {
+					mailCalled = true
+				},
+			}
+			w := newServerClient(t, NewMacroBag(), []Option{WithMilter(func() Milter {
+				return a.Wrap(&mm)
+			})}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("localhost")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail(tt.from, "")
+			assertAction(t, act, err, tt.want)
+			if mailCalled != (tt.want == ActionContinue) {
+				t.Errorf("wrapped MailFrom called = %v", mailCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package milter
+
+import "net"
+
+// setListenBacklog is a no-op on systems where we cannot change the backlog of a listener.
+func setListenBacklog(_ net.Listener, _ int) error {
+	return nil
+}
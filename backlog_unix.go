@@ -0,0 +1,28 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package milter
+
+import (
+	"net"
+	"syscall"
+)
+
+// setListenBacklog sets the accept queue length of ln to n.
+// The Go runtime already called listen(2) with its own backlog, calling it again on the same socket updates the backlog.
+func setListenBacklog(ln net.Listener, n int) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err := raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), n)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -24,6 +24,65 @@ const allClientSupportedProtocolMasksV4 = allClientSupportedProtocolMasksV3 | Op
 const AllClientSupportedActionMasks = OptAddHeader | OptChangeBody | OptAddRcpt | OptRemoveRcpt | OptChangeHeader | OptQuarantine | OptChangeFrom | OptAddRcptWithArgs | OptSetMacros
 const allClientSupportedActionMasksV2 = OptAddHeader | OptChangeBody | OptAddRcpt | OptRemoveRcpt | OptChangeHeader | OptQuarantine
 
+// MTAFlavor is an MTA whose milter negotiation the [Client] can impersonate. See [WithMTAFlavor].
+type MTAFlavor int
+
+const (
+	// MTAFlavorPostfix negotiates like Postfix 3: milter protocol version 6, all actions, all protocol options,
+	// 64K data chunks and the default macros of the milter_*_macros settings of Postfix.
+	MTAFlavorPostfix MTAFlavor = iota + 1
+	// MTAFlavorSendmail negotiates like Sendmail 8.14+: milter protocol version 6, all actions, all protocol options,
+	// 1M data chunks and the default Milter.macros.* settings of Sendmail.
+	MTAFlavorSendmail
+)
+
+func (f MTAFlavor) String() string {
+	switch f {
+	case MTAFlavorPostfix:
+		return "postfix"
+	case MTAFlavorSendmail:
+		return "sendmail"
+	default:
+		return "unknown"
+	}
+}
+
+// apply sets the negotiation options of the flavor f in o.
+func (f MTAFlavor) apply(o *options) {
+	o.mtaFlavor = f
+	o.maxVersion = 6
+	o.actions = AllClientSupportedActionMasks
+	o.protocol = allClientSupportedProtocolMasks
+	switch f {
+	case MTAFlavorPostfix:
+		o.offeredMaxData = DataSize64K
+		o.usedMaxData = DataSize64K
+		o.macrosByStage = [][]MacroName{
+			{MacroMTAFQDN, MacroDaemonName, MacroDaemonAddr, MacroMTAVersion, MacroRFC1413AuthInfo},                        // StageConnect
+			{MacroTlsVersion, MacroCipher, MacroCipherBits, MacroCertSubject, MacroCertIssuer},                             // StageHelo
+			{MacroQueueId, MacroAuthType, MacroAuthAuthen, MacroAuthAuthor, MacroMailAddr, MacroMailHost, MacroMailMailer}, // StageMail
+			{MacroRcptAddr, MacroRcptHost, MacroRcptMailer},                                                                // StageRcpt
+			{MacroQueueId}, // StageData
+			{MacroQueueId}, // StageEOM
+			{MacroQueueId}, // StageEOH
+		}
+	case MTAFlavorSendmail:
+		o.offeredMaxData = DataSize1M
+		o.usedMaxData = DataSize1M
+		o.macrosByStage = [][]MacroName{
+			{MacroMTAFQDN, MacroRFC1413AuthInfo, MacroDaemonName, MacroIfName, MacroIfAddr},                                              // StageConnect
+			{MacroTlsVersion, MacroCipher, MacroCipherBits, MacroCertSubject, MacroCertIssuer},                                           // StageHelo
+			{MacroQueueId, MacroAuthType, MacroAuthAuthen, MacroAuthSsf, MacroAuthAuthor, MacroMailMailer, MacroMailHost, MacroMailAddr}, // StageMail
+			{MacroRcptMailer, MacroRcptHost, MacroRcptAddr},                                                                              // StageRcpt
+			{},           // StageData
+			{"{msg_id}"}, // StageEOM
+			{},           // StageEOH
+		}
+	default:
+		panic(fmt.Sprintf("milter: unknown MTA flavor %d", f))
+	}
+}
+
 // Dialer is the interface of the only method we use of a net.Dialer.
 type Dialer interface {
 	Dial(network string, addr string) (net.Conn, error)
@@ -135,6 +194,66 @@ func NewClient(network, address string, opts ...Option) *Client {
 	if options.negotiationCallback != nil {
 		panic("milter: WithNegotiationCallback is a server only option")
 	}
+	if options.negotiationHook != nil {
+		panic("milter: WithNegotiationHook is a server only option")
+	}
+	if options.onNegotiationDowngrade != nil {
+		panic("milter: WithOnNegotiationDowngrade is a server only option")
+	}
+	if options.dryRun {
+		panic("milter: WithDryRun is a server only option")
+	}
+	if options.onConnectionOpen != nil || options.onConnectionClose != nil {
+		panic("milter: WithOnConnectionOpen/WithOnConnectionClose is a server only option")
+	}
+	if options.errorHandler != nil {
+		panic("milter: WithErrorHandler is a server only option")
+	}
+	if options.connectionLogger != nil {
+		panic("milter: WithConnectionLogger is a server only option")
+	}
+	if options.eventHook != nil || options.eventQueueSize != 0 {
+		panic("milter: WithEventHook/WithEventQueueSize is a server only option")
+	}
+	if options.packetLogger != nil {
+		panic("milter: WithPacketLogger is a server only option")
+	}
+	if options.listenBacklog != 0 {
+		panic("milter: WithListenBacklog is a server only option")
+	}
+	if options.readBufferSize != 0 || options.writeBufferSize != 0 {
+		panic("milter: WithReadBufferSize/WithWriteBufferSize is a server only option")
+	}
+	if options.maxAddedRecipients != 0 || options.maxAddedHeaders != 0 {
+		panic("milter: WithMaxAddedRecipients/WithMaxAddedHeaders is a server only option")
+	}
+	if options.maxConnectionMessageBytes != 0 {
+		panic("milter: WithMaxConnectionMessageBytes is a server only option")
+	}
+	if options.maxHeaderValueBytes != 0 {
+		panic("milter: WithMaxHeaderValueBytes is a server only option")
+	}
+	if options.maxMessageHeaderSize != 0 {
+		panic("milter: WithMaxMessageHeaderSize is a server only option")
+	}
+	if options.maxTransactions != 0 {
+		panic("milter: WithMaxTransactionsPerConnection is a server only option")
+	}
+	if options.readDelay != 0 || options.writeDelay != 0 {
+		panic("milter: WithTrafficShaper is a server only option")
+	}
+	if options.unknownCommandResp != nil {
+		panic("milter: WithAcceptUnknownCommands is a server only option")
+	}
+	if options.replaceBodyChunkSize != 0 {
+		panic("milter: WithReplaceBodyChunkSize is a server only option")
+	}
+	if options.shutdownTimeout != 0 {
+		panic("milter: WithShutdownTimeout is a server only option")
+	}
+	if options.timingHeader != "" {
+		panic("milter: WithTimingHeader is a server only option")
+	}
 
 	return &Client{
 		options: options,
@@ -161,13 +280,32 @@ func (c *Client) String() string {
 func (c *Client) Session(macros Macros) (*ClientSession, error) {
 	conn, err := c.options.dialer.Dial(c.network, c.address)
 	if err != nil {
-		return nil, fmt.Errorf("milter: session create: %w", err)
+		return nil, fmt.Errorf("milter: session create: %w", connError(err))
 	}
 
 	return c.session(conn, macros)
 }
 
+// NewClientConn negotiates the milter protocol with the milter on the already established connection conn
+// and returns the resulting [ClientSession].
+// Use this instead of [NewClient] and [Client.Session] when you want to use the milter protocol over a transport
+// that you set up yourself (e.g. an SSH tunnel).
+//
+// The opts are the same as for [NewClient], [WithDialer] does not have an effect.
+// macros has the same meaning as in [Client.Session].
+// When the negotiation fails conn gets closed.
+//
+// This function will panic when you provide invalid options.
+func NewClientConn(conn net.Conn, macros Macros, opts ...Option) (*ClientSession, error) {
+	return NewClient(conn.RemoteAddr().Network(), conn.RemoteAddr().String(), opts...).session(conn, macros)
+}
+
 func (c *Client) session(conn net.Conn, macros Macros) (*ClientSession, error) {
+	return c.sessionWithActions(conn, macros, c.options.actions)
+}
+
+// sessionWithActions is like session but only offers actions to the milter.
+func (c *Client) sessionWithActions(conn net.Conn, macros Macros, actions OptAction) (*ClientSession, error) {
 	s := &ClientSession{
 		readTimeout:    c.options.readTimeout,
 		writeTimeout:   c.options.writeTimeout,
@@ -183,7 +321,7 @@ func (c *Client) session(conn net.Conn, macros Macros) (*ClientSession, error) {
 	s.state = clientStateNegotiated
 
 	s.conn = conn
-	if err := s.negotiate(c.options.maxVersion, c.options.actions, c.options.protocol, c.options.offeredMaxData); err != nil {
+	if err := s.negotiate(c.options.maxVersion, actions, c.options.protocol, c.options.offeredMaxData); err != nil {
 		return nil, err
 	}
 
@@ -223,6 +361,7 @@ type ClientSession struct {
 	negotiatedBodySize uint32
 
 	state       clientSessionState
+	helo        string
 	skip        bool
 	skipUnknown bool
 	closedErr   error
@@ -268,25 +407,25 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 	}
 	msg, err := wire.ReadPacket(s.conn, s.readTimeout)
 	if err != nil {
-		return s.errorOut(fmt.Errorf("milter: negotiate: optneg read: %w", err))
+		return s.errorOut(fmt.Errorf("milter: negotiate: optneg read: %w", connError(err)))
 	}
 	if msg.Code != wire.CodeOptNeg {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unexpected code: %v", rune(msg.Code)))
+		return s.errorOut(errorf(ErrProtocol, "milter: negotiate: unexpected code: %v", rune(msg.Code)))
 	}
 	if len(msg.Data) < 4*3 /* version + action mask + proto mask */ {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unexpected data size: %v", len(msg.Data)))
+		return s.errorOut(errorf(ErrProtocol, "milter: negotiate: unexpected data size: %v", len(msg.Data)))
 	}
 	milterVersion := binary.BigEndian.Uint32(msg.Data[0:])
 
 	if milterVersion < 2 || milterVersion > maximumVersion {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unsupported protocol version: %v", milterVersion))
+		return s.errorOut(errorf(ErrNegotiation, "milter: negotiate: unsupported protocol version: %v", milterVersion))
 	}
 
 	s.version = milterVersion
 
 	milterActionMask := OptAction(binary.BigEndian.Uint32(msg.Data[4:]))
 	if milterActionMask&actionMask != milterActionMask {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unsupported actions requested: MTA %032b filter %032b", actionMask, milterActionMask))
+		return s.errorOut(errorf(ErrNegotiation, "milter: negotiate: unsupported actions requested: MTA %032b filter %032b", actionMask, milterActionMask))
 	}
 	s.actionOpts = milterActionMask
 	milterProtoMask := OptProtocol(binary.BigEndian.Uint32(msg.Data[8:]))
@@ -302,7 +441,7 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 	// mask out the size flags
 	milterProtoMask = milterProtoMask & (^OptProtocol(optInternal))
 	if milterProtoMask&protoMask != milterProtoMask {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unsupported protocol options requested: MTA %032b filter %032b", protoMask, milterProtoMask))
+		return s.errorOut(errorf(ErrNegotiation, "milter: negotiate: unsupported protocol options requested: MTA %032b filter %032b", protoMask, milterProtoMask))
 	}
 
 	// do not send commands that older versions do not understand
@@ -362,9 +501,6 @@ func (s *ClientSession) ActionOption(opt OptAction) bool {
 }
 
 func (s *ClientSession) sendMacros(code wire.Code, names []MacroName) error {
-	if s.macros == nil {
-		return nil
-	}
 	msg := &wire.Message{
 		Code: wire.CodeMacro,
 		Data: []byte{byte(code)},
@@ -372,7 +508,7 @@ func (s *ClientSession) sendMacros(code wire.Code, names []MacroName) error {
 	foundMacro := false
 	for _, name := range names {
 		// only send macros we actually defined
-		if val, ok := s.macros.GetEx(name); ok {
+		if val, ok := s.macroValue(name); ok {
 			foundMacro = true
 			msg.Data = wire.AppendCString(msg.Data, name)
 			msg.Data = wire.AppendCString(msg.Data, val)
@@ -390,6 +526,18 @@ func (s *ClientSession) sendMacros(code wire.Code, names []MacroName) error {
 	return nil
 }
 
+// macroValue returns the value of the macro name. [MacroHelo] is the argument of the last Helo call,
+// all other macros come from the [Macros] of this session.
+func (s *ClientSession) macroValue(name MacroName) (string, bool) {
+	if name == MacroHelo && s.state >= clientStateHeloCalled && s.state != clientStateError {
+		return s.helo, true
+	}
+	if s.macros == nil {
+		return "", false
+	}
+	return s.macros.GetEx(name)
+}
+
 func (s *ClientSession) sendCmdMacros(code wire.Code, macros map[MacroName]string) error {
 	if len(macros) == 0 {
 		return nil
@@ -415,7 +563,7 @@ func (s *ClientSession) readAction(skipOk bool) (*Action, error) {
 	for {
 		msg, err := wire.ReadPacket(s.conn, s.readTimeout)
 		if err != nil {
-			return nil, s.errorOut(fmt.Errorf("action read: %w", err))
+			return nil, s.errorOut(fmt.Errorf("action read: %w", connError(err)))
 		}
 		if wire.ActionCode(msg.Code) == wire.ActProgress /* progress */ {
 			continue
@@ -428,7 +576,7 @@ func (s *ClientSession) readAction(skipOk bool) (*Action, error) {
 		switch act.Type {
 		case ActionSkip:
 			if !skipOk {
-				return nil, fmt.Errorf("action read: unexpected skip message received (can only be received after SMFIC_RCPT, SMFIC_HEADER, SMFIC_BODY when SMFIP_SKIP was negotiated)")
+				return nil, errorf(ErrProtocol, "action read: unexpected skip message received (can only be received after SMFIC_RCPT, SMFIC_HEADER, SMFIC_BODY when SMFIP_SKIP was negotiated)")
 			}
 		case ActionReject:
 			act.SMTPCode = 550
@@ -443,7 +591,7 @@ func (s *ClientSession) readAction(skipOk bool) (*Action, error) {
 }
 
 func (s *ClientSession) writePacket(msg *wire.Message) error {
-	return wire.WritePacket(s.conn, msg, s.writeTimeout)
+	return connError(wire.WritePacket(s.conn, msg, s.writeTimeout))
 }
 
 // Conn sends the connection information to the milter.
@@ -505,6 +653,10 @@ func (s *ClientSession) Conn(hostname string, family ProtoFamily, port uint16, a
 
 // Helo sends the HELO hostname to the milter.
 //
+// The milter sees helo as the HELO/EHLO hostname of the SMTP client, so a proxying milter can forward a
+// normalized hostname instead of the one it received. When you requested [MacroHelo] with [WithMacroRequest]
+// helo also gets sent as the value of that macro (for the [StageHelo] and all later stages).
+//
 // It should be called once per milter session (from Client.Session to Close).
 func (s *ClientSession) Helo(helo string) (*Action, error) {
 	if s.state != clientStateConnectCalled && s.state != clientStateHeloCalled {
@@ -513,6 +665,7 @@ func (s *ClientSession) Helo(helo string) (*Action, error) {
 
 	s.skip = false
 	s.state = clientStateHeloCalled
+	s.helo = helo
 
 	if len(s.macrosByStages) > int(StageHelo) && len(s.macrosByStages[StageHelo]) > 0 {
 		if err := s.sendMacros(wire.CodeHelo, s.macrosByStages[StageHelo]); err != nil {
@@ -553,6 +706,7 @@ func (s *ClientSession) Helo(helo string) (*Action, error) {
 }
 
 // Mail sends the sender (with optional esmtpArgs) to the milter.
+// sender can be with or without angle brackets. Use the empty string or "<>" for the null sender.
 func (s *ClientSession) Mail(sender string, esmtpArgs string) (*Action, error) {
 	if s.state != clientStateHeloCalled {
 		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
@@ -575,7 +729,7 @@ func (s *ClientSession) Mail(sender string, esmtpArgs string) (*Action, error) {
 		Code: wire.CodeMail,
 	}
 
-	msg.Data = wire.AppendCString(msg.Data, "<"+sender+">")
+	msg.Data = wire.AppendCString(msg.Data, AddAngle(sender))
 	if len(esmtpArgs) > 0 {
 		msg.Data = wire.AppendCString(msg.Data, esmtpArgs)
 	}
@@ -622,7 +776,7 @@ func (s *ClientSession) Rcpt(rcpt string, esmtpArgs string) (*Action, error) {
 		Code: wire.CodeRcpt,
 	}
 
-	msg.Data = wire.AppendCString(msg.Data, "<"+rcpt+">")
+	msg.Data = wire.AppendCString(msg.Data, AddAngle(rcpt))
 	if len(esmtpArgs) > 0 {
 		msg.Data = wire.AppendCString(msg.Data, esmtpArgs)
 	}
@@ -918,7 +1072,7 @@ func (s *ClientSession) readModifyActs() (modifyActs []ModifyAction, act *Action
 	for {
 		msg, err := wire.ReadPacket(s.conn, s.readTimeout)
 		if err != nil {
-			return nil, nil, fmt.Errorf("action read: %w", err)
+			return nil, nil, fmt.Errorf("action read: %w", connError(err))
 		}
 		if msg.Code == wire.Code(wire.ActProgress) /* progress */ {
 			continue
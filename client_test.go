@@ -3,6 +3,7 @@ package milter
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -220,6 +221,60 @@ func (w *serverClientWrap) Cleanup() {
 	w.server.Close()
 }
 
+// newAcceptMilter returns a MockMilter that continues at every stage and accepts the message at the end of the message.
+func newAcceptMilter() *MockMilter {
+	return &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		UnknownResp:   RespContinue,
+	}
+}
+
+// connect sends the connect and HELO of the MTA and asserts that the milter continued.
+func connect(t *testing.T, session *ClientSession) {
+	t.Helper()
+	act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Helo("localhost")
+	assertAction(t, act, err, ActionContinue)
+}
+
+// sendTransaction sends a SMTP transaction from from to to@example.com with the header hdrs and the body body
+// and asserts that the milter continued every stage before the end of the message.
+// It returns the result of the end of the message.
+func sendTransaction(t *testing.T, session *ClientSession, from string, hdrs textproto.Header, body string) ([]ModifyAction, *Action, error) {
+	t.Helper()
+	act, err := session.Mail(from, "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Header(hdrs)
+	assertAction(t, act, err, ActionContinue)
+	return session.BodyReadFrom(strings.NewReader(body))
+}
+
+// runTransaction starts a [Server] with serverOptions and the milter mm and sends one SMTP transaction
+// (with the header "Subject: test" and the body "test\r\n") to it.
+// It returns the result of the end of the message.
+func runTransaction(t *testing.T, serverOptions []Option, mm *MockMilter) ([]ModifyAction, *Action, error) {
+	t.Helper()
+	w := newServerClient(t, nil, append(serverOptions, WithMilter(func() Milter {
+		return mm
+	})), nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	hdrs := textproto.Header{}
+	hdrs.Add("Subject", "test")
+	return sendTransaction(t, w.session, "from@example.com", hdrs, "test\r\n")
+}
+
 func TestMilterClient_UsualFlow(t *testing.T) {
 	t.Parallel()
 	mm := MockMilter{
@@ -240,7 +295,10 @@ func TestMilterClient_UsualFlow(t *testing.T) {
 			m.ChangeFrom("changed@example.com", "A=B")
 			m.AddRecipient("example@example.com", "")
 			m.AddRecipient("<example@example.com>", "A=B")
-			m.DeleteRecipient("del@example.com")
+			if err := m.DeleteRecipient("del@example.com"); err != ErrRecipientNotFound {
+				t.Errorf("DeleteRecipient() of unknown recipient error = %v, want ErrRecipientNotFound", err)
+			}
+			m.DeleteRecipient("<TO2@example.org>")
 			m.AddHeader("X-Bad", "very")
 			m.Progress()
 			m.ChangeHeader(1, "Subject", "***SPAM***")
@@ -341,7 +399,7 @@ func TestMilterClient_UsualFlow(t *testing.T) {
 		{Type: ActionChangeFrom, From: "<changed@example.com>", FromArgs: "A=B"},
 		{Type: ActionAddRcpt, Rcpt: "<example@example.com>"},
 		{Type: ActionAddRcpt, Rcpt: "<example@example.com>", RcptArgs: "A=B"},
-		{Type: ActionDelRcpt, Rcpt: "<del@example.com>"},
+		{Type: ActionDelRcpt, Rcpt: "<TO2@example.org>"},
 		{Type: ActionAddHeader, HeaderName: "X-Bad", HeaderValue: "very"},
 		{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "Subject", HeaderValue: "***SPAM***"},
 		{Type: ActionInsertHeader, HeaderIndex: 2, HeaderName: "X-Hdr", HeaderValue: "value"},
@@ -439,6 +497,45 @@ func TestMilterClient_AbortFlow(t *testing.T) {
 	}
 }
 
+func TestMilterClient_DataReject(t *testing.T) {
+	t.Parallel()
+	dataResp, err := RejectWithCodeAndReason(550, "5.7.1 not at DATA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptResp: RespContinue,
+		DataResp: dataResp,
+	}
+	w := newServerClient(t, NewMacroBag(), []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 550 {
+		t.Fatal("Wrong SMTP code:", act.SMTPCode)
+	}
+	if act.SMTPReply != "550 5.7.1 not at DATA" {
+		t.Fatalf("Wrong SMTP reply: %q", act.SMTPReply)
+	}
+	if !act.StopProcessing() {
+		t.Fatal("StopProcessing() = false")
+	}
+}
+
 func TestMilterClient_NoWorking(t *testing.T) {
 	t.Parallel()
 	mm := MockMilter{
@@ -1677,3 +1774,108 @@ func TestMilterClient_WithMockServer(t *testing.T) {
 		})
 	}
 }
+
+func TestClientSession_Helo_Forwarded(t *testing.T) {
+	t.Parallel()
+	type received struct {
+		helo, macro, annotation string
+	}
+	got := make(chan received, 2)
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		HeloMod: func(m *Modifier) {
+			got <- received{m.Helo(), m.Macros.Get(MacroHelo), m.HeloAnnotation()}
+			m.AnnotateHelo("normalized by proxy")
+		},
+		MailResp: RespContinue,
+		MailMod: func(m *Modifier) {
+			got <- received{m.Helo(), m.Macros.Get(MacroHelo), m.HeloAnnotation()}
+		},
+	}
+	macros := NewMacroBag()
+	macros.Set(MacroHelo, "received.example.com")
+	w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithMacroRequest(StageHelo, []MacroName{MacroHelo})})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("mx.example.com", FamilyInet, 25, "192.0.2.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("mx.example.com")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("<from@example.com>", "")
+	assertAction(t, act, err, ActionContinue)
+
+	want := received{"mx.example.com", "mx.example.com", ""}
+	if r := <-got; r != want {
+		t.Errorf("Helo callback got %+v, want %+v", r, want)
+	}
+	want.annotation = "normalized by proxy"
+	if r := <-got; r != want {
+		t.Errorf("MailFrom callback got %+v, want %+v", r, want)
+	}
+}
+
+func TestClient_MTAFlavor(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		flavor     MTAFlavor
+		negotiate  string // hex of version, actions and protocol options
+		connMacros []MacroName
+	}{
+		{MTAFlavorPostfix, "00000006000001ff001fffff", []MacroName{MacroMTAFQDN, MacroDaemonName, MacroDaemonAddr, MacroMTAVersion, MacroRFC1413AuthInfo}},
+		{MTAFlavorSendmail, "00000006000001ff201fffff", []MacroName{MacroMTAFQDN, MacroRFC1413AuthInfo, MacroDaemonName, MacroIfName, MacroIfAddr}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.flavor.String(), func(t *testing.T) {
+			t.Parallel()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			received := make(chan *wire.Message, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					received <- nil
+					return
+				}
+				defer conn.Close()
+				msg, _ := wire.ReadPacket(conn, time.Second)
+				received <- msg
+			}()
+			// the options before WithMTAFlavor get overwritten
+			c := NewClient("tcp", ln.Addr().String(), WithActions(OptAddHeader), WithMaximumVersion(2), WithMTAFlavor(tt.flavor))
+			_, _ = c.Session(nil)
+			msg := <-received
+			if msg == nil || msg.Code != wire.CodeOptNeg {
+				t.Fatalf("got %+v, want negotiation packet", msg)
+			}
+			if got := hex.EncodeToString(msg.Data); got != tt.negotiate {
+				t.Errorf("negotiation data = %s, want %s", got, tt.negotiate)
+			}
+			if !reflect.DeepEqual(c.options.macrosByStage[StageConnect], tt.connMacros) {
+				t.Errorf("connect macros = %v, want %v", c.options.macrosByStage[StageConnect], tt.connMacros)
+			}
+		})
+	}
+	t.Run("server", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("NewServer() with WithMTAFlavor did not panic")
+			}
+		}()
+		NewServer(WithMilter(func() Milter { return NoOpMilter{} }), WithMTAFlavor(MTAFlavorPostfix))
+	})
+	t.Run("unknown", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("NewClient() with unknown flavor did not panic")
+			}
+		}()
+		NewClient("tcp", "127.0.0.1:0", WithMTAFlavor(0))
+	})
+}
@@ -0,0 +1,216 @@
+// Command milter-replay replays captured milter sessions against a milter and reports every response
+// that differs from the captured response.
+//
+// Usage:
+//
+//	milter-replay -transport tcp -address 127.0.0.1:1234 [-session 3] capture.jsonl
+//
+// The capture file is the output of [milter.JSONPacketLogger] (use it with [milter.WithPacketLogger] in the milter
+// you want to capture). It has one JSON object per line (empty lines are ignored). Every object is one milter packet:
+//
+//	{"session":1,"dir":"recv","cmd":"O","data_hex":"00000006000001ff001fffff","ts":"2023-03-01T15:47:33.123456789+01:00"}
+//	{"session":1,"dir":"send","cmd":"O","data_hex":"000000060000000100000000","ts":"2023-03-01T15:47:33.123556789+01:00"}
+//	{"session":1,"dir":"recv","cmd":"C","data_hex":"6c6f63616c686f737400340929313237","ts":"2023-03-01T15:47:33.124456789+01:00"}
+//	{"session":1,"dir":"send","cmd":"c","data_hex":"","ts":"2023-03-01T15:47:33.125456789+01:00"}
+//
+// "recv" packets are packets of the MTA, the "send" packets after them are the expected responses of the milter.
+// SMFIR_PROGRESS packets get ignored in the capture and in the responses of the milter.
+// Every captured session gets replayed over its own connection to the milter. Use -session to only replay one session.
+//
+// milter-replay exits with status 1 when a response differed or the milter did not respond as captured.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// capturedPacket is one line of the capture file (see milter.JSONPacketLogger)
+type capturedPacket struct {
+	Session uint64 `json:"session"`
+	Dir     string `json:"dir"`
+	Cmd     string `json:"cmd"`
+	DataHex string `json:"data_hex"`
+}
+
+// phase is one packet the MTA sent and the responses of the milter to it
+type phase struct {
+	line      int
+	request   *wire.Message
+	responses []*wire.Message
+}
+
+// session are the phases of one captured MTA connection
+type session struct {
+	id     uint64
+	phases []*phase
+}
+
+func toMessage(p capturedPacket) (*wire.Message, error) {
+	if len(p.Cmd) != 1 {
+		return nil, fmt.Errorf("invalid cmd %q", p.Cmd)
+	}
+	data, err := hex.DecodeString(p.DataHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data_hex: %w", err)
+	}
+	return &wire.Message{Code: wire.Code(p.Cmd[0]), Data: data}, nil
+}
+
+// readCapture reads the output of milter.JSONPacketLogger and returns the sessions in it,
+// in the order of their first packet.
+func readCapture(r io.Reader) ([]*session, error) {
+	var sessions []*session
+	byID := make(map[uint64]*session)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var p capturedPacket
+		if err := json.Unmarshal(b, &p); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msg, err := toMessage(p)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		s := byID[p.Session]
+		if s == nil {
+			s = &session{id: p.Session}
+			byID[p.Session] = s
+			sessions = append(sessions, s)
+		}
+		switch p.Dir {
+		case "recv":
+			s.phases = append(s.phases, &phase{line: line, request: msg})
+		case "send":
+			if len(s.phases) == 0 {
+				return nil, fmt.Errorf("line %d: send packet before first recv packet of session %d", line, p.Session)
+			}
+			if msg.Code == wire.Code(wire.ActProgress) {
+				continue
+			}
+			last := s.phases[len(s.phases)-1]
+			last.responses = append(last.responses, msg)
+		default:
+			return nil, fmt.Errorf("line %d: invalid dir %q", line, p.Dir)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func describe(msg *wire.Message) string {
+	return fmt.Sprintf("%c %q", msg.Code, msg.Data)
+}
+
+// readResponse reads the next packet of conn that is not a SMFIR_PROGRESS packet.
+func readResponse(conn net.Conn, timeout time.Duration) (*wire.Message, error) {
+	for {
+		msg, err := wire.ReadPacket(conn, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Code != wire.Code(wire.ActProgress) {
+			return msg, nil
+		}
+	}
+}
+
+// replay plays phases against conn and returns the number of differences.
+func replay(conn net.Conn, phases []*phase, timeout time.Duration) (int, error) {
+	differences := 0
+	for _, p := range phases {
+		if err := wire.WritePacket(conn, p.request, timeout); err != nil {
+			return differences, fmt.Errorf("line %d: sending %c: %w", p.line, p.request.Code, err)
+		}
+		for i, expected := range p.responses {
+			got, err := readResponse(conn, timeout)
+			if err != nil {
+				log.Printf("line %d: %c: expected response %d %s, got error %v", p.line, p.request.Code, i+1, describe(expected), err)
+				return differences + len(p.responses) - i, nil
+			}
+			if got.Code != expected.Code || !bytes.Equal(got.Data, expected.Data) {
+				differences++
+				log.Printf("line %d: %c: response %d differs\n- %s\n+ %s", p.line, p.request.Code, i+1, describe(expected), describe(got))
+			}
+		}
+	}
+	return differences, nil
+}
+
+// replaySession dials the milter and replays s over this connection. It returns the number of differences.
+func replaySession(transport, address string, s *session, timeout time.Duration) (int, error) {
+	conn, err := net.DialTimeout(transport, address, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return replay(conn, s.phases, timeout)
+}
+
+func main() {
+	transport := flag.String("transport", "unix", "Transport to use for milter connection, One of 'tcp', 'unix', 'tcp4' or 'tcp6'")
+	address := flag.String("address", "", "Transport address, path for 'unix', address:port for 'tcp'")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for every read and write")
+	sessionID := flag.Uint64("session", 0, "Only replay the session with this ID (0 replays all sessions)")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] capture-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessions, err := readCapture(f)
+	_ = f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	replayed, phases, differences := 0, 0, 0
+	for _, s := range sessions {
+		if *sessionID != 0 && s.id != *sessionID {
+			continue
+		}
+		d, err := replaySession(*transport, *address, s, *timeout)
+		if err != nil {
+			log.Fatalf("session %d: %v", s.id, err)
+		}
+		replayed++
+		phases += len(s.phases)
+		differences += d
+	}
+	if replayed == 0 {
+		log.Fatalf("no session to replay in %s", flag.Arg(0))
+	}
+	if differences > 0 {
+		log.Printf("%d sessions with %d phases replayed, %d responses differ", replayed, phases, differences)
+		os.Exit(1)
+	}
+	log.Printf("%d sessions with %d phases replayed, all responses identical", replayed, phases)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+type headerMilter struct {
+	milter.NoOpMilter
+	rejectRcpt bool
+}
+
+func (h *headerMilter) RcptTo(string, string, *milter.Modifier) (*milter.Response, error) {
+	if h.rejectRcpt {
+		return milter.RespReject, nil
+	}
+	return milter.RespContinue, nil
+}
+
+func (h *headerMilter) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
+	if err := m.AddHeader("X-Test", "1"); err != nil {
+		return nil, err
+	}
+	return milter.RespAccept, nil
+}
+
+// startServer starts a milter server with rejectRcpt and returns its address and a channel that gets a value
+// for every closed connection.
+func startServer(t *testing.T, rejectRcpt bool, opts ...milter.Option) (string, chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{}, 10)
+	opts = append(opts, milter.WithActions(milter.OptAddHeader), milter.WithMilter(func() milter.Milter {
+		return &headerMilter{rejectRcpt: rejectRcpt}
+	}), milter.WithOnConnectionClose(func(net.Conn, error) {
+		closed <- struct{}{}
+	}))
+	server := milter.NewServer(opts...)
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return ln.Addr().String(), closed
+}
+
+func waitClosed(t *testing.T, closed chan struct{}) {
+	t.Helper()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not close the connection")
+	}
+}
+
+// capture records one transaction of a real milter.Server with milter.JSONPacketLogger.
+func capture(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	address, closed := startServer(t, false, milter.WithPacketLogger(milter.JSONPacketLogger(&buf)))
+	client := milter.NewClient("tcp", address)
+	session, err := client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() (*milter.Action, error){
+		func() (*milter.Action, error) { return session.Conn("localhost", milter.FamilyInet, 2525, "127.0.0.1") },
+		func() (*milter.Action, error) { return session.Helo("localhost") },
+		func() (*milter.Action, error) { return session.Mail("<root@localhost>", "") },
+		func() (*milter.Action, error) { return session.Rcpt("<nobody@localhost>", "") },
+		session.DataStart,
+		func() (*milter.Action, error) { return session.HeaderField("From", "<root@localhost>", nil) },
+		session.HeaderEnd,
+		func() (*milter.Action, error) { return session.BodyChunk([]byte("body\r\n")) },
+	}
+	for i, step := range steps {
+		act, err := step()
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if act.Type != milter.ActionContinue {
+			t.Fatalf("step %d: action %v, want continue", i, act.Type)
+		}
+	}
+	modifications, act, err := session.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act.Type != milter.ActionAccept || len(modifications) != 1 {
+		t.Fatalf("End() = %v, %v, want accept with one modification", act.Type, modifications)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, closed)
+	return buf.Bytes()
+}
+
+func TestReadCapture(t *testing.T) {
+	t.Parallel()
+	sessions, err := readCapture(bytes.NewReader(capture(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].id != 1 {
+		t.Fatalf("readCapture() returned %d sessions, want session 1", len(sessions))
+	}
+	var requests, responses []byte
+	for _, p := range sessions[0].phases {
+		requests = append(requests, byte(p.request.Code))
+		for _, r := range p.responses {
+			responses = append(responses, byte(r.Code))
+		}
+	}
+	if want := "OCHMRTLNBEQ"; string(requests) != want {
+		t.Errorf("requests %q, want %q", requests, want)
+	}
+	if want := "Occccccccha"; string(responses) != want {
+		t.Errorf("responses %q, want %q", responses, want)
+	}
+}
+
+func TestReadCapture_errors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"invalid JSON", `{"session":1,`},
+		{"invalid dir", `{"session":1,"dir":"up","cmd":"O","data_hex":""}`},
+		{"invalid cmd", `{"session":1,"dir":"recv","cmd":"OO","data_hex":""}`},
+		{"invalid data_hex", `{"session":1,"dir":"recv","cmd":"O","data_hex":"zz"}`},
+		{"send before recv", `{"session":1,"dir":"send","cmd":"O","data_hex":""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readCapture(bytes.NewReader([]byte(tt.input))); err == nil {
+				t.Errorf("readCapture(%q) did not fail", tt.input)
+			}
+		})
+	}
+}
+
+func TestReadCapture_progress(t *testing.T) {
+	t.Parallel()
+	input := `{"session":2,"dir":"recv","cmd":"E","data_hex":""}
+
+{"session":2,"dir":"send","cmd":"p","data_hex":""}
+{"session":2,"dir":"send","cmd":"a","data_hex":""}
+`
+	sessions, err := readCapture(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || len(sessions[0].phases) != 1 {
+		t.Fatalf("readCapture() = %v, want one session with one phase", sessions)
+	}
+	p := sessions[0].phases[0]
+	if len(p.responses) != 1 || p.responses[0].Code != wire.Code(wire.ActAccept) {
+		t.Errorf("responses %v, want only the accept response", p.responses)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+	sessions, err := readCapture(bytes.NewReader(capture(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		rejectRcpt bool
+		want       int
+	}{
+		{"same milter", false, 0},
+		{"changed milter", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, _ := startServer(t, tt.rejectRcpt)
+			differences, err := replaySession("tcp", address, sessions[0], time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if differences != tt.want {
+				t.Errorf("replaySession() = %d differences, want %d", differences, tt.want)
+			}
+		})
+	}
+}
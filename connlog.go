@@ -0,0 +1,60 @@
+package milter
+
+import (
+	"net"
+	"time"
+)
+
+// ConnectionEventType is the type of a [ConnectionEvent].
+type ConnectionEventType int
+
+const (
+	// ConnectionConnected gets emitted when an MTA opened a new milter connection.
+	ConnectionConnected ConnectionEventType = iota + 1
+	// ConnectionReused gets emitted when the MTA re-uses the milter connection for another SMTP session (SMFIC_QUIT_NC).
+	ConnectionReused
+	// ConnectionDisconnected gets emitted when the milter connection got closed.
+	ConnectionDisconnected
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionConnected:
+		return "connected"
+	case ConnectionReused:
+		return "reused"
+	case ConnectionDisconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionEvent describes a lifecycle event of a milter connection. See [WithConnectionLogger].
+type ConnectionEvent struct {
+	// Type is the type of the event.
+	Type ConnectionEventType
+	// RemoteAddr is the address of the MTA. It is nil when the connection does not have a remote address.
+	RemoteAddr net.Addr
+	// ConnectedAt is the time the [Server] started handling the connection.
+	ConnectedAt time.Time
+	// SessionCount is the number of SMTP sessions the MTA started on this connection (including the current one).
+	// It is 1 for the [ConnectionConnected] event and gets incremented for every [ConnectionReused] event.
+	SessionCount int
+}
+
+func (m *serverSession) emitConnectionEvent(t ConnectionEventType) {
+	if m.server.options.connectionLogger == nil {
+		return
+	}
+	var remoteAddr net.Addr
+	if m.conn != nil {
+		remoteAddr = m.conn.RemoteAddr()
+	}
+	m.server.options.connectionLogger(ConnectionEvent{
+		Type:         t,
+		RemoteAddr:   remoteAddr,
+		ConnectedAt:  m.connectedAt,
+		SessionCount: m.sessionCount,
+	})
+}
@@ -0,0 +1,33 @@
+//go:build go1.21
+
+package milter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// LoggingConnectionLogger returns a function for [WithConnectionLogger] that logs every [ConnectionEvent]
+// with logger at [slog.LevelInfo]. A nil logger means [slog.Default].
+//
+// The [ConnectionDisconnected] event additionally includes the duration of the connection.
+func LoggingConnectionLogger(logger *slog.Logger) func(ConnectionEvent) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(event ConnectionEvent) {
+		attrs := []slog.Attr{
+			slog.String("event", event.Type.String()),
+			slog.Time("connected_at", event.ConnectedAt),
+			slog.Int("session_count", event.SessionCount),
+		}
+		if event.RemoteAddr != nil {
+			attrs = append(attrs, slog.String("remote_addr", event.RemoteAddr.String()))
+		}
+		if event.Type == ConnectionDisconnected {
+			attrs = append(attrs, slog.Duration("duration", time.Since(event.ConnectedAt)))
+		}
+		logger.LogAttrs(context.Background(), slog.LevelInfo, "milter connection "+event.Type.String(), attrs...)
+	}
+}
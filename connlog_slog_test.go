@@ -0,0 +1,42 @@
+//go:build go1.21
+
+package milter
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggingConnectionLogger(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	fn := LoggingConnectionLogger(logger)
+	connectedAt := time.Now().Add(-time.Second)
+	fn(ConnectionEvent{Type: ConnectionConnected, RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}, ConnectedAt: connectedAt, SessionCount: 1})
+	fn(ConnectionEvent{Type: ConnectionDisconnected, ConnectedAt: connectedAt, SessionCount: 3})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), buf.String())
+	}
+	for _, s := range []string{`msg="milter connection connected"`, "event=connected", "session_count=1", "remote_addr=127.0.0.1:1234"} {
+		if !strings.Contains(lines[0], s) {
+			t.Errorf("line %q does not contain %q", lines[0], s)
+		}
+	}
+	for _, s := range []string{"event=disconnected", "session_count=3", "duration="} {
+		if !strings.Contains(lines[1], s) {
+			t.Errorf("line %q does not contain %q", lines[1], s)
+		}
+	}
+	if strings.Contains(lines[1], "remote_addr") {
+		t.Errorf("line %q contains remote_addr", lines[1])
+	}
+	if LoggingConnectionLogger(nil) == nil {
+		t.Error("LoggingConnectionLogger(nil) returned nil")
+	}
+}
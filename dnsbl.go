@@ -0,0 +1,276 @@
+package milter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSBLResolver resolves the DNS names of DNSBL queries. [net.Resolver] implements this interface.
+type DNSBLResolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// DNSBLZone is one DNS block list that a [DNSBLChecker] queries.
+type DNSBLZone struct {
+	// Zone is the DNS zone of the list (e.g. "zen.spamhaus.org").
+	Zone string
+	// Codes are the answers (e.g. "127.0.0.2") that mean the client is listed. This allows you to
+	// only use some sub-lists of a combined list. An empty Codes means every answer is a listing.
+	Codes []string
+	// Weight is the weight of a listing in this zone (see [WithDNSBLThreshold]). 0 means 1.
+	Weight int
+}
+
+// DNSBLChecker is a [Middleware] that rejects SMTP connections at [Milter.Connect] when the client IP address
+// is listed in DNS block lists. Use [NewDNSBLChecker] to create it and share one DNSBLChecker between all
+// connections, so that they share its cache.
+//
+// The checked address is the client address the MTA sends in the connect event (not the address of the milter connection).
+// When the MTA gets the client address via the PROXY protocol (e.g. behind HAProxy) this is the real client address.
+// Connections that are not over IPv4 or IPv6 do not get checked.
+//
+// All zones get queried in parallel. While the lookups are running the DNSBLChecker sends a progress notification
+// every second, so that the MTA does not time out the milter connection. MTAs that negotiated a milter protocol version
+// older than 6 do not understand progress notifications and do not get them.
+// Failed lookups (including timeouts) count as not listed and get logged with [LogWarning].
+type DNSBLChecker struct {
+	zones     []DNSBLZone
+	resolver  DNSBLResolver
+	timeout   time.Duration
+	cacheTTL  time.Duration
+	threshold int
+	response  func(addr string, zones []string) *Response
+
+	mu    sync.Mutex
+	cache map[string]dnsblCacheEntry
+	now   func() time.Time
+	// progressInterval is the interval of the progress notifications, a field so that tests can lower it
+	progressInterval time.Duration
+}
+
+type dnsblCacheEntry struct {
+	zones   []string
+	expires time.Time
+}
+
+// DNSBLOption configures a [DNSBLChecker].
+type DNSBLOption func(c *DNSBLChecker)
+
+// WithDNSBLResolver sets the resolver that the [DNSBLChecker] uses. The default is [net.DefaultResolver].
+func WithDNSBLResolver(resolver DNSBLResolver) DNSBLOption {
+	return func(c *DNSBLChecker) {
+		c.resolver = resolver
+	}
+}
+
+// WithDNSBLTimeout sets the timeout for all DNSBL lookups of one connection. The default is 5 seconds.
+func WithDNSBLTimeout(timeout time.Duration) DNSBLOption {
+	return func(c *DNSBLChecker) {
+		c.timeout = timeout
+	}
+}
+
+// WithDNSBLCacheTTL sets how long the [DNSBLChecker] caches the result for a client address.
+// Listed and not listed results get cached. 0 disables the cache. The default is 5 minutes.
+func WithDNSBLCacheTTL(ttl time.Duration) DNSBLOption {
+	return func(c *DNSBLChecker) {
+		c.cacheTTL = ttl
+	}
+}
+
+// WithDNSBLThreshold sets the sum of the [DNSBLZone.Weight] of all zones listing a client address
+// that is needed to reject the connection. The default is 1 – every listing rejects the connection.
+func WithDNSBLThreshold(threshold int) DNSBLOption {
+	return func(c *DNSBLChecker) {
+		if threshold < 1 {
+			threshold = 1
+		}
+		c.threshold = threshold
+	}
+}
+
+// WithDNSBLResponse sets the function that creates the response for a listed client address addr.
+// zones are the zones that listed addr. The default is a 554 reject that names the zones.
+func WithDNSBLResponse(response func(addr string, zones []string) *Response) DNSBLOption {
+	return func(c *DNSBLChecker) {
+		c.response = response
+	}
+}
+
+func defaultDNSBLResponse(addr string, zones []string) *Response {
+	resp, err := RejectWithCodeAndReason(554, fmt.Sprintf("5.7.1 Service unavailable; client [%s] blocked using %s", addr, strings.Join(zones, ", ")))
+	if err != nil {
+		return RespReject
+	}
+	return resp
+}
+
+// NewDNSBLChecker creates a new [DNSBLChecker] that queries zones.
+func NewDNSBLChecker(zones []DNSBLZone, opts ...DNSBLOption) *DNSBLChecker {
+	c := &DNSBLChecker{
+		zones:     zones,
+		resolver:  net.DefaultResolver,
+		timeout:   5 * time.Second,
+		cacheTTL:  5 * time.Minute,
+		threshold: 1,
+		response:  defaultDNSBLResponse,
+		cache:     make(map[string]dnsblCacheEntry),
+		now:       time.Now,
+
+		progressInterval: time.Second,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Wrap wraps next so that the client address gets checked before next gets called.
+func (c *DNSBLChecker) Wrap(next Milter) Milter {
+	return &dnsblMilter{Milter: next, checker: c}
+}
+
+// dnsblQueryName returns the DNS name to query for ip in zone
+func dnsblQueryName(ip net.IP, zone string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.%s", ip4[3], ip4[2], ip4[1], ip4[0], zone)
+	}
+	const hexDigits = "0123456789abcdef"
+	ip16 := ip.To16()
+	b := make([]byte, 0, 64+len(zone))
+	for i := len(ip16) - 1; i >= 0; i-- {
+		b = append(b, hexDigits[ip16[i]&0xf], '.', hexDigits[ip16[i]>>4], '.')
+	}
+	return string(append(b, zone...))
+}
+
+// Lookup returns the zones that list addr. It uses the cache of c.
+// Lookup returns nil when addr is not an IP address.
+func (c *DNSBLChecker) Lookup(ctx context.Context, addr string) []string {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return nil
+	}
+	key := ip.String()
+	if c.cacheTTL > 0 {
+		c.mu.Lock()
+		e, ok := c.cache[key]
+		c.mu.Unlock()
+		if ok && c.now().Before(e.expires) {
+			return e.zones
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	listed := make([]bool, len(c.zones))
+	var wg sync.WaitGroup
+	for i, zone := range c.zones {
+		wg.Add(1)
+		go func(i int, zone DNSBLZone) {
+			defer wg.Done()
+			answers, err := c.resolver.LookupHost(ctx, dnsblQueryName(ip, zone.Zone))
+			if err != nil {
+				if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+					LogWarning("milter: DNSBL lookup of %s in %s failed: %v", key, zone.Zone, err)
+				}
+				return
+			}
+			listed[i] = dnsblMatches(answers, zone.Codes)
+		}(i, zone)
+	}
+	wg.Wait()
+	var zones []string
+	for i, l := range listed {
+		if l {
+			zones = append(zones, c.zones[i].Zone)
+		}
+	}
+	if c.cacheTTL > 0 {
+		c.mu.Lock()
+		now := c.now()
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		c.cache[key] = dnsblCacheEntry{zones: zones, expires: now.Add(c.cacheTTL)}
+		c.mu.Unlock()
+	}
+	return zones
+}
+
+func dnsblMatches(answers []string, codes []string) bool {
+	if len(codes) == 0 {
+		return len(answers) > 0
+	}
+	for _, a := range answers {
+		for _, code := range codes {
+			if a == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listed returns true when the weights of zones sum up to at least the threshold of c.
+func (c *DNSBLChecker) listed(zones []string) bool {
+	sum := 0
+	for _, name := range zones {
+		for _, zone := range c.zones {
+			if zone.Zone == name {
+				if zone.Weight == 0 {
+					sum++
+				} else {
+					sum += zone.Weight
+				}
+				break
+			}
+		}
+	}
+	return sum >= c.threshold
+}
+
+type dnsblMilter struct {
+	Milter
+	checker *DNSBLChecker
+}
+
+func (d *dnsblMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	if (family == "tcp4" || family == "tcp6") && len(d.checker.zones) > 0 {
+		done := make(chan []string, 1)
+		go func() {
+			done <- d.checker.Lookup(context.Background(), addr)
+		}()
+		// SMFIR_PROGRESS needs protocol version 6, with older MTAs we just wait for the lookups (at most until their timeout)
+		var progress <-chan time.Time
+		if m != nil && m.Negotiation() != nil && m.Negotiation().Version >= 6 {
+			ticker := time.NewTicker(d.checker.progressInterval)
+			defer ticker.Stop()
+			progress = ticker.C
+		}
+		var zones []string
+	wait:
+		for {
+			select {
+			case zones = <-done:
+				break wait
+			case <-progress:
+				if err := m.Progress(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if d.checker.listed(zones) {
+			return d.checker.response(addr, zones), nil
+		}
+	}
+	return d.Milter.Connect(host, family, port, addr, m)
+}
+
+var _ Middleware = (*DNSBLChecker)(nil)
@@ -0,0 +1,232 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDNSBLResolver struct {
+	mu      sync.Mutex
+	records map[string][]string
+	delay   time.Duration
+	err     error
+	queries []string
+}
+
+func (r *fakeDNSBLResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	r.queries = append(r.queries, host)
+	r.mu.Unlock()
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if answers, ok := r.records[host]; ok {
+		return answers, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeDNSBLResolver) queryCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queries)
+}
+
+func Test_dnsblQueryName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		zone string
+		want string
+	}{
+		{"192.0.2.1", "zen.example.org", "1.2.0.192.zen.example.org"},
+		{"192.0.2.1", "zen.example.org.", "1.2.0.192.zen.example.org"},
+		{"::ffff:192.0.2.1", "zen.example.org", "1.2.0.192.zen.example.org"},
+		{"2001:db8::1", "v6.example.org", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.v6.example.org"},
+	}
+	for _, tt := range tests {
+		if got := dnsblQueryName(net.ParseIP(tt.ip), tt.zone); got != tt.want {
+			t.Errorf("dnsblQueryName(%s, %s) = %s, want %s", tt.ip, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestDNSBLChecker_Lookup(t *testing.T) {
+	t.Parallel()
+	resolver := &fakeDNSBLResolver{records: map[string][]string{
+		"2.0.0.127.combined.example.org": {"127.0.0.2", "127.0.0.10"},
+		"2.0.0.127.single.example.org":   {"127.0.0.2"},
+		"3.0.0.127.combined.example.org": {"127.0.0.4"},
+	}}
+	zones := []DNSBLZone{
+		{Zone: "combined.example.org", Codes: []string{"127.0.0.2", "127.0.0.3"}},
+		{Zone: "single.example.org"},
+	}
+	tests := []struct {
+		addr string
+		want []string
+	}{
+		{"127.0.0.2", []string{"combined.example.org", "single.example.org"}},
+		{"[127.0.0.2]", []string{"combined.example.org", "single.example.org"}},
+		{"127.0.0.3", nil},
+		{"127.0.0.1", nil},
+		{"not an ip", nil},
+	}
+	c := NewDNSBLChecker(zones, WithDNSBLResolver(resolver), WithDNSBLCacheTTL(0))
+	for _, tt := range tests {
+		if got := c.Lookup(context.Background(), tt.addr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestDNSBLChecker_LookupCache(t *testing.T) {
+	t.Parallel()
+	resolver := &fakeDNSBLResolver{records: map[string][]string{
+		"2.0.0.127.example.org": {"127.0.0.2"},
+	}}
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewDNSBLChecker([]DNSBLZone{{Zone: "example.org"}}, WithDNSBLResolver(resolver), WithDNSBLCacheTTL(time.Minute))
+	c.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		if got := c.Lookup(context.Background(), "127.0.0.2"); len(got) != 1 {
+			t.Fatalf("Lookup(127.0.0.2) = %v", got)
+		}
+		if got := c.Lookup(context.Background(), "127.0.0.1"); len(got) != 0 {
+			t.Fatalf("Lookup(127.0.0.1) = %v", got)
+		}
+	}
+	if got := resolver.queryCount(); got != 2 {
+		t.Fatalf("got %d queries, want 2 (results should be cached)", got)
+	}
+	now = now.Add(time.Minute)
+	c.Lookup(context.Background(), "127.0.0.2")
+	if got := resolver.queryCount(); got != 3 {
+		t.Fatalf("got %d queries, want 3 (cache entry should be expired)", got)
+	}
+}
+
+func TestDNSBLChecker_LookupErrors(t *testing.T) {
+	t.Parallel()
+	resolver := &fakeDNSBLResolver{delay: time.Second}
+	c := NewDNSBLChecker([]DNSBLZone{{Zone: "example.org"}}, WithDNSBLResolver(resolver), WithDNSBLTimeout(20*time.Millisecond))
+	start := time.Now()
+	if got := c.Lookup(context.Background(), "127.0.0.2"); got != nil {
+		t.Errorf("Lookup() = %v, want nil on timeout", got)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Lookup() did not respect timeout")
+	}
+	resolver = &fakeDNSBLResolver{err: errors.New("SERVFAIL")}
+	c = NewDNSBLChecker([]DNSBLZone{{Zone: "example.org"}}, WithDNSBLResolver(resolver))
+	if got := c.Lookup(context.Background(), "127.0.0.2"); got != nil {
+		t.Errorf("Lookup() = %v, want nil on error", got)
+	}
+}
+
+func TestDNSBLChecker(t *testing.T) {
+	t.Parallel()
+	resolver := &fakeDNSBLResolver{records: map[string][]string{
+		"2.0.0.127.a.example.org": {"127.0.0.2"},
+		"2.0.0.127.b.example.org": {"127.0.0.2"},
+		"3.0.0.127.a.example.org": {"127.0.0.2"},
+	}}
+	zones := []DNSBLZone{{Zone: "a.example.org"}, {Zone: "b.example.org"}}
+	custom := func(addr string, zones []string) *Response {
+		return RespTempFail
+	}
+	tests := []struct {
+		name   string
+		opts   []DNSBLOption
+		family ProtoFamily
+		addr   string
+		want   ActionType
+	}{
+		{"not listed", nil, FamilyInet, "127.0.0.1", ActionContinue},
+		{"listed", nil, FamilyInet, "127.0.0.2", ActionRejectWithCode},
+		{"listed once", nil, FamilyInet, "127.0.0.3", ActionRejectWithCode},
+		{"threshold not reached", []DNSBLOption{WithDNSBLThreshold(2)}, FamilyInet, "127.0.0.3", ActionContinue},
+		{"threshold reached", []DNSBLOption{WithDNSBLThreshold(2)}, FamilyInet, "127.0.0.2", ActionRejectWithCode},
+		{"custom response", []DNSBLOption{WithDNSBLResponse(custom)}, FamilyInet, "127.0.0.2", ActionTempFail},
+		{"unix socket", nil, FamilyUnix, "/run/smtp.sock", ActionContinue},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			checker := NewDNSBLChecker(zones, append([]DNSBLOption{WithDNSBLResolver(resolver)}, tt.opts...)...)
+			connCalled := false
+			mm := MockMilter{
+				ConnResp: RespContinue,
+				ConnMod: func(m *Modifier) {
+					connCalled = true
+				},
+			}
+			w := newServerClient(t, NewMacroBag(), []Option{WithMilter(func() Milter {
+				return checker.Wrap(&mm)
+			})}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("localhost", tt.family, 2525, tt.addr)
+			assertAction(t, act, err, tt.want)
+			if act.Type == ActionRejectWithCode && !strings.Contains(act.SMTPReply, "a.example.org") {
+				t.Errorf("reply %q does not contain zone", act.SMTPReply)
+			}
+			if connCalled != (tt.want == ActionContinue) {
+				t.Errorf("wrapped Connect called = %v", connCalled)
+			}
+		})
+	}
+}
+
+func TestDNSBLChecker_Progress(t *testing.T) {
+	t.Parallel()
+	const mtaTimeout = 100 * time.Millisecond
+	resolver := &fakeDNSBLResolver{delay: 3 * mtaTimeout, records: map[string][]string{
+		"2.0.0.127.example.org": {"127.0.0.2"},
+	}}
+	checker := NewDNSBLChecker([]DNSBLZone{{Zone: "example.org"}}, WithDNSBLResolver(resolver))
+	checker.progressInterval = mtaTimeout / 4
+	w := newServerClient(t, NewMacroBag(), []Option{WithMilter(func() Milter {
+		return checker.Wrap(&MockMilter{ConnResp: RespContinue})
+	})}, []Option{WithReadTimeout(mtaTimeout)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.2")
+	assertAction(t, act, err, ActionRejectWithCode)
+}
+
+func TestDNSBLChecker_Progress_oldVersion(t *testing.T) {
+	t.Parallel()
+	const interval = 10 * time.Millisecond
+	resolver := &fakeDNSBLResolver{delay: 5 * interval, records: map[string][]string{
+		"2.0.0.127.example.org": {"127.0.0.2"},
+	}}
+	checker := NewDNSBLChecker([]DNSBLZone{{Zone: "example.org"}}, WithDNSBLResolver(resolver))
+	checker.progressInterval = interval
+	logger := &recordingPacketLogger{}
+	w := newServerClient(t, NewMacroBag(), []Option{WithPacketLogger(logger), WithMilter(func() Milter {
+		return checker.Wrap(&MockMilter{ConnResp: RespContinue})
+	})}, []Option{WithMaximumVersion(4), WithActions(allClientSupportedActionMasksV2), WithProtocols(0)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.2")
+	assertAction(t, act, err, ActionRejectWithCode)
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	for _, p := range logger.packets {
+		if p == "send p" {
+			t.Errorf("sent progress packet to MTA with protocol version 4: %q", logger.packets)
+			break
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package milter
+
+import (
+	"math"
+	"strings"
+)
+
+// DomainAllowList is a [Middleware] that accepts messages of allowed sender domains at [Milter.MailFrom].
+// The wrapped [Milter] does not get the MailFrom call (and all later calls of this message) for an allowed sender,
+// the MTA gets a [RespAccept] response instead. Messages of all other senders get passed to the wrapped [Milter].
+// Use [NewDomainAllowList] to create it and share one DomainAllowList between all connections.
+//
+// The domain of the envelope sender gets compared case-insensitive and without a trailing dot.
+// Subdomains of allowed domains are not allowed automatically, you need to list them.
+// The null sender <> is never allowed.
+//
+// Senders of most messages are not on the allow list. To make this negative lookup fast for huge allow lists
+// the domains get stored in a Bloom filter. Domains that the Bloom filter reports as (possibly) allowed get
+// checked against the exact domain list, so a false positive of the Bloom filter does not allow a domain.
+type DomainAllowList struct {
+	bits    []uint64
+	m       uint64
+	k       uint64
+	domains map[string]struct{}
+}
+
+// NewDomainAllowList creates a new [DomainAllowList] of domains.
+// falsePositiveRate is the false positive rate of the Bloom filter (e.g. 0.01), a lower rate needs more memory.
+// Values outside the range (0, 1) get replaced with 0.01.
+func NewDomainAllowList(domains []string, falsePositiveRate float64) *DomainAllowList {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	n := float64(len(domains))
+	if n < 1 {
+		n = 1
+	}
+	// optimal number of bits and hash functions for n entries and the false positive rate
+	m := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	a := &DomainAllowList{
+		bits:    make([]uint64, (m+63)/64),
+		m:       m,
+		k:       k,
+		domains: make(map[string]struct{}, len(domains)),
+	}
+	for _, d := range domains {
+		d = normalizeHostname(d)
+		if d == "" {
+			continue
+		}
+		a.domains[d] = struct{}{}
+		h1, h2 := bloomHashes(d)
+		for i := uint64(0); i < a.k; i++ {
+			bit := (h1 + i*h2) % a.m
+			a.bits[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	return a
+}
+
+// bloomHashes returns the two base hashes for the double hashing of the Bloom filter
+func bloomHashes(s string) (uint64, uint64) {
+	// FNV-1a, inlined so that lookups do not allocate
+	sum := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		sum ^= uint64(s[i])
+		sum *= 1099511628211
+	}
+	h1, h2 := sum&0xffffffff, sum>>32
+	// h2 needs to be odd, so that h1 + i*h2 visits different bits
+	return h1, h2 | 1
+}
+
+// mayContain returns false when domain is definitely not in the Bloom filter of a.
+func (a *DomainAllowList) mayContain(domain string) bool {
+	h1, h2 := bloomHashes(domain)
+	for i := uint64(0); i < a.k; i++ {
+		bit := (h1 + i*h2) % a.m
+		if a.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Allowed returns true when domain is on the allow list.
+func (a *DomainAllowList) Allowed(domain string) bool {
+	domain = normalizeHostname(domain)
+	if domain == "" || !a.mayContain(domain) {
+		return false
+	}
+	_, ok := a.domains[domain]
+	return ok
+}
+
+// Wrap wraps next so that messages of allowed senders get accepted before next gets called.
+func (a *DomainAllowList) Wrap(next Milter) Milter {
+	return &domainAllowListMilter{Milter: next, list: a}
+}
+
+type domainAllowListMilter struct {
+	Milter
+	list *DomainAllowList
+}
+
+func (d *domainAllowListMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if at := strings.LastIndexByte(from, '@'); at >= 0 && d.list.Allowed(from[at+1:]) {
+		return RespAccept, nil
+	}
+	return d.Milter.MailFrom(from, esmtpArgs, m)
+}
+
+var _ Middleware = (*DomainAllowList)(nil)
@@ -0,0 +1,120 @@
+package milter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDomainAllowList_Allowed(t *testing.T) {
+	a := NewDomainAllowList([]string{"example.com", "Example.NET.", " sub.example.org ", ""}, 0.01)
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"example.com.", true},
+		{"example.net", true},
+		{"sub.example.org", true},
+		{"example.org", false},
+		{"mail.example.com", false},
+		{"example.de", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := a.Allowed(tt.domain); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestDomainAllowList_FalsePositives(t *testing.T) {
+	domains := make([]string, 10000)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("allowed-%d.example.com", i)
+	}
+	// a very high false positive rate makes the Bloom filter report many domains it does not contain
+	a := NewDomainAllowList(domains, 0.5)
+	bloomPositives := 0
+	for i := 0; i < 10000; i++ {
+		d := fmt.Sprintf("other-%d.example.com", i)
+		if a.mayContain(d) {
+			bloomPositives++
+		}
+		if a.Allowed(d) {
+			t.Fatalf("Allowed(%q) = true", d)
+		}
+	}
+	if bloomPositives == 0 {
+		t.Error("expected false positives of the Bloom filter")
+	}
+	for _, d := range domains {
+		if !a.Allowed(d) {
+			t.Fatalf("Allowed(%q) = false", d)
+		}
+	}
+}
+
+func TestDomainAllowList(t *testing.T) {
+	t.Parallel()
+	a := NewDomainAllowList([]string{"example.com"}, 0.01)
+	tests := []struct {
+		name string
+		from string
+		want ActionType
+	}{
+		{"allowed", "user@example.com", ActionAccept},
+		{"allowed upper case", "user@EXAMPLE.COM", ActionAccept},
+		{"not allowed", "user@example.net", ActionContinue},
+		{"subdomain", "user@mail.example.com", ActionContinue},
+		{"null sender", "", ActionContinue},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mailCalled := false
+			mm := MockMilter{
+				ConnResp: RespContinue,
+				HeloResp: RespContinue,
+				MailResp: RespContinue,
+				MailMod: func(m *Modifier) {
+					mailCalled = true
+				},
+			}
+			w := newServerClient(t, NewMacroBag(), []Option{WithMilter(func() Milter {
+				return a.Wrap(&mm)
+			})}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("localhost")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail(tt.from, "")
+			assertAction(t, act, err, tt.want)
+			if mailCalled != (tt.want == ActionContinue) {
+				t.Errorf("wrapped MailFrom called = %v", mailCalled)
+			}
+		})
+	}
+}
+
+func BenchmarkDomainAllowList_Allowed(b *testing.B) {
+	domains := make([]string, 1000000)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("allowed-%d.example.com", i)
+	}
+	a := NewDomainAllowList(domains, 0.01)
+	b.Run("not allowed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			a.Allowed("not-allowed.example.net")
+		}
+	})
+	b.Run("allowed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			a.Allowed("allowed-4711.example.com")
+		}
+	})
+}
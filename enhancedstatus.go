@@ -0,0 +1,93 @@
+package milter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EnhancedStatusCode is an enhanced mail system status code (RFC 3463), e.g. 5.7.1.
+// Class is 2 (success), 4 (persistent transient failure) or 5 (permanent failure),
+// Subject and Detail are numbers between 0 and 999.
+type EnhancedStatusCode struct {
+	Class, Subject, Detail int
+}
+
+// Valid returns true when c is a syntactically valid enhanced status code.
+func (c EnhancedStatusCode) Valid() bool {
+	return (c.Class == 2 || c.Class == 4 || c.Class == 5) && c.Subject >= 0 && c.Subject <= 999 && c.Detail >= 0 && c.Detail <= 999
+}
+
+// String returns c in its text form, e.g. "5.7.1".
+func (c EnhancedStatusCode) String() string {
+	return FormatEnhancedStatusCode(c.Class, c.Subject, c.Detail)
+}
+
+// FormatEnhancedStatusCode returns the text form of the enhanced status code class.subject.detail (e.g. "5.7.1").
+func FormatEnhancedStatusCode(class, subject, detail int) string {
+	return fmt.Sprintf("%d.%d.%d", class, subject, detail)
+}
+
+// ParseEnhancedStatusCode parses the enhanced status code at the start of the SMTP reply text.
+// text can start with the SMTP code ("550 5.7.1 Rejected", "550-5.7.1 Rejected") or directly with the
+// enhanced status code ("5.7.1 Rejected"). ok is false when text does not start with a valid enhanced status code.
+func ParseEnhancedStatusCode(text string) (class, subject, detail int, ok bool) {
+	if len(text) > 4 && isDigits(text[:3]) && (text[3] == ' ' || text[3] == '-') {
+		text = text[4:]
+	}
+	if end := strings.IndexAny(text, " \t\r\n"); end >= 0 {
+		text = text[:end]
+	}
+	parts := strings.Split(text, ".")
+	if len(parts) != 3 || len(parts[0]) != 1 {
+		return 0, 0, 0, false
+	}
+	var nums [3]int
+	for i, p := range parts {
+		if len(p) == 0 || len(p) > 3 || !isDigits(p) {
+			return 0, 0, 0, false
+		}
+		nums[i], _ = strconv.Atoi(p)
+	}
+	if !(EnhancedStatusCode{nums[0], nums[1], nums[2]}).Valid() {
+		return 0, 0, 0, false
+	}
+	return nums[0], nums[1], nums[2], true
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// checkEnhancedStatusCode returns an error when reason starts with an enhanced status code
+// whose class does not match the class of smtpCode (e.g. 550 with 4.7.1).
+func checkEnhancedStatusCode(smtpCode uint16, reason string) error {
+	class, subject, detail, ok := ParseEnhancedStatusCode(reason)
+	if ok && class != int(smtpCode/100) {
+		return fmt.Errorf("milter: enhanced status code %s does not match code %d", FormatEnhancedStatusCode(class, subject, detail), smtpCode)
+	}
+	return nil
+}
+
+// RejectWithEnhancedCode is like [RejectWithCodeAndReason] but puts the enhanced status code in front of every line of reason.
+// It returns an error when code is not valid or its class does not match the class of smtpCode
+// (4xx needs class 4, 5xx class 5).
+func RejectWithEnhancedCode(smtpCode uint16, code EnhancedStatusCode, reason string) (*Response, error) {
+	if !code.Valid() {
+		return nil, fmt.Errorf("milter: invalid enhanced status code %s", code)
+	}
+	if code.Class != int(smtpCode/100) {
+		return nil, fmt.Errorf("milter: enhanced status code %s does not match code %d", code, smtpCode)
+	}
+	lines := strings.Split(strings.TrimRight(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(reason), "\n"), "\n")
+	prefix := code.String() + " "
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return RejectWithCodeAndReason(smtpCode, strings.Join(lines, "\n"))
+}
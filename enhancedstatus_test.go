@@ -0,0 +1,105 @@
+package milter
+
+import (
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func TestParseEnhancedStatusCode(t *testing.T) {
+	tests := []struct {
+		text                   string
+		class, subject, detail int
+		ok                     bool
+	}{
+		{"5.7.1", 5, 7, 1, true},
+		{"5.7.1 Rejected", 5, 7, 1, true},
+		{"550 5.7.1 Rejected", 5, 7, 1, true},
+		{"451-4.3.0 Try again\r\n451 4.3.0 later", 4, 3, 0, true},
+		{"2.0.0\tOk", 2, 0, 0, true},
+		{"4.999.999 max", 4, 999, 999, true},
+		{"550 Rejected", 0, 0, 0, false},
+		{"3.7.1 Rejected", 0, 0, 0, false},
+		{"55.7.1 Rejected", 0, 0, 0, false},
+		{"5.1000.1 Rejected", 0, 0, 0, false},
+		{"5.7 Rejected", 0, 0, 0, false},
+		{"5.7.1.1 Rejected", 0, 0, 0, false},
+		{"5..1 Rejected", 0, 0, 0, false},
+		{"5.7.x Rejected", 0, 0, 0, false},
+		{"5.7.1: Rejected", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		class, subject, detail, ok := ParseEnhancedStatusCode(tt.text)
+		if class != tt.class || subject != tt.subject || detail != tt.detail || ok != tt.ok {
+			t.Errorf("ParseEnhancedStatusCode(%q) = %d, %d, %d, %v, want %d, %d, %d, %v", tt.text, class, subject, detail, ok, tt.class, tt.subject, tt.detail, tt.ok)
+		}
+	}
+}
+
+func TestFormatEnhancedStatusCode(t *testing.T) {
+	if got := FormatEnhancedStatusCode(5, 7, 1); got != "5.7.1" {
+		t.Errorf("FormatEnhancedStatusCode() = %q", got)
+	}
+	if got := (EnhancedStatusCode{4, 3, 0}).String(); got != "4.3.0" {
+		t.Errorf("String() = %q", got)
+	}
+	for _, c := range []EnhancedStatusCode{{5, 7, 1}, {2, 0, 0}, {4, 999, 999}} {
+		class, subject, detail, ok := ParseEnhancedStatusCode(c.String())
+		if !ok || (EnhancedStatusCode{class, subject, detail}) != c {
+			t.Errorf("ParseEnhancedStatusCode(%q) = %d, %d, %d, %v", c, class, subject, detail, ok)
+		}
+	}
+}
+
+func TestRejectWithEnhancedCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		smtpCode uint16
+		code     EnhancedStatusCode
+		reason   string
+		want     string
+		wantErr  bool
+	}{
+		{"single line", 550, EnhancedStatusCode{5, 7, 1}, "Rejected", "550 5.7.1 Rejected", false},
+		{"multi line", 451, EnhancedStatusCode{4, 3, 0}, "Try again\nlater\n", "451-4.3.0 Try again\r\n451 4.3.0 later", false},
+		{"CR line break", 550, EnhancedStatusCode{5, 7, 1}, "Rejected\r250 Ok", "550-5.7.1 Rejected\r\n550 5.7.1 250 Ok", false},
+		{"class mismatch", 550, EnhancedStatusCode{4, 7, 1}, "Rejected", "", true},
+		{"temp class mismatch", 451, EnhancedStatusCode{5, 7, 1}, "Rejected", "", true},
+		{"invalid code", 550, EnhancedStatusCode{5, 1000, 1}, "Rejected", "", true},
+		{"success class", 550, EnhancedStatusCode{2, 0, 0}, "Rejected", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := RejectWithEnhancedCode(tt.smtpCode, tt.code, tt.reason)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RejectWithEnhancedCode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			msg := resp.Response()
+			if msg.Code != wire.Code(wire.ActReplyCode) {
+				t.Fatalf("code = %c", msg.Code)
+			}
+			if got := string(msg.Data[:len(msg.Data)-1]); got != tt.want {
+				t.Errorf("data = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectWithCodeAndReason_EnhancedStatusCodeClass(t *testing.T) {
+	if _, err := RejectWithCodeAndReason(550, "4.7.1 Rejected"); err == nil {
+		t.Error("RejectWithCodeAndReason(550, 4.7.1) did not return an error")
+	}
+	if _, err := RejectWithCodeAndReason(451, "5.7.1 Rejected"); err == nil {
+		t.Error("RejectWithCodeAndReason(451, 5.7.1) did not return an error")
+	}
+	if _, err := RejectWithCodeAndReason(550, "5.7.1 Rejected"); err != nil {
+		t.Errorf("RejectWithCodeAndReason(550, 5.7.1) = %v", err)
+	}
+	if _, err := RejectWithCodeAndReason(550, "Rejected 4.7.1"); err != nil {
+		t.Errorf("RejectWithCodeAndReason(550, no enhanced code) = %v", err)
+	}
+}
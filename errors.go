@@ -0,0 +1,73 @@
+package milter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// Error categories of the milter protocol implementation. The errors that [ClientSession], [Client] and [Server]
+// return wrap one of these when the cause is known, so you can use [errors.Is] to distinguish them:
+//
+//	if errors.Is(err, milter.ErrTimeout) {
+//		// retry later
+//	}
+//
+// The original error (e.g. a [net.Error]) stays in the chain and can be retrieved with [errors.As].
+var (
+	// ErrProtocol indicates that the other side sent something that violates the milter protocol.
+	ErrProtocol = errors.New("milter: protocol error")
+	// ErrTimeout indicates that a read or write on the milter connection took too long.
+	ErrTimeout = errors.New("milter: timeout")
+	// ErrNegotiation indicates that the MTA and the milter could not agree on the protocol version, actions or options.
+	ErrNegotiation = errors.New("milter: negotiation failed")
+	// ErrConnectionClosed indicates that the other side closed the milter connection.
+	ErrConnectionClosed = errors.New("milter: connection closed")
+)
+
+// categoryError adds a category to err without changing its message.
+type categoryError struct {
+	category error
+	err      error
+}
+
+func (e *categoryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categoryError) Unwrap() error {
+	return e.err
+}
+
+func (e *categoryError) Is(target error) bool {
+	return target == e.category
+}
+
+// withCategory returns err so that errors.Is(err, category) is true.
+func withCategory(category, err error) error {
+	return &categoryError{category: category, err: err}
+}
+
+// errorf is like [fmt.Errorf] but the returned error belongs to category.
+func errorf(category error, format string, a ...interface{}) error {
+	return withCategory(category, fmt.Errorf(format, a...))
+}
+
+// connError adds the matching category to the error of a read or write on the milter connection.
+func connError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, wire.ErrPacketSize):
+		return withCategory(ErrProtocol, err)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return withCategory(ErrTimeout, err)
+	case isClosedConnError(err) || errors.Is(err, io.ErrUnexpectedEOF):
+		return withCategory(ErrConnectionClosed, err)
+	}
+	return err
+}
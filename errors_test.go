@@ -0,0 +1,147 @@
+package milter
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func optNegPacket(version uint32) *wire.Message {
+	msg := &wire.Message{Code: wire.CodeOptNeg, Data: make([]byte, 4*3)}
+	binary.BigEndian.PutUint32(msg.Data, version)
+	return msg
+}
+
+func TestErrorCategories_Client(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		milter func(conn net.Conn)
+		want   error
+	}{
+		{"protocol", func(conn net.Conn) {
+			_ = wire.WritePacket(conn, &wire.Message{Code: wire.Code(wire.ActAccept)}, 0)
+		}, ErrProtocol},
+		{"negotiation", func(conn net.Conn) {
+			_ = wire.WritePacket(conn, optNegPacket(1), 0)
+		}, ErrNegotiation},
+		{"timeout", func(conn net.Conn) {
+			time.Sleep(time.Second)
+		}, ErrTimeout},
+		{"connection closed", func(conn net.Conn) {
+			_ = conn.Close()
+		}, ErrConnectionClosed},
+	}
+	categories := []error{ErrProtocol, ErrNegotiation, ErrTimeout, ErrConnectionClosed}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			clientConn, milterConn := net.Pipe()
+			defer milterConn.Close()
+			go func() {
+				if _, err := wire.ReadPacket(milterConn, 0); err != nil {
+					return
+				}
+				tt.milter(milterConn)
+			}()
+			_, err := NewClientConn(clientConn, nil, WithReadTimeout(50*time.Millisecond))
+			if err == nil {
+				t.Fatal("NewClientConn() did not return an error")
+			}
+			for _, category := range categories {
+				if got := errors.Is(err, category); got != (category == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, category, got)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorCategories_ClientAs(t *testing.T) {
+	t.Parallel()
+	clientConn, milterConn := net.Pipe()
+	defer milterConn.Close()
+	go func() {
+		_, _ = wire.ReadPacket(milterConn, 0)
+	}()
+	_, err := NewClientConn(clientConn, nil, WithReadTimeout(50*time.Millisecond))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("errors.As(%v, net.Error) did not find the timeout error", err)
+	}
+}
+
+func TestErrorCategories_Server(t *testing.T) {
+	t.Parallel()
+	newSession := func() *serverSession {
+		backend := &processTestMilter{}
+		return &serverSession{
+			server:  NewServer(WithMilter(func() Milter { return backend })),
+			version: MaxServerProtocolVersion,
+			macros:  newMacroStages(),
+			backend: backend,
+		}
+	}
+	_, err := newSession().Process(&wire.Message{Code: wire.CodeHelo})
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("Process(invalid helo) = %v, want ErrProtocol", err)
+	}
+	_, err = newSession().Process(optNegPacket(MaxServerProtocolVersion))
+	if !errors.Is(err, ErrProtocol) || !errors.Is(err, ErrDuplicateNegotiation) {
+		t.Errorf("Process(second optneg) = %v, want ErrProtocol and ErrDuplicateNegotiation", err)
+	}
+	_, err = newSession().negotiate(optNegPacket(1), MaxServerProtocolVersion, 0, 0, nil, nil, 0)
+	if !errors.Is(err, ErrNegotiation) {
+		t.Errorf("negotiate(version 1) = %v, want ErrNegotiation", err)
+	}
+	_, err = newSession().negotiate(&wire.Message{Code: wire.CodeHelo}, MaxServerProtocolVersion, 0, 0, nil, nil, 0)
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("negotiate(helo) = %v, want ErrProtocol", err)
+	}
+	if !errors.Is(ErrWriteTimeout, ErrTimeout) {
+		t.Error("ErrWriteTimeout is not an ErrTimeout")
+	}
+}
+
+func TestErrorCategories_ServerConnectionClosed(t *testing.T) {
+	t.Parallel()
+	serverConn, mtaConn := net.Pipe()
+	errs := make(chan error, 1)
+	s := NewServer(WithMilter(func() Milter { return &processTestMilter{} }), WithErrorHandler(func(err error, _ Session) {
+		errs <- err
+	}))
+	go func() {
+		_ = s.ServeConn(serverConn)
+	}()
+	// send half a packet and go away
+	if _, err := mtaConn.Write([]byte{0, 0, 0, 13, byte(wire.CodeOptNeg)}); err != nil {
+		t.Fatal(err)
+	}
+	_ = mtaConn.Close()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrConnectionClosed) {
+			t.Errorf("got %v, want ErrConnectionClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error handler did not get called")
+	}
+}
+
+func TestReadPacket_invalidSize(t *testing.T) {
+	t.Parallel()
+	serverConn, mtaConn := net.Pipe()
+	defer mtaConn.Close()
+	go func() {
+		_, _ = mtaConn.Write([]byte{0, 0, 0, 0})
+	}()
+	m := &serverSession{conn: serverConn}
+	if _, err := m.readPacket(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("readPacket() = %v, want ErrProtocol", err)
+	}
+}
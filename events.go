@@ -0,0 +1,166 @@
+package milter
+
+import (
+	"net"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// defaultEventQueueSize is the default of [WithEventQueueSize].
+const defaultEventQueueSize = 128
+
+// Event is an observability event of the [Server]. See [WithEventHook].
+// It is one of [ConnectionAccepted], [StageCompleted], [ModificationApplied] or [SessionEnded].
+type Event interface {
+	// Base returns the fields that all events share.
+	Base() EventBase
+}
+
+// EventBase are the fields that all events share.
+type EventBase struct {
+	// Time is the time the event happened.
+	Time time.Time
+	// RemoteAddr is the address of the MTA. It is nil when the connection does not have a remote address.
+	RemoteAddr net.Addr
+	// ConnectedAt is the time the [Server] started handling the connection.
+	// Together with RemoteAddr you can use it to group the events of one connection.
+	ConnectedAt time.Time
+}
+
+// Base returns e.
+func (e EventBase) Base() EventBase {
+	return e
+}
+
+// ConnectionAccepted gets emitted when the [Server] starts handling a new milter connection.
+type ConnectionAccepted struct {
+	EventBase
+	// LocalAddr is the address of the [Server] side of the connection.
+	LocalAddr net.Addr
+}
+
+// StageCompleted gets emitted after the [Milter] handled a command of the MTA.
+type StageCompleted struct {
+	EventBase
+	// Stage is the callback of the [Milter] that handled the command.
+	Stage Callback
+	// Duration is the time it took to handle the command (without sending the response).
+	Duration time.Duration
+	// Decision is the response of the [Milter]. It is nil for [CallbackAbort].
+	Decision *Response
+	// Err is the error the [Milter] returned.
+	Err error
+}
+
+// ModificationApplied gets emitted after the [Server] sent a modification of the [Milter] to the MTA.
+// A [Modifier.ReplaceBody] emits one event per body chunk.
+type ModificationApplied struct {
+	EventBase
+	// Modification is the modification that got sent.
+	Modification ModifyAction
+}
+
+// SessionEnded gets emitted when the milter connection got closed. It is always the last event of a connection.
+type SessionEnded struct {
+	EventBase
+	// Reason is the error that ended the connection. It is nil when the MTA closed the connection.
+	Reason error
+	// DroppedEvents is the number of events of this connection that got dropped because the queue was full.
+	DroppedEvents int
+}
+
+// callbackForCode returns the [Callback] that handles the milter command code or 0 when code is no callback.
+func callbackForCode(code wire.Code) Callback {
+	switch code {
+	case wire.CodeConn:
+		return CallbackConnect
+	case wire.CodeHelo:
+		return CallbackHelo
+	case wire.CodeMail:
+		return CallbackMailFrom
+	case wire.CodeRcpt:
+		return CallbackRcptTo
+	case wire.CodeData:
+		return CallbackData
+	case wire.CodeHeader:
+		return CallbackHeader
+	case wire.CodeEOH:
+		return CallbackHeaders
+	case wire.CodeBody:
+		return CallbackBodyChunk
+	case wire.CodeEOB:
+		return CallbackEndOfMessage
+	case wire.CodeAbort:
+		return CallbackAbort
+	case wire.CodeUnknown:
+		return CallbackUnknown
+	default:
+		return 0
+	}
+}
+
+func (m *serverSession) eventBase() EventBase {
+	var remoteAddr net.Addr
+	if m.conn != nil {
+		remoteAddr = m.conn.RemoteAddr()
+	}
+	return EventBase{Time: time.Now(), RemoteAddr: remoteAddr, ConnectedAt: m.connectedAt}
+}
+
+// startEvents starts the goroutine that delivers the events of this connection to the hook of WithEventHook.
+func (m *serverSession) startEvents() {
+	hook := m.server.options.eventHook
+	if hook == nil || m.server.options.eventQueueSize == 0 {
+		return
+	}
+	m.events = make(chan Event, m.server.options.eventQueueSize)
+	go func(events <-chan Event) {
+		for e := range events {
+			hook(e)
+		}
+	}(m.events)
+}
+
+// emitEvent queues e for the hook of WithEventHook. It drops e when the queue is full.
+func (m *serverSession) emitEvent(e Event) {
+	hook := m.server.options.eventHook
+	if hook == nil {
+		return
+	}
+	if m.events == nil {
+		hook(e)
+		return
+	}
+	select {
+	case m.events <- e:
+	default:
+		m.droppedEvents++
+	}
+}
+
+// stopEvents emits the SessionEnded event and stops the delivery goroutine after it delivered all queued events.
+func (m *serverSession) stopEvents(reason error) {
+	hook := m.server.options.eventHook
+	if hook == nil {
+		return
+	}
+	e := SessionEnded{EventBase: m.eventBase(), Reason: reason, DroppedEvents: m.droppedEvents}
+	if m.events == nil {
+		hook(e)
+		return
+	}
+	// the last event does not get dropped
+	m.events <- e
+	close(m.events)
+	m.events = nil
+}
+
+// emitModification emits a ModificationApplied event for the modification action msg that got sent to the MTA.
+func (m *serverSession) emitModification(msg *wire.Message) {
+	act, err := parseModifyAct(msg)
+	if err != nil {
+		return
+	}
+	m.emitEvent(ModificationApplied{EventBase: m.eventBase(), Modification: *act})
+}
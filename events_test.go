@@ -0,0 +1,162 @@
+package milter
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+func eventsTestMilter() *MockMilter {
+	return &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			_ = m.AddHeader("X-Test", "1")
+		},
+	}
+}
+
+func sendEventsTestTransaction(t *testing.T, w serverClientWrap) {
+	t.Helper()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("localhost")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	hdr := textproto.Header{}
+	hdr.Add("Subject", "test")
+	act, err = w.session.Header(hdr)
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(strings.NewReader("test\r\n"))
+	assertAction(t, act, err, ActionAccept)
+	w.Cleanup()
+}
+
+func eventNames(events []Event) []string {
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		switch e := e.(type) {
+		case ConnectionAccepted:
+			names = append(names, "accepted")
+		case StageCompleted:
+			names = append(names, e.Stage.String())
+		case ModificationApplied:
+			names = append(names, "modification")
+		case SessionEnded:
+			names = append(names, "ended")
+		}
+	}
+	return names
+}
+
+func TestWithEventHook(t *testing.T) {
+	t.Parallel()
+	want := "accepted connect helo mail-from rcpt-to data header headers body-chunk modification end-of-message ended"
+	for _, tt := range []struct {
+		name      string
+		queueSize int
+	}{{"direct", 0}, {"queued", defaultEventQueueSize}} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			var events []Event
+			ended := make(chan struct{})
+			w := newServerClient(t, nil, []Option{WithActions(OptAddHeader), WithMilter(func() Milter {
+				return eventsTestMilter()
+			}), WithEventQueueSize(tt.queueSize), WithEventHook(func(e Event) {
+				mu.Lock()
+				events = append(events, e)
+				mu.Unlock()
+				if _, ok := e.(SessionEnded); ok {
+					close(ended)
+				}
+			})}, nil)
+			sendEventsTestTransaction(t, w)
+			select {
+			case <-ended:
+			case <-time.After(time.Second):
+				t.Fatal("did not get SessionEnded event")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if got := strings.Join(eventNames(events), " "); got != want {
+				t.Fatalf("events = %s, want %s", got, want)
+			}
+			connectedAt := events[0].Base().ConnectedAt
+			for _, e := range events {
+				if e.Base().ConnectedAt != connectedAt || e.Base().RemoteAddr == nil || e.Base().Time.IsZero() {
+					t.Errorf("unexpected event base %+v", e.Base())
+				}
+				switch e := e.(type) {
+				case ConnectionAccepted:
+					if e.LocalAddr == nil {
+						t.Error("ConnectionAccepted.LocalAddr is nil")
+					}
+				case StageCompleted:
+					if e.Err != nil || e.Decision == nil {
+						t.Errorf("%s: Decision = %v, Err = %v", e.Stage, e.Decision, e.Err)
+					}
+					if e.Stage == CallbackEndOfMessage && e.Decision != RespAccept {
+						t.Errorf("end-of-message: Decision = %v", e.Decision)
+					}
+				case ModificationApplied:
+					if e.Modification.Type != ActionAddHeader || e.Modification.HeaderName != "X-Test" {
+						t.Errorf("Modification = %+v", e.Modification)
+					}
+				case SessionEnded:
+					if e.Reason != nil || e.DroppedEvents != 0 {
+						t.Errorf("SessionEnded = %+v", e)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWithEventHook_drop(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	ended := make(chan SessionEnded, 1)
+	w := newServerClient(t, nil, []Option{WithActions(OptAddHeader), WithMilter(func() Milter {
+		return eventsTestMilter()
+	}), WithEventQueueSize(1), WithEventHook(func(e Event) {
+		if e, ok := e.(SessionEnded); ok {
+			ended <- e
+			return
+		}
+		<-release
+	})}, nil)
+	sendEventsTestTransaction(t, w)
+	close(release)
+	select {
+	case e := <-ended:
+		if e.DroppedEvents == 0 {
+			t.Error("SessionEnded.DroppedEvents = 0, want dropped events")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not get SessionEnded event")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithEventHook did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithEventHook(func(Event) {}))
+}
@@ -0,0 +1,132 @@
+// Package geoip implements a [milter.Middleware] that rejects SMTP clients from blocked countries.
+// It uses a MaxMind GeoLite2 (or GeoIP2) country or city database.
+//
+// It is a separate module so that the go-milter module does not depend on a MaxMind DB reader.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/d--j/go-milter"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// countryRecord is the part of a GeoLite2 record that the Middleware needs
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Middleware is a [milter.Middleware] that rejects SMTP connections at [milter.Milter.Connect] when the client IP address
+// is located in a blocked country. Use [NewMiddleware] to create it and share one Middleware between all connections.
+//
+// The checked address is the client address the MTA sends in the connect event (not the address of the milter connection).
+// Connections that are not over IPv4 or IPv6 and addresses that are not in the database do not get checked.
+// The country is the country of the database record or (when the record does not have one) its registered country.
+// Failed lookups count as not blocked and get logged with [milter.LogWarning].
+type Middleware struct {
+	db                    *maxminddb.Reader
+	blocked               map[string]bool
+	exemptIfAuthenticated bool
+}
+
+// Option configures a [Middleware].
+type Option func(m *Middleware)
+
+// WithExemptIfAuthenticated makes the [Middleware] let clients from blocked countries send mail when they authenticated
+// (SMTP AUTH), e.g. your own users while they travel.
+//
+// The client authenticates after the connect event, so the [Middleware] cannot reject the connection at
+// [milter.Milter.Connect]. It rejects every recipient of an unauthenticated client instead.
+// It uses the [milter.MacroAuthAuthen] macro to check whether the client authenticated, your MTA needs to send it at the MAIL FROM stage
+// (Postfix and Sendmail do this by default).
+func WithExemptIfAuthenticated(exempt bool) Option {
+	return func(m *Middleware) {
+		m.exemptIfAuthenticated = exempt
+	}
+}
+
+// NewMiddleware creates a new [Middleware] that looks up client addresses in db and rejects
+// clients from blockedCountries (ISO 3166-1 alpha-2 codes like "KP", compared case-insensitive).
+func NewMiddleware(db *maxminddb.Reader, blockedCountries []string, opts ...Option) milter.Middleware {
+	m := &Middleware{
+		db:      db,
+		blocked: make(map[string]bool, len(blockedCountries)),
+	}
+	for _, c := range blockedCountries {
+		m.blocked[strings.ToUpper(strings.TrimSpace(c))] = true
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of the IP address addr.
+// It returns the empty string when addr is not in the database.
+func (m *Middleware) Country(addr string) (string, error) {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return "", fmt.Errorf("geoip: invalid IP address %q", addr)
+	}
+	var record countryRecord
+	if err := m.db.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode, nil
+	}
+	return record.RegisteredCountry.ISOCode, nil
+}
+
+// Wrap wraps next so that the country of the client address gets checked before next gets called.
+func (m *Middleware) Wrap(next milter.Milter) milter.Milter {
+	return &geoipMilter{Milter: next, middleware: m}
+}
+
+type geoipMilter struct {
+	milter.Milter
+	middleware *Middleware
+	// blockedAddr and blockedCountry are the client address and its country when the client needs to authenticate
+	blockedAddr, blockedCountry string
+}
+
+func (g *geoipMilter) Connect(host string, family string, port uint16, addr string, m *milter.Modifier) (*milter.Response, error) {
+	g.blockedAddr, g.blockedCountry = "", ""
+	if (family == "tcp4" || family == "tcp6") && len(g.middleware.blocked) > 0 {
+		country, err := g.middleware.Country(addr)
+		if err != nil {
+			milter.LogWarning("geoip: lookup of %s failed: %v", addr, err)
+		} else if g.middleware.blocked[country] {
+			if !g.middleware.exemptIfAuthenticated {
+				return reject(554, fmt.Sprintf("5.7.1 Service unavailable; client [%s] is in a blocked country (%s)", addr, country)), nil
+			}
+			g.blockedAddr, g.blockedCountry = addr, country
+		}
+	}
+	return g.Milter.Connect(host, family, port, addr, m)
+}
+
+func (g *geoipMilter) RcptTo(rcptTo string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+	if g.blockedCountry != "" && (m == nil || m.Macros.Get(milter.MacroAuthAuthen) == "") {
+		return reject(550, fmt.Sprintf("5.7.1 Client [%s] is in a blocked country (%s), authentication required", g.blockedAddr, g.blockedCountry)), nil
+	}
+	return g.Milter.RcptTo(rcptTo, esmtpArgs, m)
+}
+
+// reject returns a rejection with the SMTP code and reason or [milter.RespReject] when they are invalid
+func reject(code uint16, reason string) *milter.Response {
+	resp, err := milter.RejectWithCodeAndReason(code, reason)
+	if err != nil {
+		return milter.RespReject
+	}
+	return resp
+}
+
+var _ milter.Middleware = (*Middleware)(nil)
@@ -0,0 +1,225 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/d--j/go-milter"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbString encodes s as UTF-8 string of the MaxMind DB data section (only for len(s) < 29)
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// mmdbUint16 encodes v as uint16 of the MaxMind DB data section
+func mmdbUint16(v uint16) []byte {
+	return []byte{5<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+// mmdbUint32 encodes v as uint32 of the MaxMind DB data section
+func mmdbUint32(v uint32) []byte {
+	b := []byte{6<<5 | 4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], v)
+	return b
+}
+
+// mmdbMap encodes the key value pairs kv as map of the MaxMind DB data section (only for less than 29 pairs)
+func mmdbMap(kv ...[]byte) []byte {
+	b := []byte{7<<5 | byte(len(kv)/2)}
+	for _, e := range kv {
+		b = append(b, e...)
+	}
+	return b
+}
+
+// newTestDB creates an IPv4 MaxMind DB with record size 24 that maps the networks (CIDR notation) to the country records.
+func newTestDB(t *testing.T, networks map[string][]byte) *maxminddb.Reader {
+	t.Helper()
+	type node struct{ left, right uint32 }
+	const (
+		empty = ^uint32(0) // no data, gets replaced with the node count
+		child = 1 << 31    // flag for a reference to another node
+	)
+	nodes := []node{{empty, empty}}
+	var data []byte
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		dataOffset := uint32(len(data))
+		data = append(data, record...)
+		n := 0
+		for i := 0; i < ones; i++ {
+			right := ip[i/8]>>(7-i%8)&1 == 1
+			rec := nodes[n].left
+			if right {
+				rec = nodes[n].right
+			}
+			switch {
+			case i == ones-1:
+				rec = dataOffset
+			case rec == empty:
+				nodes = append(nodes, node{empty, empty})
+				rec = child | uint32(len(nodes)-1)
+			}
+			if right {
+				nodes[n].right = rec
+			} else {
+				nodes[n].left = rec
+			}
+			n = int(rec &^ child)
+		}
+	}
+	nodeCount := uint32(len(nodes))
+	resolve := func(rec uint32) uint32 {
+		switch {
+		case rec == empty:
+			return nodeCount
+		case rec&child != 0:
+			return rec &^ child
+		default:
+			return nodeCount + 16 + rec
+		}
+	}
+	var db bytes.Buffer
+	for _, n := range nodes {
+		l, r := resolve(n.left), resolve(n.right)
+		db.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data)
+	db.WriteString("\xAB\xCD\xEFMaxMind.com")
+	db.Write(mmdbMap(
+		mmdbString("node_count"), mmdbUint32(nodeCount),
+		mmdbString("record_size"), mmdbUint16(24),
+		mmdbString("ip_version"), mmdbUint16(4),
+		mmdbString("database_type"), mmdbString("Test-Country"),
+		mmdbString("binary_format_major_version"), mmdbUint16(2),
+	))
+	reader, err := maxminddb.FromBytes(db.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func countryRecordData(field, isoCode string) []byte {
+	return mmdbMap(mmdbString(field), mmdbMap(mmdbString("iso_code"), mmdbString(isoCode)))
+}
+
+func newTestMiddleware(t *testing.T, opts ...Option) *Middleware {
+	t.Helper()
+	db := newTestDB(t, map[string][]byte{
+		"192.0.2.0/24":    countryRecordData("country", "KP"),
+		"198.51.100.0/24": countryRecordData("country", "DE"),
+		"203.0.113.0/25":  countryRecordData("registered_country", "IR"),
+	})
+	return NewMiddleware(db, []string{"kp", "IR"}, opts...).(*Middleware)
+}
+
+func newModifier(authenticated bool) *milter.Modifier {
+	macros := milter.NewMacroBag()
+	if authenticated {
+		macros.Set(milter.MacroAuthAuthen, "user")
+	}
+	return milter.NewTestModifier(macros, nil, nil, 0, milter.DataSize64K)
+}
+
+func TestMiddleware_Country(t *testing.T) {
+	m := newTestMiddleware(t)
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{"192.0.2.1", "KP", false},
+		{"[198.51.100.200]", "DE", false},
+		{"203.0.113.1", "IR", false},
+		{"203.0.113.200", "", false},
+		{"127.0.0.1", "", false},
+		{"::1", "", true},
+		{"invalid", "", true},
+	}
+	for _, tt := range tests {
+		got, err := m.Country(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Country(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("Country(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestMiddleware_Connect(t *testing.T) {
+	m := newTestMiddleware(t)
+	tests := []struct {
+		name   string
+		family string
+		addr   string
+		want   string
+	}{
+		{"blocked", "tcp4", "192.0.2.1", "554 5.7.1 Service unavailable; client [192.0.2.1] is in a blocked country (KP)"},
+		{"registered country blocked", "tcp4", "203.0.113.1", "554 5.7.1 Service unavailable; client [203.0.113.1] is in a blocked country (IR)"},
+		{"allowed", "tcp4", "198.51.100.1", ""},
+		{"unknown", "tcp4", "127.0.0.1", ""},
+		{"lookup error", "tcp6", "::1", ""},
+		{"unix socket", "unix", "/var/run/smtp.sock", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := m.Wrap(milter.NoOpMilter{}).Connect("host", tt.family, 25, tt.addr, newModifier(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if resp != milter.RespContinue {
+					t.Errorf("Connect() = %v, want continue", resp)
+				}
+				return
+			}
+			if !strings.Contains(resp.String(), `code=554 reason="`+tt.want+`"`) {
+				t.Errorf("Connect() = %v, want %q", resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_ExemptIfAuthenticated(t *testing.T) {
+	m := newTestMiddleware(t, WithExemptIfAuthenticated(true))
+	t.Run("blocked country", func(t *testing.T) {
+		wrapped := m.Wrap(milter.NoOpMilter{})
+		if resp, err := wrapped.Connect("host", "tcp4", 25, "192.0.2.1", newModifier(false)); resp != milter.RespContinue || err != nil {
+			t.Fatalf("Connect() = %v, %v", resp, err)
+		}
+		resp, err := wrapped.RcptTo("to@example.com", "", newModifier(false))
+		if err != nil || !strings.Contains(resp.String(), "code=550") || !strings.Contains(resp.String(), "authentication required") {
+			t.Errorf("RcptTo() without authentication = %v, %v", resp, err)
+		}
+		// the rejection of a recipient does not end the transaction, every recipient gets rejected
+		resp, err = wrapped.RcptTo("other@example.com", "", newModifier(false))
+		if err != nil || !strings.Contains(resp.String(), "code=550") {
+			t.Errorf("second RcptTo() without authentication = %v, %v", resp, err)
+		}
+		if resp, err := wrapped.RcptTo("to@example.com", "", newModifier(true)); resp != milter.RespContinue || err != nil {
+			t.Errorf("RcptTo() with authentication = %v, %v", resp, err)
+		}
+	})
+	t.Run("allowed country", func(t *testing.T) {
+		wrapped := m.Wrap(milter.NoOpMilter{})
+		if resp, err := wrapped.Connect("host", "tcp4", 25, "198.51.100.1", newModifier(false)); resp != milter.RespContinue || err != nil {
+			t.Fatalf("Connect() = %v, %v", resp, err)
+		}
+		if resp, err := wrapped.RcptTo("to@example.com", "", newModifier(false)); resp != milter.RespContinue || err != nil {
+			t.Errorf("RcptTo() = %v, %v", resp, err)
+		}
+	})
+}
@@ -0,0 +1,16 @@
+module github.com/d--j/go-milter/geoip
+
+go 1.18
+
+replace github.com/d--j/go-milter => ../
+
+require (
+	github.com/d--j/go-milter v0.8.2
+	github.com/oschwald/maxminddb-golang v1.10.0
+)
+
+require (
+	github.com/emersion/go-message v0.16.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+)
@@ -0,0 +1,112 @@
+package milter
+
+import (
+	"strings"
+)
+
+// HeloMismatchDetector is a [Middleware] that temporarily rejects SMTP connections whose HELO/EHLO hostname differs
+// from the reverse DNS name of the client ([MacroClientName]).
+// Use [NewHeloMismatchDetector] to create it.
+//
+// The hostnames are compared case-insensitive and without a trailing dot. If they differ by more than the
+// Levenshtein distance threshold (see [WithLevenshteinThreshold]) the wrapped [Milter] does not get the Helo call
+// and the MTA gets a [RespTempFail] response instead.
+//
+// When the MTA does not send the [MacroClientName] macro at the HELO stage, or the client has no reverse DNS name
+// (the macro is empty, "unknown" or an address literal like "[192.0.2.1]") no comparison is done.
+// You need to request [MacroClientName] at [StageHelo] (or [StageConnect]) with [WithMacroRequest] for this middleware to work.
+type HeloMismatchDetector struct {
+	threshold int
+}
+
+// HeloMismatchOption configures a [HeloMismatchDetector].
+type HeloMismatchOption func(d *HeloMismatchDetector)
+
+// WithLevenshteinThreshold sets the maximum Levenshtein distance between the HELO hostname and the reverse DNS name
+// of the client that the [HeloMismatchDetector] accepts. 0 means both names need to be equal.
+// The default threshold is 2.
+func WithLevenshteinThreshold(n int) HeloMismatchOption {
+	return func(d *HeloMismatchDetector) {
+		if n < 0 {
+			n = 0
+		}
+		d.threshold = n
+	}
+}
+
+// NewHeloMismatchDetector creates a new [HeloMismatchDetector].
+func NewHeloMismatchDetector(opts ...HeloMismatchOption) *HeloMismatchDetector {
+	d := &HeloMismatchDetector{threshold: 2}
+	for _, o := range opts {
+		o(d)
+	}
+	return d
+}
+
+// Wrap wraps next so that the HELO hostname gets checked before next gets called.
+func (d *HeloMismatchDetector) Wrap(next Milter) Milter {
+	return &heloMismatchMilter{Milter: next, detector: d}
+}
+
+// Mismatch returns true when helo and clientName differ more than the configured threshold.
+// It returns false when clientName is not a hostname.
+func (d *HeloMismatchDetector) Mismatch(helo, clientName string) bool {
+	clientName = normalizeHostname(clientName)
+	if clientName == "" || clientName == "unknown" || clientName[0] == '[' {
+		return false
+	}
+	return levenshtein(normalizeHostname(helo), clientName) > d.threshold
+}
+
+func normalizeHostname(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// levenshtein calculates the Levenshtein distance between a and b (in runes)
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type heloMismatchMilter struct {
+	Milter
+	detector *HeloMismatchDetector
+}
+
+func (h *heloMismatchMilter) Helo(name string, m *Modifier) (*Response, error) {
+	if m != nil && h.detector.Mismatch(name, m.Macros.Get(MacroClientName)) {
+		return RespTempFail, nil
+	}
+	return h.Milter.Helo(name, m)
+}
+
+var _ Middleware = (*HeloMismatchDetector)(nil)
@@ -0,0 +1,77 @@
+package milter
+
+import (
+	"testing"
+)
+
+func Test_levenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"mail.example.com", "mail.example.com", 0},
+		{"mail.example.com", "mail1.example.com", 1},
+		{"mäil", "mail", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestHeloMismatchDetector(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		opts       []HeloMismatchOption
+		helo       string
+		clientName string
+		want       ActionType
+	}{
+		{"exact match", nil, "mail.example.com", "mail.example.com", ActionContinue},
+		{"case and trailing dot", nil, "Mail.Example.COM", "mail.example.com.", ActionContinue},
+		{"close match", nil, "mail1.example.com", "mail.example.com", ActionContinue},
+		{"close match with threshold 0", []HeloMismatchOption{WithLevenshteinThreshold(0)}, "mail1.example.com", "mail.example.com", ActionTempFail},
+		{"mismatch", nil, "localhost", "mail.example.com", ActionTempFail},
+		{"wildcard mismatch", nil, "mail.example.com", "dynamic-192-0-2-1.pool.example.net", ActionTempFail},
+		{"mismatch with high threshold", []HeloMismatchOption{WithLevenshteinThreshold(100)}, "localhost", "mail.example.com", ActionContinue},
+		{"no PTR", nil, "mail.example.com", "unknown", ActionContinue},
+		{"address literal", nil, "mail.example.com", "[192.0.2.1]", ActionContinue},
+		{"no macro", nil, "mail.example.com", "", ActionContinue},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			macros := NewMacroBag()
+			if tt.clientName != "" {
+				macros.Set(MacroClientName, tt.clientName)
+			}
+			detector := NewHeloMismatchDetector(tt.opts...)
+			heloCalled := false
+			mm := MockMilter{
+				ConnResp: RespContinue,
+				HeloResp: RespContinue,
+				HeloMod: func(m *Modifier) {
+					heloCalled = true
+				},
+			}
+			w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+				return detector.Wrap(&mm)
+			})}, []Option{WithMacroRequest(StageHelo, []MacroName{MacroClientName})})
+			defer w.Cleanup()
+			act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo(tt.helo)
+			assertAction(t, act, err, tt.want)
+			if heloCalled != (tt.want == ActionContinue) {
+				t.Errorf("wrapped Helo called = %v", heloCalled)
+			}
+		})
+	}
+}
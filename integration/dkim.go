@@ -0,0 +1,161 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The DKIM key of the integration tests. It is not published in DNS, [VerifyDKIM] uses it directly.
+const (
+	dkimDomain   = "example.com"
+	dkimSelector = "integration"
+)
+
+var dkimKey = ed25519.NewKeyFromSeed([]byte("go-milter integration DKIM key!!"))
+
+// dkimSignedHeaders are the header fields that [SignDKIM] signs.
+var dkimSignedHeaders = []string{"from", "to", "subject", "date", "message-id"}
+
+// SignDKIM signs the message header and body with the ed25519-sha256 DKIM key of the integration tests
+// (relaxed/relaxed canonicalization) and returns header with a DKIM-Signature field in front of it.
+func SignDKIM(header, body []byte) []byte {
+	fields := splitHeaderFields(header)
+	bh := sha256.Sum256(dkimRelaxedBody(body))
+	sig := fmt.Sprintf("DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=%s; s=%s;\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+		dkimDomain, dkimSelector, strings.Join(dkimSignedHeaders, ":"), base64.StdEncoding.EncodeToString(bh[:]))
+	data := dkimSignedData(fields, dkimSignedHeaders, []byte(sig))
+	hash := sha256.Sum256(data)
+	signed := make([]byte, 0, len(header)+len(sig)+100)
+	signed = append(signed, sig...)
+	signed = append(signed, base64.StdEncoding.EncodeToString(ed25519.Sign(dkimKey, hash[:]))...)
+	signed = append(signed, "\r\n"...)
+	return append(signed, header...)
+}
+
+var dkimTagB = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// VerifyDKIM verifies the first DKIM-Signature field of header with the DKIM key of the integration tests.
+// It returns an error when there is no such field or when the signature does not match header and body.
+func VerifyDKIM(header, body []byte) error {
+	fields := splitHeaderFields(header)
+	var sigField []byte
+	for _, f := range fields {
+		if strings.EqualFold(headerFieldName(f), "DKIM-Signature") {
+			sigField = f
+			break
+		}
+	}
+	if sigField == nil {
+		return errors.New("dkim: no DKIM-Signature header field")
+	}
+	_, value, _ := bytes.Cut(sigField, []byte(":"))
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(string(value), ";") {
+		name, val, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(val), "")
+	}
+	if tags["a"] != "ed25519-sha256" || tags["c"] != "relaxed/relaxed" || tags["d"] != dkimDomain || tags["s"] != dkimSelector {
+		return fmt.Errorf("dkim: unexpected DKIM-Signature %q", sigField)
+	}
+	bh := sha256.Sum256(dkimRelaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("dkim: body hash does not match")
+	}
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("dkim: invalid signature: %w", err)
+	}
+	unsigned := dkimTagB.ReplaceAll(sigField, []byte("$1$2"))
+	data := dkimSignedData(fields, strings.Split(tags["h"], ":"), unsigned)
+	hash := sha256.Sum256(data)
+	if !ed25519.Verify(dkimKey.Public().(ed25519.PublicKey), hash[:], signature) {
+		return errors.New("dkim: signature does not match header")
+	}
+	return nil
+}
+
+// dkimSignedData returns the data that DKIM signs: the signed header fields and the DKIM-Signature field
+// (with an empty b= tag and without trailing CRLF) in relaxed canonicalization.
+func dkimSignedData(fields [][]byte, signedHeaders []string, sigField []byte) []byte {
+	var data []byte
+	used := make(map[int]bool)
+	for _, name := range signedHeaders {
+		// multiple fields with the same name get used from the bottom to the top
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headerFieldName(fields[i]), name) {
+				used[i] = true
+				data = append(data, dkimRelaxedHeader(fields[i])...)
+				break
+			}
+		}
+	}
+	sig := dkimRelaxedHeader(sigField)
+	return append(data, sig[:len(sig)-2]...)
+}
+
+// splitHeaderFields splits header into its raw fields (including the CRLF of every field).
+func splitHeaderFields(header []byte) [][]byte {
+	var fields [][]byte
+	for len(header) > 0 {
+		end := 0
+		for {
+			i := bytes.Index(header[end:], []byte("\r\n"))
+			if i < 0 {
+				end = len(header)
+				break
+			}
+			end += i + 2
+			if end == len(header) || (header[end] != ' ' && header[end] != '\t') {
+				break
+			}
+		}
+		if f := header[:end]; len(bytes.TrimSpace(f)) > 0 {
+			fields = append(fields, f)
+		}
+		header = header[end:]
+	}
+	return fields
+}
+
+func headerFieldName(field []byte) string {
+	name, _, _ := bytes.Cut(field, []byte(":"))
+	return string(bytes.TrimSpace(name))
+}
+
+var dkimWSP = regexp.MustCompile(`[ \t]+`)
+
+// dkimRelaxedHeader canonicalizes field with the relaxed header canonicalization of RFC 6376 section 3.4.2.
+func dkimRelaxedHeader(field []byte) []byte {
+	name, value, _ := bytes.Cut(field, []byte(":"))
+	value = bytes.ReplaceAll(value, []byte("\r\n"), nil)
+	value = bytes.TrimSpace(dkimWSP.ReplaceAll(value, []byte(" ")))
+	out := append([]byte(strings.ToLower(string(bytes.TrimSpace(name)))), ':')
+	out = append(out, value...)
+	return append(out, "\r\n"...)
+}
+
+// dkimRelaxedBody canonicalizes body with the relaxed body canonicalization of RFC 6376 section 3.4.4.
+func dkimRelaxedBody(body []byte) []byte {
+	lines := bytes.Split(body, []byte("\r\n"))
+	var out []byte
+	empty := 0
+	for _, line := range lines {
+		line = bytes.TrimRight(dkimWSP.ReplaceAll(line, []byte(" ")), " ")
+		if len(line) == 0 {
+			empty++
+			continue
+		}
+		for ; empty > 0; empty-- {
+			out = append(out, "\r\n"...)
+		}
+		out = append(out, line...)
+		out = append(out, "\r\n"...)
+	}
+	return out
+}
@@ -141,6 +141,11 @@ if [ "start" = "$1" ]; then
   mkdir "$SCRATCH_DIR/conf" "$SCRATCH_DIR/conf/sasl" "$SCRATCH_DIR/data" "$SCRATCH_DIR/queue" || die "could not create $SCRATCH_DIR/{conf,data,queue}"
   render_template <"$SCRIPT_DIR/main.cf" >"$SCRATCH_DIR/conf/main.cf" || die "could not create $SCRATCH_DIR/conf/main.cf"
   render_template <"$SCRIPT_DIR/master.cf" >"$SCRATCH_DIR/conf/master.cf" || die "could not create $SCRATCH_DIR/conf/master.cf"
+  if [ -n "$MTA_CONFIG" ]; then
+    while IFS= read -r setting; do
+      postconf -c "$SCRATCH_DIR/conf" -e "$setting" || die "could not set $setting"
+    done <"$MTA_CONFIG"
+  fi
   cp "$SCRIPT_DIR/dhparam.pem" "$SCRATCH_DIR/conf/dhparam.pem" || die "could not create $SCRATCH_DIR/conf/dhparam.pem"
   cp "$SCRATCH_DIR/../cert.pem" "$SCRATCH_DIR/conf/cert.pem" || die "could not create $SCRATCH_DIR/conf/cert.pem"
   cp "$SCRATCH_DIR/../key.pem" "$SCRATCH_DIR/conf/key.pem" || die "could not create $SCRATCH_DIR/conf/key.pem"
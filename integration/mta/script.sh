@@ -35,6 +35,11 @@ parse_args() {
       shift
       shift
       ;;
+    -mtaConfig)
+      MTA_CONFIG="$2"
+      shift
+      shift
+      ;;
     *)
       usage "unknown argument $1"
       ;;
@@ -43,7 +48,7 @@ parse_args() {
   if [ -z "$MTA_PORT" ] || [ -z "$MILTER_PORT" ] || [ -z "$RECEIVER_PORT" ] || [ -z "$SCRATCH_DIR" ]; then
     usage "missing required arguments"
   fi
-  export MTA_PORT MILTER_PORT RECEIVER_PORT SCRATCH_DIR
+  export MTA_PORT MILTER_PORT RECEIVER_PORT SCRATCH_DIR MTA_CONFIG
 }
 
 render_template() {
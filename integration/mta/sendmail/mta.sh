@@ -23,6 +23,10 @@ if [ "start" = "$1" ]; then
   cp "$SCRATCH_DIR/../cert.pem" "$SCRATCH_DIR/cert.pem" || die "could not create $SCRATCH_DIR/cert.pem"
   cp "$SCRATCH_DIR/../key.pem" "$SCRATCH_DIR/key.pem" || die "could not create $SCRATCH_DIR/key.pem"
   render_template <"$SCRIPT_DIR/sendmail.cf" >"$SCRATCH_DIR/sendmail.cf" || die "could not create $SCRATCH_DIR/sendmail.cf"
+  if [ -n "$MTA_CONFIG" ]; then
+    # later option lines override the defaults
+    sed 's/^/O /' "$MTA_CONFIG" >>"$SCRATCH_DIR/sendmail.cf" || die "could not add MTA config to $SCRATCH_DIR/sendmail.cf"
+  fi
   mkdir "${SCRATCH_DIR}/mqueue" || die "could not create $SCRATCH_DIR/mqueue"
   sudo -n -- chown smmta:smmsp "${SCRATCH_DIR}/mqueue" || die "could not chown $SCRATCH_DIR/mqueue"
   sudo -n -- chmod u=rwx,g=rs,o= "${SCRATCH_DIR}/mqueue" || die "could not chmod $SCRATCH_DIR/mqueue"
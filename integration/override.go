@@ -0,0 +1,116 @@
+package integration
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/d--j/go-milter"
+)
+
+// Override forces a [milter.Milter] wrapped with [NewOverrideMilter] to respond with Response at Step.
+type Override struct {
+	// Step is the SMTP step at which Response should be sent. [StepAny] matches every step.
+	Step DecisionStep
+	// N is the 1-based number of the call at Step in the current SMTP transaction
+	// (e.g. Step [StepTo] and N 2 overrides the response to the second RCPT TO).
+	// 0 matches every call at Step.
+	N int
+	// Response is the response that gets sent to the MTA instead of the response of the wrapped [milter.Milter].
+	Response *milter.Response
+}
+
+type overrideMilter struct {
+	milter.Milter
+	overrides []Override
+	calls     map[DecisionStep]int
+}
+
+// NewOverrideMilter wraps next so that the responses at the steps of overrides get replaced.
+// The first matching [Override] wins. The wrapped [milter.Milter] still gets called, so it sees the
+// whole SMTP transaction up to the point where the MTA stops it.
+//
+// This is meant for integration tests of the MTA handling of the different decisions.
+func NewOverrideMilter(next milter.Milter, overrides ...Override) milter.Milter {
+	return &overrideMilter{Milter: next, overrides: overrides, calls: make(map[DecisionStep]int)}
+}
+
+func (o *overrideMilter) override(step DecisionStep, resp *milter.Response, err error) (*milter.Response, error) {
+	o.calls[step]++
+	if err != nil {
+		return resp, err
+	}
+	for _, ov := range o.overrides {
+		if (ov.Step == StepAny || ov.Step == step) && (ov.N == 0 || ov.N == o.calls[step]) {
+			return ov.Response, nil
+		}
+	}
+	return resp, nil
+}
+
+func (o *overrideMilter) resetCalls() {
+	for step := range o.calls {
+		if step != StepHelo {
+			delete(o.calls, step)
+		}
+	}
+}
+
+func (o *overrideMilter) Helo(name string, m *milter.Modifier) (*milter.Response, error) {
+	resp, err := o.Milter.Helo(name, m)
+	return o.override(StepHelo, resp, err)
+}
+
+func (o *overrideMilter) MailFrom(from string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+	o.resetCalls()
+	resp, err := o.Milter.MailFrom(from, esmtpArgs, m)
+	return o.override(StepFrom, resp, err)
+}
+
+func (o *overrideMilter) RcptTo(rcptTo string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+	resp, err := o.Milter.RcptTo(rcptTo, esmtpArgs, m)
+	return o.override(StepTo, resp, err)
+}
+
+func (o *overrideMilter) Data(m *milter.Modifier) (*milter.Response, error) {
+	resp, err := o.Milter.Data(m)
+	return o.override(StepData, resp, err)
+}
+
+func (o *overrideMilter) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
+	resp, err := o.Milter.EndOfMessage(m)
+	return o.override(StepEOM, resp, err)
+}
+
+func (o *overrideMilter) Abort(m *milter.Modifier) error {
+	o.resetCalls()
+	return o.Milter.Abort(m)
+}
+
+var _ milter.Milter = (*overrideMilter)(nil)
+
+// TestOverrides is like [Test] but instead of a mail filter it starts a milter server that
+// continues/accepts everything except at the steps of overrides.
+// Use this to check how the MTA handles the different decisions at the different steps.
+func TestOverrides(overrides ...Override) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if Network == nil || *Network == "" {
+		log.Fatal("no network specified")
+	}
+	if Address == nil || *Address == "" {
+		log.Fatal("no address specified")
+	}
+	socket, err := net.Listen(*Network, *Address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := milter.NewServer(milter.WithMilter(func() milter.Milter {
+		return NewOverrideMilter(milter.NoOpMilter{}, overrides...)
+	}))
+	log.Printf("Started milter on %s:%s", socket.Addr().Network(), socket.Addr().String())
+	if err := server.Serve(socket); err != nil && err != milter.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
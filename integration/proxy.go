@@ -0,0 +1,47 @@
+package integration
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/d--j/go-milter"
+)
+
+// TestProxy is like [TestOverrides] but starts a milter server that chains to a fake downstream milter with
+// [milter.ProxyMilter]. The proxy wraps the milters of local, the downstream milter server uses the milters of downstream.
+// Both milter servers request actions.
+// Use this to check that the decisions and modifications of a downstream milter get relayed to the MTA.
+func TestProxy(local, downstream func() milter.Milter, actions milter.OptAction) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if Network == nil || *Network == "" {
+		log.Fatal("no network specified")
+	}
+	if Address == nil || *Address == "" {
+		log.Fatal("no address specified")
+	}
+	downstreamSocket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	downstreamServer := milter.NewServer(milter.WithMilter(downstream), milter.WithActions(actions))
+	go func() {
+		if err := downstreamServer.Serve(downstreamSocket); err != nil && err != milter.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	proxy := milter.NewProxyMilter(milter.NewClient("tcp", downstreamSocket.Addr().String()))
+	socket, err := net.Listen(*Network, *Address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := milter.NewServer(milter.WithActions(actions), milter.WithMilter(func() milter.Milter {
+		return proxy.Wrap(local())
+	}))
+	log.Printf("Started milter on %s:%s proxying to %s", socket.Addr().Network(), socket.Addr().String(), downstreamSocket.Addr().String())
+	if err := server.Serve(socket); err != nil && err != milter.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/d--j/go-milter/integration"
+)
+
+// aliasFlag parses -alias flags of the form alias=target1,target2 into an alias map
+type aliasFlag map[string][]string
+
+func (a aliasFlag) String() string {
+	aliases := make([]string, 0, len(a))
+	for alias, targets := range a {
+		aliases = append(aliases, fmt.Sprintf("%s=%s", alias, strings.Join(targets, ",")))
+	}
+	sort.Strings(aliases)
+	return strings.Join(aliases, " ")
+}
+
+func (a aliasFlag) Set(value string) error {
+	alias, targets, ok := strings.Cut(value, "=")
+	alias = strings.TrimSpace(alias)
+	if !ok || alias == "" {
+		return errors.New("alias needs to be in the form alias@example.com=target1@example.com,target2@example.com")
+	}
+	for _, target := range strings.Split(targets, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			a[alias] = append(a[alias], target)
+		}
+	}
+	if len(a[alias]) == 0 {
+		return fmt.Errorf("alias %s has no targets", alias)
+	}
+	return nil
+}
+
+// expandAliases simulates the alias expansion of an MTA: every RCPT TO of an alias in aliasMap gets replaced with
+// RCPT TO commands for the targets of the alias and the header gets an X-Original-To field for the alias
+// (like Postfix adds it when it expands an alias).
+// It returns the new steps and the aliases that got expanded.
+func expandAliases(steps []*integration.InputStep, aliasMap map[string][]string) ([]*integration.InputStep, []string) {
+	if len(aliasMap) == 0 {
+		return steps, nil
+	}
+	var expanded, transactionExpanded []string
+	result := make([]*integration.InputStep, 0, len(steps))
+	for _, step := range steps {
+		switch step.What {
+		case "TO":
+			if targets, ok := aliasMap[step.Addr]; ok {
+				for _, target := range targets {
+					result = append(result, &integration.InputStep{What: "TO", Addr: target, Arg: step.Arg})
+				}
+				expanded = append(expanded, step.Addr)
+				transactionExpanded = append(transactionExpanded, step.Addr)
+				continue
+			}
+		case "RESET":
+			transactionExpanded = nil
+		case "HEADER":
+			if len(transactionExpanded) > 0 {
+				var data []byte
+				for _, alias := range transactionExpanded {
+					data = append(data, fmt.Sprintf("X-Original-To: %s\r\n", alias)...)
+				}
+				result = append(result, &integration.InputStep{What: "HEADER", Data: append(data, step.Data...)})
+				continue
+			}
+		}
+		result = append(result, step)
+	}
+	return result, expanded
+}
+
+// checkOriginalTo checks that header has an X-Original-To field for every alias in aliases.
+func checkOriginalTo(header []byte, aliases []string) (string, bool) {
+	if len(aliases) == 0 {
+		return "", true
+	}
+	h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(header))).ReadMIMEHeader()
+	if err != nil {
+		return err.Error(), false
+	}
+	values := h.Values("X-Original-To")
+outer:
+	for _, alias := range aliases {
+		for _, v := range values {
+			if strings.TrimSpace(v) == alias {
+				continue outer
+			}
+		}
+		return fmt.Sprintf("no X-Original-To: %s in %q", alias, values), false
+	}
+	return "", true
+}
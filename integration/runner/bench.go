@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchResult holds the measurements of one benchmarked testcase.
+type BenchResult struct {
+	Name      string
+	Messages  int
+	Failed    int
+	Wall      time.Duration
+	Latencies []time.Duration
+}
+
+// MsgsPerSecond returns the throughput of the benchmark.
+func (b *BenchResult) MsgsPerSecond() float64 {
+	if b.Wall <= 0 {
+		return 0
+	}
+	return float64(b.Messages) / b.Wall.Seconds()
+}
+
+// Avg returns the average latency of one SMTP transaction.
+func (b *BenchResult) Avg() time.Duration {
+	if len(b.Latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range b.Latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(b.Latencies))
+}
+
+// Percentile returns the p-th percentile (0 < p <= 100) latency of one SMTP transaction.
+func (b *BenchResult) Percentile(p float64) time.Duration {
+	if len(b.Latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(b.Latencies))
+	copy(sorted, b.Latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted))*p/100+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// String formats b as a line that benchstat understands.
+func (b *BenchResult) String() string {
+	nsPerOp := int64(0)
+	if b.Messages > 0 {
+		nsPerOp = b.Wall.Nanoseconds() / int64(b.Messages)
+	}
+	return fmt.Sprintf("%s\t%8d\t%12d ns/op\t%12.2f msgs/s\t%12d avg-ns/msg\t%12d p99-ns/msg", b.Name, b.Messages, nsPerOp, b.MsgsPerSecond(), b.Avg().Nanoseconds(), b.Percentile(99).Nanoseconds())
+}
+
+func benchName(dir *TestDir, t *TestCase, concurrency int) string {
+	mta := path.Base(path.Dir(dir.MTA.path))
+	name := strings.TrimSuffix(t.Filename, filepath.Ext(t.Filename))
+	return fmt.Sprintf("BenchmarkIntegration/%s/%s/%s-%d", mta, path.Base(dir.Path), name, concurrency)
+}
+
+// benchTestCase sends the testcase t r.config.Bench times with r.config.BenchConcurrency goroutines to the MTA.
+func (r *Runner) benchTestCase(dir *TestDir, t *TestCase) *BenchResult {
+	n, concurrency := r.config.Bench, r.config.BenchConcurrency
+	result := &BenchResult{
+		Name:      benchName(dir, t, concurrency),
+		Messages:  n,
+		Latencies: make([]time.Duration, n),
+	}
+	var next, failed int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := atomic.AddInt64(&next, 1) - 1
+				if i >= int64(n) {
+					return
+				}
+				// each message gets its own TestCase so that the SMTP logs do not get mixed up
+				c := &TestCase{Index: t.Index, Filename: t.Filename, TestCase: t.TestCase, parent: t.parent}
+				sendStart := time.Now()
+				code, message, step, err := c.Send(t.TestCase.InputSteps, dir.MTA.Port)
+				result.Latencies[i] = time.Since(sendStart)
+				_, rcptOk := c.CheckRecipients()
+				if err != nil || !t.TestCase.Decision.Compare(code, message, step) || !rcptOk {
+					if atomic.AddInt64(&failed, 1) == 1 {
+						LevelThreeLogger.Printf("NOK %s: %v %d %s @%s\nSMTP transaction:\n%s", t.Filename, err, code, message, step, c.smtpData.String())
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	result.Wall = time.Since(start)
+	result.Failed = int(failed)
+	return result
+}
+
+// Bench runs all testcases in benchmark mode and outputs the results in a benchstat compatible format.
+// Only the decision of the testcases gets checked, the output of the MTA gets ignored.
+func (r *Runner) Bench() bool {
+	var prevMta *MTA
+	var prevDir *TestDir
+	defer func() {
+		if prevDir != nil {
+			prevDir.Stop()
+		}
+		if prevMta != nil {
+			prevMta.Stop()
+		}
+	}()
+	r.receiver.DiscardMessages(true)
+	defer r.receiver.DiscardMessages(false)
+	ok := true
+	for _, dir := range r.config.TestDirs {
+		if prevMta != dir.MTA {
+			if prevMta != nil {
+				prevMta.Stop()
+			}
+			LevelOneLogger.Print(dir.MTA)
+			prevMta = dir.MTA
+			if err := dir.MTA.Start(); err != nil {
+				LevelTwoLogger.Printf("ERR starting MTA %v", err)
+				return false
+			}
+		}
+		prevDir = dir
+		LevelTwoLogger.Print(dir.Path)
+		if err := dir.Start(); err != nil {
+			if err == ErrTestSkipped {
+				for _, t := range dir.Tests {
+					t.MarkSkipped("SKIP %s", t.Filename)
+				}
+				dir.Stop()
+				continue
+			}
+			LevelTwoLogger.Printf("ERR starting milter %v", err)
+			return false
+		}
+		for _, t := range dir.Tests {
+			result := r.benchTestCase(dir, t)
+			// benchstat only looks at lines starting with "Benchmark", so we cannot use the level loggers here
+			fmt.Println(result.String())
+			if result.Failed > 0 {
+				ok = false
+				t.parent.MarkFailedTest()
+				t.State = TestFailed
+				LevelThreeLogger.Printf("NOK %s: %d of %d messages failed", t.Filename, result.Failed, result.Messages)
+			} else {
+				t.State = TestOk
+			}
+		}
+		prevDir.Stop()
+	}
+	return ok
+}
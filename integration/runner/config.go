@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
@@ -18,14 +20,23 @@ import (
 )
 
 type Config struct {
-	MtaStartPort uint16
-	ReceiverPort uint16
-	MilterPort   uint16
-	ScratchDir   string
-	MTAs         []string
-	TestDirs     []*TestDir
-	Tests        []*TestCase
-	Filter       *regexp.Regexp
+	MtaStartPort     uint16
+	ReceiverPort     uint16
+	MilterPort       uint16
+	ScratchDir       string
+	MTAs             []string
+	TestDirs         []*TestDir
+	Tests            []*TestCase
+	Filter           *regexp.Regexp
+	Bench            int
+	BenchConcurrency int
+	// CACert is the CA certificate of the MTA certificates when the test SMTP client verifies them (-tlsVerify).
+	// It is nil when the client does not verify the certificates.
+	CACert *x509.Certificate
+	// AliasMap maps recipient addresses to the addresses the runner expands them to (see expandAliases)
+	AliasMap map[string][]string
+	// RunTags are the tags a testcase needs to have all to get run (see [integration.TestCase.Tags])
+	RunTags []string
 }
 
 func (c *Config) Cleanup() {
@@ -34,6 +45,17 @@ func (c *Config) Cleanup() {
 	}
 }
 
+// TLSConfig returns the TLS configuration of the test SMTP client.
+// It only verifies the certificate of the MTA when c.CACert is set.
+func (c *Config) TLSConfig() *tls.Config {
+	if c.CACert == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(c.CACert)
+	return &tls.Config{RootCAs: pool, ServerName: tlsHost}
+}
+
 func ParseConfig() *Config {
 	_, filename, _, ok := runtime.Caller(0)
 	if !ok {
@@ -49,8 +71,18 @@ func ParseConfig() *Config {
 	flag.UintVar(&milterPort, "milterPort", 34126, "`port` for test milter servers (1024 < port < 65536")
 	filter := ""
 	flag.StringVar(&filter, "filter", "", "regexp `pattern` to filter testcases")
+	runTags := ""
+	flag.StringVar(&runTags, "run-tags", "", "comma separated `tags`: only run testcases that have all of these tags")
 	mtaFilter := ""
 	flag.StringVar(&mtaFilter, "mtaFilter", "", "regexp `pattern` to filter MTAs")
+	bench := 0
+	flag.IntVar(&bench, "bench", 0, "benchmark mode: send each testcase `n` times and output benchstat compatible results")
+	benchConcurrency := runtime.NumCPU()
+	flag.IntVar(&benchConcurrency, "benchConcurrency", runtime.NumCPU(), "`number` of concurrent SMTP connections in benchmark mode")
+	tlsVerify := false
+	flag.BoolVar(&tlsVerify, "tlsVerify", false, "sign the MTA certificates with a test CA and verify them in the SMTP client")
+	aliases := aliasFlag{}
+	flag.Var(aliases, "alias", "expand RCPT TO `alias@example.com=target1@example.com,target2@example.com` (can be repeated)")
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
@@ -76,7 +108,9 @@ func ParseConfig() *Config {
 		ReceiverPort: uint16(receiverPort),
 		MilterPort:   uint16(milterPort),
 		Filter:       filterRe,
+		RunTags:      splitTags(runTags),
 		ScratchDir:   "",
+		AliasMap:     aliases,
 	}
 	tmpDir, err := os.MkdirTemp("", "scratch-*")
 	if err != nil {
@@ -91,6 +125,12 @@ func ParseConfig() *Config {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if bench < 0 || benchConcurrency < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	config.Bench = bench
+	config.BenchConcurrency = benchConcurrency
 	if mtaPort > math.MaxUint16 || mtaPort < 1025 || receiverPort > math.MaxUint16 || receiverPort < 1025 || milterPort > math.MaxUint16 || milterPort < 1025 {
 		flag.Usage()
 		os.Exit(1)
@@ -99,6 +139,9 @@ func ParseConfig() *Config {
 	if err != nil {
 		LevelOneLogger.Fatalf("error getting tests: %s", err)
 	}
+	if err := validateTestCases(testDirs); err != nil {
+		LevelOneLogger.Fatal(err)
+	}
 	mtas, err := filepath.Glob(path.Join(mtaPath, "*/mta.sh"))
 	if err != nil {
 		LevelOneLogger.Fatalf("error getting MTAs: %s", err)
@@ -150,7 +193,10 @@ func ParseConfig() *Config {
 					if filepath.Ext(path) == ".testcase" && filterRe.MatchString(path) {
 						testCase, err := integration.ParseTestCase(path)
 						if err != nil {
-							return fmt.Errorf("parsing %s: %w", path, err)
+							return err
+						}
+						if !testCase.HasTags(config.RunTags) {
+							return nil
 						}
 						test := &TestCase{
 							Index:    len(tests),
@@ -182,7 +228,11 @@ func ParseConfig() *Config {
 	config.TestDirs = dirs
 	config.Tests = tests
 
-	if err := GenCert("localhost.local", config.ScratchDir); err != nil {
+	if tlsVerify {
+		if config.CACert, err = GenVerifiableCert(config.ScratchDir); err != nil {
+			LevelOneLogger.Fatal(err)
+		}
+	} else if err := GenCert(tlsHost, config.ScratchDir); err != nil {
 		LevelOneLogger.Fatal(err)
 	}
 
@@ -193,6 +243,17 @@ func ParseConfig() *Config {
 
 var tagsSplit = regexp.MustCompile("[\n\r]")
 
+// splitTags splits the comma separated list of tags s
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 func removeEmptyOrDuplicates(str []string) []string {
 	if len(str) == 0 {
 		return []string{}
@@ -258,6 +319,23 @@ func expandTestDirs(in []string) (dirs []string, err error) {
 	return
 }
 
+// validateTestCases checks all testcase files in dirs (regardless of -filter and -run-tags)
+// so that a malformed testcase stops the runner before any MTA gets started.
+func validateTestCases(dirs []string) error {
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.testcase"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := integration.ValidateTestCaseFile(file); err != nil {
+				return fmt.Errorf("invalid testcase: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 func overlap(start1 uint, end1 uint, start2 uint, end2 uint) bool {
 	if end1 < start1 || end2 < start2 {
 		panic("end < start")
@@ -18,7 +18,11 @@ func main() {
 	}
 	defer receiver.Cleanup()
 	runner := NewRunner(config, &receiver)
-	if !runner.Run() {
+	run := runner.Run
+	if config.Bench > 0 {
+		run = runner.Bench
+	}
+	if !run() {
 		receiver.Cleanup()
 		config.Cleanup()
 		os.Exit(1)
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,6 +24,9 @@ type MTA struct {
 	once       sync.Once
 	m          sync.Mutex
 	failedTest bool
+	// mtaConfig are the settings of the running MTA that differ from its default configuration
+	mtaConfig map[string]string
+	starts    int
 }
 
 func NewMTA(path string, port uint16, config *Config) (*MTA, error) {
@@ -65,17 +69,33 @@ func (m *MTA) MarkFailedTest() {
 }
 
 func (m *MTA) Start() error {
-	m.dir = path.Join(m.config.ScratchDir, fmt.Sprintf("mta-%d", m.Port))
+	return m.StartWithConfig(nil)
+}
+
+// StartWithConfig starts the MTA with mtaConfig merged into its default configuration.
+// Every start uses a fresh scratch directory since the MTA scripts set it up from scratch.
+func (m *MTA) StartWithConfig(mtaConfig map[string]string) error {
+	m.once = sync.Once{}
+	m.starts++
+	m.mtaConfig = mtaConfig
+	if m.starts == 1 {
+		m.dir = path.Join(m.config.ScratchDir, fmt.Sprintf("mta-%d", m.Port))
+	} else {
+		m.dir = path.Join(m.config.ScratchDir, fmt.Sprintf("mta-%d-%d", m.Port, m.starts))
+	}
 	err := os.Mkdir(m.dir, 0755)
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
-	m.cmd = exec.Command("sh", m.path, "start",
-		"-mtaPort", fmt.Sprintf("%d", m.Port),
-		"-receiverPort", fmt.Sprintf("%d", m.config.ReceiverPort),
-		"-milterPort", fmt.Sprintf("%d", m.config.MilterPort),
-		"-scratchDir", m.dir,
-	)
+	args := m.args("start")
+	if len(mtaConfig) > 0 {
+		configPath := path.Join(m.dir, "mta-config")
+		if err := os.WriteFile(configPath, []byte(formatMTAConfig(mtaConfig)), 0644); err != nil {
+			return err
+		}
+		args = append(args, "-mtaConfig", configPath)
+	}
+	m.cmd = exec.Command("sh", args...)
 	for _, t := range m.tags {
 		if strings.HasPrefix(t, "sleep-") {
 			d, err := time.ParseDuration(t[6:])
@@ -116,12 +136,7 @@ func (m *MTA) Start() error {
 func (m *MTA) Stop() {
 	m.once.Do(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-		b, _ := exec.CommandContext(ctx, "sh", m.path, "stop",
-			"-mtaPort", fmt.Sprintf("%d", m.Port),
-			"-receiverPort", fmt.Sprintf("%d", m.config.ReceiverPort),
-			"-milterPort", fmt.Sprintf("%d", m.config.MilterPort),
-			"-scratchDir", m.dir,
-		).CombinedOutput()
+		b, _ := exec.CommandContext(ctx, "sh", m.args("stop")...).CombinedOutput()
 		cancel()
 		if m.cmd != nil && m.cmd.Process != nil {
 			_ = m.cmd.Process.Signal(syscall.SIGTERM)
@@ -136,3 +151,40 @@ func (m *MTA) Stop() {
 		}
 	})
 }
+
+// Reconfigure restarts the MTA with mtaConfig when it does not already run with these settings.
+// A nil mtaConfig restores the default configuration.
+func (m *MTA) Reconfigure(mtaConfig map[string]string) error {
+	if formatMTAConfig(m.mtaConfig) == formatMTAConfig(mtaConfig) {
+		return nil
+	}
+	m.Stop()
+	LevelTwoLogger.Printf("restarting MTA %s with config %v", m.path, mtaConfig)
+	return m.StartWithConfig(mtaConfig)
+}
+
+func (m *MTA) args(command string) []string {
+	return []string{m.path, command,
+		"-mtaPort", fmt.Sprintf("%d", m.Port),
+		"-receiverPort", fmt.Sprintf("%d", m.config.ReceiverPort),
+		"-milterPort", fmt.Sprintf("%d", m.config.MilterPort),
+		"-scratchDir", m.dir,
+	}
+}
+
+// formatMTAConfig returns the key=value lines of mtaConfig in a stable order
+func formatMTAConfig(mtaConfig map[string]string) string {
+	keys := make([]string, 0, len(mtaConfig))
+	for k := range mtaConfig {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(mtaConfig[k])
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
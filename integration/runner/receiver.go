@@ -18,6 +18,7 @@ import (
 type Receiver struct {
 	Msg          chan *integration.Output
 	expectOutput bool
+	discard      bool
 	m            sync.Mutex
 	Config       *Config
 	s            *smtp.Server
@@ -123,6 +124,14 @@ func (r *Receiver) ExpectMessage() {
 	r.clearMessages()
 }
 
+// DiscardMessages configures the Receiver to silently drop all messages it receives.
+func (r *Receiver) DiscardMessages(discard bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.discard = discard
+	r.clearMessages()
+}
+
 func (r *Receiver) IgnoreMessages() {
 	r.m.Lock()
 	defer r.m.Unlock()
@@ -151,6 +160,9 @@ func (r *Receiver) WaitForMessage() *integration.Output {
 func (r *Receiver) onMsg(output *integration.Output) {
 	r.m.Lock()
 	defer r.m.Unlock()
+	if r.discard {
+		return
+	}
 	if r.expectOutput {
 		r.Msg <- output
 	} else {
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/d--j/go-milter/integration"
 )
 
@@ -50,6 +54,7 @@ func (r *Runner) Run() bool {
 					LevelThreeLogger.Printf("%03d/%03d %s", i+1, tests, t.Filename)
 					t.MarkSkipped("%03d/%03d SKIP", i, tests)
 				}
+				dir.Stop()
 				continue
 			}
 			LevelTwoLogger.Printf("ERR starting milter %v", err)
@@ -58,35 +63,17 @@ func (r *Runner) Run() bool {
 		for _, t := range dir.Tests {
 			i++
 			LevelThreeLogger.Printf("%03d/%03d %s", i, tests, t.Filename)
-			if t.TestCase.ExpectsOutput() {
-				r.receiver.ExpectMessage()
-			}
-			code, message, step, err := t.Send(t.TestCase.InputSteps, dir.MTA.Port)
-			if err != nil {
-				t.MarkFailed("ERR %v", err)
+			if err := dir.MTA.Reconfigure(t.TestCase.MTAConfig); err != nil {
+				t.MarkFailed("ERR restarting MTA %v", err)
 				return false
 			}
-			if !t.TestCase.Decision.Compare(code, message, step) {
-				r.receiver.IgnoreMessages()
-				t.MarkFailed("NOK DECISION %s != %d %s @%s", t.TestCase.Decision, code, message, step)
-				continue
-			}
-			if t.TestCase.ExpectsOutput() {
-				output := r.receiver.WaitForMessage()
-				r.receiver.IgnoreMessages()
-				diff, ok := integration.DiffOutput(t.TestCase.Output, output)
-				if !ok {
-					if t.parent.MTA.HasTag("mta-sendmail") {
-						if integration.CompareOutputSendmail(t.TestCase.Output, output) {
-							t.MarkOk("OK (sendmail) %s", diff)
-							continue
-						}
-					}
-					t.MarkFailed("NOK OUTPUT %sRECEIVED OUTPUT\n%s", diff, output)
-					continue
-				}
+			if !r.runRepeated(t, dir.MTA.Port) {
+				return false
 			}
-			t.MarkOk("OK")
+		}
+		if err := dir.MTA.Reconfigure(nil); err != nil {
+			LevelTwoLogger.Printf("ERR restarting MTA %v", err)
+			return false
 		}
 		prevDir.Stop()
 	}
@@ -104,3 +91,96 @@ func (r *Runner) Run() bool {
 	LevelOneLogger.Printf("%d tests done: %d OK %d skipped %d failed", len(r.config.Tests), numOk, numSkipped, numFailed)
 	return numFailed == 0
 }
+
+// runRepeated runs t as often as its Repeat field says and marks it as passed when all runs passed.
+// It returns false when the runner needs to stop.
+func (r *Runner) runRepeated(t *TestCase, port uint16) bool {
+	repeat := t.TestCase.Repeat
+	if repeat < 1 {
+		repeat = 1
+	}
+	passed := 0
+	var okMessage, failMessage, failData string
+	for n := 1; n <= repeat; n++ {
+		t.smtpData.Reset()
+		message, ok, err := r.run(t, port)
+		if err != nil {
+			t.MarkFailed("ERR %v", err)
+			return false
+		}
+		if ok {
+			passed++
+			okMessage = message
+			continue
+		}
+		if repeat > 1 {
+			LevelThreeLogger.Printf("repetition %d/%d: %s", n, repeat, message)
+		}
+		if failMessage == "" {
+			failMessage, failData = message, t.smtpData.String()
+		}
+	}
+	switch {
+	case passed == repeat && repeat == 1:
+		t.MarkOk("%s", okMessage)
+	case passed == repeat:
+		t.MarkOk("OK %d/%d repetitions", passed, repeat)
+	default:
+		// log the SMTP transaction of the first failed repetition
+		t.smtpData.Reset()
+		t.smtpData.WriteString(failData)
+		if repeat == 1 {
+			t.MarkFailed("%s", failMessage)
+		} else {
+			t.MarkFailed("NOK %d/%d repetitions passed, %d failed, first failure: %s", passed, repeat, repeat-passed, failMessage)
+		}
+	}
+	return true
+}
+
+// run sends the SMTP transaction of t once and checks the result.
+// It returns the result message and whether t passed. A non-nil error means the runner needs to stop.
+func (r *Runner) run(t *TestCase, port uint16) (string, bool, error) {
+	if t.TestCase.ExpectsOutput() {
+		r.receiver.ExpectMessage()
+	}
+	time.Sleep(t.TestCase.PreDelay)
+	code, message, step, err := t.Send(t.TestCase.InputSteps, port)
+	time.Sleep(t.TestCase.PostDelay)
+	if err != nil {
+		return "", false, err
+	}
+	if !t.TestCase.Decision.Compare(code, message, step) {
+		r.receiver.IgnoreMessages()
+		return fmt.Sprintf("NOK DECISION %s != %d %s @%s", t.TestCase.Decision, code, message, step), false, nil
+	}
+	if diff, ok := t.CheckRecipients(); !ok {
+		r.receiver.IgnoreMessages()
+		return fmt.Sprintf("NOK RCPT %s", diff), false, nil
+	}
+	if t.TestCase.ExpectsOutput() {
+		output := r.receiver.WaitForMessage()
+		r.receiver.IgnoreMessages()
+		if diff, ok := checkOriginalTo(output.Header, t.originalTo); !ok {
+			return fmt.Sprintf("NOK X-Original-To %s", diff), false, nil
+		}
+		if present := t.TestCase.PresentAbsentHeaders(output.Header); len(present) > 0 {
+			return fmt.Sprintf("NOK ABSENT-HEADER %s present\nRECEIVED OUTPUT\n%s", strings.Join(present, ", "), output), false, nil
+		}
+		if t.TestCase.ExpectValidDKIM {
+			if err := integration.VerifyDKIM(output.Header, output.Body); err != nil {
+				return fmt.Sprintf("NOK DKIM %v\nRECEIVED OUTPUT\n%s", err, output), false, nil
+			}
+		}
+		diff, ok := integration.DiffOutput(t.TestCase.Output, output)
+		if !ok {
+			if t.parent.MTA.HasTag("mta-sendmail") {
+				if integration.CompareOutputSendmail(t.TestCase.Output, output) {
+					return fmt.Sprintf("OK (sendmail) %s", diff), true, nil
+				}
+			}
+			return fmt.Sprintf("NOK OUTPUT %sRECEIVED OUTPUT\n%s", diff, output), false, nil
+		}
+	}
+	return "OK", true, nil
+}
@@ -3,13 +3,14 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net/textproto"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strings"
 	"sync"
 	"syscall"
@@ -34,6 +35,15 @@ type TestDir struct {
 	m          sync.Mutex
 	startErr   error
 	failedTest bool
+	cleanups   []func()
+}
+
+// RegisterCleanup registers fn to be called when t gets stopped.
+// Like [testing.T.Cleanup] the functions get called in reverse order of their registration.
+func (t *TestDir) RegisterCleanup(fn func()) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.cleanups = append(t.cleanups, fn)
 }
 
 func (t *TestDir) Start() error {
@@ -42,6 +52,9 @@ func (t *TestDir) Start() error {
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
+	t.RegisterCleanup(func() {
+		_ = os.RemoveAll(p)
+	})
 	exe := path.Join(p, "test.exe")
 	if err := Build(t.Path, exe); err != nil {
 		return err
@@ -93,6 +106,13 @@ func (t *TestDir) Stop() {
 			t.cmd = nil
 			t.wg.Wait()
 		}
+		t.m.Lock()
+		cleanups := t.cleanups
+		t.cleanups = nil
+		t.m.Unlock()
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
 	})
 }
 
@@ -118,9 +138,32 @@ type TestCase struct {
 	Filename string
 	TestCase *integration.TestCase
 	smtpData bytes.Buffer
-	Config   *Config
-	parent   *TestDir
-	State    TestState
+	// rcptCodes are the SMTP response codes of the RCPT TO commands of the last Send
+	rcptCodes []rcptCode
+	// originalTo are the aliases that the last Send expanded
+	originalTo []string
+	Config     *Config
+	parent     *TestDir
+	State      TestState
+}
+
+type rcptCode struct {
+	addr string
+	code uint16
+}
+
+// CheckRecipients checks the response codes of the RCPT TO commands of the last Send against
+// [integration.TestCase.ExpectRejectedRecipients]. It returns a description of the first mismatch.
+func (t *TestCase) CheckRecipients() (string, bool) {
+	if len(t.TestCase.ExpectRejectedRecipients) == 0 {
+		return "", true
+	}
+	for _, r := range t.rcptCodes {
+		if !t.TestCase.CompareRecipient(r.addr, r.code) {
+			return fmt.Sprintf("<%s> got %d", r.addr, r.code), false
+		}
+	}
+	return "", true
 }
 
 func (t *TestCase) MarkFailed(format string, v ...any) {
@@ -148,22 +191,70 @@ func (l *logWriter) Write(p []byte) (n int, err error) {
 	return l.t.smtpData.Write(p)
 }
 
+// dialWithRetry connects to the SMTP server at addr and retries up to retries times with exponential backoff
+// (starting at 100ms, capped at maxInterval) when the connection cannot be established.
+// A negative greeting of the SMTP server does not get retried.
+func dialWithRetry(addr string, retries int, maxInterval time.Duration) (*smtp.Client, error) {
+	if maxInterval <= 0 {
+		maxInterval = time.Second
+	}
+	interval := 100 * time.Millisecond
+	for i := 0; ; i++ {
+		client, err := smtp.Dial(addr)
+		if err == nil || i >= retries {
+			return client, err
+		}
+		if _, ok := err.(*smtp.SMTPError); ok {
+			return nil, err
+		}
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+		LevelThreeLogger.Printf("dial %s failed (%v), retry %d/%d in %s", addr, err, i+1, retries, interval)
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
 func (t *TestCase) Send(steps []*integration.InputStep, port uint16) (uint16, string, integration.DecisionStep, error) {
-	client, err := smtp.Dial(fmt.Sprintf(":%d", port))
+	usePipelining := t.TestCase.UsePipelining
+	stopAfter := t.TestCase.StopAfterStep
+	if usePipelining && stopAfter != integration.StepAny && stopAfter != integration.StepEOM {
+		return 0, "", integration.StepAny, errors.New("StopAfterStep cannot be used with pipelining")
+	}
+	// stopHere returns true when the runner should not send the steps after steps[i] of the phase step
+	stopHere := func(i int, step integration.DecisionStep) bool {
+		if stopAfter != step {
+			return false
+		}
+		// all recipients of the TO phase get sent
+		return step != integration.StepTo || i+1 >= len(steps) || steps[i+1].What != "TO"
+	}
+	t.rcptCodes = nil
+	steps, t.originalTo = expandAliases(steps, t.parent.Config.AliasMap)
+	client, err := dialWithRetry(fmt.Sprintf(":%d", port), t.TestCase.MaxDialRetries, t.TestCase.DialRetryInterval)
 	if err != nil {
-		return 0, "", integration.StepAny, err
+		// an MTA might reject a connection (that the milter rejected at CONNECT) with its greeting
+		return smtpErr(err, integration.StepHelo)
 	}
 	defer client.Close()
 	client.DebugWriter = &logWriter{t: t}
 	var dataWriter io.WriteCloser
-	for _, step := range steps {
+	for i, step := range steps {
 		switch step.What {
 		case "HELO":
 			if err := client.Hello(step.Arg); err != nil {
 				return smtpErr(err, integration.StepHelo)
 			}
+			if stopHere(i, integration.StepHelo) {
+				_ = client.Quit()
+				return 250, "OK", integration.StepHelo, nil
+			}
 		case "STARTTLS":
-			if err := client.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+			if err := requireExtension(client, "STARTTLS"); err != nil {
+				return 0, "", integration.StepAny, err
+			}
+			if err := client.StartTLS(t.parent.Config.TLSConfig()); err != nil {
 				return smtpErr(err, integration.StepAny)
 			}
 		case "AUTH":
@@ -171,17 +262,36 @@ func (t *TestCase) Send(steps []*integration.InputStep, port uint16) (uint16, st
 			if step.Arg == "user2@example.com" {
 				password = "password2"
 			}
+			if err := requireExtension(client, "AUTH"); err != nil {
+				return 0, "", integration.StepAny, err
+			}
 			if err := client.Auth(sasl.NewPlainClient("", step.Arg, password)); err != nil {
 				return smtpErr(err, integration.StepAny)
 			}
 		case "FROM":
+			if usePipelining {
+				return t.sendPipelined(client, steps[i:])
+			}
 			if err := client.Mail(step.Addr, nil); err != nil {
 				return smtpErr(err, integration.StepFrom)
 			}
+			if stopHere(i, integration.StepFrom) {
+				_ = client.Quit()
+				return 250, "OK", integration.StepFrom, nil
+			}
 		case "TO":
-			if err := client.Rcpt(step.Addr); err != nil {
+			err := client.Rcpt(step.Addr)
+			if err == nil {
+				t.rcptCodes = append(t.rcptCodes, rcptCode{step.Addr, 250})
+			} else if sErr, ok := err.(*smtp.SMTPError); ok && t.TestCase.ExpectsRejectedRecipient(step.Addr) {
+				t.rcptCodes = append(t.rcptCodes, rcptCode{step.Addr, uint16(sErr.Code)})
+			} else {
 				return smtpErr(err, integration.StepTo)
 			}
+			if stopHere(i, integration.StepTo) {
+				_ = client.Quit()
+				return 250, "OK", integration.StepTo, nil
+			}
 		case "RESET":
 			if err := client.Reset(); err != nil {
 				return smtpErr(err, integration.StepAny)
@@ -191,6 +301,10 @@ func (t *TestCase) Send(steps []*integration.InputStep, port uint16) (uint16, st
 			if err != nil {
 				return smtpErr(err, integration.StepData)
 			}
+			if stopHere(i, integration.StepData) {
+				// we cannot QUIT in the middle of the DATA command, closing the connection aborts the transaction
+				return 354, "", integration.StepData, nil
+			}
 			if _, err := dataWriter.Write(step.Data); err != nil {
 				return smtpErr(err, integration.StepAny)
 			}
@@ -210,6 +324,105 @@ func (t *TestCase) Send(steps []*integration.InputStep, port uint16) (uint16, st
 	return 0, "", integration.StepEOM, errors.New("incomplete input sequence")
 }
 
+// requireExtension returns an error when the MTA did not advertise the SMTP extension ext in its EHLO response.
+// client already sent the EHLO command in the HELO step (that every testcase starts with), so this does not send anything.
+// We check this before we use the extension, so that a test fails with a clear error instead of an SMTP 503 error of the MTA.
+func requireExtension(client *smtp.Client, ext string) error {
+	if ok, _ := client.Extension(ext); !ok {
+		return fmt.Errorf("MTA does not advertise the %s extension", ext)
+	}
+	return nil
+}
+
+// sendPipelined sends steps (that start with the MAIL FROM) with the PIPELINING extension of RFC 2920:
+// all commands up to and including DATA get sent in one go and only then the responses get read.
+// go-smtp does not support pipelining, so we use the underlying text connection of client.
+func (t *TestCase) sendPipelined(client *smtp.Client, steps []*integration.InputStep) (uint16, string, integration.DecisionStep, error) {
+	if err := requireExtension(client, "PIPELINING"); err != nil {
+		return 0, "", integration.StepAny, err
+	}
+	type pending struct {
+		expectCode int
+		step       integration.DecisionStep
+		rcpt       string
+	}
+	var group []pending
+	for len(steps) > 0 && steps[0].What != "HEADER" {
+		step := steps[0]
+		steps = steps[1:]
+		switch step.What {
+		case "FROM":
+			_, _ = fmt.Fprintf(client.Text.W, "MAIL FROM:<%s>\r\n", step.Addr)
+			group = append(group, pending{25, integration.StepFrom, ""})
+		case "TO":
+			_, _ = fmt.Fprintf(client.Text.W, "RCPT TO:<%s>\r\n", step.Addr)
+			group = append(group, pending{25, integration.StepTo, step.Addr})
+		case "RESET":
+			_, _ = fmt.Fprint(client.Text.W, "RSET\r\n")
+			group = append(group, pending{250, integration.StepAny, ""})
+		case "HELO":
+			// ParseTestCase adds a HELO after a RESET, we already greeted the server
+		default:
+			return 0, "", integration.StepAny, fmt.Errorf("cannot pipeline step %s", step.What)
+		}
+	}
+	if len(steps) != 2 || steps[0].What != "HEADER" || steps[1].What != "BODY" {
+		return 0, "", integration.StepEOM, errors.New("incomplete input sequence")
+	}
+	// DATA is the last command of the pipelined group
+	_, _ = fmt.Fprint(client.Text.W, "DATA\r\n")
+	group = append(group, pending{354, integration.StepData, ""})
+	if err := client.Text.W.Flush(); err != nil {
+		return 0, "", integration.StepAny, err
+	}
+	for _, p := range group {
+		code, _, err := client.Text.ReadResponse(p.expectCode)
+		if p.rcpt != "" {
+			if err == nil {
+				t.rcptCodes = append(t.rcptCodes, rcptCode{p.rcpt, uint16(code)})
+				continue
+			}
+			if pErr, ok := err.(*textproto.Error); ok && t.TestCase.ExpectsRejectedRecipient(p.rcpt) {
+				t.rcptCodes = append(t.rcptCodes, rcptCode{p.rcpt, uint16(pErr.Code)})
+				continue
+			}
+		}
+		if err != nil {
+			return textprotoErr(err, p.step)
+		}
+	}
+	dataWriter := client.Text.DotWriter()
+	if _, err := dataWriter.Write(steps[0].Data); err != nil {
+		return 0, "", integration.StepAny, err
+	}
+	if _, err := dataWriter.Write(steps[1].Data); err != nil {
+		return 0, "", integration.StepAny, err
+	}
+	if err := dataWriter.Close(); err != nil {
+		return 0, "", integration.StepAny, err
+	}
+	if _, _, err := client.Text.ReadResponse(250); err != nil {
+		return textprotoErr(err, integration.StepEOM)
+	}
+	_ = client.Quit()
+	return 250, "OK: queued", integration.StepEOM, nil
+}
+
+var enhancedCode = regexp.MustCompile(`^[245]\.\d{1,3}\.\d{1,3}$`)
+
+// textprotoErr is the equivalent of smtpErr for errors of [textproto.Reader.ReadResponse]
+func textprotoErr(err error, step integration.DecisionStep) (uint16, string, integration.DecisionStep, error) {
+	if pErr, ok := err.(*textproto.Error); ok {
+		// go-smtp strips the enhanced status code from the message (of every line), so we do the same
+		msg := pErr.Msg
+		if parts := strings.SplitN(msg, " ", 2); len(parts) == 2 && enhancedCode.MatchString(parts[0]) {
+			msg = strings.ReplaceAll(parts[1], "\n"+parts[0]+" ", "\n")
+		}
+		return uint16(pErr.Code), msg, step, nil
+	}
+	return 0, "", step, err
+}
+
 func smtpErr(err error, step integration.DecisionStep) (uint16, string, integration.DecisionStep, error) {
 	if sErr, ok := err.(*smtp.SMTPError); ok {
 		return uint16(sErr.Code), sErr.Message, step, nil
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -14,6 +15,10 @@ import (
 	"time"
 )
 
+// tlsHost is the host name of the certificates of the MTAs
+const tlsHost = "localhost.local"
+
+// GenCert generates a self-signed certificate for host and writes it as cert.pem and key.pem into outDir.
 func GenCert(host string, outDir string) error {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -33,8 +38,82 @@ func GenCert(host string, outDir string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create certificate: %w", err)
 	}
+	return writeCert(derBytes, priv, outDir)
+}
+
+// GenerateTestCA generates a self-signed CA certificate and its private key.
+// Use [GenerateTestCert] to create MTA certificates that the test SMTP client can verify with this CA.
+func GenerateTestCA() (*x509.Certificate, crypto.PrivateKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-milter integration test CA"},
+		NotBefore:             time.Now().Add(-1 * time.Minute),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	return ca, priv, nil
+}
+
+// GenerateTestCert generates a certificate for the MTAs (host name localhost.local) that is signed by ca.
+// caKey is the private key of ca.
+func GenerateTestCert(ca *x509.Certificate, caKey crypto.PrivateKey) (*x509.Certificate, crypto.PrivateKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: tlsHost},
+		NotBefore:             time.Now().Add(-1 * time.Minute),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{tlsHost},
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, ca, &priv.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert, priv, nil
+}
+
+// GenVerifiableCert generates a CA and a certificate signed by this CA. It writes the certificate as
+// cert.pem and key.pem into outDir and returns the CA certificate.
+func GenVerifiableCert(outDir string) (*x509.Certificate, error) {
+	ca, caKey, err := GenerateTestCA()
+	if err != nil {
+		return nil, err
+	}
+	cert, key, err := GenerateTestCert(ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return ca, writeCert(cert.Raw, key.(*rsa.PrivateKey), outDir)
+}
+
+// writeCert writes the DER encoded certificate derBytes and its private key priv as cert.pem and key.pem into outDir.
+func writeCert(derBytes []byte, priv *rsa.PrivateKey, outDir string) error {
 	b := &bytes.Buffer{}
-	err = pem.Encode(b, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	err := pem.Encode(b, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	if err != nil {
 		return fmt.Errorf("failed to encode certificate: %w", err)
 	}
@@ -10,8 +10,10 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/d--j/go-milter/milterutil"
 	"github.com/emersion/go-message/mail"
@@ -97,7 +99,8 @@ func (d Decision) Compare(code uint16, message string, step DecisionStep) bool {
 		}
 	}
 	if d.Code < 10 {
-		return code/100 == uint16(d.Code)
+		// the 354 of a DATA command is the positive response when the transaction stops after DATA (see TestCase.StopAfterStep)
+		return code/100 == uint16(d.Code) || (d.Code == 2 && code == 354 && step == StepData)
 	}
 	if d.Code < 100 {
 		return code/10 == uint16(d.Code)
@@ -155,6 +158,109 @@ type TestCase struct {
 	InputSteps []*InputStep
 	Decision   *Decision
 	Output     *Output
+	// PreDelay is the time the runner waits before it sends the SMTP transaction of this testcase.
+	PreDelay time.Duration
+	// PostDelay is the time the runner waits after the SMTP transaction of this testcase.
+	PostDelay time.Duration
+	// MaxDialRetries is the number of times the runner retries to connect to the MTA when the connection fails
+	// (e.g. because the MTA is slow to start). 0 means the runner does not retry.
+	MaxDialRetries int
+	// DialRetryInterval is the maximum time the runner waits between two connection attempts.
+	// The wait time starts at 100ms (or DialRetryInterval when it is smaller) and doubles with every retry.
+	// 0 means one second.
+	DialRetryInterval time.Duration
+	// UsePipelining makes the runner send the envelope commands (MAIL FROM, RCPT TO, RSET and DATA)
+	// in one go without waiting for the responses (RFC 2920 PIPELINING).
+	UsePipelining bool
+	// ExpectRejectedRecipients are the RCPT TO addresses that the MTA needs to reject (because the milter rejected them)
+	// while it accepts all other recipients of the transaction.
+	ExpectRejectedRecipients []string
+	// ExpectedCode is the SMTP code that the recipients in ExpectRejectedRecipients need to get.
+	// Like [Decision.Code] it can be a code class (4 or 5), the first two digits or a complete code.
+	// 0 means any 4xx or 5xx code.
+	ExpectedCode int
+	// ExpectedHeadersAbsent are the names of header fields that must not be in the delivered message
+	// (e.g. because the milter needs to remove them).
+	ExpectedHeadersAbsent []string
+	// SignDKIM makes the runner sign the message with the DKIM key of the integration tests (see [SignDKIM])
+	// before it sends it. The DKIM-Signature field is already part of the HEADER input step.
+	SignDKIM bool
+	// ExpectValidDKIM makes the runner check that the DKIM signature of the delivered message is still valid
+	// (see [VerifyDKIM]). Use it together with SignDKIM to check that the milter does not break DKIM signatures.
+	ExpectValidDKIM bool
+	// MTAConfig are MTA settings that get merged into the default configuration of the MTA for this testcase
+	// (e.g. "smtpd_delay_reject": "no" for Postfix). The runner restarts the MTA with the merged configuration
+	// before it runs the testcase and restores the default configuration afterwards.
+	MTAConfig map[string]string
+	// Repeat is the number of times the runner sends the SMTP transaction of this testcase (one after another,
+	// to the same milter). The testcase only passes when all repetitions pass. 0 means once.
+	// Use it to find failures that only happen sometimes (e.g. because of timing or state issues).
+	Repeat int
+	// StopAfterStep makes the runner stop the SMTP transaction after the phase StopAfterStep (StepHelo, StepFrom,
+	// StepTo or StepData) succeeded, e.g. to check the decision of the milter at RCPT TO without sending a message.
+	// The runner then reports the positive response code of this phase (250, or 354 for StepData) as decision
+	// and closes the connection, so the MTA aborts the transaction.
+	// StepAny (the default) and StepEOM send the complete transaction. It cannot be used together with UsePipelining.
+	StopAfterStep DecisionStep
+	// Tags categorize the testcase (e.g. "auth", "tls", "large-message").
+	// The runner can only run testcases with specific tags (-run-tags).
+	Tags []string
+}
+
+// HasTags returns true when c has all tags.
+func (c *TestCase) HasTags(tags []string) bool {
+outer:
+	for _, tag := range tags {
+		for _, t := range c.Tags {
+			if t == tag {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// PresentAbsentHeaders returns the names of [TestCase.ExpectedHeadersAbsent] that are in header.
+// header is the raw header of the delivered message.
+func (c *TestCase) PresentAbsentHeaders(header []byte) []string {
+	if len(c.ExpectedHeadersAbsent) == 0 {
+		return nil
+	}
+	fields, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(header))).ReadMIMEHeader()
+	if err != nil && err != io.EOF && len(fields) == 0 {
+		// the header could not be parsed, so we cannot be sure that the fields are absent
+		return c.ExpectedHeadersAbsent
+	}
+	var present []string
+	for _, name := range c.ExpectedHeadersAbsent {
+		if _, ok := fields[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+			present = append(present, name)
+		}
+	}
+	return present
+}
+
+// ExpectsRejectedRecipient returns true when the MTA needs to reject the RCPT TO addr.
+func (c *TestCase) ExpectsRejectedRecipient(addr string) bool {
+	for _, r := range c.ExpectRejectedRecipients {
+		if r == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareRecipient checks if code is the right SMTP response code for the RCPT TO addr.
+// Accepted recipients need to get a 2xx code, rejected recipients the code of [TestCase.ExpectedCode].
+func (c *TestCase) CompareRecipient(addr string, code uint16) bool {
+	if !c.ExpectsRejectedRecipient(addr) {
+		return code/100 == 2
+	}
+	if c.ExpectedCode == 0 {
+		return code/100 == 4 || code/100 == 5
+	}
+	return Decision{Code: c.ExpectedCode}.Compare(code, "", StepAny)
 }
 
 func (c *TestCase) ExpectsOutput() bool {
@@ -173,17 +279,69 @@ const (
 	stepBody
 )
 
+// ParseError is the error that [ParseTestCase] and [ValidateTestCaseFile] return when a testcase file is malformed.
+type ParseError struct {
+	// Filename is the name of the testcase file.
+	Filename string
+	// Line is the line number (1-based) of the line that ParseTestCase read last.
+	// For HEADER, BODY and multi-line DECISION blocks this is the line that ended the block.
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateTestCaseFile checks that the testcase file at path can be parsed.
+// It returns a [*ParseError] that points to the offending line when the file is malformed.
+func ValidateTestCaseFile(path string) error {
+	_, err := ParseTestCase(path)
+	return err
+}
+
+// ParseTestCase parses the testcase file filename. Syntax errors get returned as [*ParseError].
 func ParseTestCase(filename string) (*TestCase, error) {
-	f, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	r := textproto.NewReader(bufio.NewReader(f))
+	in := bytes.NewReader(data)
+	r := textproto.NewReader(bufio.NewReader(in))
+	c, err := parseTestCase(r)
+	if err != nil {
+		// everything that the reader did not give back to parseTestCase yet got consumed
+		consumed := len(data) - in.Len() - r.R.Buffered()
+		line := bytes.Count(data[:consumed], []byte("\n"))
+		if consumed > 0 && data[consumed-1] != '\n' || line == 0 {
+			line++
+		}
+		return nil, &ParseError{Filename: filename, Line: line, Err: err}
+	}
+	return c, nil
+}
+
+func parseTestCase(r *textproto.Reader) (*TestCase, error) {
 	steps := 0
 	var inputs []*InputStep
 	var decision *Decision
 	var output *Output
+	var preDelay, postDelay *time.Duration
+	usePipelining := false
+	var rejectedRecipients []string
+	var expectedCode *int
+	var tags []string
+	var absentHeaders []string
+	signDKIM, expectValidDKIM := false, false
+	var dialRetries *int
+	var dialRetryInterval time.Duration
+	var mtaConfig map[string]string
+	var repeat *int
+	var stopAfter *DecisionStep
 	for true {
 		line, err := r.ReadLine()
 		if err == io.EOF {
@@ -197,6 +355,150 @@ func ParseTestCase(filename string) (*TestCase, error) {
 		}
 		line = strings.TrimSpace(line)
 		switch {
+		case strings.HasPrefix(line, "PREDELAY "):
+			if preDelay != nil {
+				return nil, errors.New("only one PREDELAY line")
+			}
+			preDelay, err = parseDelay(line[9:])
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "POSTDELAY "):
+			if postDelay != nil {
+				return nil, errors.New("only one POSTDELAY line")
+			}
+			postDelay, err = parseDelay(line[10:])
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "DIAL-RETRY "):
+			if dialRetries != nil {
+				return nil, errors.New("only one DIAL-RETRY line")
+			}
+			parts := strings.Fields(line[11:])
+			if len(parts) < 1 || len(parts) > 2 {
+				return nil, fmt.Errorf("invalid DIAL-RETRY %q", line[11:])
+			}
+			retries, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			if retries < 0 {
+				return nil, fmt.Errorf("negative DIAL-RETRY %d", retries)
+			}
+			dialRetries = &retries
+			if len(parts) == 2 {
+				interval, err := parseDelay(parts[1])
+				if err != nil {
+					return nil, err
+				}
+				dialRetryInterval = *interval
+			}
+		case strings.HasPrefix(line, "MTA-CONFIG "):
+			key, value, ok := strings.Cut(line[11:], "=")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid MTA-CONFIG %q", line[11:])
+			}
+			if mtaConfig == nil {
+				mtaConfig = make(map[string]string)
+			}
+			if _, exists := mtaConfig[key]; exists {
+				return nil, fmt.Errorf("MTA-CONFIG %s set multiple times", key)
+			}
+			mtaConfig[key] = value
+		case strings.HasPrefix(line, "REPEAT "):
+			if repeat != nil {
+				return nil, errors.New("only one REPEAT line")
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(line[7:]))
+			if err != nil {
+				return nil, err
+			}
+			if n < 1 {
+				return nil, fmt.Errorf("invalid REPEAT %d", n)
+			}
+			repeat = &n
+		case strings.HasPrefix(line, "STOP-AFTER "):
+			if stopAfter != nil {
+				return nil, errors.New("only one STOP-AFTER line")
+			}
+			step, err := parseStep(strings.TrimSpace(line[11:]))
+			if err != nil {
+				return nil, err
+			}
+			if step == StepAny || step == StepEOM {
+				return nil, fmt.Errorf("invalid STOP-AFTER %s, use HELO, FROM, TO or DATA", step)
+			}
+			stopAfter = &step
+		case strings.HasPrefix(line, "TAGS "):
+			tags = append(tags, strings.FieldsFunc(line[5:], func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})...)
+		case strings.HasPrefix(line, "ABSENT-HEADER "):
+			if decision == nil {
+				return nil, errors.New("ABSENT-HEADER before DECISION")
+			}
+			if output == nil {
+				output = &Output{}
+			}
+			absentHeaders = append(absentHeaders, strings.FieldsFunc(line[14:], func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})...)
+		case line == "DKIM-SIGN":
+			if decision != nil {
+				return nil, errors.New("DKIM-SIGN after DECISION")
+			}
+			if signDKIM {
+				return nil, errors.New("only one DKIM-SIGN line")
+			}
+			signDKIM = true
+		case line == "DKIM-VALID":
+			if decision == nil {
+				return nil, errors.New("DKIM-VALID before DECISION")
+			}
+			if output == nil {
+				output = &Output{}
+			}
+			expectValidDKIM = true
+		case line == "PIPELINING":
+			if usePipelining {
+				return nil, errors.New("only one PIPELINING line")
+			}
+			usePipelining = true
+		case strings.HasPrefix(line, "REJECTED-CODE "):
+			if expectedCode != nil {
+				return nil, errors.New("only one REJECTED-CODE line")
+			}
+			code, err := strconv.Atoi(strings.TrimSpace(line[14:]))
+			if err != nil {
+				return nil, err
+			}
+			if code < 4 || (code > 5 && code < 40) || (code > 59 && code < 400) || code > 599 {
+				return nil, fmt.Errorf("invalid REJECTED-CODE %d", code)
+			}
+			expectedCode = &code
+		case strings.HasPrefix(line, "REJECTED-TO "):
+			if decision != nil {
+				return nil, errors.New("REJECTED-TO after DECISION")
+			}
+			if steps&stepHelo == 0 {
+				inputs, steps, err = inputHelo("", inputs, steps)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if steps&stepFrom == 0 {
+				inputs, steps, err = inputFrom("<from@example.com>", inputs, steps)
+				if err != nil {
+					return nil, err
+				}
+			}
+			inputs, steps, err = inputRcpt(line[12:], inputs, steps)
+			if err != nil {
+				return nil, err
+			}
+			rejectedRecipients = append(rejectedRecipients, inputs[len(inputs)-1].Addr)
 		case strings.HasPrefix(line, "HELO "):
 			if decision != nil {
 				return nil, errors.New("HELO after DECISION")
@@ -433,11 +735,72 @@ func ParseTestCase(filename string) (*TestCase, error) {
 		return nil, errors.New("no DECISION line specified")
 	}
 
-	return &TestCase{
-		InputSteps: inputs,
-		Decision:   decision,
-		Output:     output,
-	}, nil
+	if stopAfter != nil && output != nil {
+		return nil, errors.New("STOP-AFTER with OUTPUT, there is no message to receive")
+	}
+	if stopAfter != nil && usePipelining {
+		return nil, errors.New("STOP-AFTER cannot be used with PIPELINING")
+	}
+
+	if expectedCode != nil && len(rejectedRecipients) == 0 {
+		return nil, errors.New("REJECTED-CODE without REJECTED-TO")
+	}
+
+	if signDKIM {
+		var hdr *InputStep
+		for _, step := range inputs {
+			switch step.What {
+			case "HEADER":
+				hdr = step
+			case "BODY":
+				hdr.Data = SignDKIM(hdr.Data, step.Data)
+			}
+		}
+	}
+
+	c := &TestCase{
+		InputSteps:               inputs,
+		Decision:                 decision,
+		Output:                   output,
+		UsePipelining:            usePipelining,
+		ExpectRejectedRecipients: rejectedRecipients,
+		ExpectedHeadersAbsent:    absentHeaders,
+		SignDKIM:                 signDKIM,
+		ExpectValidDKIM:          expectValidDKIM,
+		MTAConfig:                mtaConfig,
+		Tags:                     tags,
+	}
+	if expectedCode != nil {
+		c.ExpectedCode = *expectedCode
+	}
+	if repeat != nil {
+		c.Repeat = *repeat
+	}
+	if stopAfter != nil {
+		c.StopAfterStep = *stopAfter
+	}
+	if dialRetries != nil {
+		c.MaxDialRetries = *dialRetries
+		c.DialRetryInterval = dialRetryInterval
+	}
+	if preDelay != nil {
+		c.PreDelay = *preDelay
+	}
+	if postDelay != nil {
+		c.PostDelay = *postDelay
+	}
+	return c, nil
+}
+
+func parseDelay(input string) (*time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(input))
+	if err != nil {
+		return nil, err
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("negative delay %q", input)
+	}
+	return &d, nil
 }
 
 func inputHelo(input string, inputs []*InputStep, steps int) ([]*InputStep, int, error) {
@@ -571,6 +934,26 @@ func inputBody(r *textproto.Reader, inputs []*InputStep, steps int) ([]*InputSte
 	return inputs, steps, nil
 }
 
+// parseStep parses the step s (the [DecisionStep.String] representation)
+func parseStep(s string) (DecisionStep, error) {
+	switch s {
+	case "HELO":
+		return StepHelo, nil
+	case "FROM":
+		return StepFrom, nil
+	case "TO":
+		return StepTo, nil
+	case "DATA":
+		return StepData, nil
+	case "EOM":
+		return StepEOM, nil
+	case "*":
+		return StepAny, nil
+	default:
+		return StepAny, fmt.Errorf("unknown step %s", s)
+	}
+}
+
 func parseDecision(decisionStr string, r *textproto.Reader) (*Decision, error) {
 	decisionStr = strings.TrimSpace(decisionStr)
 	parts := strings.Split(decisionStr, "@")
@@ -579,21 +962,9 @@ func parseDecision(decisionStr string, r *textproto.Reader) (*Decision, error) {
 	}
 	at := StepAny
 	if len(parts) == 2 {
-		switch parts[1] {
-		case "HELO":
-			at = StepHelo
-		case "FROM":
-			at = StepFrom
-		case "TO":
-			at = StepTo
-		case "DATA":
-			at = StepData
-		case "EOM":
-			at = StepEOM
-		case "*":
-			at = StepAny
-		default:
-			return nil, fmt.Errorf("unkonwn step %s", parts[1])
+		var err error
+		if at, err = parseStep(parts[1]); err != nil {
+			return nil, err
 		}
 	}
 	switch parts[0] {
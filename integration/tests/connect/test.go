@@ -0,0 +1,15 @@
+package main
+
+import (
+	"context"
+
+	"github.com/d--j/go-milter/integration"
+	"github.com/d--j/go-milter/mailfilter"
+)
+
+func main() {
+	integration.Test(func(ctx context.Context, trx mailfilter.Trx) (mailfilter.Decision, error) {
+		// reject every SMTP client at CONNECT
+		return mailfilter.CustomErrorResponse(554, "5.7.1 client blocked"), nil
+	}, mailfilter.WithDecisionAt(mailfilter.DecisionAtConnect))
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+
+	"github.com/d--j/go-milter/integration"
+	"github.com/d--j/go-milter/mailfilter"
+)
+
+func main() {
+	integration.Test(func(ctx context.Context, trx mailfilter.Trx) (mailfilter.Decision, error) {
+		if trx.MailFrom().Addr == "reject@example.com" {
+			return mailfilter.CustomErrorResponse(550, "rejected at DATA"), nil
+		}
+		return mailfilter.Accept, nil
+	}, mailfilter.WithDecisionAt(mailfilter.DecisionAtData))
+}
@@ -31,6 +31,9 @@ func main() {
 					break
 				}
 			}
+		case "dkim@example.com":
+			// the message is DKIM signed, adding a header field at the end must not break the signature
+			trx.Headers().Add("X-DKIM-Checked", "yes")
 		case "subject@example.com":
 			trx.Headers().SetSubject("changed")
 		case "del@example.com":
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/integration"
+)
+
+func main() {
+	// discard every message
+	integration.TestOverrides(integration.Override{Step: integration.StepEOM, N: 0, Response: milter.RespDiscard})
+}
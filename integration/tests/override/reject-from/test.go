@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/integration"
+)
+
+func main() {
+	// reject every MAIL FROM
+	integration.TestOverrides(integration.Override{Step: integration.StepFrom, N: 0, Response: milter.RespReject})
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/integration"
+)
+
+func main() {
+	// temporarily fail the second RCPT TO of every transaction
+	integration.TestOverrides(integration.Override{Step: integration.StepTo, N: 2, Response: milter.RespTempFail})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/d--j/go-milter/integration"
+	"github.com/d--j/go-milter/mailfilter"
+)
+
+func main() {
+	integration.Test(func(ctx context.Context, trx mailfilter.Trx) (mailfilter.Decision, error) {
+		// the testcases send the envelope commands pipelined, check that we nevertheless got all of them in the right order.
+		if trx.MailFrom().Addr != "pipelining@example.com" {
+			return mailfilter.CustomErrorResponse(500, "unexpected mail from "+trx.MailFrom().Addr), nil
+		}
+		var rcpts []string
+		for _, r := range trx.RcptTos() {
+			rcpts = append(rcpts, r.Addr)
+		}
+		if got := strings.Join(rcpts, ","); got != "one@example.com,two@example.com,three@example.com" {
+			return mailfilter.CustomErrorResponse(500, "unexpected rcpt tos "+got), nil
+		}
+		if subject, _ := trx.Headers().Subject(); subject != "pipelining" {
+			return mailfilter.CustomErrorResponse(500, "unexpected subject "+subject), nil
+		}
+		b, err := io.ReadAll(trx.Body())
+		if err != nil {
+			return nil, err
+		}
+		if string(b) != "pipelined body\r\n" {
+			return mailfilter.CustomErrorResponse(500, "unexpected body"), nil
+		}
+		return mailfilter.Accept, nil
+	})
+}
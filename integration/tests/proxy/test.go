@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/integration"
+)
+
+// local adds a header field at the end of the message
+type local struct {
+	milter.NoOpMilter
+}
+
+func (local) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
+	if err := m.AddHeader("X-Proxy", "local"); err != nil {
+		return nil, err
+	}
+	return milter.RespAccept, nil
+}
+
+// downstream is the fake downstream milter: it rejects reject@example.com and adds a header field to all other messages
+type downstream struct {
+	milter.NoOpMilter
+}
+
+func (downstream) MailFrom(from string, _ string, _ *milter.Modifier) (*milter.Response, error) {
+	if from == "reject@example.com" {
+		return milter.RespReject, nil
+	}
+	return milter.RespContinue, nil
+}
+
+func (downstream) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
+	if err := m.AddHeader("X-Downstream", "yes"); err != nil {
+		return nil, err
+	}
+	return milter.RespAccept, nil
+}
+
+func main() {
+	integration.TestProxy(func() milter.Milter {
+		return local{}
+	}, func() milter.Milter {
+		return downstream{}
+	}, milter.OptAddHeader)
+}
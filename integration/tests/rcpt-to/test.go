@@ -32,6 +32,10 @@ func main() {
 			// Sendmail does not like setting ESMTP args, so we do not set any
 			trx.AddRcptTo("another@example.com", "")
 		}
+		if trx.HasRcptTo("delete@example.com") {
+			// only keep@example.com should receive the message
+			trx.DelRcptTo("delete@example.com")
+		}
 		return mailfilter.Accept, nil
 		// the decision is done at the DATA command but the mock server sends the DATA response before we can intercept
 		// so the testcases allow the rejection at any step, not only at "DATA".
@@ -26,6 +26,7 @@ type Body struct {
 	mem     *bytes.Reader
 	file    *os.File
 	reading bool
+	size    int64
 }
 
 // Write implements the io.Writer interface.
@@ -35,9 +36,12 @@ func (b *Body) Write(p []byte) (n int, err error) {
 		panic("cannot write after read")
 	}
 	if b.file != nil {
-		return b.file.Write(p)
+		n, err = b.file.Write(p)
+		b.size += int64(n)
+		return
 	}
 	n, _ = b.buf.Write(p)
+	b.size += int64(n)
 	if b.buf.Len() > b.maxMem {
 		b.file, err = os.CreateTemp("", "body-*")
 		if err != nil {
@@ -49,6 +53,12 @@ func (b *Body) Write(p []byte) (n int, err error) {
 	return
 }
 
+// Size returns the number of bytes written to b.
+// It does not matter whether b is backed by memory or by a temporary file.
+func (b *Body) Size() int64 {
+	return b.size
+}
+
 func (b *Body) switchToReading() error {
 	if !b.reading {
 		b.reading = true
@@ -156,3 +156,30 @@ func TestBody(t *testing.T) {
 		}
 	})
 }
+
+func TestBody_Size(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxMem int
+		chunks [][]byte
+		want   int64
+	}{
+		{"empty", 10, nil, 0},
+		{"mem", 10, [][]byte{[]byte("test"), []byte("test")}, 8},
+		{"file", 5, [][]byte{[]byte("test"), []byte("test"), []byte("test")}, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.maxMem)
+			defer b.Close()
+			for _, c := range tt.chunks {
+				if _, err := b.Write(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := b.Size(); got != tt.want {
+				t.Errorf("Size() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -142,6 +142,10 @@ func Diff(orig *Header, changed *Header) (changeInsertOps []Op, addOps []Op) {
 	for _, diff := range diffs {
 		switch diff.kind {
 		case KindInsert:
+			if diff.field.Deleted() {
+				// the field got added and deleted again
+				continue
+			}
 			idx := diff.index + 2
 			if idx-1 >= origLen {
 				addOps = append(addOps, Op{
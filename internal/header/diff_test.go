@@ -99,6 +99,14 @@ func TestDiff(t *testing.T) {
 	fields := addOneInFront.Fields()
 	fields.Next()
 	fields.InsertBefore("X-Test", "1")
+	addAndDelete := testHeader()
+	addAndDelete.Add("X-Test", "1")
+	fields = addAndDelete.Fields()
+	for fields.Next() {
+		if fields.CanonicalKey() == "X-Test" {
+			fields.Del()
+		}
+	}
 	complexChanges := testHeader()
 	fields = complexChanges.Fields()
 	for fields.Next() {
@@ -124,6 +132,7 @@ func TestDiff(t *testing.T) {
 		{"equal", args{orig, orig}, nil, nil},
 		{"add-one", args{orig, addOne}, nil, []Op{{Index: 5, Name: "X-Test", Value: " 1"}}},
 		{"add-one-in-front", args{orig, addOneInFront}, []Op{{Kind: KindInsert, Index: 1, Name: "X-Test", Value: " 1"}}, nil},
+		{"add-and-delete", args{orig, addAndDelete}, nil, nil},
 		{"complex", args{orig, complexChanges}, []Op{
 			{Kind: KindInsert, Index: 1, Name: "X-Test", Value: " 1"},
 			{Kind: KindInsert, Index: 2, Name: "X-Test", Value: " 1"},
@@ -4,13 +4,13 @@ package header
 import (
 	"bytes"
 	"io"
-	netmail "net/mail"
 	"net/textproto"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/d--j/go-milter/mailfilter/header"
+	"github.com/d--j/go-milter/milterutil"
 	"github.com/emersion/go-message/mail"
 )
 
@@ -103,6 +103,21 @@ func (h *Header) Add(key string, value string) {
 	h.fields = append(h.fields, &Field{-1, textproto.CanonicalMIMEHeaderKey(key), getRaw(key, value)})
 }
 
+// Size returns the number of bytes of all non-deleted fields when they get written out (with trailing CR LF).
+// The empty line at the end of the header is not counted.
+func (h *Header) Size() int64 {
+	if h == nil {
+		return 0
+	}
+	var size int64
+	for _, f := range h.fields {
+		if !f.Deleted() {
+			size += int64(len(f.Raw) + 2)
+		}
+	}
+	return size
+}
+
 func (h *Header) Value(key string) string {
 	canonicalKey := textproto.CanonicalMIMEHeaderKey(key)
 	for _, f := range h.fields {
@@ -176,6 +191,15 @@ func (h *Header) SetText(key string, value string) {
 	h.Set(key, h.helper.Get(helperKey))
 }
 
+func (h *Header) PrefixText(key string, prefix string) error {
+	text, err := h.Text(key)
+	if err != nil {
+		return err
+	}
+	h.SetText(key, prefix+strings.TrimLeft(text, " \t"))
+	return nil
+}
+
 func (h *Header) SetAddressList(key string, addresses []*mail.Address) {
 	h.Set(key, formatAddressList(addresses))
 }
@@ -189,7 +213,11 @@ func (h *Header) SetSubject(value string) {
 }
 
 func (h *Header) Date() (time.Time, error) {
-	return netmail.ParseDate(h.Value("Date"))
+	value := h.Value("Date")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return milterutil.ParseDate(value)
 }
 
 // SetDate sets the Date header to the value.
@@ -254,6 +282,10 @@ func (f *Fields) index() int {
 	return f.cursor
 }
 
+func (f *Fields) Index() int {
+	return f.h.fields[f.index()].Index
+}
+
 func (f *Fields) Raw() []byte {
 	return f.h.fields[f.index()].Raw
 }
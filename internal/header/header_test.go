@@ -647,6 +647,10 @@ func TestHeader_Add(t *testing.T) {
 func TestHeader_Date(t *testing.T) {
 	brokenDate := testHeader()
 	brokenDate.fields[3].Raw = []byte("Date: broken")
+	obsoleteDate := testHeader()
+	obsoleteDate.fields[3].Raw = []byte("Date: 1 Mar 23 09:47:33 EST")
+	missingDate := testHeader()
+	missingDate.fields = append(missingDate.fields[:3], missingDate.fields[4:]...)
 	tests := []struct {
 		name    string
 		fields  []*Field
@@ -654,6 +658,8 @@ func TestHeader_Date(t *testing.T) {
 		wantErr bool
 	}{
 		{"Date", testHeader().fields, time.Date(2023, time.March, 1, 15, 47, 33, 0, time.FixedZone("CET", 60*60)), false},
+		{"Obsolete", obsoleteDate.fields, time.Date(2023, time.March, 1, 14, 47, 33, 0, time.UTC), false},
+		{"Missing", missingDate.fields, time.Time{}, false},
 		{"Broken", brokenDate.fields, time.Time{}, true},
 	}
 	for _, tt := range tests {
@@ -1026,6 +1032,38 @@ func TestHeader_SetText(t *testing.T) {
 	}
 }
 
+func TestHeader_PrefixText(t *testing.T) {
+	brokenSubject := testHeader()
+	brokenSubject.fields[2].Raw = []byte("Subject: =?e-404?Q?=F0=9F=9F=A2?=")
+	tests := []struct {
+		name    string
+		fields  []*Field
+		key     string
+		prefix  string
+		want    []*Field
+		wantErr bool
+	}{
+		{"encoded", testHeader().fields, "Subject", "[EXTERNAL] ", append(testHeader().fields[:2], &Field{2, "Subject", []byte("subject: =?utf-8?q?[EXTERNAL]_=F0=9F=9F=A2?=")}, testHeader().fields[3]), false},
+		{"plain", testHeader().fields, "To", "x ", append(testHeader().fields[:1], &Field{1, "To", []byte("To: x <root@localhost>, <nobody@localhost>")}, testHeader().fields[2], testHeader().fields[3]), false},
+		{"add", testHeader().fields, "x-tag", "[EXTERNAL]", append(testHeader().fields, &Field{-1, "X-Tag", []byte("x-tag: [EXTERNAL]")}), false},
+		{"broken", brokenSubject.fields, "Subject", "[EXTERNAL] ", brokenSubject.fields, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Header{
+				fields: tt.fields,
+			}
+			if err := h.PrefixText(tt.key, tt.prefix); (err != nil) != tt.wantErr {
+				t.Errorf("PrefixText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := h.fields
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrefixText() = %q, want %q", outputFields(got), outputFields(tt.want))
+			}
+		})
+	}
+}
+
 func TestHeader_Subject(t *testing.T) {
 	brokenSubject := testHeader()
 	brokenSubject.fields[2].Raw = []byte("Subject: =?e-404?Q?=F0=9F=9F=A2?=")
@@ -1217,3 +1255,59 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestHeaderFields_Index(t *testing.T) {
+	raw := []byte("Received: from a\r\n\tby b\r\nX-Spam: no\r\nReceived: from c\r\nSubject: test\r\nX-Spam: yes\r\n\r\n")
+	h, err := New(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type field struct {
+		index      int
+		key, value string
+	}
+	collect := func(h *Header) []field {
+		var got []field
+		for f := h.Fields(); f.Next(); {
+			got = append(got, field{f.Index(), f.Key(), f.Value()})
+		}
+		return got
+	}
+	want := []field{
+		{0, "Received", " from a\r\n\tby b"},
+		{1, "X-Spam", " no"},
+		{2, "Received", " from c"},
+		{3, "Subject", " test"},
+		{4, "X-Spam", " yes"},
+	}
+	if got := collect(h); !reflect.DeepEqual(got, want) {
+		t.Fatalf("New() fields = %+v, want %+v", got, want)
+	}
+
+	h2 := &Header{}
+	for f := h.Fields(); f.Next(); {
+		h2.AddRaw(f.Key(), f.Raw())
+	}
+	if got := collect(h2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("AddRaw() fields = %+v, want %+v", got, want)
+	}
+
+	f := h.Fields()
+	f.Next()
+	f.InsertBefore("X-Added", "1")
+	f.Next()
+	f.Replace("X-Spam", "maybe")
+	h.Add("X-Spam", "again")
+	want = []field{
+		{-1, "X-Added", " 1"},
+		{0, "Received", " from a\r\n\tby b"},
+		{1, "X-Spam", " maybe"},
+		{2, "Received", " from c"},
+		{3, "Subject", " test"},
+		{4, "X-Spam", " yes"},
+		{-1, "X-Spam", " again"},
+	}
+	if got := collect(h); !reflect.DeepEqual(got, want) {
+		t.Fatalf("modified fields = %+v, want %+v", got, want)
+	}
+}
@@ -66,6 +66,9 @@ const (
 // We reject reading/writing messages larger than 512 MB outright.
 const maxPacketSize = 512 * 1024 * 1024
 
+// ErrPacketSize gets returned by ReadPacket when the length of the incoming packet is invalid.
+var ErrPacketSize = errors.New("milter: invalid packet size")
+
 func ReadPacket(conn net.Conn, timeout time.Duration) (*Message, error) {
 	if timeout != 0 {
 		_ = conn.SetReadDeadline(time.Now().Add(timeout))
@@ -80,8 +83,8 @@ func ReadPacket(conn net.Conn, timeout time.Duration) (*Message, error) {
 		return nil, err
 	}
 
-	if length > maxPacketSize {
-		return nil, fmt.Errorf("milter: reject to read %d bytes in one message", length)
+	if length == 0 || length > maxPacketSize {
+		return nil, fmt.Errorf("%w: reject to read %d bytes in one message", ErrPacketSize, length)
 	}
 
 	// read packet data
@@ -9,6 +9,45 @@ func logWarning(format string, v ...interface{}) {
 	log.Printf(fmt.Sprintf("milter: warning: %s", format), v...)
 }
 
+func logDryRun(act *ModifyAction) {
+	switch act.Type {
+	case ActionAddRcpt:
+		log.Printf("milter: dry-run: add recipient %s %q", act.Rcpt, act.RcptArgs)
+	case ActionDelRcpt:
+		log.Printf("milter: dry-run: delete recipient %s", act.Rcpt)
+	case ActionQuarantine:
+		log.Printf("milter: dry-run: quarantine %q", act.Reason)
+	case ActionReplaceBody:
+		log.Printf("milter: dry-run: replace body chunk of %d bytes", len(act.Body))
+	case ActionChangeFrom:
+		log.Printf("milter: dry-run: change from %s %q", act.From, act.FromArgs)
+	case ActionAddHeader:
+		log.Printf("milter: dry-run: add header %s: %q", act.HeaderName, act.HeaderValue)
+	case ActionChangeHeader:
+		log.Printf("milter: dry-run: change header %d %s: %q", act.HeaderIndex, act.HeaderName, act.HeaderValue)
+	case ActionInsertHeader:
+		log.Printf("milter: dry-run: insert header at %d %s: %q", act.HeaderIndex, act.HeaderName, act.HeaderValue)
+	default:
+		log.Printf("milter: dry-run: %+v", act)
+	}
+}
+
+func logDebug(string, ...interface{}) {
+}
+
+// LogDebug is called by this library for events that are normal but might be interesting when debugging
+// (e.g. the MTA closed the connection while we wanted to send a response).
+//
+// The default implementation does not output anything.
+// You can re-assign LogDebug to something more suitable for your application. But do not assign nil to it.
+var LogDebug = logDebug
+
+// LogDryRun is called by this library for every message modification that gets suppressed by [WithDryRun].
+//
+// The default implementation uses [log.Print] to output the modification.
+// You can re-assign LogDryRun to something more suitable for your application (e.g. a structured logger). But do not assign nil to it.
+var LogDryRun = logDryRun
+
 // LogWarning is called by this library when it wants to output a warning.
 // Warnings can happen even when the library user did everything right (because the other end did something wrong)
 //
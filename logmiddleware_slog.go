@@ -0,0 +1,153 @@
+//go:build go1.21
+
+package milter
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// LoggingMiddleware is a [Middleware] that logs every callback of the wrapped [Milter] as a structured log record.
+// Use [NewLoggingMiddleware] to create it.
+type LoggingMiddleware struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// NewLoggingMiddleware creates a new [LoggingMiddleware] that logs every callback of the wrapped [Milter] with logger at level.
+// A nil logger means [slog.Default].
+//
+// Every record has the attributes
+//
+//	phase       -- the callback, see [Callback.String]
+//	sender      -- the envelope sender (see [Modifier.Sender]), empty before MAIL FROM and for bounces
+//	recipient   -- the recipient, only for the rcpt-to phase
+//	header_name -- the name of the header field, only for the header phase
+//	response    -- the response of the wrapped Milter without the "response=" prefix of [Response.String] (e.g. "accept")
+//	latency_ms  -- the time the wrapped Milter needed for the callback in milliseconds
+//	error       -- the error the wrapped Milter returned, only when it returned one
+func NewLoggingMiddleware(logger *slog.Logger, level slog.Level) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &LoggingMiddleware{logger: logger, level: level}
+}
+
+// Wrap wraps next so that its callbacks get logged.
+func (l *LoggingMiddleware) Wrap(next Milter) Milter {
+	return &loggingMilter{log: l, next: next}
+}
+
+type loggingMilter struct {
+	log  *LoggingMiddleware
+	next Milter
+}
+
+func (l *loggingMilter) record(callback Callback, m *Modifier, start time.Time, resp *Response, err error, attrs ...slog.Attr) {
+	if !l.log.logger.Enabled(context.Background(), l.log.level) {
+		return
+	}
+	latency := time.Since(start)
+	var sender string
+	if m != nil {
+		sender = m.Sender()
+	}
+	attrs = append([]slog.Attr{
+		slog.String("phase", callback.String()),
+		slog.String("sender", sender),
+	}, attrs...)
+	if resp != nil {
+		attrs = append(attrs, slog.String("response", strings.TrimPrefix(resp.String(), "response=")))
+	}
+	attrs = append(attrs, slog.Float64("latency_ms", float64(latency.Microseconds())/1000))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	l.log.logger.LogAttrs(context.Background(), l.log.level, "milter "+callback.String(), attrs...)
+}
+
+func (l *loggingMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.Connect(host, family, port, addr, m)
+	l.record(CallbackConnect, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) Helo(name string, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.Helo(name, m)
+	l.record(CallbackHelo, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.MailFrom(from, esmtpArgs, m)
+	l.record(CallbackMailFrom, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.RcptTo(rcptTo, esmtpArgs, m)
+	l.record(CallbackRcptTo, m, start, resp, err, slog.String("recipient", RemoveAngle(rcptTo)))
+	return resp, err
+}
+
+func (l *loggingMilter) Data(m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.Data(m)
+	l.record(CallbackData, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) Header(name string, value string, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.Header(name, value, m)
+	l.record(CallbackHeader, m, start, resp, err, slog.String("header_name", name))
+	return resp, err
+}
+
+func (l *loggingMilter) Headers(m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.Headers(m)
+	l.record(CallbackHeaders, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.BodyChunk(chunk, m)
+	l.record(CallbackBodyChunk, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.EndOfMessage(m)
+	l.record(CallbackEndOfMessage, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) Abort(m *Modifier) error {
+	start := time.Now()
+	err := l.next.Abort(m)
+	l.record(CallbackAbort, m, start, nil, err)
+	return err
+}
+
+func (l *loggingMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	start := time.Now()
+	resp, err := l.next.Unknown(cmd, m)
+	l.record(CallbackUnknown, m, start, resp, err)
+	return resp, err
+}
+
+func (l *loggingMilter) Cleanup() {
+	l.next.Cleanup()
+}
+
+var _ Middleware = (*LoggingMiddleware)(nil)
+var _ Milter = (*loggingMilter)(nil)
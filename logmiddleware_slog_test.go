@@ -0,0 +1,107 @@
+//go:build go1.21
+
+package milter
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	mw := NewLoggingMiddleware(logger, slog.LevelInfo)
+	eomResp, _ := RejectWithCodeAndReason(554, "5.7.1 spam")
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      eomResp,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mw.Wrap(mm)
+	})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("localhost")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderField("Subject", "test", nil)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(strings.NewReader("test\r\n"))
+	assertAction(t, act, err, ActionRejectWithCode)
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	expected := []map[string]interface{}{
+		{"phase": "connect", "sender": "", "response": "continue"},
+		{"phase": "helo", "sender": "", "response": "continue"},
+		{"phase": "mail-from", "sender": "from@example.com", "response": "continue"},
+		{"phase": "rcpt-to", "sender": "from@example.com", "recipient": "to@example.com", "response": "continue"},
+		{"phase": "data", "sender": "from@example.com", "response": "continue"},
+		{"phase": "header", "sender": "from@example.com", "header_name": "Subject", "response": "continue"},
+		{"phase": "headers", "sender": "from@example.com", "response": "continue"},
+		{"phase": "body-chunk", "sender": "from@example.com", "response": "continue"},
+		{"phase": "end-of-message", "sender": "from@example.com", "response": `reply_code action=reject code=554 reason="554 5.7.1 spam"`},
+	}
+	got := records
+	if len(got) != len(expected) {
+		t.Fatalf("got %d log records, want %d: %s", len(got), len(expected), buf.String())
+	}
+	for i, want := range expected {
+		for k, v := range want {
+			if got[i][k] != v {
+				t.Errorf("record %d: %s = %v, want %v", i, k, got[i][k], v)
+			}
+		}
+		if _, ok := got[i]["latency_ms"].(float64); !ok {
+			t.Errorf("record %d: latency_ms = %v", i, got[i]["latency_ms"])
+		}
+		if got[i]["level"] != "INFO" {
+			t.Errorf("record %d: level = %v", i, got[i]["level"])
+		}
+	}
+	if _, ok := got[0]["recipient"]; ok {
+		t.Errorf("connect record has recipient: %v", got[0])
+	}
+}
+
+func TestLoggingMiddleware_disabledLevel(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	m := NewLoggingMiddleware(logger, slog.LevelDebug).Wrap(&MockMilter{HeloResp: RespContinue})
+	if resp, err := m.Helo("localhost", nil); resp != RespContinue || err != nil {
+		t.Fatalf("Helo() = %v, %v", resp, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got log output %q for a disabled level", buf.String())
+	}
+	if NewLoggingMiddleware(nil, slog.LevelInfo) == nil {
+		t.Error("NewLoggingMiddleware(nil) returned nil")
+	}
+}
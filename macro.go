@@ -22,6 +22,17 @@ const (
 	StageNotFoundMarker                   // identifies that a macro was not found
 )
 
+// MacroName is the name of a macro as the MTA sends it.
+// Single-character macros are sent as-is (e.g. "i"), longer macro names are enclosed in braces (e.g. "{auth_type}").
+//
+// The constants of this package are only the macros that are commonly used. [Macros] gives you access to every macro
+// the MTA sends – you just need to use its raw name, e.g. m.Macros.Get("{my_macro}").
+// Some MTAs also send single-character macros with braces ("{i}"). [Macros] returned by this library
+// find single-character macros with and without braces, so m.Macros.Get("i") and m.Macros.Get("{i}") return the same value.
+//
+// The MTA only sends the macros it knows and that are configured/requested for the current stage.
+// Consult the documentation of your MTA for a complete list: sendmail lists its macros in the "Macros" section of its
+// operations guide (op.me) and Postfix in its MILTER_README under "Macros".
 type MacroName = string
 
 // Macros that have good support between MTAs like sendmail and Postfix
@@ -49,8 +60,8 @@ const (
 	MacroAuthSsf           MacroName = "{auth_ssf}"           // The key length (in bits) of the used encryption layer (TLS) – if any
 	MacroAuthAuthor        MacroName = "{auth_author}"        // The optional overwrite username for this message
 	MacroMailMailer        MacroName = "{mail_mailer}"        // the delivery agent for this MAIL FROM (e.g. esmtp, lmtp)
-	MacroMailHost          MacroName = "{mail_host}"          // the domain part of the MAIL FROM address
-	MacroMailAddr          MacroName = "{mail_addr}"          // the MAIL FROM address (only the address without <>)
+	MacroMailHost          MacroName = "{mail_host}"          // the domain part of the MAIL FROM address. MTAs differ in what they set for the null sender <>, do not rely on it.
+	MacroMailAddr          MacroName = "{mail_addr}"          // the MAIL FROM address (only the address without <>). For the null sender <> this is the empty string or "<>" depending on the MTA, use [Modifier.Sender] (it is always the empty string for the null sender) instead.
 	MacroRcptMailer        MacroName = "{rcpt_mailer}"        // MacroRcptMailer holds the delivery agent for the current RCPT TO address
 	MacroRcptHost          MacroName = "{rcpt_host}"          // The domain part of the RCPT TO address
 	MacroRcptAddr          MacroName = "{rcpt_addr}"          // the RCPT TO address (only the address without <>)
@@ -58,15 +69,33 @@ const (
 
 // Macros that do not have good cross-MTA support. Only usable with sendmail as MTA.
 const (
-	MacroRFC1413AuthInfo    MacroName = "_"
-	MacroHopCount           MacroName = "c"
-	MacroSenderHostName     MacroName = "s"
-	MacroProtocolUsed       MacroName = "r"
-	MacroMTAPid             MacroName = "p"
-	MacroDateRFC822Origin   MacroName = "a"
-	MacroDateRFC822Current  MacroName = "b"
-	MacroDateANSICCurrent   MacroName = "d"
-	MacroDateSecondsCurrent MacroName = "t"
+	MacroRFC1413AuthInfo    MacroName = "_" // RFC 1413 ident information of the client (user@host [ip])
+	MacroHopCount           MacroName = "c" // hop count (number of Received header fields) of the message
+	MacroSenderHostName     MacroName = "s" // sender's host name (HELO/EHLO argument or client hostname)
+	MacroProtocolUsed       MacroName = "r" // protocol used to receive the message (e.g. SMTP, ESMTP, ESMTPSA)
+	MacroMTAPid             MacroName = "p" // process ID of the MTA
+	MacroDateRFC822Origin   MacroName = "a" // origin date of the message in RFC 822 format (from the Date header)
+	MacroDateRFC822Current  MacroName = "b" // current date in RFC 822 format
+	MacroDateANSICCurrent   MacroName = "d" // current date in ANSI C (ctime) format
+	MacroDateSecondsCurrent MacroName = "t" // current date as seconds since the epoch
+	MacroEntryMessage       MacroName = "e" // SMTP entry (greeting) message (deprecated in sendmail, use the SmtpGreetingMessage option)
+	MacroSenderAddr         MacroName = "f" // envelope sender address (same as MAIL FROM but sendmail may have rewritten it)
+	MacroSenderRelativeAddr MacroName = "g" // envelope sender address relative to the recipient
+	MacroRcptHostName       MacroName = "h" // host of the current recipient
+	MacroRcptUser           MacroName = "u" // user part of the current recipient
+	MacroSenderFullName     MacroName = "x" // full name of the sender
+)
+
+// MacroClientResolve is the result of the reverse DNS verification of the client: OK, FAIL, FORGED or TEMP.
+// Only sendmail sends it, see [ParseClientReverseDNS].
+const MacroClientResolve MacroName = "{client_resolve}"
+
+// Macros that the MTA does not send but this library sets.
+const (
+	// MacroHelo is the HELO/EHLO hostname. When you request it with [WithMacroRequest] a [ClientSession]
+	// sends the hostname of its last [ClientSession.Helo] call as the value of this macro.
+	// This way a milter that proxies to another milter can present a normalized HELO hostname to the downstream milter.
+	MacroHelo MacroName = "{helo}"
 )
 
 type macroRequests [][]MacroName
@@ -102,6 +131,11 @@ func (m *MacroBag) GetEx(name MacroName) (value string, ok bool) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 	value, ok = m.macros[name]
+	if !ok {
+		if alt, hasAlt := alternativeMacroName(name); hasAlt {
+			value, ok = m.macros[alt]
+		}
+	}
 	if !ok {
 		switch name {
 		case MacroDateRFC822Origin:
@@ -170,7 +204,28 @@ func newMacroStages() *macrosStages {
 	}
 }
 
+// alternativeMacroName returns the other spelling of a single-character macro name ("i" for "{i}" and "{i}" for "i").
+func alternativeMacroName(name MacroName) (MacroName, bool) {
+	if len(name) == 1 {
+		return "{" + name + "}", true
+	}
+	if len(name) == 3 && name[0] == '{' && name[2] == '}' {
+		return name[1:2], true
+	}
+	return "", false
+}
+
 func (s *macrosStages) GetMacroEx(name MacroName) (value string, stageFound MacroStage) {
+	value, stageFound = s.getMacroEx(name)
+	if stageFound == StageNotFoundMarker {
+		if alt, ok := alternativeMacroName(name); ok {
+			return s.getMacroEx(alt)
+		}
+	}
+	return
+}
+
+func (s *macrosStages) getMacroEx(name MacroName) (value string, stageFound MacroStage) {
 	i := StageEndMarker
 	for {
 		if s.byStages[i] != nil {
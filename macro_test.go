@@ -558,3 +558,47 @@ func Test_removeEmpty(t *testing.T) {
 		})
 	}
 }
+
+func TestMacros_RawNames(t *testing.T) {
+	t.Parallel()
+	// arbitrary macro names that this library does not know about
+	names := []MacroName{MacroEntryMessage, MacroSenderAddr, "{my_custom_macro}", "{x-unknown}", "{i}", "Z"}
+	bag := NewMacroBag()
+	for _, name := range names {
+		bag.Set(name, "value of "+name)
+	}
+	got := map[MacroName]string{}
+	gotAlternative := map[MacroName]string{}
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			for _, name := range names {
+				got[name] = m.Macros.Get(name)
+			}
+			gotAlternative["i"] = m.Macros.Get("i")
+			gotAlternative["{Z}"] = m.Macros.Get("{Z}")
+		},
+	}
+	w := newServerClient(t, bag, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithMacroRequest(StageConnect, names)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	if err != nil || act.Type != ActionContinue {
+		t.Fatalf("Conn() = %+v, %v", act, err)
+	}
+	for _, name := range names {
+		if got[name] != "value of "+name {
+			t.Errorf("Get(%q) = %q, want %q", name, got[name], "value of "+name)
+		}
+	}
+	if gotAlternative["i"] != "value of {i}" {
+		t.Errorf("Get(%q) = %q, want %q", "i", gotAlternative["i"], "value of {i}")
+	}
+	if gotAlternative["{Z}"] != "value of Z" {
+		t.Errorf("Get(%q) = %q, want %q", "{Z}", gotAlternative["{Z}"], "value of Z")
+	}
+	if v := bag.Get("{e}"); v != "value of e" {
+		t.Errorf("MacroBag.Get(%q) = %q, want %q", "{e}", v, "value of e")
+	}
+}
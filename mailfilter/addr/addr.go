@@ -15,12 +15,8 @@ var IDNAProfile = idna.Lookup
 // split an user@domain address into user and domain.
 // Includes the input address as third array element to quickly check if splitting must be re-done
 func split(addr string) []string {
-	at := strings.LastIndex(addr, "@")
-	if at < 0 {
-		return []string{addr, "", addr}
-	}
-
-	return []string{addr[:at], addr[at+1:], addr}
+	a := Address(addr)
+	return []string{a.Local(), a.Domain(), addr}
 }
 
 type addr struct {
@@ -93,6 +89,33 @@ func (a *addr) UnicodeDomain() string {
 	return unicodeDomain
 }
 
+// Params returns the ESMTP parameters of Args as map. The keys are the upper-cased parameter keywords
+// (e.g. "SIZE", "BODY" or "AUTH"), the values are returned as-is (xtext encoded values do not get decoded).
+// Parameters without a value (e.g. "SMTPUTF8") have the empty string as value.
+// Returns an empty map when Args is empty.
+func (a *addr) Params() map[string]string {
+	return ParseParams(a.Args)
+}
+
+// Param returns the value of the ESMTP parameter keyword (case-insensitive) of Args
+// and whether Args includes this parameter.
+func (a *addr) Param(keyword string) (value string, ok bool) {
+	value, ok = ParseParams(a.Args)[strings.ToUpper(keyword)]
+	return
+}
+
+// ParseParams parses the ESMTP parameters esmtpArgs of a MAIL FROM or RCPT TO command (e.g. "SIZE=1024 BODY=8BITMIME")
+// into a map. See [MailFrom.Params] for the format of the map.
+// When a keyword occurs multiple times the last value wins.
+func ParseParams(esmtpArgs string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Fields(esmtpArgs) {
+		keyword, value, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(keyword)] = value
+	}
+	return params
+}
+
 // MailFrom is the sender address and the sender info (used transport, authenticated user).
 type MailFrom struct {
 	addr
@@ -126,6 +149,12 @@ func (m *MailFrom) AuthenticationMethod() string {
 	return m.authenticationMethod
 }
 
+// IsNull returns true when m is the null sender <> (the Addr is empty).
+// MTAs use the null sender for bounces and other delivery status notifications.
+func (m *MailFrom) IsNull() bool {
+	return Address(m.Addr).IsEmpty()
+}
+
 // Copy returns an independent copy of m.
 func (m *MailFrom) Copy() *MailFrom {
 	if m == nil {
@@ -151,6 +151,18 @@ func TestMailFrom(t *testing.T) {
 	}
 }
 
+func TestMailFrom_IsNull(t *testing.T) {
+	for _, tt := range []struct {
+		from string
+		want bool
+	}{{"", true}, {"<>", true}, {" <> ", true}, {"root@localhost", false}, {"<root@localhost>", false}} {
+		m := NewMailFrom(tt.from, "", "", "", "")
+		if got := m.IsNull(); got != tt.want {
+			t.Errorf("IsNull() of %q = %v, want %v", tt.from, got, tt.want)
+		}
+	}
+}
+
 func TestRcptTo(t *testing.T) {
 	m := RcptTo{
 		addr:      addr{Addr: "root@localhost", Args: "A=B"},
@@ -180,6 +192,54 @@ func Test_split(t *testing.T) {
 	}
 }
 
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		esmtpArgs string
+		want      map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"SIZE", "SIZE=1024", map[string]string{"SIZE": "1024"}},
+		{"multiple", "SIZE=1024  BODY=8BITMIME AUTH=<>", map[string]string{"SIZE": "1024", "BODY": "8BITMIME", "AUTH": "<>"}},
+		{"AUTH", "AUTH=user+40example.com", map[string]string{"AUTH": "user+40example.com"}},
+		{"no value", "SMTPUTF8 REQUIRETLS", map[string]string{"SMTPUTF8": "", "REQUIRETLS": ""}},
+		{"case", "size=1024 Auth=user", map[string]string{"SIZE": "1024", "AUTH": "user"}},
+		{"equals in value", "ENVID=a=b", map[string]string{"ENVID": "a=b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseParams(tt.esmtpArgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMailFrom_Param(t *testing.T) {
+	m := NewMailFrom("root@localhost", "SIZE=1024 AUTH=root@localhost SMTPUTF8", "", "", "")
+	if got := m.Params(); !reflect.DeepEqual(got, map[string]string{"SIZE": "1024", "AUTH": "root@localhost", "SMTPUTF8": ""}) {
+		t.Errorf("Params() = %v", got)
+	}
+	for _, tt := range []struct {
+		keyword string
+		value   string
+		ok      bool
+	}{
+		{"SIZE", "1024", true},
+		{"auth", "root@localhost", true},
+		{"SMTPUTF8", "", true},
+		{"BODY", "", false},
+	} {
+		if value, ok := m.Param(tt.keyword); value != tt.value || ok != tt.ok {
+			t.Errorf("Param(%q) = %q, %v, want %q, %v", tt.keyword, value, ok, tt.value, tt.ok)
+		}
+	}
+	r := NewRcptTo("root@localhost", "NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;root@localhost", "")
+	if value, ok := r.Param("NOTIFY"); value != "SUCCESS,FAILURE" || !ok {
+		t.Errorf("Param(NOTIFY) = %q, %v", value, ok)
+	}
+}
+
 func TestNewMailFrom(t *testing.T) {
 	type args struct {
 		from                 string
@@ -0,0 +1,121 @@
+package addr
+
+import (
+	"net"
+	"strings"
+)
+
+// Address is an address of the SMTP envelope like the argument of a MAIL FROM or RCPT TO command.
+// One layer of angle brackets and surrounding white space get ignored by all methods of Address,
+// so "<root@localhost>" and "root@localhost" are the same Address.
+type Address string
+
+// trimmed returns a without surrounding white space and angle brackets.
+func (a Address) trimmed() string {
+	s := strings.TrimSpace(string(a))
+	if len(s) > 1 && s[0] == '<' && s[len(s)-1] == '>' {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+// IsEmpty returns true when a is the null sender <> (or the empty string).
+func (a Address) IsEmpty() bool {
+	return a.trimmed() == ""
+}
+
+// Local returns the part of a in front of the last @ symbol.
+// If a does not include an @ the whole address gets returned.
+func (a Address) Local() string {
+	s := a.trimmed()
+	if at := strings.LastIndexByte(s, '@'); at >= 0 {
+		return s[:at]
+	}
+	return s
+}
+
+// Domain returns the part of a after the last @ symbol. It is returned as-is without any validation.
+// If a does not include an @ an empty string gets returned.
+func (a Address) Domain() string {
+	s := a.trimmed()
+	if at := strings.LastIndexByte(s, '@'); at >= 0 {
+		return s[at+1:]
+	}
+	return ""
+}
+
+// IsValid returns true when a is a syntactically valid mailbox of RFC 5321 (with the UTF-8 extension of RFC 6531):
+// a dot-atom or quoted local part of at most 64 bytes, an @ and a domain name or an address literal
+// like [192.0.2.1] or [IPv6:2001:db8::1].
+//
+// The null sender <> is not a mailbox, IsValid returns false for it. Use [Address.IsEmpty] to check for it.
+// Source routes (<@a,@b:user@example.com>) are not supported.
+func (a Address) IsValid() bool {
+	s := a.trimmed()
+	at := strings.LastIndexByte(s, '@')
+	if at < 0 {
+		return false
+	}
+	local, domain := s[:at], s[at+1:]
+	if len(local) > 64 || !(isDotAtom(local) || isQuotedLocal(local)) {
+		return false
+	}
+	if len(domain) > 1 && domain[0] == '[' && domain[len(domain)-1] == ']' {
+		return isAddressLiteral(domain[1 : len(domain)-1])
+	}
+	return isDomain(domain)
+}
+
+// isQuotedLocal returns true when s is a Quoted-string of RFC 5321.
+func isQuotedLocal(s string) bool {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return false
+	}
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(s) || s[i] < ' ' || s[i] > '~' {
+				return false
+			}
+		case c == '"', c < ' ', c == 0x7f:
+			return false
+		}
+	}
+	return true
+}
+
+// isDomain returns true when s is a Domain of RFC 5321. U-labels get converted with [IDNAProfile] before the check.
+func isDomain(s string) bool {
+	ascii, err := IDNAProfile.ToASCII(s)
+	if err != nil {
+		return false
+	}
+	if ascii == "" || len(ascii) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isAddressLiteral returns true when s is the content of an IPv4 or IPv6 address-literal of RFC 5321.
+func isAddressLiteral(s string) bool {
+	if len(s) > 5 && strings.EqualFold(s[:5], "IPv6:") {
+		ip := net.ParseIP(s[5:])
+		return ip != nil && strings.Contains(s[5:], ":")
+	}
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+}
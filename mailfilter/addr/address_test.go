@@ -0,0 +1,60 @@
+package addr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       Address
+		local   string
+		domain  string
+		isEmpty bool
+		isValid bool
+	}{
+		{"empty", "", "", "", true, false},
+		{"null sender", "<>", "", "", true, false},
+		{"null sender with space", " < > ", "", "", true, false},
+		{"no domain", "root", "root", "", false, false},
+		{"normal", "root@localhost", "root", "localhost", false, true},
+		{"angle brackets", "<root@example.com>", "root", "example.com", false, true},
+		{"sub-address", "user+tag@example.com", "user+tag", "example.com", false, true},
+		{"quoted local", `"john doe"@example.com`, `"john doe"`, "example.com", false, true},
+		{"quoted local with @", `"a@b"@example.com`, `"a@b"`, "example.com", false, true},
+		{"quoted local with escape", `"a\"b"@example.com`, `"a\"b"`, "example.com", false, true},
+		{"unterminated quoted local", `"a\"@example.com`, `"a\"`, "example.com", false, false},
+		{"IDNA", "root@スパム.example.com", "root", "スパム.example.com", false, true},
+		{"UTF-8 local", "用户@example.com", "用户", "example.com", false, true},
+		{"IPv4 literal", "root@[192.0.2.1]", "root", "[192.0.2.1]", false, true},
+		{"IPv6 literal", "root@[IPv6:2001:db8::1]", "root", "[IPv6:2001:db8::1]", false, true},
+		{"IPv6 literal without tag", "root@[2001:db8::1]", "root", "[2001:db8::1]", false, false},
+		{"invalid IPv4 literal", "root@[192.0.2.256]", "root", "[192.0.2.256]", false, false},
+		{"empty local", "@example.com", "", "example.com", false, false},
+		{"empty domain", "root@", "root", "", false, false},
+		{"double dot local", "a..b@example.com", "a..b", "example.com", false, false},
+		{"double dot domain", "root@example..com", "root", "example..com", false, false},
+		{"hyphen domain", "root@-example.com", "root", "-example.com", false, false},
+		{"space in local", "a b@example.com", "a b", "example.com", false, false},
+		{"underscore domain", "root@exa_mple.com", "root", "exa_mple.com", false, false},
+		{"long local", Address(strings.Repeat("a", 65) + "@example.com"), strings.Repeat("a", 65), "example.com", false, false},
+		{"max local", Address(strings.Repeat("a", 64) + "@example.com"), strings.Repeat("a", 64), "example.com", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Local(); got != tt.local {
+				t.Errorf("Local() = %q, want %q", got, tt.local)
+			}
+			if got := tt.a.Domain(); got != tt.domain {
+				t.Errorf("Domain() = %q, want %q", got, tt.domain)
+			}
+			if got := tt.a.IsEmpty(); got != tt.isEmpty {
+				t.Errorf("IsEmpty() = %v, want %v", got, tt.isEmpty)
+			}
+			if got := tt.a.IsValid(); got != tt.isValid {
+				t.Errorf("IsValid() = %v, want %v", got, tt.isValid)
+			}
+		})
+	}
+}
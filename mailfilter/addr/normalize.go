@@ -0,0 +1,138 @@
+package addr
+
+import (
+	"strings"
+)
+
+// NormalizeOption changes how [Normalize] and [Equal] normalize an address. You can combine options with |.
+type NormalizeOption uint
+
+const (
+	// NormalizeLocalCase lower-cases the local part. RFC 5321 says that the local part is case-sensitive,
+	// but nearly all mail servers treat it case-insensitive.
+	NormalizeLocalCase NormalizeOption = 1 << iota
+	// NormalizeStripTag removes the +tag of a sub-address (user+tag@example.com becomes user@example.com).
+	// The tag of a quoted local part that needs to stay quoted does not get removed.
+	NormalizeStripTag
+	// NormalizeUnicodeDomain outputs the domain in its Unicode representation instead of the ASCII (punycode) representation.
+	NormalizeUnicodeDomain
+)
+
+// Normalize returns a normalized version of the address s that you can compare with other normalized addresses
+// (e.g. to check against an allow or deny list):
+//
+//   - white space and angle brackets around the address get removed
+//   - the domain gets lower-cased, a trailing dot gets removed and it gets converted to its ASCII representation with [IDNAProfile]
+//     (or its Unicode representation when you use [NormalizeUnicodeDomain])
+//   - a quoted local part that does not need to be quoted gets unquoted ("john.doe"@example.com becomes john.doe@example.com)
+//
+// opts enables additional normalizations. The null sender <> gets normalized to the empty string.
+// An address without @ gets treated as a local part.
+func Normalize(s string, opts NormalizeOption) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 1 && s[0] == '<' && s[len(s)-1] == '>' {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	if s == "" {
+		return ""
+	}
+	parts := split(s)
+	local, domain := normalizeLocal(parts[0], opts), normalizeDomain(parts[1], opts)
+	if domain == "" && !strings.Contains(s, "@") {
+		return local
+	}
+	return local + "@" + domain
+}
+
+// Equal returns true when the addresses a and b are the same after [Normalize] normalized them with opts.
+func Equal(a, b string, opts NormalizeOption) bool {
+	return Normalize(a, opts) == Normalize(b, opts)
+}
+
+func normalizeLocal(local string, opts NormalizeOption) string {
+	if len(local) > 1 && local[0] == '"' && local[len(local)-1] == '"' {
+		unquoted := unquoteLocal(local[1 : len(local)-1])
+		if !isDotAtom(unquoted) {
+			if opts&NormalizeLocalCase != 0 {
+				unquoted = strings.ToLower(unquoted)
+			}
+			return quoteLocal(unquoted)
+		}
+		local = unquoted
+	}
+	if opts&NormalizeStripTag != 0 {
+		if plus := strings.IndexByte(local, '+'); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	if opts&NormalizeLocalCase != 0 {
+		local = strings.ToLower(local)
+	}
+	return local
+}
+
+func normalizeDomain(domain string, opts NormalizeOption) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return ""
+	}
+	var converted string
+	var err error
+	if opts&NormalizeUnicodeDomain != 0 {
+		converted, err = IDNAProfile.ToUnicode(domain)
+	} else {
+		converted, err = IDNAProfile.ToASCII(domain)
+	}
+	if err != nil {
+		return domain
+	}
+	return strings.ToLower(converted)
+}
+
+// unquoteLocal removes the backslash escapes of the content of a quoted local part.
+func unquoteLocal(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// quoteLocal quotes s as a quoted local part and escapes " and \ with a backslash.
+func quoteLocal(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isDotAtom returns true when s is a valid dot-atom local part (RFC 5322 with the UTF-8 extension of RFC 6531).
+func isDotAtom(s string) bool {
+	if s == "" || s[0] == '.' || s[len(s)-1] == '.' || strings.Contains(s, "..") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 0x80, c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-/=?^_`{|}~.", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package addr
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		opts NormalizeOption
+		want string
+	}{
+		{"empty", "", 0, ""},
+		{"null sender", "<>", 0, ""},
+		{"angle", " <root@example.com> ", 0, "root@example.com"},
+		{"no domain", "root", 0, "root"},
+		{"empty domain", "root@", 0, "root@"},
+		{"domain case", "Root@Example.COM", 0, "Root@example.com"},
+		{"trailing dot", "root@example.com.", 0, "root@example.com"},
+		{"local case", "Root@Example.COM", NormalizeLocalCase, "root@example.com"},
+		{"tag kept", "root+spam@example.com", 0, "root+spam@example.com"},
+		{"tag", "root+spam@example.com", NormalizeStripTag, "root@example.com"},
+		{"tag multiple", "root+spam+more@example.com", NormalizeStripTag, "root@example.com"},
+		{"tag only", "+spam@example.com", NormalizeStripTag, "+spam@example.com"},
+		{"tag and case", "<Root+Spam@Example.com>", NormalizeStripTag | NormalizeLocalCase, "root@example.com"},
+		{"IDN", "root@スパム.example.com", 0, "root@xn--zck5b2b.example.com"},
+		{"IDN encoded", "root@XN--ZCK5B2B.example.com", 0, "root@xn--zck5b2b.example.com"},
+		{"IDN unicode", "root@xn--zck5b2b.example.com", NormalizeUnicodeDomain, "root@スパム.example.com"},
+		{"IDN broken", "root@スパム\u0000.example.com", 0, "root@スパム\u0000.example.com"},
+		{"UTF-8 local", "Jörg@example.com", NormalizeLocalCase, "jörg@example.com"},
+		{"quoted unnecessary", `"root"@example.com`, 0, "root@example.com"},
+		{"quoted unnecessary tag", `"Root+spam"@example.com`, NormalizeStripTag | NormalizeLocalCase, "root@example.com"},
+		{"quoted escaped", `"r\oot"@example.com`, 0, "root@example.com"},
+		{"quoted space", `"john doe"@example.com`, 0, `"john doe"@example.com`},
+		{"quoted space case", `"John Doe+tag"@example.com`, NormalizeLocalCase | NormalizeStripTag, `"john doe+tag"@example.com`},
+		{"quoted at", `"root@home"@example.com`, 0, `"root@home"@example.com`},
+		{"quoted escapes", `"a\"b\\c"@example.com`, 0, `"a\"b\\c"@example.com`},
+		{"quoted double dot", `"a..b"@example.com`, 0, `"a..b"@example.com`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.s, tt.opts); got != tt.want {
+				t.Errorf("Normalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		opts NormalizeOption
+		want bool
+	}{
+		{"same", "root@example.com", "root@example.com", 0, true},
+		{"angle", "<root@example.com>", "root@example.com", 0, true},
+		{"domain case", "root@EXAMPLE.com", "root@example.com", 0, true},
+		{"local case", "ROOT@example.com", "root@example.com", 0, false},
+		{"local case folded", "ROOT@example.com", "root@example.com", NormalizeLocalCase, true},
+		{"tag", "root+a@example.com", "root@example.com", 0, false},
+		{"tag stripped", "root+a@example.com", "root@example.com", NormalizeStripTag, true},
+		{"IDN", "root@スパム.example.com", "root@xn--zck5b2b.example.com", 0, true},
+		{"quoted", `"root"@example.com`, "root@example.com", 0, true},
+		{"different domain", "root@example.com", "root@example.net", NormalizeLocalCase | NormalizeStripTag, false},
+		{"subdomain", "root@sub.example.com", "root@example.com", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Equal(tt.a, tt.b, tt.opts); got != tt.want {
+				t.Errorf("Equal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package mailfilter
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/milterutil"
+)
+
+// fileSignatures are the magic bytes of file types that get blocked regularly and the extensions of these file types.
+// A blocked extension also blocks attachments whose content starts with the magic bytes of its file type.
+var fileSignatures = []struct {
+	magic      []byte
+	extensions []string
+}{
+	{[]byte("MZ"), []string{"exe", "dll", "scr", "com", "pif", "cpl", "sys", "ocx"}},
+	{[]byte("\x7fELF"), []string{"elf", "bin", "so"}},
+	{[]byte("\xca\xfe\xba\xbe"), []string{"class"}},
+	{[]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), []string{"msi", "doc", "xls", "ppt"}},
+	{[]byte("PK\x03\x04"), []string{"zip", "jar"}},
+	{[]byte("Rar!\x1a\x07"), []string{"rar"}},
+	{[]byte("7z\xbc\xaf\x27\x1c"), []string{"7z"}},
+	{[]byte("\x1f\x8b"), []string{"gz", "tgz"}},
+	{[]byte("%PDF-"), []string{"pdf"}},
+}
+
+// normalizeExtension returns ext lower-cased and without leading dot
+func normalizeExtension(ext string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+}
+
+// hasExtension returns true when the file name filename has the extension ext (lower-case, without leading dot).
+// Every extension of a file name with multiple extensions counts, so "invoice.exe.pdf" has the extensions
+// "exe", "pdf" and "exe.pdf". Trailing dots and spaces (that Windows ignores) get removed.
+func hasExtension(filename, ext string) bool {
+	filename = strings.TrimRight(strings.ToLower(filename), ". ")
+	i := strings.IndexByte(filename, '.')
+	if i < 0 {
+		return false
+	}
+	extensions := "." + filename[i+1:] + "."
+	return strings.Contains(extensions, "."+ext+".")
+}
+
+// hasSignature returns true when head starts with the magic bytes of a file type with the extension ext.
+func hasSignature(head []byte, ext string) bool {
+	for _, s := range fileSignatures {
+		if !bytes.HasPrefix(head, s.magic) {
+			continue
+		}
+		for _, e := range s.extensions {
+			if e == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blockedAttachment returns the first attachment of attachments that matches one of the blocked extensions.
+func blockedAttachment(attachments []milterutil.Attachment, extensions []string) (milterutil.Attachment, bool) {
+	for _, a := range attachments {
+		for _, ext := range extensions {
+			if hasExtension(a.Filename, ext) || hasSignature(a.Head, ext) {
+				return a, true
+			}
+		}
+	}
+	return milterutil.Attachment{}, false
+}
+
+// checkAttachments returns the decision of [WithBlockedExtensions] when the current message has a blocked attachment.
+// It returns nil when the message does not have one.
+func (b *backend) checkAttachments() Decision {
+	body := b.transaction.Body()
+	if len(b.opts.blockedExtensions) == 0 || body == nil {
+		return nil
+	}
+	var contentType, contentDisposition, contentTransferEncoding string
+	if b.transaction.origHeaders != nil {
+		contentType = b.transaction.origHeaders.UnfoldedValue("Content-Type")
+		contentDisposition = b.transaction.origHeaders.UnfoldedValue("Content-Disposition")
+		contentTransferEncoding = b.transaction.origHeaders.UnfoldedValue("Content-Transfer-Encoding")
+	}
+	attachments, err := milterutil.Attachments(contentType, contentDisposition, contentTransferEncoding, body)
+	if err != nil {
+		// still check the attachments that got found before the error
+		milter.LogWarning("milter: cannot parse the MIME structure of the message: %s", err)
+	}
+	a, found := blockedAttachment(attachments, b.opts.blockedExtensions)
+	if !found {
+		return nil
+	}
+	milter.LogWarning("milter: message has the blocked attachment %q (%s)", a.Filename, a.ContentType)
+	switch b.opts.blockedDecision {
+	case Reject:
+		return CustomErrorResponse(550, "5.7.1 Message contains a blocked attachment")
+	case TempFail:
+		return CustomErrorResponse(451, "4.7.1 Message contains a blocked attachment")
+	default:
+		return b.opts.blockedDecision
+	}
+}
+
+// WithBlockedExtensions configures the [MailFilter] to check the attachments (see [milterutil.Attachments]) of every message
+// before your decision function gets called. When an attachment has one of the file name extensions (e.g. "exe", ".js")
+// the [MailFilter] does not call your decision function and uses decision instead.
+// A nil decision means [Reject]. Extensions get compared case-insensitive.
+//
+// An attachment gets blocked when
+//   - its file name has a blocked extension. Every extension of file names with multiple extensions counts,
+//     so a blocked "exe" also blocks "invoice.pdf.exe" and "invoice.exe.pdf".
+//   - its content starts with the magic bytes of a file type with a blocked extension, e.g. a blocked "exe" also blocks
+//     a Windows executable with the name "invoice.pdf". Only some common file types get detected this way (executables,
+//     archives, OLE documents and PDF). File types that share their magic bytes also get blocked together:
+//     a blocked "zip" also blocks Office Open XML documents (e.g. .docx) since they are ZIP archives.
+//
+// The name of the blocked attachment gets logged with [milter.LogWarning], the SMTP reply does not contain it.
+//
+// This option has no effect when you use [WithDecisionAt] with anything before [DecisionAtEndOfMessage] or [WithoutBody]
+// since the [MailFilter] does not have the body in that case.
+func WithBlockedExtensions(decision Decision, extensions ...string) Option {
+	return func(opt *options) {
+		if decision == nil {
+			decision = Reject
+		}
+		opt.blockedDecision = decision
+		opt.blockedExtensions = make([]string, 0, len(extensions))
+		for _, ext := range extensions {
+			if ext = normalizeExtension(ext); ext != "" {
+				opt.blockedExtensions = append(opt.blockedExtensions, ext)
+			}
+		}
+	}
+}
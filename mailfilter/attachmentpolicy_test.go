@@ -0,0 +1,107 @@
+package mailfilter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// attachmentMessage returns the header fields and the body of a message with the attachment filename and its base64 encoded content
+func attachmentMessage(filename, content string) ([][2]string, string) {
+	return [][2]string{
+		{"From", "<root@localhost>"},
+		{"Content-Type", "multipart/mixed; boundary=b1"},
+	}, "--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"see attachment\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"" + filename + "\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		content + "\r\n" +
+		"--b1--\r\n"
+}
+
+func Test_backend_BlockedExtensions(t *testing.T) {
+	t.Parallel()
+	const (
+		exeContent = "TVqQAAMAAAAEAAAA"
+		pdfContent = "JVBERi0xLjQK"
+	)
+	tests := []struct {
+		name         string
+		decision     Decision
+		filename     string
+		content      string
+		wantReply    string
+		wantResponse *milter.Response
+	}{
+		{"exe", nil, "setup.exe", exeContent, "550 5.7.1 Message contains a blocked attachment", nil},
+		{"pdf", nil, "report.pdf", pdfContent, "", milter.RespAccept},
+		{"double extension", nil, "report.pdf.exe", exeContent, "550 5.7.1 Message contains a blocked attachment", nil},
+		{"upper case and trailing dot", nil, "SETUP.EXE.", exeContent, "550 5.7.1 Message contains a blocked attachment", nil},
+		{"encoded filename", nil, "=?UTF-8?B?UmVjaG51bmcuZXhl?=", pdfContent, "550 5.7.1 Message contains a blocked attachment", nil},
+		{"exe disguised as pdf", nil, "report.pdf", exeContent, "550 5.7.1 Message contains a blocked attachment", nil},
+		{"temp fail", TempFail, "setup.exe", exeContent, "451 4.7.1 Message contains a blocked attachment", nil},
+		{"custom decision", Discard, "setup.exe", exeContent, "", milter.RespDiscard},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			b, s := newMockBackend()
+			WithBlockedExtensions(tt.decision, ".EXE", "js")(&b.opts)
+			called := false
+			b.decision = func(_ context.Context, _ Trx) (Decision, error) {
+				called = true
+				return Accept, nil
+			}
+			fields, body := attachmentMessage(tt.filename, tt.content)
+			sendHeaders(t, b, s, fields)
+			resp, err := b.BodyChunk([]byte(body), s.newModifier())
+			assertContinue(t, resp, err)
+			resp, err = b.EndOfMessage(s.newModifier())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantReply != "" {
+				msg := resp.Response()
+				if msg.Code != wire.Code(wire.ActReplyCode) || string(msg.Data) != tt.wantReply+"\x00" {
+					t.Fatalf("EndOfMessage() = %c %q", msg.Code, msg.Data)
+				}
+			} else if resp != tt.wantResponse {
+				t.Fatalf("EndOfMessage() = %v, want %v", resp, tt.wantResponse)
+			}
+			if blocked := tt.wantResponse != milter.RespAccept; called == blocked {
+				t.Errorf("decision function called = %v, want %v", called, !blocked)
+			}
+		})
+	}
+}
+
+func Test_hasExtension(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		filename string
+		ext      string
+		want     bool
+	}{
+		{"setup.exe", "exe", true},
+		{"setup.exe.pdf", "exe", true},
+		{"setup.pdf.exe", "pdf", true},
+		{"archive.tar.gz", "tar.gz", true},
+		{"exe", "exe", false},
+		{"setup.exe2", "exe", false},
+		{"notes.txt", "exe", false},
+		{"setup.EXE . ", "exe", true},
+	}
+	for _, tt := range tests {
+		if got := hasExtension(tt.filename, tt.ext); got != tt.want {
+			t.Errorf("hasExtension(%q, %q) = %v, want %v", tt.filename, tt.ext, got, tt.want)
+		}
+	}
+}
@@ -51,12 +51,22 @@ func (b *backend) error(err error) (*milter.Response, error) {
 }
 
 func (b *backend) makeDecision(m *milter.Modifier) {
-	ticker := time.NewTicker(time.Second)
+	ticker := time.NewTicker(b.opts.progressInterval())
 	defer ticker.Stop()
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(contextWithStore(context.Background(), b.opts.store))
 	done := make(chan struct{})
+	decide := b.decision
+	if len(b.opts.headerPolicy) > 0 {
+		decide = func(ctx context.Context, trx Trx) (Decision, error) {
+			d, err := b.decision(ctx, trx)
+			if err == nil {
+				applyHeaderPolicy(b.opts.headerPolicy, trx.Headers())
+			}
+			return d, err
+		}
+	}
 	go func() {
-		b.transaction.makeDecision(ctx, b.decision)
+		b.transaction.makeDecision(ctx, decide)
 		done <- struct{}{}
 	}()
 	for {
@@ -119,6 +129,10 @@ func (b *backend) MailFrom(from string, esmtpArgs string, m *milter.Modifier) (*
 	if b.transaction.hasDecision {
 		return milter.RespContinue, nil
 	}
+	if b.opts.budget != nil && b.opts.budget.exhausted() {
+		milter.LogWarning("milter: temp fail transaction because the total buffer budget is exhausted")
+		return (&transaction{decision: respBudgetExhausted}).response(), nil
+	}
 	b.transaction.origMailFrom = addr.NewMailFrom(from, esmtpArgs, m.Macros.Get(milter.MacroMailMailer), m.Macros.Get(milter.MacroAuthAuthen), m.Macros.Get(milter.MacroAuthType))
 	return b.decideOrContinue(DecisionAtMailFrom, m)
 }
@@ -161,7 +175,10 @@ func (b *backend) Header(name string, value string, _ *milter.Modifier) (*milter
 	if name == "" {
 		milter.LogWarning("skip header because we got an empty  name")
 	} else {
-		b.transaction.addHeader(name, []byte(fmt.Sprintf("%s:%s", name, value)))
+		if !b.transaction.addHeader(name, []byte(fmt.Sprintf("%s:%s", name, value))) {
+			b.budgetExhausted()
+			return milter.RespSkip, nil
+		}
 	}
 	return milter.RespContinue, nil
 }
@@ -170,14 +187,61 @@ func (b *backend) Headers(m *milter.Modifier) (*milter.Response, error) {
 	if b.transaction.hasDecision {
 		return milter.RespContinue, nil
 	}
+	if missing := b.missingHeaders(); len(missing) > 0 {
+		milter.LogWarning("milter: message is missing required header fields: %s", strings.Join(missing, ", "))
+		b.transaction.hasDecision = true
+		b.transaction.decision = missingHeadersDecision(b.opts.requiredDecision, missing)
+		return b.transaction.response(), nil
+	}
+	if b.opts.headerCompression && b.opts.decisionAt == DecisionAtEndOfMessage {
+		if err := b.transaction.compressHeaders(); err != nil {
+			return b.error(err)
+		}
+	}
 	return b.decideOrContinue(DecisionAtEndOfHeaders, m)
 }
 
+// missingHeaders returns the canonical names of the required header fields (see [WithRequiredHeaders]) that the current message does not have.
+func (b *backend) missingHeaders() (missing []string) {
+	if len(b.opts.requiredHeaders) == 0 {
+		return nil
+	}
+	found := make(map[string]bool, len(b.opts.requiredHeaders))
+	if b.transaction.origHeaders != nil {
+		fields := b.transaction.origHeaders.Fields()
+		for fields.Next() {
+			found[fields.CanonicalKey()] = true
+		}
+	}
+	for _, name := range b.opts.requiredHeaders {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	return
+}
+
+// missingHeadersDecision adds the missing header field names to the reply when decision is [Reject] or [TempFail].
+func missingHeadersDecision(decision Decision, missing []string) Decision {
+	switch decision {
+	case Reject:
+		return CustomErrorResponse(550, fmt.Sprintf("5.6.0 Message is missing required header fields: %s", strings.Join(missing, ", ")))
+	case TempFail:
+		return CustomErrorResponse(451, fmt.Sprintf("4.6.0 Message is missing required header fields: %s", strings.Join(missing, ", ")))
+	default:
+		return decision
+	}
+}
+
 func (b *backend) BodyChunk(chunk []byte, _ *milter.Modifier) (*milter.Response, error) {
 	if b.transaction.hasDecision || b.opts.skipBody {
 		return milter.RespSkip, nil
 	}
 	err := b.transaction.addBodyChunk(chunk)
+	if err == errBudgetExhausted {
+		b.budgetExhausted()
+		return milter.RespSkip, nil
+	}
 	if err != nil {
 		return b.error(err)
 	}
@@ -198,6 +262,21 @@ func (b *backend) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
 	if !b.transaction.hasDecision && b.transaction.queueId == "" {
 		b.transaction.queueId = m.Macros.Get(milter.MacroQueueId)
 	}
+	if !b.transaction.hasDecision {
+		err := b.transaction.decompressHeaders()
+		if err == errBudgetExhausted {
+			b.budgetExhausted()
+		} else if err != nil {
+			return b.error(err)
+		}
+	}
+	if !b.transaction.hasDecision {
+		if decision := b.checkAttachments(); decision != nil {
+			b.transaction.makeDecision(context.Background(), func(context.Context, Trx) (Decision, error) {
+				return decision, nil
+			})
+		}
+	}
 	if !b.transaction.hasDecision {
 		b.makeDecision(m)
 	}
@@ -226,7 +305,7 @@ func (b *backend) Cleanup() {
 	if b.transaction != nil {
 		b.transaction.cleanup()
 	}
-	b.transaction = &transaction{}
+	b.transaction = &transaction{budget: b.opts.budget}
 }
 
 var _ milter.Milter = (*backend)(nil)
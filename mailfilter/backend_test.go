@@ -1,10 +1,12 @@
 package mailfilter
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -230,6 +232,37 @@ func Test_backend_EndOfMessage(t *testing.T) {
 	}
 }
 
+func Test_backend_MessageSize(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	resp, err := b.Header("From", "<root@localhost>", s.newModifier())
+	assertContinue(t, resp, err)
+	resp, err = b.Header("Subject", "test", s.newModifier())
+	assertContinue(t, resp, err)
+	// more than the in-memory limit of the body, so it gets spilled to a file
+	chunk := bytes.Repeat([]byte("0123456789\r\n"), 5000)
+	for i := 0; i < 5; i++ {
+		resp, err = b.BodyChunk(chunk, s.newModifier())
+		assertContinue(t, resp, err)
+	}
+	var headerSize, bodySize int64
+	b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+		headerSize, bodySize = trx.HeaderSize(), trx.BodySize()
+		return Accept, nil
+	}
+	resp, err = b.EndOfMessage(s.newModifier())
+	if resp != milter.RespAccept || err != nil {
+		t.Fatalf("wrong return %v, %v", resp, err)
+	}
+	// "From: <root@localhost>\r\n" + "Subject: test\r\n"
+	if headerSize != 24+15 {
+		t.Errorf("HeaderSize() = %d, want %d", headerSize, 24+15)
+	}
+	if bodySize != int64(len(chunk)*5) {
+		t.Errorf("BodySize() = %d, want %d", bodySize, len(chunk)*5)
+	}
+}
+
 func outputFields(hdr *header.Header) string {
 	bytes, _ := io.ReadAll(hdr.Reader())
 	return string(bytes)
@@ -261,6 +294,61 @@ func Test_backend_Header(t *testing.T) {
 func Test_backend_Headers(t *testing.T) {
 }
 
+func Test_backend_RequiredHeaders(t *testing.T) {
+	t.Parallel()
+	newBackend := func(decision Decision) (*backend, *mockSession, *bool) {
+		b, s := newMockBackend()
+		WithRequiredHeaders(decision, "from", "Date", "Message-ID")(&b.opts)
+		called := false
+		b.decision = func(_ context.Context, _ Trx) (Decision, error) {
+			called = true
+			return Accept, nil
+		}
+		return b, s, &called
+	}
+	t.Run("missing Message-ID", func(t *testing.T) {
+		b, s, called := newBackend(Reject)
+		_, _ = b.Header("From", "<root@localhost>", s.newModifier())
+		_, _ = b.Header("Date", "Wed, 01 Mar 2023 15:47:33 +0100", s.newModifier())
+		resp, err := b.Headers(s.newModifier())
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := resp.Response()
+		if msg.Code != wire.Code(wire.ActReplyCode) || string(msg.Data) != "550 5.6.0 Message is missing required header fields: Message-Id\x00" {
+			t.Fatalf("Headers() = %c %q", msg.Code, msg.Data)
+		}
+		if *called {
+			t.Fatal("decision function got called")
+		}
+	})
+	t.Run("no headers custom decision", func(t *testing.T) {
+		b, s, called := newBackend(Discard)
+		resp, err := b.Headers(s.newModifier())
+		if resp != milter.RespDiscard || err != nil {
+			t.Fatalf("Headers() = %v, %v", resp, err)
+		}
+		if *called {
+			t.Fatal("decision function got called")
+		}
+	})
+	t.Run("complete", func(t *testing.T) {
+		b, s, called := newBackend(Reject)
+		_, _ = b.Header("From", "<root@localhost>", s.newModifier())
+		_, _ = b.Header("Date", "Wed, 01 Mar 2023 15:47:33 +0100", s.newModifier())
+		_, _ = b.Header("Message-Id", "<1234@localhost>", s.newModifier())
+		resp, err := b.Headers(s.newModifier())
+		assertContinue(t, resp, err)
+		resp, err = b.EndOfMessage(s.newModifier())
+		if resp != milter.RespAccept || err != nil {
+			t.Fatalf("EndOfMessage() = %v, %v", resp, err)
+		}
+		if !*called {
+			t.Fatal("decision function did not get called")
+		}
+	})
+}
+
 func Test_backend_Helo(t *testing.T) {
 	t.Parallel()
 	b, s := newMockBackend()
@@ -292,6 +380,34 @@ func Test_backend_MailFrom(t *testing.T) {
 	}
 }
 
+func Test_backend_MailFrom_nullSender(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		from string
+		want bool
+	}{{"", true}, {"root@localhost", false}} {
+		b, s := newMockBackend()
+		b.opts.decisionAt = DecisionAtMailFrom
+		var isBounce, isNull bool
+		b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+			isBounce, isNull = trx.IsBounce(), trx.MailFrom().IsNull()
+			return Accept, nil
+		}
+		resp, err := b.MailFrom(tt.from, "", s.newModifier())
+		if resp != milter.RespAccept || err != nil {
+			t.Fatalf("MailFrom(%q) = %v, %v", tt.from, resp, err)
+		}
+		if isBounce != tt.want || isNull != tt.want {
+			t.Errorf("MailFrom(%q): IsBounce() = %v, MailFrom().IsNull() = %v, want %v", tt.from, isBounce, isNull, tt.want)
+		}
+		// changing the sender does not change IsBounce
+		b.transaction.ChangeMailFrom("changed@localhost", "")
+		if b.transaction.IsBounce() != tt.want {
+			t.Errorf("IsBounce() changed with MailFrom")
+		}
+	}
+}
+
 func Test_backend_RcptTo(t *testing.T) {
 	t.Parallel()
 	b, s := newMockBackend()
@@ -420,3 +536,138 @@ func Test_backend_makeDecision(t *testing.T) {
 		t.Fatal("values not set")
 	}
 }
+
+func Test_backend_makeDecision_AssumedMTATimeout(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	WithAssumedMTATimeout(200 * time.Millisecond)(&b.opts)
+	if got := b.opts.progressInterval(); got != 100*time.Millisecond {
+		t.Fatalf("progressInterval() = %v, want 100ms", got)
+	}
+	b.decision = func(_ context.Context, _ Trx) (Decision, error) {
+		time.Sleep(350 * time.Millisecond)
+		return Accept, nil
+	}
+	b.makeDecision(s.newModifier())
+	if b.transaction.decision != Accept || b.transaction.decisionErr != nil {
+		t.Fatal("values not set")
+	}
+	// progress at 100ms, 200ms and 300ms
+	if s.progressCalled != 3 {
+		t.Fatalf("progress called %d times, want 3", s.progressCalled)
+	}
+	WithAssumedMTATimeout(0)(&b.opts)
+	if got := b.opts.progressInterval(); got != defaultProgressInterval {
+		t.Fatalf("progressInterval() = %v, want %v", got, defaultProgressInterval)
+	}
+	WithAssumedMTATimeout(time.Nanosecond)(&b.opts)
+	if got := b.opts.progressInterval(); got != time.Millisecond {
+		t.Fatalf("progressInterval() = %v, want 1ms", got)
+	}
+}
+
+func Test_backend_TotalBufferBudget(t *testing.T) {
+	t.Parallel()
+	budget := &bufferBudget{limit: 100_000}
+	newBudgetBackend := func() (*backend, *mockSession) {
+		b, s := newMockBackend()
+		b.opts.budget = budget
+		b.transaction = &transaction{budget: budget}
+		b.decision = func(_ context.Context, _ Trx) (Decision, error) {
+			return Accept, nil
+		}
+		return b, s
+	}
+	tempFail, _ := milter.RejectWithCodeAndReason(451, "4.3.1 Insufficient system resources, try again later")
+	send := func(b *backend, s *mockSession, size int) *milter.Response {
+		t.Helper()
+		resp, err := b.Header("Subject", "test", s.newModifier())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for size > 0 {
+			chunk := bytes.Repeat([]byte("x"), 10_000)
+			if size < len(chunk) {
+				chunk = chunk[:size]
+			}
+			size -= len(chunk)
+			if resp, err = b.BodyChunk(chunk, s.newModifier()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp
+	}
+	mailFrom := func(b *backend, s *mockSession, from string) {
+		t.Helper()
+		resp, err := b.MailFrom(from, "", s.newModifier())
+		assertContinue(t, resp, err)
+	}
+	used := func() int64 {
+		budget.mu.Lock()
+		defer budget.mu.Unlock()
+		return budget.used
+	}
+
+	b1, s1 := newBudgetBackend()
+	b2, s2 := newBudgetBackend()
+	b3, s3 := newBudgetBackend()
+	mailFrom(b1, s1, "one@example.com")
+	assertContinue(t, send(b1, s1, 50_000), nil)
+	mailFrom(b2, s2, "two@example.com")
+	assertContinue(t, send(b2, s2, 40_000), nil)
+	// the third message does not fit into the budget anymore
+	mailFrom(b3, s3, "three@example.com")
+	if resp := send(b3, s3, 20_000); resp != milter.RespSkip {
+		t.Fatalf("BodyChunk() = %v, want RespSkip", resp)
+	}
+	if resp, err := b3.EndOfMessage(s3.newModifier()); err != nil || !reflect.DeepEqual(resp, tempFail) {
+		t.Fatalf("EndOfMessage() = %v, %v, want temp fail", resp, err)
+	}
+	// the first message gets bigger than the in-memory limit and frees its part of the budget
+	if u := used(); u <= 90_000 {
+		t.Fatalf("used budget %d, want more than 90000", u)
+	}
+	resp, err := b1.BodyChunk(bytes.Repeat([]byte("x"), maxBodyMemory), s1.newModifier())
+	assertContinue(t, resp, err)
+	if u := used(); u > 50_000 {
+		t.Fatalf("used budget %d after body got moved to a file, want at most 50000", u)
+	}
+	// fill the budget completely, new transactions get rejected at MAIL FROM
+	b4, s4 := newBudgetBackend()
+	mailFrom(b4, s4, "four@example.com")
+	assertContinue(t, send(b4, s4, 100_000-int(used())-len("Subject: test")), nil)
+	if resp, err := b3.MailFrom("three@example.com", "", s3.newModifier()); err != nil || !reflect.DeepEqual(resp, tempFail) {
+		t.Fatalf("MailFrom() = %v, %v, want temp fail", resp, err)
+	}
+	for _, bs := range []struct {
+		b *backend
+		s *mockSession
+	}{{b1, s1}, {b2, s2}, {b4, s4}} {
+		if resp, err := bs.b.EndOfMessage(bs.s.newModifier()); err != nil || resp != milter.RespAccept {
+			t.Fatalf("EndOfMessage() = %v, %v, want accept", resp, err)
+		}
+	}
+	if u := used(); u != 0 {
+		t.Fatalf("used budget %d after all messages ended, want 0", u)
+	}
+
+	// many concurrent messages
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, s := newBudgetBackend()
+			_, _ = b.MailFrom("root@localhost", "", s.newModifier())
+			_, _ = b.Header("Subject", "test", s.newModifier())
+			for j := 0; j < 5; j++ {
+				_, _ = b.BodyChunk(bytes.Repeat([]byte("x"), 10_000), s.newModifier())
+			}
+			_, _ = b.EndOfMessage(s.newModifier())
+		}()
+	}
+	wg.Wait()
+	if u := used(); u != 0 {
+		t.Fatalf("used budget %d after concurrent messages, want 0", u)
+	}
+}
@@ -0,0 +1,64 @@
+package mailfilter
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/d--j/go-milter"
+)
+
+// bufferBudget is the memory budget that all connections of a [MailFilter] share (see [WithTotalBufferBudget]).
+type bufferBudget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
+
+// reserve reserves n bytes of the budget. It returns false and reserves nothing when this would exceed the budget.
+func (b *bufferBudget) reserve(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release gives n previously reserved bytes back to the budget.
+func (b *bufferBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+}
+
+// exhausted returns true when nothing of the budget is left.
+func (b *bufferBudget) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used >= b.limit
+}
+
+// errBudgetExhausted gets returned by addBodyChunk when the body chunk does not fit into the budget.
+var errBudgetExhausted = errors.New("milter: total buffer budget exhausted")
+
+// respBudgetExhausted is the response for transactions that cannot get buffered because the budget is exhausted.
+var respBudgetExhausted = CustomErrorResponse(451, "4.3.1 Insufficient system resources, try again later")
+
+// budgetExhausted lets the current transaction temporarily fail and frees the buffered data of it.
+// The decision function does not get called.
+func (b *backend) budgetExhausted() {
+	milter.LogWarning("milter: temp fail message because the total buffer budget is exhausted")
+	t := b.transaction
+	if t.body != nil {
+		_ = t.body.Close()
+		t.body = nil
+	}
+	t.origHeaders = nil
+	t.compressedHeaders = nil
+	t.releaseBudget()
+	t.makeDecision(context.Background(), func(context.Context, Trx) (Decision, error) {
+		return respBudgetExhausted, nil
+	})
+}
@@ -3,8 +3,10 @@ package mailfilter_test
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/d--j/go-milter/mailfilter"
 )
@@ -44,3 +46,41 @@ func ExampleNew() {
 	// wait for the mail filter to end
 	mailFilter.Wait()
 }
+
+func ExampleStoreFromContext() {
+	const greylistDelay = 5 * time.Minute
+	const greylistTTL = 36 * time.Hour
+
+	// a simple greylisting filter: temporarily reject every (client IP, sender, recipient) triplet
+	// that we have not seen at least greylistDelay ago
+	mailFilter, err := mailfilter.New("tcp", "127.0.0.1:10003",
+		func(ctx context.Context, trx mailfilter.Trx) (mailfilter.Decision, error) {
+			// never greylist authenticated users
+			if trx.MailFrom().AuthenticatedUser() != "" {
+				return mailfilter.Accept, nil
+			}
+			store := mailfilter.StoreFromContext(ctx)
+			now := time.Now()
+			greylisted := false
+			for _, rcpt := range trx.RcptTos() {
+				key := fmt.Sprintf("greylist:%s:%s:%s", trx.Connect().Addr, trx.MailFrom().Addr, rcpt.Addr)
+				firstSeen, ok := store.Get(key)
+				if !ok {
+					store.Set(key, now, greylistTTL)
+					greylisted = true
+				} else if now.Sub(firstSeen.(time.Time)) < greylistDelay {
+					greylisted = true
+				}
+			}
+			if greylisted {
+				return mailfilter.CustomErrorResponse(451, "4.7.1 Greylisted, please try again later"), nil
+			}
+			return mailfilter.Accept, nil
+		},
+		mailfilter.WithDecisionAt(mailfilter.DecisionAtData),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mailFilter.Wait()
+}
@@ -0,0 +1,314 @@
+package header
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// Address is one mailbox of an [AddressList].
+type Address struct {
+	// Name is the decoded display name. It is empty when the mailbox does not have a display name.
+	Name string
+	// Address is the address without angle brackets, e.g. "joe@example.com".
+	Address string
+	// Comment is the decoded text of the comments of the mailbox without the parentheses
+	// (e.g. "work" for "joe@example.com (work)"). Multiple comments get joined with a space.
+	Comment string
+}
+
+// String returns the RFC 5322 representation of a.
+// Display names and comments with non-ASCII characters get encoded (RFC 2047).
+func (a *Address) String() string {
+	s := (&mail.Address{Name: a.Name, Address: a.Address}).String()
+	if a.Comment != "" {
+		s += " (" + formatComment(a.Comment) + ")"
+	}
+	return s
+}
+
+// AddressListEntry is a single mailbox or a group of mailboxes (e.g. "Friends: joe@example.com, jane@example.com;")
+// of an [AddressList].
+type AddressListEntry struct {
+	// Group is the decoded display name of the group. It is empty when this entry is a single mailbox.
+	Group string
+	// Addresses are the mailboxes of this entry: exactly one for a single mailbox, zero or more for a group.
+	Addresses []*Address
+}
+
+// String returns the RFC 5322 representation of e.
+func (e *AddressListEntry) String() string {
+	formatted := make([]string, len(e.Addresses))
+	for i, a := range e.Addresses {
+		formatted[i] = a.String()
+	}
+	if e.Group == "" {
+		return strings.Join(formatted, ", ")
+	}
+	return formatPhrase(e.Group) + ":" + strings.Join(formatted, ", ") + ";"
+}
+
+// AddressList is a parsed RFC 5322 address-list (the value of e.g. the From, To or Cc header field).
+// Other than [Header.AddressList] it keeps groups and comments, so you can change
+// an address list and write it back with [Header.Set] without losing them:
+//
+//	list, err := header.ParseAddressList(trx.Headers().Value("To"))
+//	if err == nil {
+//		list.StripDisplayNames()
+//		trx.Headers().Set("To", list.String())
+//	}
+type AddressList []*AddressListEntry
+
+// ParseAddressList parses the raw header field value (it may be folded) as RFC 5322 address-list.
+// Display names get decoded (RFC 2047).
+func ParseAddressList(value string) (AddressList, error) {
+	// unfold
+	value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+	entries, err := splitAddressList(value)
+	if err != nil {
+		return nil, err
+	}
+	list := make(AddressList, len(entries))
+	for i, e := range entries {
+		entry := &AddressListEntry{Addresses: make([]*Address, 0, len(e.mailboxes))}
+		if e.group {
+			if entry.Group, err = parsePhrase(e.name); err != nil {
+				return nil, fmt.Errorf("header: invalid group name %q: %w", e.name, err)
+			}
+		}
+		for _, m := range e.mailboxes {
+			a, err := m.parse()
+			if err != nil {
+				return nil, err
+			}
+			entry.Addresses = append(entry.Addresses, a)
+		}
+		list[i] = entry
+	}
+	return list, nil
+}
+
+// Addresses returns all mailboxes of l (including the members of groups) in the order they appear in l.
+func (l AddressList) Addresses() []*Address {
+	var addresses []*Address
+	for _, e := range l {
+		addresses = append(addresses, e.Addresses...)
+	}
+	return addresses
+}
+
+// StripDisplayNames removes the display names of all mailboxes of l. Group names and comments stay.
+func (l AddressList) StripDisplayNames() {
+	for _, a := range l.Addresses() {
+		a.Name = ""
+	}
+}
+
+// String returns the RFC 5322 representation of l (on one line).
+func (l AddressList) String() string {
+	formatted := make([]string, len(l))
+	for i, e := range l {
+		formatted[i] = e.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// rawMailbox is the unparsed text of one mailbox of an address-list
+type rawMailbox struct {
+	text     string
+	comments []string
+	hasAngle bool
+}
+
+func (m rawMailbox) parse() (*Address, error) {
+	parsed, err := mail.ParseAddress(m.text)
+	if err != nil {
+		return nil, fmt.Errorf("header: invalid address %q: %w", m.text, err)
+	}
+	a := &Address{Name: parsed.Name, Address: parsed.Address}
+	// net/mail uses the comment as display name when the mailbox is only an addr-spec
+	if !m.hasAngle {
+		a.Name = ""
+	}
+	if len(m.comments) > 0 {
+		a.Comment = decodeComment(strings.Join(m.comments, " "))
+	}
+	return a, nil
+}
+
+// rawEntry is the unparsed text of one entry of an address-list
+type rawEntry struct {
+	group     bool
+	name      string
+	mailboxes []rawMailbox
+}
+
+// splitAddressList splits the address-list s into its entries.
+// It respects quoted strings, comments, angle brackets and domain literals.
+func splitAddressList(s string) ([]rawEntry, error) {
+	var (
+		entries                     []rawEntry
+		group                       *rawEntry
+		cur                         strings.Builder
+		mailbox                     rawMailbox
+		depth, commentStart         int
+		inQuote, inAngle, inLiteral bool
+	)
+	flush := func() {
+		mailbox.text = strings.TrimSpace(cur.String())
+		cur.Reset()
+		if mailbox.text != "" {
+			if group != nil {
+				group.mailboxes = append(group.mailboxes, mailbox)
+			} else {
+				entries = append(entries, rawEntry{mailboxes: []rawMailbox{mailbox}})
+			}
+		}
+		mailbox = rawMailbox{}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuote || depth > 0:
+			cur.WriteByte(c)
+			if c == '\\' && i+1 < len(s) {
+				i++
+				cur.WriteByte(s[i])
+			} else if inQuote && c == '"' {
+				inQuote = false
+			} else if !inQuote && c == '(' {
+				depth++
+			} else if !inQuote && c == ')' {
+				depth--
+				if depth == 0 {
+					mailbox.comments = append(mailbox.comments, cur.String()[commentStart:cur.Len()-1])
+				}
+			}
+			continue
+		case inLiteral:
+			cur.WriteByte(c)
+			inLiteral = c != ']'
+			continue
+		}
+		switch c {
+		case '"':
+			inQuote = true
+		case '(':
+			depth = 1
+			commentStart = cur.Len() + 1
+		case '[':
+			inLiteral = true
+		case '<':
+			inAngle = true
+			mailbox.hasAngle = true
+		case '>':
+			inAngle = false
+		case ',':
+			if !inAngle {
+				flush()
+				continue
+			}
+		case ':':
+			if !inAngle && group == nil {
+				group = &rawEntry{group: true, name: cur.String()}
+				cur.Reset()
+				mailbox = rawMailbox{}
+				continue
+			}
+		case ';':
+			if !inAngle && group != nil {
+				flush()
+				entries = append(entries, *group)
+				group = nil
+				continue
+			}
+		}
+		cur.WriteByte(c)
+	}
+	switch {
+	case inQuote:
+		return nil, errors.New("header: unterminated quoted string in address list")
+	case depth > 0:
+		return nil, errors.New("header: unterminated comment in address list")
+	case inAngle:
+		return nil, errors.New("header: unterminated angle bracket in address list")
+	case inLiteral:
+		return nil, errors.New("header: unterminated domain literal in address list")
+	case group != nil:
+		return nil, fmt.Errorf("header: group %q does not end with a semicolon", strings.TrimSpace(group.name))
+	}
+	flush()
+	return entries, nil
+}
+
+// parsePhrase decodes the display name s of a group
+func parsePhrase(s string) (string, error) {
+	// let net/mail do the decoding of quoted strings, comments and encoded words
+	a, err := mail.ParseAddress(s + " <group@example.com>")
+	if err != nil {
+		return "", err
+	}
+	return a.Name, nil
+}
+
+// decodeComment removes the quoting of the comment text s and decodes its encoded words
+func decodeComment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	decoder := mime.WordDecoder{CharsetReader: message.CharsetReader}
+	decoded, err := decoder.DecodeHeader(b.String())
+	if err != nil {
+		return b.String()
+	}
+	return decoded
+}
+
+// isASCII returns true when s only consists of printable ASCII characters and white space
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' && s[i] != '\t') || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatComment encodes the comment text s (without parentheses)
+func formatComment(s string) string {
+	if !isASCII(s) {
+		return mime.QEncoding.Encode("utf-8", s)
+	}
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+}
+
+// formatPhrase encodes the display name s of a group
+func formatPhrase(s string) string {
+	if !isASCII(s) {
+		return mime.QEncoding.Encode("utf-8", s)
+	}
+	for i := 0; i < len(s); i++ {
+		if !isAtext(s[i]) && s[i] != ' ' {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+	}
+	return s
+}
+
+// isAtext returns true when c is an atext character of RFC 5322
+func isAtext(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+	}
+}
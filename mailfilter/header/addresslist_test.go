@@ -0,0 +1,95 @@
+package header
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	t.Parallel()
+	mailbox := func(name, address, comment string) *AddressListEntry {
+		return &AddressListEntry{Addresses: []*Address{{Name: name, Address: address, Comment: comment}}}
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    AddressList
+		wantStr string
+		wantErr bool
+	}{
+		{"single", " joe@example.com", AddressList{mailbox("", "joe@example.com", "")}, "<joe@example.com>", false},
+		{"multiple", " Joe <joe@example.com>, \"Doe, Jane\" <jane@example.com>,\r\n\tboss@example.com", AddressList{
+			mailbox("Joe", "joe@example.com", ""),
+			mailbox("Doe, Jane", "jane@example.com", ""),
+			mailbox("", "boss@example.com", ""),
+		}, `"Joe" <joe@example.com>, "Doe, Jane" <jane@example.com>, <boss@example.com>`, false},
+		{"comments", " joe@example.com (Joe (the) Man), Jane <jane@example.com> (home) (private)", AddressList{
+			mailbox("", "joe@example.com", "Joe (the) Man"),
+			mailbox("Jane", "jane@example.com", "home private"),
+		}, `<joe@example.com> (Joe \(the\) Man), "Jane" <jane@example.com> (home private)`, false},
+		{"empty group", " undisclosed-recipients:;", AddressList{
+			{Group: "undisclosed-recipients", Addresses: []*Address{}},
+		}, "undisclosed-recipients:;", false},
+		{"groups", " Friends: Joe <joe@example.com>, jane@example.com (Jane);, boss@example.com, \"Team A\": a@example.com;", AddressList{
+			{Group: "Friends", Addresses: []*Address{{Name: "Joe", Address: "joe@example.com"}, {Address: "jane@example.com", Comment: "Jane"}}},
+			mailbox("", "boss@example.com", ""),
+			{Group: "Team A", Addresses: []*Address{{Address: "a@example.com"}}},
+		}, `Friends:"Joe" <joe@example.com>, <jane@example.com> (Jane);, <boss@example.com>, Team A:<a@example.com>;`, false},
+		{"encoded", " =?utf-8?q?J=C3=B6rg?= <joerg@example.com> (=?utf-8?q?B=C3=BCro?=), =?utf-8?q?Gr=C3=BCppe?=:;", AddressList{
+			mailbox("Jörg", "joerg@example.com", "Büro"),
+			{Group: "Grüppe", Addresses: []*Address{}},
+		}, "=?utf-8?q?J=C3=B6rg?= <joerg@example.com> (=?utf-8?q?B=C3=BCro?=), =?utf-8?q?Gr=C3=BCppe?=:;", false},
+		{"special characters", ` "a:b;c" <a@example.com>, "x@y" <x@[192.0.2.1]>`, AddressList{
+			mailbox("a:b;c", "a@example.com", ""),
+			mailbox("x@y", "x@[192.0.2.1]", ""),
+		}, `"a:b;c" <a@example.com>, "x@y" <x@[192.0.2.1]>`, false},
+		{"empty", "", AddressList{}, "", false},
+		{"unterminated quote", ` "Joe <joe@example.com>`, nil, "", true},
+		{"unterminated comment", ` joe@example.com (Joe`, nil, "", true},
+		{"unterminated angle", ` Joe <joe@example.com`, nil, "", true},
+		{"unterminated group", ` Friends: joe@example.com`, nil, "", true},
+		{"invalid address", ` Joe <joe>`, nil, "", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseAddressList(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAddressList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAddressList() = %s, want %s", got, tt.want)
+			}
+			if s := got.String(); s != tt.wantStr {
+				t.Errorf("String() = %q, want %q", s, tt.wantStr)
+			}
+			// the rendered address list parses to the same addresses
+			again, err := ParseAddressList(got.String())
+			if err != nil {
+				t.Fatalf("ParseAddressList(String()) error = %v", err)
+			}
+			if !reflect.DeepEqual(again, tt.want) {
+				t.Errorf("ParseAddressList(String()) = %s, want %s", again, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressList_StripDisplayNames(t *testing.T) {
+	t.Parallel()
+	list, err := ParseAddressList(`Joe <joe@example.com> (work), Friends: "Doe, Jane" <jane@example.com>, boss@example.com;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.StripDisplayNames()
+	if got, want := list.String(), "<joe@example.com> (work), Friends:<jane@example.com>, <boss@example.com>;"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := len(list.Addresses()); got != 3 {
+		t.Errorf("len(Addresses()) = %d, want 3", got)
+	}
+}
@@ -31,6 +31,12 @@ type Header interface {
 	// SetText sets the value of the first header field with the canonical key "key" to "value" (encoded).
 	// If key was not found, this a new header field gets added.
 	SetText(key string, value string)
+	// PrefixText prepends prefix to the decoded value of the first header field with the canonical key "key"
+	// and sets the result as encoded text (e.g. PrefixText("Subject", "[EXTERNAL] ")).
+	// If key was not found, a new header field with the value prefix gets added.
+	// When the value cannot be decoded (e.g. because the charset is not known) the decoding error is returned
+	// and the header field does not get changed.
+	PrefixText(key string, prefix string) error
 	// SetAddressList sets the value of the first header field with the canonical key "key" to "value" (encoded as address list).
 	// The address list is encoded as multi-line header field when the MTA supports this (Sendmail does not).
 	// If key was not found, this a new header field gets added.
@@ -60,12 +66,18 @@ type Header interface {
 }
 
 // Fields is a Scanner like interface to access all fields of a Header.
+// It visits the fields in the order the MTA sent them, including duplicate fields.
 // You can modify the fields while you are iterating them.
 type Fields interface {
 	// Next forwards the cursor to the next field and returns true when there is a next field.
 	Next() bool
 	// Len returns the number of fields in the header
 	Len() int
+	// Index returns the 0-based position of the current header field in the header as the MTA sent it.
+	// Duplicate fields (e.g. multiple Received fields) each have their own position.
+	// Fields that got added by the filter return -1. Replaced fields keep the position of the field they replaced.
+	// Panics when called before calling Next or when Next returned false.
+	Index() int
 	// Raw returns the raw bytes of the current header field.
 	// Panics when called before calling Next or when Next returned false.
 	Raw() []byte
@@ -0,0 +1,104 @@
+package mailfilter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/d--j/go-milter/internal/header"
+)
+
+// gzipWriters re-uses gzip writers since every new gzip writer allocates about 1 MiB.
+var gzipWriters = sync.Pool{New: func() interface{} {
+	return gzip.NewWriter(nil)
+}}
+
+// compressHeaders replaces the buffered header fields of t with a gzip compressed copy (see [WithHeaderCompression]).
+// The header fields stay uncompressed when compression does not make them smaller.
+func (t *transaction) compressHeaders() error {
+	if t.origHeaders == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer func() {
+		// do not keep buf alive through the pool
+		zw.Reset(io.Discard)
+		gzipWriters.Put(zw)
+	}()
+	zw.Reset(&buf)
+	var size int64
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	fields := t.origHeaders.Fields()
+	for fields.Next() {
+		raw := fields.Raw()
+		size += int64(len(raw))
+		if _, err := zw.Write(lenBuf[:binary.PutUvarint(lenBuf, uint64(len(raw)))]); err != nil {
+			return err
+		}
+		if _, err := zw.Write(raw); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if int64(buf.Len()) >= size {
+		return nil
+	}
+	// do not keep the spare capacity of buf
+	t.compressedHeaders = append([]byte(nil), buf.Bytes()...)
+	t.origHeaders = nil
+	if t.budget != nil {
+		t.budget.release(t.headerReserved - int64(len(t.compressedHeaders)))
+		t.headerReserved = int64(len(t.compressedHeaders))
+	}
+	return nil
+}
+
+// decompressHeaders restores the header fields that compressHeaders compressed.
+// It returns errBudgetExhausted when the uncompressed header fields do not fit into the budget.
+func (t *transaction) decompressHeaders() error {
+	if t.compressedHeaders == nil {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(t.compressedHeaders))
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(zr)
+	h := &header.Header{}
+	var size int64
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		raw := make([]byte, n)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return err
+		}
+		key, _, found := bytes.Cut(raw, []byte{':'})
+		if !found {
+			return errors.New("milter: compressed header field without colon")
+		}
+		h.AddRaw(string(key), raw)
+		size += int64(n)
+	}
+	if t.budget != nil {
+		if !t.budget.reserve(size - t.headerReserved) {
+			return errBudgetExhausted
+		}
+		t.headerReserved = size
+	}
+	t.origHeaders = h
+	t.compressedHeaders = nil
+	return nil
+}
@@ -0,0 +1,180 @@
+package mailfilter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// mailingListHeaders is a typical header of a message that a mailing list sent
+func mailingListHeaders() [][2]string {
+	fields := [][2]string{
+		{"Return-Path", "<list-bounces+user=example.com@lists.example.org>"},
+		{"Delivered-To", "user@example.com"},
+	}
+	for i := 0; i < 8; i++ {
+		fields = append(fields, [2]string{"Received", fmt.Sprintf("from relay%d.lists.example.org (relay%d.lists.example.org [192.0.2.%d])\r\n\tby mx.example.com (Postfix) with ESMTPS id 4Q%dXyZ\r\n\tfor <user@example.com>; Wed, 01 Mar 2023 15:47:3%d +0100 (CET)", i, i, i+10, i, i)})
+	}
+	fields = append(fields,
+		[2]string{"ARC-Seal", "i=1; a=rsa-sha256; t=1677682053; cv=none; d=lists.example.org; s=arc-20160816;\r\n\tb=Tm9uZSBvZiB0aGlzIGlzIGEgcmVhbCBzaWduYXR1cmUgYnV0IGl0IGlzIGxvbmcgZW5vdWdoIHRvIGxvb2sgbGlrZSBvbmU="},
+		[2]string{"DKIM-Signature", "v=1; a=rsa-sha256; c=relaxed/relaxed; d=lists.example.org; s=20230301;\r\n\th=list-unsubscribe:list-post:list-id:subject:date:message-id:to:from;\r\n\tbh=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=;\r\n\tb=Tm9uZSBvZiB0aGlzIGlzIGEgcmVhbCBzaWduYXR1cmUgYnV0IGl0IGlzIGxvbmcgZW5vdWdoIHRvIGxvb2sgbGlrZSBvbmU="},
+		[2]string{"From", "Someone <someone@example.net>"},
+		[2]string{"To", "discussion@lists.example.org"},
+		[2]string{"Subject", "[discussion] Re: header compression"},
+		[2]string{"Date", "Wed, 01 Mar 2023 15:47:33 +0100"},
+		[2]string{"Message-ID", "<20230301154733.GA1234@example.net>"},
+		[2]string{"In-Reply-To", "<20230301120000.GA1000@example.com>"},
+		[2]string{"References", "<20230301100000.GA900@example.com>\r\n <20230301120000.GA1000@example.com>"},
+		[2]string{"MIME-Version", "1.0"},
+		[2]string{"Content-Type", "text/plain; charset=utf-8"},
+		[2]string{"Content-Transfer-Encoding", "8bit"},
+		[2]string{"List-Id", "Discussion <discussion.lists.example.org>"},
+		[2]string{"List-Unsubscribe", "<mailto:discussion-request@lists.example.org?subject=unsubscribe>,\r\n <https://lists.example.org/options/discussion>"},
+		[2]string{"List-Archive", "<https://lists.example.org/archives/discussion>"},
+		[2]string{"List-Post", "<mailto:discussion@lists.example.org>"},
+		[2]string{"List-Help", "<mailto:discussion-request@lists.example.org?subject=help>"},
+		[2]string{"List-Subscribe", "<mailto:discussion-request@lists.example.org?subject=subscribe>,\r\n <https://lists.example.org/listinfo/discussion>"},
+		[2]string{"Precedence", "list"},
+		[2]string{"Errors-To", "discussion-bounces@lists.example.org"},
+		[2]string{"Sender", "\"discussion\" <discussion-bounces@lists.example.org>"},
+	)
+	return fields
+}
+
+func sendHeaders(t *testing.T, b *backend, s *mockSession, fields [][2]string) {
+	t.Helper()
+	for _, f := range fields {
+		resp, err := b.Header(f[0], f[1], s.newModifier())
+		assertContinue(t, resp, err)
+	}
+	resp, err := b.Headers(s.newModifier())
+	assertContinue(t, resp, err)
+}
+
+func Test_backend_HeaderCompression(t *testing.T) {
+	t.Parallel()
+	budget := &bufferBudget{limit: 100_000}
+	used := func() int64 {
+		budget.mu.Lock()
+		defer budget.mu.Unlock()
+		return budget.used
+	}
+	run := func(compression bool) (header string, modifications []*wire.Message, usedAtEOH int64) {
+		b, s := newMockBackend()
+		b.opts.budget = budget
+		b.transaction = &transaction{budget: budget}
+		if compression {
+			WithHeaderCompression()(&b.opts)
+		}
+		b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+			raw, err := io.ReadAll(trx.Headers().Reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			header = string(raw)
+			if trx.HeaderSize() == 0 {
+				t.Error("HeaderSize() = 0")
+			}
+			trx.Headers().Set("Subject", "changed")
+			trx.Headers().Add("X-Filtered", "yes")
+			return Accept, nil
+		}
+		sendHeaders(t, b, s, mailingListHeaders())
+		if compression && b.transaction.compressedHeaders == nil {
+			t.Fatal("header did not get compressed")
+		}
+		usedAtEOH = used()
+		resp, err := b.BodyChunk([]byte("body\r\n"), s.newModifier())
+		assertContinue(t, resp, err)
+		resp, err = b.EndOfMessage(s.newModifier())
+		if resp != milter.RespAccept || err != nil {
+			t.Fatalf("EndOfMessage() = %v, %v", resp, err)
+		}
+		return header, s.modifications, usedAtEOH
+	}
+	wantHeader, wantModifications, uncompressedSize := run(false)
+	gotHeader, gotModifications, compressedSize := run(true)
+	if gotHeader != wantHeader {
+		t.Errorf("decision function got header %q, want %q", gotHeader, wantHeader)
+	}
+	if !reflect.DeepEqual(gotModifications, wantModifications) {
+		t.Errorf("modifications = %+v, want %+v", gotModifications, wantModifications)
+	}
+	if compressedSize >= uncompressedSize {
+		t.Errorf("compressed header uses %d bytes of the budget, uncompressed %d", compressedSize, uncompressedSize)
+	}
+	if u := used(); u != 0 {
+		t.Errorf("used budget %d after all messages ended, want 0", u)
+	}
+}
+
+func Test_transaction_compressHeaders(t *testing.T) {
+	t.Parallel()
+	t.Run("small header stays uncompressed", func(t *testing.T) {
+		trx := &transaction{}
+		trx.addHeader("Subject", []byte("Subject: test"))
+		if err := trx.compressHeaders(); err != nil {
+			t.Fatal(err)
+		}
+		if trx.compressedHeaders != nil || trx.origHeaders == nil {
+			t.Error("small header got compressed")
+		}
+	})
+	t.Run("budget exhausted", func(t *testing.T) {
+		budget := &bufferBudget{limit: 100_000}
+		trx := &transaction{budget: budget}
+		for _, f := range mailingListHeaders() {
+			trx.addHeader(f[0], []byte(f[0]+": "+f[1]))
+		}
+		if err := trx.compressHeaders(); err != nil {
+			t.Fatal(err)
+		}
+		budget.limit = budget.used
+		if err := trx.decompressHeaders(); err != errBudgetExhausted {
+			t.Fatalf("decompressHeaders() = %v, want errBudgetExhausted", err)
+		}
+		budget.limit = 100_000
+		if err := trx.decompressHeaders(); err != nil {
+			t.Fatal(err)
+		}
+		if got := trx.origHeaders.Fields().Len(); got != len(mailingListHeaders()) {
+			t.Errorf("got %d header fields, want %d", got, len(mailingListHeaders()))
+		}
+		trx.cleanup()
+		if budget.used != 0 {
+			t.Errorf("used budget %d after cleanup, want 0", budget.used)
+		}
+	})
+}
+
+func benchmarkHeaderMemory(b *testing.B, compression bool) {
+	fields := mailingListHeaders()
+	var size int64
+	for i := 0; i < b.N; i++ {
+		trx := &transaction{}
+		for _, f := range fields {
+			trx.addHeader(f[0], []byte(f[0]+": "+f[1]))
+		}
+		size = trx.origHeaders.Size()
+		if compression {
+			if err := trx.compressHeaders(); err != nil {
+				b.Fatal(err)
+			}
+			size = int64(len(trx.compressedHeaders))
+		}
+	}
+	b.ReportMetric(float64(size), "held-bytes/op")
+}
+
+func BenchmarkHeaderMemory_uncompressed(b *testing.B) {
+	benchmarkHeaderMemory(b, false)
+}
+
+func BenchmarkHeaderMemory_compressed(b *testing.B) {
+	benchmarkHeaderMemory(b, true)
+}
@@ -0,0 +1,112 @@
+package mailfilter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/d--j/go-milter/mailfilter/header"
+)
+
+// HeaderAction is what a [HeaderRule] does with the header fields it matches.
+type HeaderAction int
+
+const (
+	// HeaderStrip removes the header field.
+	HeaderStrip HeaderAction = iota
+	// HeaderRename replaces the name of the header field with [HeaderRule.NewName] and keeps its value.
+	HeaderRename
+	// HeaderRewrite replaces every match of [HeaderRule.Value] in the value of the header field
+	// with [HeaderRule.Replacement].
+	HeaderRewrite
+)
+
+// HeaderRule is one rule of the header policy of a [MailFilter]. See [WithHeaderPolicy].
+type HeaderRule struct {
+	// Name is a case-insensitive glob pattern (see [path.Match]) for the header field name, e.g. "X-Internal-*".
+	Name string
+	// Value restricts the rule to header fields with a value that matches Value. nil matches every value.
+	// The value is matched as-is (it can be folded and has leading white space).
+	Value *regexp.Regexp
+	// Action is what the rule does with the matching header fields.
+	Action HeaderAction
+	// NewName is the new header field name of a [HeaderRename] rule.
+	NewName string
+	// Replacement is the replacement of a [HeaderRewrite] rule. It can reference submatches of Value with $1 etc.
+	// (see [regexp.Regexp.Expand]).
+	Replacement string
+}
+
+// matches returns true when the header field with name and value matches r.
+func (r *HeaderRule) matches(name, value string) bool {
+	if ok, _ := path.Match(strings.ToLower(r.Name), strings.ToLower(name)); !ok {
+		return false
+	}
+	return r.Value == nil || r.Value.MatchString(value)
+}
+
+// applyHeaderPolicy applies rules to all header fields of hdr. Only the first matching rule gets applied to a field.
+func applyHeaderPolicy(rules []HeaderRule, hdr header.Header) {
+	for f := hdr.Fields(); f.Next(); {
+		if f.IsDeleted() {
+			continue
+		}
+		for i := range rules {
+			r := &rules[i]
+			value := f.Value()
+			if !r.matches(f.Key(), value) {
+				continue
+			}
+			switch r.Action {
+			case HeaderStrip:
+				f.Del()
+			case HeaderRename:
+				f.Replace(r.NewName, value)
+			case HeaderRewrite:
+				f.Set(r.Value.ReplaceAllString(value, r.Replacement))
+			}
+			break
+		}
+	}
+}
+
+// WithHeaderPolicy configures the [MailFilter] to strip, rename or rewrite header fields with rules
+// after your decision function returned without error (e.g. to remove internal X-Internal-* header fields
+// before the message leaves your organisation). The rules get checked in their order, only the first matching rule
+// gets applied to a header field. The [MailFilter] sends the resulting header modifications to the MTA
+// together with the modifications of your decision function.
+//
+//	mailfilter.WithHeaderPolicy(
+//		mailfilter.HeaderRule{Name: "X-Internal-*", Action: mailfilter.HeaderStrip},
+//		mailfilter.HeaderRule{Name: "Received", Value: regexp.MustCompile(`\[10\.\d+\.\d+\.\d+\]`), Action: mailfilter.HeaderRewrite, Replacement: "[redacted]"},
+//	)
+//
+// WithHeaderPolicy panics when a rule has an invalid Name pattern, a [HeaderRename] rule has no NewName
+// or a [HeaderRewrite] rule has no Value.
+// This option has no effect when you use [WithDecisionAt] with anything before [DecisionAtEndOfHeaders]
+// since the MTA does not send the header fields in that case.
+func WithHeaderPolicy(rules ...HeaderRule) Option {
+	for _, r := range rules {
+		if _, err := path.Match(r.Name, ""); err != nil {
+			panic(fmt.Sprintf("mailfilter: invalid header rule name %q: %s", r.Name, err))
+		}
+		switch r.Action {
+		case HeaderStrip:
+		case HeaderRename:
+			if r.NewName == "" {
+				panic(fmt.Sprintf("mailfilter: header rule %q renames without NewName", r.Name))
+			}
+		case HeaderRewrite:
+			if r.Value == nil {
+				panic(fmt.Sprintf("mailfilter: header rule %q rewrites without Value", r.Name))
+			}
+		default:
+			panic(fmt.Sprintf("mailfilter: header rule %q has unknown action %d", r.Name, r.Action))
+		}
+	}
+	rules = append([]HeaderRule(nil), rules...)
+	return func(opt *options) {
+		opt.headerPolicy = rules
+	}
+}
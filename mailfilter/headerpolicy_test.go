@@ -0,0 +1,122 @@
+package mailfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func TestWithHeaderPolicy(t *testing.T) {
+	t.Parallel()
+	rules := []HeaderRule{
+		{Name: "X-Internal-*", Action: HeaderStrip},
+		{Name: "received", Value: regexp.MustCompile(`\[10\.\d+\.\d+\.\d+\]`), Action: HeaderRewrite, Replacement: "[redacted]"},
+		{Name: "X-Spam-Old", Action: HeaderRename, NewName: "X-Spam-Previous"},
+		{Name: "X-Internal-Keep", Action: HeaderRename, NewName: "X-Never-Applied"},
+	}
+	fields := [][2]string{
+		{"Received", "from relay.internal (relay.internal [10.1.2.3])\n\tby mx.example.com"},
+		{"Received", "from mail.example.net (mail.example.net [192.0.2.1])\n\tby relay.internal"},
+		{"From", "<root@example.com>"},
+		{"x-internal-route", "relay.internal"},
+		{"Subject", "test"},
+		{"X-Internal-Keep", "yes"},
+		{"X-Spam-Old", "no"},
+	}
+	run := func(t *testing.T, decision DecisionModificationFunc, opts ...Option) []string {
+		b, s := newMockBackend()
+		for _, o := range opts {
+			o(&b.opts)
+		}
+		b.decision = decision
+		for _, f := range fields {
+			if _, err := b.Header(f[0], f[1], s.newModifier()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, _ = b.EndOfMessage(s.newModifier())
+		var got []string
+		for _, msg := range s.modifications {
+			if msg.Code != wire.Code(wire.ActChangeHeader) && msg.Code != wire.Code(wire.ActInsertHeader) {
+				got = append(got, fmt.Sprintf("%c", msg.Code))
+				continue
+			}
+			parts := bytes.Split(msg.Data[4:], []byte{0})
+			got = append(got, fmt.Sprintf("%c %d %s:%s", msg.Code, binary.BigEndian.Uint32(msg.Data), parts[0], parts[1]))
+		}
+		return got
+	}
+	accept := func(_ context.Context, _ Trx) (Decision, error) {
+		return Accept, nil
+	}
+
+	t.Run("strip and rewrite", func(t *testing.T) {
+		t.Parallel()
+		got := run(t, accept, WithHeaderPolicy(rules...))
+		want := []string{
+			"m 1 X-Spam-Old:",
+			"m 1 X-Internal-Keep:",
+			"m 1 x-internal-route:",
+			"m 1 Received: from relay.internal (relay.internal [redacted])\n\tby mx.example.com",
+			"i 112 X-Spam-Previous: no",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("modifications = %q, want %q", got, want)
+		}
+	})
+	t.Run("after decision function", func(t *testing.T) {
+		t.Parallel()
+		got := run(t, func(_ context.Context, trx Trx) (Decision, error) {
+			if trx.Headers().Value("X-Internal-Route") == "" {
+				t.Error("decision function does not see X-Internal-Route")
+			}
+			trx.Headers().Add("X-Internal-Added", "yes")
+			return Accept, nil
+		}, WithHeaderPolicy(rules[0]))
+		want := []string{"m 1 X-Internal-Keep:", "m 1 x-internal-route:"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("modifications = %q, want %q", got, want)
+		}
+	})
+	t.Run("decision error", func(t *testing.T) {
+		t.Parallel()
+		got := run(t, func(_ context.Context, _ Trx) (Decision, error) {
+			return nil, errors.New("error")
+		}, WithHeaderPolicy(rules...), WithErrorHandling(AcceptWhenError))
+		if len(got) != 0 {
+			t.Errorf("modifications = %q, want none", got)
+		}
+	})
+	t.Run("no policy", func(t *testing.T) {
+		t.Parallel()
+		if got := run(t, accept); len(got) != 0 {
+			t.Errorf("modifications = %q, want none", got)
+		}
+	})
+}
+
+func TestWithHeaderPolicy_Panics(t *testing.T) {
+	t.Parallel()
+	for _, r := range []HeaderRule{
+		{Name: "[", Action: HeaderStrip},
+		{Name: "X-Test", Action: HeaderRename},
+		{Name: "X-Test", Action: HeaderRewrite},
+		{Name: "X-Test", Action: HeaderAction(99)},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithHeaderPolicy(%+v) did not panic", r)
+				}
+			}()
+			WithHeaderPolicy(r)
+		}()
+	}
+}
@@ -13,7 +13,8 @@ import (
 //
 // ctx is a [context.Context] that might get canceled when the connection to the MTA fails while your callback is running.
 // If your decision function is running longer than one second the [MailFilter] automatically sends progress notifications
-// every second so that MTA does not time out the milter connection.
+// every second so that MTA does not time out the milter connection. Use [WithAssumedMTATimeout] to change this interval.
+// Use [StoreFromContext] to get the [Store] that all connections of the [MailFilter] share.
 //
 // trx is the [Trx] object that you can inspect to see what the [MailFilter] got as information about the current SMTP transaction.
 // You can also use trx to modify the transaction (e.g. change recipients, alter headers).
@@ -41,6 +42,9 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 	for _, o := range opts {
 		o(&resolvedOptions)
 	}
+	if resolvedOptions.store == nil {
+		resolvedOptions.store = NewMemoryStore()
+	}
 
 	actions := milter.AllClientSupportedActionMasks
 	protocols := milter.OptHeaderLeadingSpace | milter.OptNoUnknown
@@ -59,6 +63,10 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 	default:
 		protocols = protocols | milter.OptNoConnReply | milter.OptNoHeloReply | milter.OptNoRcptReply | milter.OptNoHeaderReply | milter.OptNoEOHReply | milter.OptNoBodyReply
 	}
+	if len(resolvedOptions.requiredHeaders) > 0 {
+		// we need to be able to respond at EOH for the required header check
+		protocols = protocols & ^milter.OptNoEOHReply
+	}
 	if resolvedOptions.skipBody {
 		protocols = protocols | milter.OptNoBody
 	}
@@ -86,11 +94,12 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 				opts:         resolvedOptions,
 				decision:     decision,
 				leadingSpace: protocol&milter.OptHeaderLeadingSpace != 0,
-				transaction:  &transaction{},
+				transaction:  &transaction{budget: resolvedOptions.budget},
 			}
 		}),
 		milter.WithActions(actions),
 		milter.WithProtocols(protocols),
+		milter.WithDryRun(resolvedOptions.dryRun),
 	}
 	for i, macros := range macroStages {
 		milterOptions = append(milterOptions, milter.WithMacroRequest(milter.MacroStage(i), macros))
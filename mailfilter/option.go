@@ -1,5 +1,10 @@
 package mailfilter
 
+import (
+	"net/textproto"
+	"time"
+)
+
 // DecisionAt defines when the filter decision is made.
 type DecisionAt int
 
@@ -41,6 +46,37 @@ type options struct {
 	decisionAt    DecisionAt
 	errorHandling ErrorHandling
 	skipBody      bool
+	dryRun        bool
+	// requiredHeaders are the canonical names of header fields that every message needs to have
+	requiredHeaders  []string
+	requiredDecision Decision
+	store            Store
+	// assumedMTATimeout is the milter command timeout of the MTA, 0 means unknown
+	assumedMTATimeout time.Duration
+	// budget is the memory budget that all connections share, nil means unlimited
+	budget *bufferBudget
+	// headerCompression keeps the header fields compressed while the body gets received
+	headerCompression bool
+	// headerPolicy are the rules that get applied to the header fields after the decision function
+	headerPolicy []HeaderRule
+	// blockedExtensions are the lower-case file name extensions (without leading dot) of attachments that get blocked
+	blockedExtensions []string
+	blockedDecision   Decision
+}
+
+// defaultProgressInterval is the interval of the progress notifications when the MTA timeout is unknown
+const defaultProgressInterval = time.Second
+
+// progressInterval returns the interval in which the [MailFilter] sends progress notifications
+// while the decision function is running: half of the assumed MTA timeout or defaultProgressInterval.
+func (o *options) progressInterval() time.Duration {
+	if o.assumedMTATimeout <= 0 {
+		return defaultProgressInterval
+	}
+	if interval := o.assumedMTATimeout / 2; interval > time.Millisecond {
+		return interval
+	}
+	return time.Millisecond
 }
 
 type Option func(opt *options)
@@ -67,3 +103,91 @@ func WithoutBody() Option {
 		opt.skipBody = true
 	}
 }
+
+// WithDryRun configures the [MailFilter] to only log the modifications your decision function makes
+// (with [milter.LogDryRun]) instead of sending them to the MTA. The decision itself still gets sent to the MTA.
+func WithDryRun(dryRun bool) Option {
+	return func(opt *options) {
+		opt.dryRun = dryRun
+	}
+}
+
+// WithRequiredHeaders configures the [MailFilter] to check at the end of the headers (EOH) that the message has
+// a header field for every name in names (e.g. "From", "Date", "Message-ID"). Names get compared case-insensitive.
+//
+// When at least one header field is missing, the [MailFilter] does not call your decision function and uses decision instead.
+// The missing header field names get logged with [milter.LogWarning]. When decision is [Reject] or [TempFail]
+// the SMTP reply also contains the missing header field names. Other decisions get sent as-is.
+// A nil decision means [Reject].
+//
+// This option has no effect when you use [WithDecisionAt] with anything before [DecisionAtEndOfHeaders]
+// since the MTA does not send the header fields in that case.
+func WithRequiredHeaders(decision Decision, names ...string) Option {
+	return func(opt *options) {
+		if decision == nil {
+			decision = Reject
+		}
+		opt.requiredDecision = decision
+		opt.requiredHeaders = make([]string, len(names))
+		for i, n := range names {
+			opt.requiredHeaders[i] = textproto.CanonicalMIMEHeaderKey(n)
+		}
+	}
+}
+
+// WithAssumedMTATimeout tells the [MailFilter] that the MTA waits at most d for the response of a milter command
+// (e.g. the milter_content_timeout of Postfix or the T:E timeout of the InputMailFilters of Sendmail).
+// The MTA does not tell the milter this timeout, so you need to configure it yourself.
+//
+// While your decision function is running the [MailFilter] sends progress notifications every d/2,
+// so every notification arrives well before the MTA times out. Without this option
+// (or with d <= 0) the [MailFilter] sends a progress notification every second.
+func WithAssumedMTATimeout(d time.Duration) Option {
+	return func(opt *options) {
+		if d < 0 {
+			d = 0
+		}
+		opt.assumedMTATimeout = d
+	}
+}
+
+// WithStore sets the [Store] that the [MailFilter] hands to your decision function (see [StoreFromContext]).
+// All connections of the [MailFilter] share this store.
+// The default is a new [MemoryStore].
+func WithStore(store Store) Option {
+	return func(opt *options) {
+		opt.store = store
+	}
+}
+
+// WithTotalBufferBudget limits the memory that all connections of the [MailFilter] together use to buffer
+// header fields and message bodies to bytes (bodies bigger than 200 KiB get buffered in a temporary file
+// and do not count against the budget). Use it to protect the server from running out of memory
+// when many messages arrive at the same time.
+//
+// When the budget is exhausted, new transactions get temporarily rejected at MAIL FROM.
+// When a running transaction cannot buffer a header field or body chunk, it gets temporarily rejected
+// at the end of the message (your decision function does not get called) and its buffered data gets freed.
+// The default (or bytes <= 0) is no limit.
+func WithTotalBufferBudget(bytes int64) Option {
+	return func(opt *options) {
+		if bytes <= 0 {
+			opt.budget = nil
+			return
+		}
+		opt.budget = &bufferBudget{limit: bytes}
+	}
+}
+
+// WithHeaderCompression configures the [MailFilter] to keep the header fields of a message gzip compressed
+// while it receives the body of this message. Messages with many header fields (e.g. of mailing lists)
+// need considerably less memory this way when many messages arrive at the same time. The compressed size counts against
+// the budget of [WithTotalBufferBudget].
+// The header fields get decompressed before your decision function gets called, so you do not notice the compression.
+//
+// This option only has an effect when you use [WithDecisionAt] with [DecisionAtEndOfMessage] (the default).
+func WithHeaderCompression() Option {
+	return func(opt *options) {
+		opt.headerCompression = true
+	}
+}
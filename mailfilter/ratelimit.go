@@ -0,0 +1,17 @@
+package mailfilter
+
+import (
+	"context"
+)
+
+// RateLimiter limits how many messages a sender can send in a time window.
+// Your decision function can use it to e.g. temporarily reject a message when its sender exceeded its limit.
+//
+// Implementations need to be safe for concurrent use by multiple goroutines.
+// The module github.com/d--j/go-milter/mailfilter/redisratelimit has an implementation
+// that multiple milter instances can share.
+type RateLimiter interface {
+	// Allow counts one message of sender and returns true when sender is still within its limit.
+	// Messages that exceed the limit do not count.
+	Allow(ctx context.Context, sender string) (bool, error)
+}
@@ -0,0 +1,22 @@
+module github.com/d--j/go-milter/mailfilter/redisratelimit
+
+go 1.18
+
+replace github.com/d--j/go-milter => ../..
+
+require (
+	github.com/alicebob/miniredis/v2 v2.30.5
+	github.com/d--j/go-milter v0.8.2
+	github.com/redis/go-redis/v9 v9.0.5
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/emersion/go-message v0.16.0 // indirect
+	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
+	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+)
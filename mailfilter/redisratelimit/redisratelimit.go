@@ -0,0 +1,69 @@
+// Package redisratelimit implements a [mailfilter.RateLimiter] with a Redis server.
+//
+// It is a separate module so that the go-milter module does not depend on a Redis client.
+package redisratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/d--j/go-milter/mailfilter"
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowScript atomically removes the events that are older than the window,
+// checks the limit and adds the new event.
+// It uses the clock of the Redis server, so the clocks of the milter instances do not matter.
+//
+// KEYS[1] is the key of the sorted set, ARGV[1] the window in microseconds, ARGV[2] the limit
+// and ARGV[3] a random value that makes the member of this event unique.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now, now .. '-' .. ARGV[3])
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+return 1
+`)
+
+// RateLimiter is a sliding-window [mailfilter.RateLimiter] that stores its state in Redis.
+// Every sender gets a sorted set at the key keyPrefix + ":" + sender with the times of its messages in the last window.
+// The check and the increment happen atomically in a Lua script, so multiple milter instances
+// (e.g. behind a load balancer) can share the same limits.
+type RateLimiter struct {
+	client    *redis.Client
+	keyPrefix string
+	limit     int
+	window    time.Duration
+}
+
+// NewRedisRateLimiter creates a new [RateLimiter] that allows limit messages per sender in every window.
+// A limit <= 0 rejects every message.
+func NewRedisRateLimiter(client *redis.Client, keyPrefix string, limit int, window time.Duration) mailfilter.RateLimiter {
+	return &RateLimiter{client: client, keyPrefix: keyPrefix, limit: limit, window: window}
+}
+
+// Allow implements [mailfilter.RateLimiter.Allow].
+// The sender gets used as-is, normalize it (e.g. lower-case the domain part) before you call this method.
+func (r *RateLimiter) Allow(ctx context.Context, sender string) (bool, error) {
+	if r.limit <= 0 {
+		return false, nil
+	}
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return false, err
+	}
+	allowed, err := slidingWindowScript.Run(ctx, r.client, []string{r.keyPrefix + ":" + sender}, r.window.Microseconds(), r.limit, hex.EncodeToString(nonce[:])).Int()
+	if err != nil {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+var _ mailfilter.RateLimiter = (*RateLimiter)(nil)
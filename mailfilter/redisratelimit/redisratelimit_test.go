@@ -0,0 +1,107 @@
+package redisratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/d--j/go-milter/mailfilter"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	s := miniredis.RunT(t)
+	s.SetTime(time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC))
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return s, client
+}
+
+func assertAllow(t *testing.T, l mailfilter.RateLimiter, sender string, want bool) {
+	t.Helper()
+	got, err := l.Allow(context.Background(), sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("Allow(%q) = %v, want %v", sender, got, want)
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	s, client := newTestRedis(t)
+	l := NewRedisRateLimiter(client, "milter", 3, time.Minute)
+	start := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		s.SetTime(start.Add(time.Duration(i) * 10 * time.Second))
+		assertAllow(t, l, "one@example.com", true)
+	}
+	assertAllow(t, l, "one@example.com", false)
+	// other senders have their own limit
+	assertAllow(t, l, "two@example.com", true)
+	if !s.Exists("milter:one@example.com") || !s.Exists("milter:two@example.com") {
+		t.Fatalf("keys = %v", s.Keys())
+	}
+	// the first message leaves the window, the other two are still in it
+	s.SetTime(start.Add(time.Minute + time.Second))
+	assertAllow(t, l, "one@example.com", true)
+	assertAllow(t, l, "one@example.com", false)
+	// everything left the window
+	s.SetTime(start.Add(3 * time.Minute))
+	assertAllow(t, l, "one@example.com", true)
+	if ttl := s.TTL("milter:one@example.com"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, want the window", ttl)
+	}
+}
+
+func TestRateLimiter_Allow_zeroLimit(t *testing.T) {
+	_, client := newTestRedis(t)
+	assertAllow(t, NewRedisRateLimiter(client, "milter", 0, time.Minute), "one@example.com", false)
+}
+
+func TestRateLimiter_Allow_sharedInstances(t *testing.T) {
+	_, client := newTestRedis(t)
+	// two milter instances with their own client that share the same Redis server
+	other := redis.NewClient(&redis.Options{Addr: client.Options().Addr})
+	defer other.Close()
+	limiters := []mailfilter.RateLimiter{
+		NewRedisRateLimiter(client, "milter", 10, time.Minute),
+		NewRedisRateLimiter(other, "milter", 10, time.Minute),
+	}
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(l mailfilter.RateLimiter) {
+			defer wg.Done()
+			ok, err := l.Allow(context.Background(), "one@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}(limiters[i%2])
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Errorf("%d messages got allowed, want 10", allowed)
+	}
+}
+
+func TestRateLimiter_Allow_error(t *testing.T) {
+	s, client := newTestRedis(t)
+	s.Close()
+	if _, err := NewRedisRateLimiter(client, "milter", 1, time.Minute).Allow(context.Background(), "one@example.com"); err == nil {
+		t.Error("Allow() did not return an error for a closed server")
+	}
+}
@@ -0,0 +1,120 @@
+package mailfilter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ResultType is the kind of [Decision] a [Result] describes.
+type ResultType string
+
+const (
+	ResultAccept     ResultType = "accept"     // the [Accept] decision
+	ResultReject     ResultType = "reject"     // the [Reject] decision
+	ResultTempFail   ResultType = "tempfail"   // the [TempFail] decision
+	ResultDiscard    ResultType = "discard"    // the [Discard] decision
+	ResultQuarantine ResultType = "quarantine" // a decision created with [QuarantineResponse]
+	ResultCustom     ResultType = "custom"     // a decision created with [CustomErrorResponse]
+)
+
+// ModificationKind is the kind of a [Modification].
+type ModificationKind int
+
+const (
+	ModChangeFrom   ModificationKind = iota // a change mail from modification
+	ModAddRcptTo                            // an add-recipient modification
+	ModDelRcptTo                            // a delete-recipient modification
+	ModInsertHeader                         // an insert-header modification
+	ModChangeHeader                         // a change-header modification
+	ModReplaceBody                          // a replace-body modification
+)
+
+// Modification is a modification that a [DecisionModificationFunc] made to the [Trx].
+// Depending on Kind only some of the fields are set:
+// Addr and Args for [ModChangeFrom], [ModAddRcptTo] and [ModDelRcptTo],
+// Index, Name and Value for [ModInsertHeader] and [ModChangeHeader] and Body for [ModReplaceBody].
+type Modification struct {
+	Kind  ModificationKind `json:"kind"`
+	Addr  string           `json:"addr,omitempty"`
+	Args  string           `json:"args,omitempty"`
+	Index int              `json:"index,omitempty"`
+	Name  string           `json:"name,omitempty"`
+	Value string           `json:"value,omitempty"`
+	Body  []byte           `json:"body,omitempty"`
+}
+
+// Result is a plain representation of a [Decision] and the modifications of a [DecisionModificationFunc].
+// It only consists of exported fields, so you can marshal it (e.g. as JSON) to hand the result of your filter
+// to another service (e.g. a gRPC or HTTP bridge).
+//
+// Use [NewResult] to create a Result and [Result.Decision] to convert it back.
+type Result struct {
+	Type ResultType `json:"type"`
+	// Code is the SMTP reply code of the decision (e.g. 250 or 550).
+	Code uint16 `json:"code"`
+	// EnhancedCode is the RFC 3463 enhanced status code (e.g. "5.7.1") at the start of the reply text. It might be empty.
+	EnhancedCode string `json:"enhanced_code,omitempty"`
+	// Text is the reply text without EnhancedCode.
+	Text string `json:"text,omitempty"`
+	// QuarantineReason is the reason of a [ResultQuarantine] decision.
+	QuarantineReason string `json:"quarantine_reason,omitempty"`
+	// Modifications are the modifications that should be sent to the MTA (when Type is [ResultAccept] or [ResultQuarantine]).
+	Modifications []Modification `json:"modifications,omitempty"`
+}
+
+var enhancedCodeRe = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3}) `)
+
+// NewResult creates a [Result] for decision d and the modifications mods (e.g. from testtrx.Trx.Modifications).
+// d must not be nil.
+func NewResult(d Decision, mods []Modification) *Result {
+	r := &Result{Modifications: mods}
+	switch d {
+	case Accept:
+		r.Type = ResultAccept
+	case Reject:
+		r.Type = ResultReject
+	case TempFail:
+		r.Type = ResultTempFail
+	case Discard:
+		r.Type = ResultDiscard
+	default:
+		if q, ok := d.(*quarantineResponse); ok {
+			r.Type = ResultQuarantine
+			r.QuarantineReason = q.reason
+		} else {
+			r.Type = ResultCustom
+		}
+	}
+	r.Code = d.getCode()
+	r.Text = d.getReason()
+	if m := enhancedCodeRe.FindStringSubmatch(r.Text); m != nil {
+		r.EnhancedCode = m[1]
+		r.Text = r.Text[len(m[0]):]
+	}
+	return r
+}
+
+// Decision converts r back to a [Decision].
+// It returns an error when r.Type is unknown.
+func (r *Result) Decision() (Decision, error) {
+	switch r.Type {
+	case ResultAccept:
+		return Accept, nil
+	case ResultReject:
+		return Reject, nil
+	case ResultTempFail:
+		return TempFail, nil
+	case ResultDiscard:
+		return Discard, nil
+	case ResultQuarantine:
+		return QuarantineResponse(r.QuarantineReason), nil
+	case ResultCustom:
+		reason := r.Text
+		if r.EnhancedCode != "" {
+			reason = r.EnhancedCode + " " + reason
+		}
+		return CustomErrorResponse(r.Code, reason), nil
+	default:
+		return nil, fmt.Errorf("mailfilter: unknown result type %q", r.Type)
+	}
+}
@@ -0,0 +1,65 @@
+package mailfilter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResult_RoundTrip(t *testing.T) {
+	mods := []Modification{
+		{Kind: ModChangeFrom, Addr: "from@example.com", Args: "A=B"},
+		{Kind: ModDelRcptTo, Addr: "old@example.com"},
+		{Kind: ModAddRcptTo, Addr: "new@example.com"},
+		{Kind: ModChangeHeader, Index: 1, Name: "Subject", Value: " [SPAM] test"},
+		{Kind: ModInsertHeader, Index: 0, Name: "X-Spam", Value: " yes"},
+		{Kind: ModReplaceBody, Body: []byte("new body")},
+	}
+	tests := []struct {
+		name     string
+		decision Decision
+		mods     []Modification
+		want     Result
+	}{
+		{"accept", Accept, mods, Result{Type: ResultAccept, Code: 250, Text: "accept", Modifications: mods}},
+		{"reject", Reject, nil, Result{Type: ResultReject, Code: 550, EnhancedCode: "5.7.1", Text: "Command rejected"}},
+		{"tempfail", TempFail, nil, Result{Type: ResultTempFail, Code: 451, EnhancedCode: "4.7.1", Text: "Service unavailable - try again later"}},
+		{"discard", Discard, nil, Result{Type: ResultDiscard, Code: 250, Text: "discard"}},
+		{"quarantine", QuarantineResponse("virus"), mods[:1], Result{Type: ResultQuarantine, Code: 250, Text: "accept (quarantined: \"virus\")", QuarantineReason: "virus", Modifications: mods[:1]}},
+		{"custom", CustomErrorResponse(554, "5.7.0 go away"), nil, Result{Type: ResultCustom, Code: 554, EnhancedCode: "5.7.0", Text: "go away"}},
+		{"custom without enhanced code", CustomErrorResponse(421, "closing"), nil, Result{Type: ResultCustom, Code: 421, Text: "closing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewResult(tt.decision, tt.mods)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("NewResult() = %+v, want %+v", *got, tt.want)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var unmarshalled Result
+			if err := json.Unmarshal(b, &unmarshalled); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(unmarshalled, tt.want) {
+				t.Fatalf("json round-trip = %+v, want %+v", unmarshalled, tt.want)
+			}
+			d, err := unmarshalled.Decision()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, tt.decision) {
+				t.Fatalf("Decision() = %#v, want %#v", d, tt.decision)
+			}
+		})
+	}
+}
+
+func TestResult_DecisionUnknownType(t *testing.T) {
+	r := Result{Type: "bogus"}
+	if d, err := r.Decision(); err == nil {
+		t.Fatalf("Decision() = %v, want error", d)
+	}
+}
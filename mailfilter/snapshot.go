@@ -0,0 +1,110 @@
+package mailfilter
+
+import (
+	"io"
+
+	"github.com/d--j/go-milter/internal/header"
+	"github.com/d--j/go-milter/mailfilter/addr"
+	"github.com/d--j/go-milter/milterutil"
+	"golang.org/x/text/transform"
+)
+
+// Snapshot is the portable state of a transaction up to the end of the header.
+// You can use it to hand off a transaction to another process or host and resume it there:
+// create it with [NewSnapshot], serialize it (all fields are exported, so e.g. [encoding/json] works)
+// and call [Snapshot.Trx] on the other side.
+//
+// A Snapshot holds the macro derived values ([MTA], [Connect] and [Helo]), the envelope (sender and recipients
+// with their transport and authentication info), the queue ID and the raw header fields in their original order and folding.
+// The body, the decision, a replacement body and the header order enforcement are not portable.
+//
+// A Snapshot holds the current values of the transaction. Take it before you change the transaction,
+// otherwise your changes become the original values of the restored transaction.
+type Snapshot struct {
+	MTA      MTA
+	Connect  Connect
+	Helo     Helo
+	MailFrom SnapshotAddress
+	RcptTos  []SnapshotAddress
+	QueueId  string
+	// Header is the raw header (every field with its trailing CR LF and a final CR LF).
+	// It is empty when the transaction did not have a header (yet).
+	Header []byte
+}
+
+// SnapshotAddress is the portable form of an [addr.MailFrom] or [addr.RcptTo].
+// AuthenticatedUser and AuthenticationMethod are always empty for recipients.
+type SnapshotAddress struct {
+	Addr                 string
+	Args                 string
+	Transport            string
+	AuthenticatedUser    string `json:",omitempty"`
+	AuthenticationMethod string `json:",omitempty"`
+}
+
+// NewSnapshot captures the portable state of trx.
+func NewSnapshot(trx Trx) (*Snapshot, error) {
+	s := &Snapshot{
+		MTA:     *trx.MTA(),
+		Connect: *trx.Connect(),
+		Helo:    *trx.Helo(),
+		QueueId: trx.QueueId(),
+	}
+	if from := trx.MailFrom(); from != nil {
+		s.MailFrom = SnapshotAddress{
+			Addr:                 from.Addr,
+			Args:                 from.Args,
+			Transport:            from.Transport(),
+			AuthenticatedUser:    from.AuthenticatedUser(),
+			AuthenticationMethod: from.AuthenticationMethod(),
+		}
+	}
+	for _, r := range trx.RcptTos() {
+		s.RcptTos = append(s.RcptTos, SnapshotAddress{Addr: r.Addr, Args: r.Args, Transport: r.Transport()})
+	}
+	if headers := trx.Headers(); headers != nil && headers.Fields().Len() > 0 {
+		raw, err := io.ReadAll(headers.Reader())
+		if err != nil {
+			return nil, err
+		}
+		s.Header = raw
+	}
+	return s, nil
+}
+
+// Trx restores the transaction of s. The restored transaction does not have a body
+// and the values of s are its original values.
+//
+// Changes to the restored transaction do not get sent to any MTA.
+// Ship the decision (and your changes, e.g. with another Snapshot) back to the process that handles the milter connection.
+func (s *Snapshot) Trx() (Trx, error) {
+	t := &transaction{
+		mta:          s.MTA,
+		connect:      s.Connect,
+		helo:         s.Helo,
+		origMailFrom: addr.NewMailFrom(s.MailFrom.Addr, s.MailFrom.Args, s.MailFrom.Transport, s.MailFrom.AuthenticatedUser, s.MailFrom.AuthenticationMethod),
+		queueId:      s.QueueId,
+		origHeaders:  &header.Header{},
+	}
+	for _, r := range s.RcptTos {
+		t.origRcptTos = append(t.origRcptTos, addr.NewRcptTo(r.Addr, r.Args, r.Transport))
+	}
+	if len(s.Header) > 0 {
+		raw, _, err := transform.Bytes(&milterutil.CrLfCanonicalizationTransformer{}, s.Header)
+		if err != nil {
+			return nil, err
+		}
+		h, err := header.New(raw)
+		if err != nil {
+			return nil, err
+		}
+		t.origHeaders = h
+	}
+	t.mailFrom = *t.origMailFrom.Copy()
+	t.rcptTos = make([]*addr.RcptTo, len(t.origRcptTos))
+	for i, r := range t.origRcptTos {
+		t.rcptTos[i] = r.Copy()
+	}
+	t.headers = t.origHeaders.Copy()
+	return t, nil
+}
@@ -0,0 +1,105 @@
+package mailfilter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/header"
+	"github.com/d--j/go-milter/mailfilter/addr"
+)
+
+func TestSnapshot_roundTrip(t *testing.T) {
+	t.Parallel()
+	rawHeader := "From: <root@localhost>\r\nTo: <one@example.com>,\r\n <two@example.com>\r\nSubject: test\r\nX-Spam: no\r\nSubject: second\r\n\r\n"
+	h, err := header.New([]byte(rawHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := &transaction{
+		mta:          MTA{Version: "Postfix 3.6.4", FQDN: "mx.example.com", Daemon: "smtpd"},
+		connect:      Connect{Host: "client.example.net", Family: "tcp4", Port: 2525, Addr: "192.0.2.1"},
+		helo:         Helo{Name: "client.example.net", TlsVersion: "TLSv1.3", Cipher: "TLS_AES_256_GCM_SHA384", CipherBits: "256"},
+		origMailFrom: addr.NewMailFrom("root@localhost", "SIZE=100", "smtp", "root", "PLAIN"),
+		origRcptTos:  []*addr.RcptTo{addr.NewRcptTo("one@example.com", "", "smtp"), addr.NewRcptTo("two@example.com", "NOTIFY=NEVER", "local")},
+		origHeaders:  h,
+		queueId:      "ABC123",
+	}
+	orig.makeDecision(context.Background(), func(_ context.Context, _ Trx) (Decision, error) { return Accept, nil })
+
+	s, err := NewSnapshot(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s2 Snapshot
+	if err := json.Unmarshal(b, &s2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, &s2) {
+		t.Fatalf("json round-trip: got %+v, want %+v", s2, s)
+	}
+	restored, err := s2.Trx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *restored.MTA() != orig.mta || *restored.Connect() != orig.connect || *restored.Helo() != orig.helo {
+		t.Errorf("MTA/Connect/Helo = %+v %+v %+v", restored.MTA(), restored.Connect(), restored.Helo())
+	}
+	if restored.QueueId() != "ABC123" {
+		t.Errorf("QueueId() = %q", restored.QueueId())
+	}
+	if got := restored.MailFrom(); !reflect.DeepEqual(got, orig.MailFrom()) {
+		t.Errorf("MailFrom() = %+v, want %+v", got, orig.MailFrom())
+	}
+	if got := restored.RcptTos(); !reflect.DeepEqual(got, orig.RcptTos()) {
+		t.Errorf("RcptTos() = %+v, want %+v", got, orig.RcptTos())
+	}
+	gotHeader, err := io.ReadAll(restored.Headers().Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotHeader) != rawHeader {
+		t.Errorf("Headers() = %q, want %q", gotHeader, rawHeader)
+	}
+	if restored.Body() != nil {
+		t.Error("Body() != nil")
+	}
+	if restored.(*transaction).hasModifications() {
+		t.Error("restored transaction has modifications")
+	}
+	restored.Headers().Add("X-Restored", "yes")
+	restored.DelRcptTo("two@example.com")
+	if len(restored.RcptTos()) != 1 || len(orig.RcptTos()) != 2 {
+		t.Error("restored transaction shares recipients with the original")
+	}
+	if orig.Headers().Fields().Len() != 5 {
+		t.Error("restored transaction shares header with the original")
+	}
+}
+
+func TestSnapshot_empty(t *testing.T) {
+	t.Parallel()
+	orig := &transaction{connect: Connect{Family: "unknown"}}
+	orig.makeDecision(context.Background(), func(_ context.Context, _ Trx) (Decision, error) { return Accept, nil })
+	s, err := NewSnapshot(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Header != nil || s.RcptTos != nil {
+		t.Errorf("NewSnapshot() = %+v", s)
+	}
+	restored, err := s.Trx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Headers().Fields().Len() != 0 || len(restored.RcptTos()) != 0 || restored.Connect().Family != "unknown" {
+		t.Errorf("Trx() = %+v", restored)
+	}
+}
@@ -0,0 +1,117 @@
+package mailfilter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store is a key-value store that gets shared across all SMTP transactions and connections of a [MailFilter].
+// Use it to cache data that your decision function needs across sessions (e.g. greylisting tuples or rate counters).
+//
+// Implementations need to be safe for concurrent use by multiple goroutines.
+// The [MailFilter] hands the store to your decision function via its context, see [StoreFromContext].
+type Store interface {
+	// Get returns the value stored at key. ok is false when there is no value at key or it has expired.
+	Get(key string) (value interface{}, ok bool)
+	// Set stores value at key. The value expires after ttl. A ttl <= 0 means the value never expires.
+	Set(key string, value interface{}, ttl time.Duration)
+	// Delete removes the value at key.
+	Delete(key string)
+}
+
+type memoryStoreEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// memoryStoreSweepInterval is the minimal interval between two sweeps of expired entries of a [MemoryStore].
+const memoryStoreSweepInterval = time.Minute
+
+// MemoryStore is an in-memory [Store]. Expired entries get removed lazily:
+// on Get and periodically on Set. It does not need any background goroutine.
+//
+// This is the default [Store] of a [MailFilter]. Use [NewMemoryStore] to create it.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryStoreEntry
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore creates a new empty [MemoryStore].
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryStoreEntry),
+		now:     time.Now,
+	}
+}
+
+func (e memoryStoreEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// Get implements [Store.Get].
+func (s *MemoryStore) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(s.now()) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set implements [Store.Set].
+func (s *MemoryStore) Set(key string, value interface{}, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.After(s.nextSweep) {
+		for k, e := range s.entries {
+			if e.expired(now) {
+				delete(s.entries, k)
+			}
+		}
+		s.nextSweep = now.Add(memoryStoreSweepInterval)
+	}
+	e := memoryStoreEntry{value: value}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	s.entries[key] = e
+}
+
+// Delete implements [Store.Delete].
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// Len returns the number of entries in the store. This might include expired entries that did not get removed yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+type storeContextKey struct{}
+
+// contextWithStore returns a copy of ctx that carries store
+func contextWithStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, storeContextKey{}, store)
+}
+
+// StoreFromContext returns the [Store] of the [MailFilter] that called your decision function with ctx.
+// It returns nil when ctx was not created by a [MailFilter].
+func StoreFromContext(ctx context.Context) Store {
+	store, _ := ctx.Value(storeContextKey{}).(Store)
+	return store
+}
@@ -0,0 +1,164 @@
+package mailfilter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newFakeClockStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = clock.Now
+	return s, clock
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+	s, clock := newFakeClockStore()
+	if _, ok := s.Get("a"); ok {
+		t.Fatal("empty store returned value")
+	}
+	s.Set("a", 1, time.Second)
+	s.Set("b", "two", 0)
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v", v, ok)
+	}
+	clock.Advance(999 * time.Millisecond)
+	if _, ok := s.Get("a"); !ok {
+		t.Fatal("a expired too early")
+	}
+	clock.Advance(time.Millisecond)
+	if v, ok := s.Get("a"); ok {
+		t.Fatalf("Get(a) = %v, want expired", v)
+	}
+	clock.Advance(24 * time.Hour)
+	if v, ok := s.Get("b"); !ok || v != "two" {
+		t.Fatalf("Get(b) = %v, %v", v, ok)
+	}
+	s.Set("b", "three", -1)
+	if v, ok := s.Get("b"); !ok || v != "three" {
+		t.Fatalf("Get(b) = %v, %v", v, ok)
+	}
+	s.Delete("b")
+	if _, ok := s.Get("b"); ok {
+		t.Fatal("b not deleted")
+	}
+	s.Delete("does-not-exist")
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	t.Parallel()
+	s, clock := newFakeClockStore()
+	for i := 0; i < 10; i++ {
+		s.Set(fmt.Sprintf("k%d", i), i, time.Second)
+	}
+	s.Set("keep", true, 0)
+	if got := s.Len(); got != 11 {
+		t.Fatalf("Len() = %d, want 11", got)
+	}
+	clock.Advance(2 * time.Second)
+	s.Set("new", true, time.Second)
+	if got := s.Len(); got != 12 {
+		t.Fatalf("Len() = %d, want 12 (no sweep before interval)", got)
+	}
+	clock.Advance(memoryStoreSweepInterval)
+	s.Set("new", true, time.Hour)
+	if got := s.Len(); got != 2 {
+		t.Fatalf("Len() = %d, want 2 after sweep", got)
+	}
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	t.Parallel()
+	s, clock := newFakeClockStore()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("k%d", i%50)
+				s.Set(key, g, time.Duration(i%3)*time.Second)
+				if v, ok := s.Get(key); ok {
+					if _, isInt := v.(int); !isInt {
+						t.Errorf("Get(%s) = %v", key, v)
+					}
+				}
+				if i%10 == 0 {
+					clock.Advance(time.Second)
+				}
+				if i%7 == 0 {
+					s.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	clock.Advance(3 * time.Second)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if v, ok := s.Get(key); ok {
+			// only entries without expiry may survive
+			s.mu.Lock()
+			e := s.entries[key]
+			s.mu.Unlock()
+			if !e.expires.IsZero() {
+				t.Errorf("Get(%s) = %v, want expired", key, v)
+			}
+		}
+	}
+}
+
+func TestStoreFromContext(t *testing.T) {
+	t.Parallel()
+	if got := StoreFromContext(context.Background()); got != nil {
+		t.Fatalf("StoreFromContext() = %v, want nil", got)
+	}
+	store := NewMemoryStore()
+	if got := StoreFromContext(contextWithStore(context.Background(), store)); got != store {
+		t.Fatalf("StoreFromContext() = %v, want %v", got, store)
+	}
+}
+
+func Test_backend_makeDecision_Store(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	b.opts.store = NewMemoryStore()
+	b.decision = func(ctx context.Context, _ Trx) (Decision, error) {
+		store := StoreFromContext(ctx)
+		if store == nil {
+			t.Error("no store in context")
+			return Accept, nil
+		}
+		n, _ := store.Get("calls")
+		i, _ := n.(int)
+		store.Set("calls", i+1, 0)
+		return Accept, nil
+	}
+	b.makeDecision(s.newModifier())
+	b.Cleanup()
+	b.makeDecision(s.newModifier())
+	if v, _ := b.opts.store.Get("calls"); v != 2 {
+		t.Fatalf("calls = %v, want 2", v)
+	}
+}
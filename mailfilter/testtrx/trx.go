@@ -4,6 +4,7 @@ package testtrx
 import (
 	"bytes"
 	"io"
+	"time"
 
 	"github.com/d--j/go-milter/internal/header"
 	"github.com/d--j/go-milter/internal/rcptto"
@@ -14,27 +15,21 @@ import (
 	"golang.org/x/text/transform"
 )
 
-type ModificationKind int
+// ModificationKind is an alias of [mailfilter.ModificationKind].
+type ModificationKind = mailfilter.ModificationKind
 
 const (
-	ChangeFrom   ModificationKind = iota // a change mail from modification would have been sent to the MTA
-	AddRcptTo                            // an add-recipient modification would have been sent to the MTA
-	DelRcptTo                            // a delete-recipient modification would have been sent to the MTA
-	InsertHeader                         // an insert-header modification would have been sent to the MTA
-	ChangeHeader                         // a change-header modification would have been sent to the MTA
-	ReplaceBody                          // a replace-body modification would have been sent to the MTA
+	ChangeFrom   = mailfilter.ModChangeFrom   // a change mail from modification would have been sent to the MTA
+	AddRcptTo    = mailfilter.ModAddRcptTo    // an add-recipient modification would have been sent to the MTA
+	DelRcptTo    = mailfilter.ModDelRcptTo    // a delete-recipient modification would have been sent to the MTA
+	InsertHeader = mailfilter.ModInsertHeader // an insert-header modification would have been sent to the MTA
+	ChangeHeader = mailfilter.ModChangeHeader // a change-header modification would have been sent to the MTA
+	ReplaceBody  = mailfilter.ModReplaceBody  // a replace-body modification would have been sent to the MTA
 )
 
 // Modification is a modification that a [mailfilter.DecisionModificationFunc] made to the Trx.
-type Modification struct {
-	Kind  ModificationKind
-	Addr  string
-	Args  string
-	Index int
-	Name  string
-	Value string
-	Body  []byte
-}
+// It is an alias of [mailfilter.Modification], so you can use it with [mailfilter.NewResult].
+type Modification = mailfilter.Modification
 
 // Trx implements [mailfilter.Trx] for unit tests.
 // Use this struct when you want to test your decision functions.
@@ -94,6 +89,10 @@ func (t *Trx) SetMailFrom(mailFrom addr.MailFrom) *Trx {
 	return t
 }
 
+func (t *Trx) IsBounce() bool {
+	return t.origMailFrom.IsNull()
+}
+
 func (t *Trx) ChangeMailFrom(from string, esmtpArgs string) {
 	t.mailFrom.Addr = from
 	t.mailFrom.Args = esmtpArgs
@@ -134,6 +133,13 @@ func (t *Trx) Headers() header2.Header {
 	return t.header
 }
 
+func (t *Trx) Date() (time.Time, error) {
+	if t.header == nil {
+		return time.Time{}, nil
+	}
+	return t.header.Date()
+}
+
 func (t *Trx) HeadersEnforceOrder() {
 	if t.mta.IsSendmail() {
 		t.enforceHeaderOrder = true
@@ -148,6 +154,11 @@ func (t *Trx) SetHeaders(headers header2.Header) *Trx {
 	return t.SetHeadersRaw(r)
 }
 
+// SetHeaderFields sets the header of t to the header fields fields. See [NewHeader].
+func (t *Trx) SetHeaderFields(fields [][2]string) *Trx {
+	return t.SetHeaders(NewHeader(fields))
+}
+
 func (t *Trx) SetHeadersRaw(raw []byte) *Trx {
 	canonicalRaw, _, err := transform.Bytes(&milterutil.CrLfCanonicalizationTransformer{}, raw)
 	if err != nil {
@@ -169,6 +180,19 @@ func (t *Trx) Body() io.ReadSeeker {
 	return t.body
 }
 
+func (t *Trx) TextBody() (io.Reader, error) {
+	b := t.Body()
+	if b == nil {
+		return nil, nil
+	}
+	var contentType, contentTransferEncoding string
+	if t.header != nil {
+		contentType = t.header.UnfoldedValue("Content-Type")
+		contentTransferEncoding = t.header.UnfoldedValue("Content-Transfer-Encoding")
+	}
+	return milterutil.PrimaryTextPart(contentType, contentTransferEncoding, b)
+}
+
 func (t *Trx) SetBody(body io.ReadSeeker) *Trx {
 	t.body = body
 	return t
@@ -183,6 +207,28 @@ func (t *Trx) ReplaceBody(r io.Reader) {
 	t.bodyReplacement = r
 }
 
+func (t *Trx) HeaderSize() int64 {
+	return t.origHeader.Size()
+}
+
+func (t *Trx) BodySize() int64 {
+	if t.body == nil {
+		return 0
+	}
+	pos, err := t.body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		panic(err)
+	}
+	size, err := t.body.Seek(0, io.SeekEnd)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := t.body.Seek(pos, io.SeekStart); err != nil {
+		panic(err)
+	}
+	return size
+}
+
 func (t *Trx) QueueId() string {
 	return t.queueId
 }
@@ -229,3 +275,33 @@ func (t *Trx) Modifications() []Modification {
 }
 
 var _ mailfilter.Trx = (*Trx)(nil)
+
+// NewHeader creates a new in-memory [header2.Header] with fields.
+// fields is an ordered list of name and value pairs. Duplicate names (e.g. multiple Received fields) are allowed.
+// A space gets inserted between the colon and the value unless the value starts with a space or tab.
+// Multi-line values must be folded (the continuation lines need to start with a space or tab).
+//
+// You can use it to test functions that take a [header2.Header] or pass it to [Trx.SetHeaders].
+// NewHeader panics when fields cannot be parsed as header.
+func NewHeader(fields [][2]string) header2.Header {
+	var raw bytes.Buffer
+	for _, f := range fields {
+		raw.WriteString(f[0])
+		raw.WriteByte(':')
+		if f[1] == "" || (f[1][0] != ' ' && f[1][0] != '\t') {
+			raw.WriteByte(' ')
+		}
+		raw.WriteString(f[1])
+		raw.WriteString("\n")
+	}
+	raw.WriteString("\n")
+	canonicalRaw, _, err := transform.Bytes(&milterutil.CrLfCanonicalizationTransformer{}, raw.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	h, err := header.New(canonicalRaw)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
@@ -2,6 +2,7 @@ package testtrx
 
 import (
 	"bytes"
+	"io"
 	"reflect"
 	"testing"
 
@@ -64,3 +65,35 @@ func TestTestTrx(t *testing.T) {
 		t.Fatalf("trx.Modifications() = %+v, want %+v", m, expected)
 	}
 }
+
+func TestNewHeader(t *testing.T) {
+	t.Parallel()
+	h := NewHeader([][2]string{
+		{"Received", "from a"},
+		{"Received", "from b"},
+		{"Subject", "test"},
+		{"To", "\t<root@localhost>,\n <postmaster@example.com>"},
+		{"X-Empty", ""},
+	})
+	raw, err := io.ReadAll(h.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Received: from a\r\nReceived: from b\r\nSubject: test\r\nTo:\t<root@localhost>,\r\n <postmaster@example.com>\r\nX-Empty: \r\n\r\n"
+	if string(raw) != want {
+		t.Errorf("NewHeader() = %q, want %q", raw, want)
+	}
+	if got := h.Fields().Len(); got != 5 {
+		t.Errorf("Fields().Len() = %d, want 5", got)
+	}
+	if got := h.Value("Received"); got != " from a" {
+		t.Errorf("Value(Received) = %q, want %q", got, " from a")
+	}
+
+	trx := (&Trx{}).SetHeaderFields([][2]string{{"Subject", "test"}})
+	trx.Headers().SetSubject("changed")
+	expected := []Modification{{Kind: ChangeHeader, Index: 1, Name: "Subject", Value: " changed"}}
+	if m := trx.Modifications(); !reflect.DeepEqual(m, expected) {
+		t.Errorf("trx.Modifications() = %+v, want %+v", m, expected)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"regexp"
+	"time"
 
 	"github.com/d--j/go-milter"
 	"github.com/d--j/go-milter/internal/body"
@@ -12,6 +13,7 @@ import (
 	"github.com/d--j/go-milter/internal/rcptto"
 	"github.com/d--j/go-milter/mailfilter/addr"
 	header2 "github.com/d--j/go-milter/mailfilter/header"
+	"github.com/d--j/go-milter/milterutil"
 )
 
 type MTA struct {
@@ -48,15 +50,17 @@ type Helo struct {
 // transaction can be used to examine the data of the current mail transaction and
 // also send changes to the message back to the MTA.
 type transaction struct {
-	mta                MTA
-	connect            Connect
-	helo               Helo
-	mailFrom           addr.MailFrom
-	origMailFrom       addr.MailFrom
-	rcptTos            []*addr.RcptTo
-	origRcptTos        []*addr.RcptTo
-	headers            *header.Header
-	origHeaders        *header.Header
+	mta          MTA
+	connect      Connect
+	helo         Helo
+	mailFrom     addr.MailFrom
+	origMailFrom addr.MailFrom
+	rcptTos      []*addr.RcptTo
+	origRcptTos  []*addr.RcptTo
+	headers      *header.Header
+	origHeaders  *header.Header
+	// compressedHeaders are the gzip compressed origHeaders (see [WithHeaderCompression])
+	compressedHeaders  []byte
 	enforceHeaderOrder bool
 	body               *body.Body
 	replacementBody    io.Reader
@@ -65,6 +69,11 @@ type transaction struct {
 	decision           Decision
 	decisionErr        error
 	quarantineReason   *string
+	// budget is the shared memory budget of the MailFilter (nil when there is none)
+	budget *bufferBudget
+	// headerReserved and bodyReserved are the bytes that this transaction reserved of budget
+	headerReserved int64
+	bodyReserved   int64
 }
 
 func (t *transaction) MTA() *MTA {
@@ -86,6 +95,7 @@ func (t *transaction) QueueId() string {
 func (t *transaction) cleanup() {
 	t.headers = nil
 	t.origHeaders = nil
+	t.compressedHeaders = nil
 	t.rcptTos = nil
 	t.origRcptTos = nil
 	t.quarantineReason = nil
@@ -94,6 +104,15 @@ func (t *transaction) cleanup() {
 		_ = t.body.Close()
 		t.body = nil
 	}
+	t.releaseBudget()
+}
+
+// releaseBudget gives the reserved bytes of t back to the budget.
+func (t *transaction) releaseBudget() {
+	if t.budget != nil {
+		t.budget.release(t.headerReserved + t.bodyReserved)
+		t.headerReserved, t.bodyReserved = 0, 0
+	}
 }
 
 func (t *transaction) response() *milter.Response {
@@ -239,18 +258,41 @@ func (t *transaction) sendModifications(m *milter.Modifier) error {
 	return nil
 }
 
-func (t *transaction) addHeader(key string, raw []byte) {
+// addHeader adds the header field raw. It returns false and does not add the field when the budget is exhausted.
+func (t *transaction) addHeader(key string, raw []byte) bool {
+	if t.budget != nil {
+		if !t.budget.reserve(int64(len(raw))) {
+			return false
+		}
+		t.headerReserved += int64(len(raw))
+	}
 	if t.origHeaders == nil {
 		t.origHeaders = &header.Header{}
 	}
 	t.origHeaders.AddRaw(key, raw)
+	return true
 }
 
+// maxBodyMemory is the number of body bytes that get buffered in memory, bigger bodies get buffered in a temporary file
+const maxBodyMemory = 200 * 1024
+
 func (t *transaction) addBodyChunk(chunk []byte) (err error) {
 	if t.body == nil {
-		t.body = body.New(200 * 1024)
+		t.body = body.New(maxBodyMemory)
+	}
+	inMemory := t.body.Size()+int64(len(chunk)) <= maxBodyMemory
+	if t.budget != nil && inMemory {
+		if !t.budget.reserve(int64(len(chunk))) {
+			return errBudgetExhausted
+		}
+		t.bodyReserved += int64(len(chunk))
 	}
 	_, err = t.body.Write(chunk)
+	if t.budget != nil && !inMemory && t.bodyReserved > 0 {
+		// the body got moved to a temporary file
+		t.budget.release(t.bodyReserved)
+		t.bodyReserved = 0
+	}
 	return
 }
 
@@ -258,6 +300,10 @@ func (t *transaction) MailFrom() *addr.MailFrom {
 	return &t.mailFrom
 }
 
+func (t *transaction) IsBounce() bool {
+	return t.origMailFrom.IsNull()
+}
+
 func (t *transaction) ChangeMailFrom(from string, esmtpArgs string) {
 	t.mailFrom.Addr = from
 	t.mailFrom.Args = esmtpArgs
@@ -283,6 +329,13 @@ func (t *transaction) Headers() header2.Header {
 	return t.headers
 }
 
+func (t *transaction) Date() (time.Time, error) {
+	if t.headers == nil {
+		return time.Time{}, nil
+	}
+	return t.headers.Date()
+}
+
 func (t *transaction) HeadersEnforceOrder() {
 	if t.mta.IsSendmail() {
 		t.enforceHeaderOrder = true
@@ -297,6 +350,30 @@ func (t *transaction) Body() io.ReadSeeker {
 	return t.body
 }
 
+func (t *transaction) TextBody() (io.Reader, error) {
+	b := t.Body()
+	if b == nil {
+		return nil, nil
+	}
+	var contentType, contentTransferEncoding string
+	if t.origHeaders != nil {
+		contentType = t.origHeaders.UnfoldedValue("Content-Type")
+		contentTransferEncoding = t.origHeaders.UnfoldedValue("Content-Transfer-Encoding")
+	}
+	return milterutil.PrimaryTextPart(contentType, contentTransferEncoding, b)
+}
+
+func (t *transaction) HeaderSize() int64 {
+	return t.origHeaders.Size()
+}
+
+func (t *transaction) BodySize() int64 {
+	if t.body == nil {
+		return 0
+	}
+	return t.body.Size()
+}
+
 func (t *transaction) ReplaceBody(r io.Reader) {
 	t.closeReplacementBody()
 	t.replacementBody = r
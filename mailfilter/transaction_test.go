@@ -8,7 +8,10 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/d--j/go-milter/internal/body"
+	"github.com/d--j/go-milter/internal/header"
 	"github.com/d--j/go-milter/internal/wire"
 	"github.com/d--j/go-milter/mailfilter/addr"
 	"github.com/emersion/go-message/mail"
@@ -347,3 +350,89 @@ func Test_transaction_HeadersEnforceOrder(t1 *testing.T) {
 		})
 	}
 }
+
+func Test_transaction_Date(t1 *testing.T) {
+	parse := func(raw string) *header.Header {
+		h, err := header.New([]byte(raw))
+		if err != nil {
+			t1.Fatal(err)
+		}
+		return h
+	}
+	tests := []struct {
+		name    string
+		headers *header.Header
+		want    time.Time
+		wantErr bool
+	}{
+		{"No headers", nil, time.Time{}, false},
+		{"No Date", parse("Subject: test\r\n\r\n"), time.Time{}, false},
+		{"Date", parse("Date: Wed, 01 Mar 2023 15:47:33 +0100\r\n\r\n"), time.Date(2023, time.March, 1, 14, 47, 33, 0, time.UTC), false},
+		{"Named zone", parse("Date: Wed, 01 Mar 2023 15:47:33 PST\r\n\r\n"), time.Date(2023, time.March, 1, 23, 47, 33, 0, time.UTC), false},
+		{"Malformed", parse("Date: tomorrow\r\n\r\n"), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t1.Run(tt.name, func(t1 *testing.T) {
+			t := &transaction{headers: tt.headers}
+			got, err := t.Date()
+			if (err != nil) != tt.wantErr {
+				t1.Fatalf("Date() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t1.Errorf("Date() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_transaction_TextBody(t1 *testing.T) {
+	headers := func(kv ...string) *header.Header {
+		h := &header.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Add(kv[i], kv[i+1])
+		}
+		return h
+	}
+	newBody := func(s string) *body.Body {
+		b := body.New(200)
+		if _, err := b.Write([]byte(s)); err != nil {
+			t1.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name    string
+		headers *header.Header
+		body    *body.Body
+		want    string
+		wantNil bool
+	}{
+		{"No body", headers("Subject", "test"), nil, "", true},
+		{"No headers", nil, newBody("Hello"), "Hello", false},
+		{"ISO-8859-1 quoted-printable", headers("Content-Type", "text/plain;\r\n charset=ISO-8859-1", "Content-Transfer-Encoding", "quoted-printable"), newBody("Gr=FC=DFe aus K=F6ln"), "Grüße aus Köln", false},
+		{"UTF-8 base64", headers("Content-Type", "text/plain; charset=utf-8", "Content-Transfer-Encoding", "base64"), newBody("R3LDvMOfZSBhdXMgS8O2bG4="), "Grüße aus Köln", false},
+		{"Not text", headers("Content-Type", "application/pdf"), newBody("%PDF"), "", true},
+	}
+	for _, tt := range tests {
+		t1.Run(tt.name, func(t1 *testing.T) {
+			t := &transaction{origHeaders: tt.headers, body: tt.body}
+			r, err := t.TextBody()
+			if err != nil {
+				t1.Fatalf("TextBody() error = %v", err)
+			}
+			if (r == nil) != tt.wantNil {
+				t1.Fatalf("TextBody() = %v, wantNil %v", r, tt.wantNil)
+			}
+			if r == nil {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t1.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t1.Errorf("TextBody() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
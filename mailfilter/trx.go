@@ -2,6 +2,7 @@ package mailfilter
 
 import (
 	"io"
+	"time"
 
 	"github.com/d--j/go-milter/mailfilter/addr"
 	"github.com/d--j/go-milter/mailfilter/header"
@@ -30,6 +31,12 @@ type Trx interface {
 	// When your filter should work with Sendmail you should set esmtpArgs to the empty string
 	// since Sendmail validates the provided esmtpArgs and also rejects valid values like `SIZE=20`.
 	ChangeMailFrom(from string, esmtpArgs string)
+	// IsBounce returns true when the MTA received this message with the null sender <> (MAIL FROM:<>).
+	// Bounces and other delivery status notifications use the null sender, so filters often relax their policy for them.
+	// Your changes to MailFrom do not alter this value.
+	//
+	// Only meaningful if [WithDecisionAt] is bigger than [DecisionAtHelo]: before that IsBounce always returns true.
+	IsBounce() bool
 
 	// RcptTos holds the [RcptTo] recipient slice of this transaction.
 	// Your changes to Addr and/or Args values of the elements of this slice get send back to the MTA.
@@ -68,17 +75,44 @@ type Trx interface {
 	//
 	// For other MTAs this method does not do anything (since there we can ensure correct header ordering without this workaround).
 	HeadersEnforceOrder()
+	// Date returns the parsed value of the Date header field of this message (RFC 5322 syntax including the obsolete forms).
+	// When the Date header field is missing the zero [time.Time] and no error is returned.
+	// When its value cannot be parsed the zero [time.Time] and a parsing error is returned.
+	//
+	// Only populated if [WithDecisionAt] is bigger than [DecisionAtData].
+	Date() (time.Time, error)
 
 	// Body gets you a [io.ReadSeeker] of the body.
 	// The reader gets seeked to the start of the body whenever you call this method.
+	// Use [milterutil.NewWindowScanner] to scan the body for patterns in overlapping windows.
 	//
 	// This method returns nil when you used [WithDecisionAt] with anything other than [DecisionAtEndOfMessage]
 	// or you used [WithoutBody].
 	Body() io.ReadSeeker
+	// TextBody returns the text of the primary text part of the message as UTF-8 (see [milterutil.PrimaryTextPart]).
+	// The Content-Transfer-Encoding gets decoded and the text gets converted from its charset to UTF-8.
+	// Text in an unknown charset gets returned as-is (with invalid UTF-8 sequences replaced).
+	//
+	// TextBody returns nil when the message does not have a text part or when Body would return nil.
+	// The Content-Type and Content-Transfer-Encoding of the original message get used, your changes to Headers do not matter.
+	TextBody() (io.Reader, error)
 	// ReplaceBody replaces the body of the current message with the contents
 	// of the [io.Reader] r.
 	ReplaceBody(r io.Reader)
 
+	// HeaderSize is the size in bytes of the header fields the MTA sent us
+	// (every field counted as "Name: value" with its trailing CR LF).
+	// Changes you make to Headers do not alter this value.
+	//
+	// Only populated if [WithDecisionAt] is bigger than [DecisionAtData].
+	HeaderSize() int64
+	// BodySize is the size in bytes of the body the MTA sent us.
+	// It does not matter whether the body was held in memory or got spilled to a temporary file.
+	//
+	// This method returns 0 when you used [WithDecisionAt] with anything other than [DecisionAtEndOfMessage]
+	// or you used [WithoutBody].
+	BodySize() int64
+
 	// QueueId is the queue ID the MTA assigned for this transaction.
 	// You cannot change this value.
 	//
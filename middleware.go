@@ -0,0 +1,198 @@
+package milter
+
+// Middleware wraps a [Milter] backend to add functionality (logging, policy checks etc.) in front of or after it.
+//
+// Wrap gets called for every [Milter] instance, so you normally call it in the function you pass to [WithMilter]:
+//
+//	server := milter.NewServer(milter.WithMilter(func() milter.Milter {
+//		return mw.Wrap(&MyMilter{})
+//	}))
+//
+// The [Milter] returned by Wrap needs to call the respective methods of next.
+// It can keep per-connection state since every connection gets its own [Milter] instance.
+type Middleware interface {
+	Wrap(next Milter) Milter
+}
+
+// MiddlewareFunc is an adapter that allows you to use an ordinary function as [Middleware].
+type MiddlewareFunc func(next Milter) Milter
+
+// Wrap calls f(next).
+func (f MiddlewareFunc) Wrap(next Milter) Milter {
+	return f(next)
+}
+
+// Wrap wraps m with the middlewares mws and returns the resulting [Milter].
+// The first middleware is the outermost one: it sees the calls of the MTA first and the responses of m last.
+//
+//	server := milter.NewServer(milter.WithMilter(func() milter.Milter {
+//		return milter.Wrap(&MyMilter{}, logging, metrics)
+//	}))
+func Wrap(m Milter, mws ...Middleware) Milter {
+	for i := len(mws) - 1; i >= 0; i-- {
+		m = mws[i].Wrap(m)
+	}
+	return m
+}
+
+// Callback identifies a callback method of [Milter] in [CallbackHooks].
+type Callback int
+
+const (
+	CallbackConnect      Callback = iota + 1 // [Milter.Connect]
+	CallbackHelo                             // [Milter.Helo]
+	CallbackMailFrom                         // [Milter.MailFrom]
+	CallbackRcptTo                           // [Milter.RcptTo]
+	CallbackData                             // [Milter.Data]
+	CallbackHeader                           // [Milter.Header]
+	CallbackHeaders                          // [Milter.Headers]
+	CallbackBodyChunk                        // [Milter.BodyChunk]
+	CallbackEndOfMessage                     // [Milter.EndOfMessage]
+	CallbackAbort                            // [Milter.Abort]
+	CallbackUnknown                          // [Milter.Unknown]
+)
+
+func (c Callback) String() string {
+	switch c {
+	case CallbackConnect:
+		return "connect"
+	case CallbackHelo:
+		return "helo"
+	case CallbackMailFrom:
+		return "mail-from"
+	case CallbackRcptTo:
+		return "rcpt-to"
+	case CallbackData:
+		return "data"
+	case CallbackHeader:
+		return "header"
+	case CallbackHeaders:
+		return "headers"
+	case CallbackBodyChunk:
+		return "body-chunk"
+	case CallbackEndOfMessage:
+		return "end-of-message"
+	case CallbackAbort:
+		return "abort"
+	case CallbackUnknown:
+		return "unknown"
+	default:
+		return "invalid"
+	}
+}
+
+// CallbackHooks is a [Middleware] that calls Before and After around every callback of the wrapped [Milter]
+// (except Cleanup). Use it for cross-cutting behaviour like logging or metrics that only needs to know
+// which callback got called and how it got answered. Both hooks are optional.
+//
+// The arguments of the callback are not passed to the hooks, write your own [Middleware] if you need them.
+type CallbackHooks struct {
+	// Before gets called before the callback of the wrapped [Milter].
+	// When it returns a non-nil [*Response] or error the wrapped [Milter] does not get called and this is the result of the callback.
+	// For [CallbackAbort] only the error is used.
+	Before func(callback Callback, m *Modifier) (*Response, error)
+	// After gets called with the result of the callback (or the result of Before) and returns the result that gets sent to the MTA.
+	// Return resp and err unchanged to only observe the result. For [CallbackAbort] resp is always nil and the returned response gets ignored.
+	After func(callback Callback, resp *Response, err error, m *Modifier) (*Response, error)
+}
+
+// Wrap wraps next so that h.Before and h.After get called around every callback of next.
+func (h *CallbackHooks) Wrap(next Milter) Milter {
+	return &callbackHooksMilter{next: next, hooks: h}
+}
+
+type callbackHooksMilter struct {
+	next  Milter
+	hooks *CallbackHooks
+}
+
+func (c *callbackHooksMilter) call(callback Callback, m *Modifier, fn func() (*Response, error)) (*Response, error) {
+	var resp *Response
+	var err error
+	if c.hooks.Before != nil {
+		resp, err = c.hooks.Before(callback, m)
+		if callback == CallbackAbort {
+			resp = nil
+		}
+	}
+	if resp == nil && err == nil {
+		resp, err = fn()
+	}
+	if c.hooks.After != nil {
+		resp, err = c.hooks.After(callback, resp, err, m)
+	}
+	return resp, err
+}
+
+func (c *callbackHooksMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	return c.call(CallbackConnect, m, func() (*Response, error) {
+		return c.next.Connect(host, family, port, addr, m)
+	})
+}
+
+func (c *callbackHooksMilter) Helo(name string, m *Modifier) (*Response, error) {
+	return c.call(CallbackHelo, m, func() (*Response, error) {
+		return c.next.Helo(name, m)
+	})
+}
+
+func (c *callbackHooksMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	return c.call(CallbackMailFrom, m, func() (*Response, error) {
+		return c.next.MailFrom(from, esmtpArgs, m)
+	})
+}
+
+func (c *callbackHooksMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	return c.call(CallbackRcptTo, m, func() (*Response, error) {
+		return c.next.RcptTo(rcptTo, esmtpArgs, m)
+	})
+}
+
+func (c *callbackHooksMilter) Data(m *Modifier) (*Response, error) {
+	return c.call(CallbackData, m, func() (*Response, error) {
+		return c.next.Data(m)
+	})
+}
+
+func (c *callbackHooksMilter) Header(name string, value string, m *Modifier) (*Response, error) {
+	return c.call(CallbackHeader, m, func() (*Response, error) {
+		return c.next.Header(name, value, m)
+	})
+}
+
+func (c *callbackHooksMilter) Headers(m *Modifier) (*Response, error) {
+	return c.call(CallbackHeaders, m, func() (*Response, error) {
+		return c.next.Headers(m)
+	})
+}
+
+func (c *callbackHooksMilter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	return c.call(CallbackBodyChunk, m, func() (*Response, error) {
+		return c.next.BodyChunk(chunk, m)
+	})
+}
+
+func (c *callbackHooksMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	return c.call(CallbackEndOfMessage, m, func() (*Response, error) {
+		return c.next.EndOfMessage(m)
+	})
+}
+
+func (c *callbackHooksMilter) Abort(m *Modifier) error {
+	_, err := c.call(CallbackAbort, m, func() (*Response, error) {
+		return nil, c.next.Abort(m)
+	})
+	return err
+}
+
+func (c *callbackHooksMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	return c.call(CallbackUnknown, m, func() (*Response, error) {
+		return c.next.Unknown(cmd, m)
+	})
+}
+
+func (c *callbackHooksMilter) Cleanup() {
+	c.next.Cleanup()
+}
+
+var _ Middleware = (*CallbackHooks)(nil)
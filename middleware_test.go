@@ -0,0 +1,131 @@
+package milter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type recordingMiddleware struct {
+	name  string
+	calls *[]string
+}
+
+func (r recordingMiddleware) Wrap(next Milter) Milter {
+	return &recordingMilter{Milter: next, r: r}
+}
+
+type recordingMilter struct {
+	Milter
+	r recordingMiddleware
+}
+
+func (r *recordingMilter) Helo(name string, m *Modifier) (*Response, error) {
+	*r.r.calls = append(*r.r.calls, r.r.name+">")
+	resp, err := r.Milter.Helo(name, m)
+	*r.r.calls = append(*r.r.calls, "<"+r.r.name)
+	return resp, err
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+	var calls []string
+	m := Wrap(NoOpMilter{}, recordingMiddleware{"a", &calls}, recordingMiddleware{"b", &calls})
+	if _, err := m.Helo("example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, " "); got != "a> b> <b <a" {
+		t.Errorf("calls = %q, want %q", got, "a> b> <b <a")
+	}
+	if m := Wrap(NoOpMilter{}); m != (NoOpMilter{}) {
+		t.Errorf("Wrap() without middlewares = %v, want NoOpMilter", m)
+	}
+}
+
+func TestCallbackHooks(t *testing.T) {
+	t.Parallel()
+	before := make(map[Callback]int)
+	after := make(map[Callback]int)
+	logging := &CallbackHooks{
+		Before: func(callback Callback, m *Modifier) (*Response, error) {
+			before[callback]++
+			return nil, nil
+		},
+		After: func(callback Callback, resp *Response, err error, m *Modifier) (*Response, error) {
+			after[callback]++
+			return resp, err
+		},
+	}
+	m := Wrap(NoOpMilter{}, logging)
+	mod := &Modifier{}
+	assertResp := func(resp *Response, err error) {
+		t.Helper()
+		if err != nil || resp == nil {
+			t.Fatalf("got %v, %v", resp, err)
+		}
+	}
+	assertResp(m.Connect("localhost", "tcp4", 25, "127.0.0.1", mod))
+	assertResp(m.Helo("localhost", mod))
+	assertResp(m.MailFrom("from@example.com", "", mod))
+	assertResp(m.RcptTo("to1@example.com", "", mod))
+	assertResp(m.RcptTo("to2@example.com", "", mod))
+	assertResp(m.Data(mod))
+	assertResp(m.Header("Subject", "test", mod))
+	assertResp(m.Header("From", "from@example.com", mod))
+	assertResp(m.Headers(mod))
+	assertResp(m.BodyChunk([]byte("body"), mod))
+	assertResp(m.EndOfMessage(mod))
+	assertResp(m.Unknown("HELP", mod))
+	if err := m.Abort(mod); err != nil {
+		t.Fatal(err)
+	}
+	m.Cleanup()
+	want := map[Callback]int{
+		CallbackConnect: 1, CallbackHelo: 1, CallbackMailFrom: 1, CallbackRcptTo: 2, CallbackData: 1, CallbackHeader: 2,
+		CallbackHeaders: 1, CallbackBodyChunk: 1, CallbackEndOfMessage: 1, CallbackUnknown: 1, CallbackAbort: 1,
+	}
+	for callback, n := range want {
+		if before[callback] != n || after[callback] != n {
+			t.Errorf("%s: got %d before and %d after calls, want %d", callback, before[callback], after[callback], n)
+		}
+	}
+}
+
+func TestCallbackHooks_ShortCircuit(t *testing.T) {
+	t.Parallel()
+	expected := errors.New("abort failed")
+	var afterResp *Response
+	m := (&CallbackHooks{
+		Before: func(callback Callback, m *Modifier) (*Response, error) {
+			switch callback {
+			case CallbackMailFrom:
+				return RespReject, nil
+			case CallbackAbort:
+				return RespAccept, expected
+			}
+			return nil, nil
+		},
+		After: func(callback Callback, resp *Response, err error, m *Modifier) (*Response, error) {
+			if callback == CallbackRcptTo {
+				return RespTempFail, nil
+			}
+			afterResp = resp
+			return resp, err
+		},
+	}).Wrap(&MockMilter{MailResp: RespContinue, RcptResp: RespContinue})
+	if resp, err := m.MailFrom("from@example.com", "", nil); resp != RespReject || err != nil {
+		t.Errorf("MailFrom() = %v, %v want reject", resp, err)
+	}
+	if afterResp != RespReject {
+		t.Errorf("After got %v, want reject", afterResp)
+	}
+	if resp, err := m.RcptTo("to@example.com", "", nil); resp != RespTempFail || err != nil {
+		t.Errorf("RcptTo() = %v, %v want tempfail", resp, err)
+	}
+	if err := m.Abort(nil); err != expected {
+		t.Errorf("Abort() = %v, want %v", err, expected)
+	}
+	if afterResp != nil {
+		t.Errorf("After got %v for abort, want nil", afterResp)
+	}
+}
@@ -0,0 +1,97 @@
+package milterutil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+// attachmentHeadSize is the maximum length of [Attachment.Head]
+const attachmentHeadSize = 512
+
+// Attachment is a MIME part of a message that is an attachment. See [Attachments].
+type Attachment struct {
+	// Filename is the decoded file name of the part (RFC 2231 parameters and RFC 2047 encoded words get decoded).
+	// It is empty when the part is marked as attachment but does not have a file name.
+	Filename string
+	// ContentType is the media type of the part without parameters (e.g. "application/pdf").
+	ContentType string
+	// Head are the first bytes (at most 512) of the decoded content of the part.
+	// Use them to detect the real type of the file, the sender chooses Filename and ContentType.
+	Head []byte
+}
+
+// wordDecoder decodes RFC 2047 encoded words in file names. Many mail clients use them although RFC 2047 does not allow
+// encoded words in parameter values.
+var wordDecoder = mime.WordDecoder{CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+	enc := charsetEncoding(charset)
+	if enc == nil {
+		return nil, fmt.Errorf("milterutil: unknown charset %q", charset)
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
+}}
+
+// Attachments returns the attachments of a message with the header field values contentType, contentDisposition
+// and contentTransferEncoding and the body body.
+//
+// A part is an attachment when its Content-Disposition is "attachment" or when it has a file name
+// (the filename parameter of the Content-Disposition or the name parameter of the Content-Type).
+// Multipart parts get walked recursively, the parts of an attached message (message/rfc822) do not.
+// When the multipart structure cannot be parsed, Attachments returns the attachments it found so far and the error.
+func Attachments(contentType, contentDisposition, contentTransferEncoding string, body io.Reader) ([]Attachment, error) {
+	return attachments(nil, contentType, contentDisposition, contentTransferEncoding, body, 0)
+}
+
+func attachments(found []Attachment, contentType, contentDisposition, contentTransferEncoding string, body io.Reader, depth int) ([]Attachment, error) {
+	if strings.TrimSpace(contentType) == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// RFC 2045: default to text/plain for an invalid Content-Type
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxMultipartDepth {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return found, nil
+			}
+			if err != nil {
+				return found, err
+			}
+			// multipart.Part already decodes quoted-printable and removes the Content-Transfer-Encoding header in that case
+			found, err = attachments(found, part.Header.Get("Content-Type"), part.Header.Get("Content-Disposition"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err != nil {
+				return found, err
+			}
+		}
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(contentDisposition)
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if disposition != "attachment" && filename == "" {
+		return found, nil
+	}
+	if decoded, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+	if strings.EqualFold(strings.TrimSpace(contentTransferEncoding), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	head := make([]byte, attachmentHeadSize)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// the content is broken (e.g. invalid base64), but we still know the name of the attachment
+		n = 0
+	}
+	return append(found, Attachment{Filename: filename, ContentType: mediaType, Head: head[:n]}), nil
+}
@@ -0,0 +1,73 @@
+package milterutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAttachments(t *testing.T) {
+	const multipartMessage = "--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--b1\r\n" +
+		"Content-Type: multipart/mixed; boundary=b2\r\n" +
+		"\r\n" +
+		"--b2\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQK\r\n" +
+		"--b2\r\n" +
+		"Content-Type: application/octet-stream; name=\"=?UTF-8?B?UmVjaG51bmcucGRmLmV4ZQ==?=\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"TVqQAAMAAAAE\r\n" +
+		"AAAA\r\n" +
+		"--b2--\r\n" +
+		"--b1\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename*=UTF-8''Gr%C3%BC%C3%9Fe.png\r\n" +
+		"\r\n" +
+		"\x89PNG\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"K=C3=B6ln\r\n" +
+		"--b1--\r\n"
+	tests := []struct {
+		name                    string
+		contentType             string
+		contentDisposition      string
+		contentTransferEncoding string
+		body                    string
+		want                    []Attachment
+		wantErr                 bool
+	}{
+		{"no Content-Type", "", "", "", "Hello", nil, false},
+		{"single part attachment", "application/pdf; name=a.pdf", "", "", "%PDF-1.4", []Attachment{{"a.pdf", "application/pdf", []byte("%PDF-1.4")}}, false},
+		{"single part disposition", "application/octet-stream", "attachment; filename=a.bin", "base64", "AAEC", []Attachment{{"a.bin", "application/octet-stream", []byte{0, 1, 2}}}, false},
+		{"multipart", "multipart/mixed; boundary=b1", "", "", multipartMessage, []Attachment{
+			{"report.pdf", "application/pdf", []byte("%PDF-1.4\n")},
+			{"Rechnung.pdf.exe", "application/octet-stream", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00")},
+			{"Grüße.png", "image/png", []byte("\x89PNG")},
+			{"", "text/plain", []byte("Köln")},
+		}, false},
+		{"broken multipart", "multipart/mixed; boundary=b1", "", "", "--b1\r\nContent-Type: application/pdf; name=a.pdf\r\n\r\n%PDF\r\n--b1\r\nbroken", []Attachment{{"a.pdf", "application/pdf", []byte("%PDF")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Attachments(tt.contentType, tt.contentDisposition, tt.contentTransferEncoding, strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Attachments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Attachments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
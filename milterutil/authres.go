@@ -0,0 +1,260 @@
+package milterutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthResult is the result of one authentication method in an Authentication-Results header field (RFC 8601).
+type AuthResult struct {
+	// Method is the authentication method, e.g. "spf", "dkim", "dmarc" or "arc".
+	Method string
+	// Result is the result of Method, e.g. "pass", "fail", "softfail", "neutral", "none", "temperror" or "permerror".
+	Result string
+	// Reason is an optional human-readable explanation of Result.
+	Reason string
+	// Properties are the properties (e.g. smtp.mailfrom or header.d) of this result.
+	Properties []AuthProperty
+}
+
+// AuthProperty is one property of an [AuthResult], e.g. Type "smtp", Property "mailfrom" and Value "user@example.com".
+type AuthProperty struct {
+	// Type is the property type: "smtp", "header", "body" or "policy".
+	Type     string
+	Property string
+	Value    string
+}
+
+// FormatAuthenticationResults returns the value of an Authentication-Results header field (RFC 8601)
+// for the authentication service authservID (usually the hostname of your MTA) and results.
+// When results is empty, the value states that no authentication was done ("none").
+//
+// The returned value is a single line without the header field name. You can pass it e.g. to Modifier.InsertHeader of the milter package.
+// An error is returned when one of the inputs cannot be represented in the header field (e.g. a method with spaces).
+func FormatAuthenticationResults(authservID string, results []AuthResult) (string, error) {
+	if !isToken(authservID) {
+		return "", fmt.Errorf("milterutil: invalid authserv-id %q", authservID)
+	}
+	var b strings.Builder
+	b.WriteString(authservID)
+	if len(results) == 0 {
+		b.WriteString("; none")
+		return b.String(), nil
+	}
+	for _, r := range results {
+		if !isKeyword(r.Method) {
+			return "", fmt.Errorf("milterutil: invalid method %q", r.Method)
+		}
+		if !isKeyword(r.Result) {
+			return "", fmt.Errorf("milterutil: invalid result %q of method %s", r.Result, r.Method)
+		}
+		b.WriteString("; ")
+		b.WriteString(strings.ToLower(r.Method))
+		b.WriteByte('=')
+		b.WriteString(strings.ToLower(r.Result))
+		if r.Reason != "" {
+			reason, err := quoteString(r.Reason)
+			if err != nil {
+				return "", fmt.Errorf("milterutil: invalid reason of method %s: %w", r.Method, err)
+			}
+			b.WriteString(" reason=")
+			b.WriteString(reason)
+		}
+		for _, p := range r.Properties {
+			if !isKeyword(p.Type) || !isKeyword(p.Property) {
+				return "", fmt.Errorf("milterutil: invalid property %s.%s of method %s", p.Type, p.Property, r.Method)
+			}
+			value, err := formatPValue(p.Value)
+			if err != nil {
+				return "", fmt.Errorf("milterutil: invalid value of property %s.%s of method %s: %w", p.Type, p.Property, r.Method, err)
+			}
+			b.WriteByte(' ')
+			b.WriteString(strings.ToLower(p.Type))
+			b.WriteByte('.')
+			b.WriteString(p.Property)
+			b.WriteByte('=')
+			b.WriteString(value)
+		}
+	}
+	return b.String(), nil
+}
+
+// ReceivedSPF is the data of a Received-SPF header field (RFC 7208 section 9.1).
+// All fields besides Result are optional.
+type ReceivedSPF struct {
+	// Result is the result of the SPF check: "pass", "fail", "softfail", "neutral", "none", "temperror" or "permerror".
+	Result string
+	// Comment is a human-readable explanation of Result.
+	Comment string
+	// Receiver is the hostname of the host that did the SPF check.
+	Receiver string
+	// ClientIP is the IP address of the SMTP client.
+	ClientIP string
+	// EnvelopeFrom is the envelope sender.
+	EnvelopeFrom string
+	// Helo is the HELO/EHLO hostname of the SMTP client.
+	Helo string
+	// Problem describes why the check resulted in "temperror" or "permerror".
+	Problem string
+	// Mechanism is the SPF mechanism that matched.
+	Mechanism string
+	// Identity is the checked identity: "mailfrom" or "helo".
+	Identity string
+}
+
+var spfResults = map[string]bool{
+	"pass": true, "fail": true, "softfail": true, "neutral": true, "none": true, "temperror": true, "permerror": true,
+}
+
+// FormatReceivedSPF returns the value of a Received-SPF header field (RFC 7208 section 9.1) for spf.
+//
+// The returned value is a single line without the header field name. You can pass it e.g. to Modifier.InsertHeader of the milter package.
+// An error is returned when spf.Result is not a valid SPF result or one of the fields cannot be represented in the header field.
+func FormatReceivedSPF(spf ReceivedSPF) (string, error) {
+	result := strings.ToLower(spf.Result)
+	if !spfResults[result] {
+		return "", fmt.Errorf("milterutil: invalid SPF result %q", spf.Result)
+	}
+	var b strings.Builder
+	b.WriteString(result)
+	if spf.Comment != "" {
+		comment, err := formatComment(spf.Comment)
+		if err != nil {
+			return "", fmt.Errorf("milterutil: invalid comment: %w", err)
+		}
+		b.WriteByte(' ')
+		b.WriteString(comment)
+	}
+	sep := " "
+	for _, kv := range [][2]string{
+		{"receiver", spf.Receiver},
+		{"client-ip", spf.ClientIP},
+		{"envelope-from", spf.EnvelopeFrom},
+		{"helo", spf.Helo},
+		{"problem", spf.Problem},
+		{"mechanism", spf.Mechanism},
+		{"identity", spf.Identity},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		value := kv[1]
+		if !isDotAtom(value) {
+			var err error
+			if value, err = quoteString(value); err != nil {
+				return "", fmt.Errorf("milterutil: invalid %s: %w", kv[0], err)
+			}
+		}
+		b.WriteString(sep)
+		b.WriteString(kv[0])
+		b.WriteByte('=')
+		b.WriteString(value)
+		sep = "; "
+	}
+	return b.String(), nil
+}
+
+// isKeyword returns true when s is a Keyword of RFC 8601 (letters, digits and inner hyphens).
+func isKeyword(s string) bool {
+	if s == "" || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken returns true when s is a token of RFC 2045.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?=`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isDotAtom returns true when s is a dot-atom of RFC 5322.
+func isDotAtom(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, atom := range strings.Split(s, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			c := atom[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// formatPValue formats value as pvalue of RFC 8601: a token, an address or a quoted-string.
+func formatPValue(value string) (string, error) {
+	if isToken(value) {
+		return value, nil
+	}
+	if at := strings.LastIndexByte(value, '@'); at >= 0 {
+		local, domain := value[:at], value[at+1:]
+		if (local == "" || isDotAtom(local)) && isDotAtom(domain) {
+			return value, nil
+		}
+	}
+	return quoteString(value)
+}
+
+// quoteString returns s as quoted-string of RFC 5322.
+func quoteString(s string) (string, error) {
+	if err := checkPrintable(s); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String(), nil
+}
+
+// formatComment returns s as comment of RFC 5322.
+func formatComment(s string) (string, error) {
+	if err := checkPrintable(s); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteByte('(')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '(' || s[i] == ')' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte(')')
+	return b.String(), nil
+}
+
+// checkPrintable returns an error when s includes control characters (including CR and LF).
+func checkPrintable(s string) error {
+	for _, r := range s {
+		if r < ' ' && r != '\t' || r == 0x7f {
+			return fmt.Errorf("control character %q in %q", r, s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package milterutil
+
+import "testing"
+
+func TestFormatAuthenticationResults(t *testing.T) {
+	tests := []struct {
+		name       string
+		authservID string
+		results    []AuthResult
+		want       string
+		wantErr    bool
+	}{
+		{"none", "example.com", nil, "example.com; none", false},
+		{"spf", "example.com", []AuthResult{{Method: "spf", Result: "pass", Properties: []AuthProperty{{"smtp", "mailfrom", "example.net"}}}}, "example.com; spf=pass smtp.mailfrom=example.net", false},
+		{"multiple", "example.com", []AuthResult{
+			{Method: "SPF", Result: "Pass", Properties: []AuthProperty{{"smtp", "mailfrom", "sender@example.net"}}},
+			{Method: "dkim", Result: "fail", Reason: `bad "signature"`, Properties: []AuthProperty{{"header", "d", "example.net"}, {"header", "s", "selector"}}},
+			{Method: "dmarc", Result: "none", Properties: []AuthProperty{{"header", "from", "example.net"}}},
+		}, `example.com; spf=pass smtp.mailfrom=sender@example.net; dkim=fail reason="bad \"signature\"" header.d=example.net header.s=selector; dmarc=none header.from=example.net`, false},
+		{"null sender", "mx.example.com", []AuthResult{{Method: "spf", Result: "none", Properties: []AuthProperty{{"smtp", "mailfrom", "<>"}}}}, `mx.example.com; spf=none smtp.mailfrom="<>"`, false},
+		{"quoted value", "mx.example.com", []AuthResult{{Method: "dkim", Result: "pass", Properties: []AuthProperty{{"header", "i", "first last@example.com"}}}}, `mx.example.com; dkim=pass header.i="first last@example.com"`, false},
+		{"invalid authserv-id", "example com", nil, "", true},
+		{"empty authserv-id", "", nil, "", true},
+		{"invalid method", "example.com", []AuthResult{{Method: "sp f", Result: "pass"}}, "", true},
+		{"invalid result", "example.com", []AuthResult{{Method: "spf", Result: ""}}, "", true},
+		{"invalid reason", "example.com", []AuthResult{{Method: "spf", Result: "pass", Reason: "a\r\nb"}}, "", true},
+		{"invalid property", "example.com", []AuthResult{{Method: "spf", Result: "pass", Properties: []AuthProperty{{"smtp", "mail.from", "a"}}}}, "", true},
+		{"invalid value", "example.com", []AuthResult{{Method: "spf", Result: "pass", Properties: []AuthProperty{{"smtp", "mailfrom", "a\nb"}}}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FormatAuthenticationResults(tt.authservID, tt.results)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatAuthenticationResults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FormatAuthenticationResults() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatReceivedSPF(t *testing.T) {
+	tests := []struct {
+		name    string
+		spf     ReceivedSPF
+		want    string
+		wantErr bool
+	}{
+		{"result only", ReceivedSPF{Result: "none"}, "none", false},
+		// the examples of RFC 7208 section 9.1
+		{"RFC 7208 pass", ReceivedSPF{
+			Result:       "pass",
+			Comment:      "mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender",
+			Receiver:     "mybox.example.org",
+			ClientIP:     "192.0.2.1",
+			EnvelopeFrom: "myname@example.com",
+			Helo:         "foo.example.com",
+		}, `pass (mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender) receiver=mybox.example.org; client-ip=192.0.2.1; envelope-from="myname@example.com"; helo=foo.example.com`, false},
+		{"RFC 7208 fail", ReceivedSPF{
+			Result:       "Fail",
+			Comment:      "mybox.example.org: domain of myname@example.com does not designate 192.0.2.1 as permitted sender",
+			Identity:     "mailfrom",
+			ClientIP:     "192.0.2.1",
+			EnvelopeFrom: "myname@example.com",
+		}, `fail (mybox.example.org: domain of myname@example.com does not designate 192.0.2.1 as permitted sender) client-ip=192.0.2.1; envelope-from="myname@example.com"; identity=mailfrom`, false},
+		{"IPv6 and escaped comment", ReceivedSPF{Result: "softfail", Comment: "not (quite) allowed", ClientIP: "2001:db8::1"}, `softfail (not \(quite\) allowed) client-ip="2001:db8::1"`, false},
+		{"invalid result", ReceivedSPF{Result: "ok"}, "", true},
+		{"invalid comment", ReceivedSPF{Result: "pass", Comment: "a\nb"}, "", true},
+		{"invalid helo", ReceivedSPF{Result: "pass", Helo: "a\r\n"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FormatReceivedSPF(tt.spf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatReceivedSPF() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FormatReceivedSPF() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
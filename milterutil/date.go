@@ -0,0 +1,171 @@
+package milterutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var colonSpaces = regexp.MustCompile(`\s*:\s*`)
+
+var months = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+var weekdays = map[string]bool{
+	"mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true, "sun": true,
+}
+
+// obsZones are the named time zones of RFC 5322 section 4.3
+var obsZones = map[string]int{
+	"UT": 0, "GMT": 0,
+	"EST": -5 * 3600, "EDT": -4 * 3600,
+	"CST": -6 * 3600, "CDT": -5 * 3600,
+	"MST": -7 * 3600, "MDT": -6 * 3600,
+	"PST": -8 * 3600, "PDT": -7 * 3600,
+}
+
+// stripComments removes all (possibly nested) comments from value and replaces them with a space.
+// Quoted pairs inside comments are handled.
+func stripComments(value string) (string, error) {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && depth > 0:
+			i++
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return "", errors.New("unbalanced comment")
+			}
+			depth--
+			if depth == 0 {
+				b.WriteByte(' ')
+			}
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	if depth > 0 {
+		return "", errors.New("unterminated comment")
+	}
+	return b.String(), nil
+}
+
+func parseZone(zone string) (*time.Location, error) {
+	if len(zone) == 5 && (zone[0] == '+' || zone[0] == '-') {
+		hh, err1 := strconv.Atoi(zone[1:3])
+		mm, err2 := strconv.Atoi(zone[3:5])
+		if err1 != nil || err2 != nil || hh > 99 || mm > 59 {
+			return nil, fmt.Errorf("invalid zone %q", zone)
+		}
+		offset := hh*3600 + mm*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		return time.FixedZone("", offset), nil
+	}
+	zone = strings.ToUpper(zone)
+	if offset, ok := obsZones[zone]; ok {
+		return time.FixedZone(zone, offset), nil
+	}
+	// RFC 5322 says military zones should be considered equivalent to "-0000" since their meaning was given wrongly in RFC 822
+	if len(zone) == 1 && zone[0] >= 'A' && zone[0] <= 'Z' && zone[0] != 'J' {
+		return time.UTC, nil
+	}
+	return nil, fmt.Errorf("unknown zone %q", zone)
+}
+
+func parseTimeOfDay(value string) (hour, minute, second int, err error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		if len(p) != 2 {
+			return 0, 0, 0, fmt.Errorf("invalid time of day %q", value)
+		}
+		if nums[i], err = strconv.Atoi(p); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid time of day %q", value)
+		}
+	}
+	// allow a leap second
+	if nums[0] > 23 || nums[1] > 59 || nums[2] > 60 {
+		return 0, 0, 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return nums[0], nums[1], nums[2], nil
+}
+
+// ParseDate parses value as date-time of RFC 5322 (e.g. the value of the Date header field).
+//
+// The obsolete syntax of RFC 5322 section 4.3 is also accepted: comments, two- and three-digit years,
+// the named zones "UT", "GMT", "EST", "EDT", "CST", "CDT", "MST", "MDT", "PST", "PDT" (with their correct offset)
+// and military zones (that are treated as UTC). The day-of-week and the seconds are optional.
+// A day-of-week that does not match the date is not an error.
+//
+// When value cannot be parsed, the zero [time.Time] and an error describing the problem is returned.
+func ParseDate(value string) (time.Time, error) {
+	fail := func(err error) (time.Time, error) {
+		return time.Time{}, fmt.Errorf("milterutil: cannot parse date %q: %w", value, err)
+	}
+	clean, err := stripComments(value)
+	if err != nil {
+		return fail(err)
+	}
+	// obsolete syntax allows whitespace around the colons of the time of day and around the comma of the day-of-week
+	clean = colonSpaces.ReplaceAllString(strings.ReplaceAll(clean, ",", " , "), ":")
+	fields := strings.Fields(clean)
+	if len(fields) > 1 && fields[1] == "," {
+		if !weekdays[strings.ToLower(fields[0])] {
+			return fail(fmt.Errorf("invalid day-of-week %q", fields[0]))
+		}
+		fields = fields[2:]
+	}
+	if len(fields) != 5 {
+		return fail(errors.New("expected day, month, year, time and zone"))
+	}
+	day, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields[0]) > 2 || day < 1 {
+		return fail(fmt.Errorf("invalid day %q", fields[0]))
+	}
+	month, ok := months[strings.ToLower(fields[1])]
+	if !ok {
+		return fail(fmt.Errorf("invalid month %q", fields[1]))
+	}
+	year, err := strconv.Atoi(fields[2])
+	if err != nil || len(fields[2]) < 2 || year < 0 {
+		return fail(fmt.Errorf("invalid year %q", fields[2]))
+	}
+	switch len(fields[2]) {
+	case 2:
+		if year < 50 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	case 3:
+		year += 1900
+	}
+	hour, minute, second, err := parseTimeOfDay(fields[3])
+	if err != nil {
+		return fail(err)
+	}
+	loc, err := parseZone(fields[4])
+	if err != nil {
+		return fail(err)
+	}
+	t := time.Date(year, month, day, hour, minute, 0, 0, loc)
+	if t.Day() != day {
+		return fail(fmt.Errorf("invalid day %d for %s %d", day, month, year))
+	}
+	return t.Add(time.Duration(second) * time.Second), nil
+}
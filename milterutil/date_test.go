@@ -0,0 +1,72 @@
+package milterutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"numeric offset", "Wed, 01 Mar 2023 15:47:33 +0100", time.Date(2023, time.March, 1, 14, 47, 33, 0, time.UTC), false},
+		{"negative offset", "Wed, 01 Mar 2023 15:47:33 -0530", time.Date(2023, time.March, 1, 21, 17, 33, 0, time.UTC), false},
+		{"zero offset", "1 Mar 2023 15:47:33 -0000", time.Date(2023, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"no day-of-week no seconds", "01 Mar 2023 15:47 +0000", time.Date(2023, time.March, 1, 15, 47, 0, 0, time.UTC), false},
+		{"folded", "Wed,\r\n 01 Mar 2023\r\n\t15:47:33 +0100", time.Date(2023, time.March, 1, 14, 47, 33, 0, time.UTC), false},
+		{"comment", "Wed, 01 Mar 2023 15:47:33 +0100 (CET)", time.Date(2023, time.March, 1, 14, 47, 33, 0, time.UTC), false},
+		{"nested comment", "Wed, 01 Mar 2023 (a (b) \\) c) 15:47:33 +0100", time.Date(2023, time.March, 1, 14, 47, 33, 0, time.UTC), false},
+		{"GMT", "Wed, 01 Mar 2023 15:47:33 GMT", time.Date(2023, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"UT", "Wed, 01 Mar 2023 15:47:33 UT", time.Date(2023, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"EST", "Wed, 01 Mar 2023 15:47:33 EST", time.Date(2023, time.March, 1, 20, 47, 33, 0, time.UTC), false},
+		{"PDT", "Sat, 01 Jul 2023 15:47:33 PDT", time.Date(2023, time.July, 1, 22, 47, 33, 0, time.UTC), false},
+		{"lower case zone and month", "wed, 01 mar 2023 15:47:33 cst", time.Date(2023, time.March, 1, 21, 47, 33, 0, time.UTC), false},
+		{"military zone", "Wed, 01 Mar 2023 15:47:33 Z", time.Date(2023, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"two digit year 20xx", "01 Mar 23 15:47:33 +0000", time.Date(2023, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"two digit year 19xx", "01 Mar 99 15:47:33 +0000", time.Date(1999, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"three digit year", "01 Mar 103 15:47:33 +0000", time.Date(2003, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"space around colons", "01 Mar 2023 15 : 47 : 33 +0000", time.Date(2023, time.March, 1, 15, 47, 33, 0, time.UTC), false},
+		{"leap second", "31 Dec 2016 23:59:60 +0000", time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC), false},
+		{"empty", "", time.Time{}, true},
+		{"garbage", "yesterday", time.Time{}, true},
+		{"unknown zone", "Wed, 01 Mar 2023 15:47:33 CET", time.Time{}, true},
+		{"missing zone", "Wed, 01 Mar 2023 15:47:33", time.Time{}, true},
+		{"invalid month", "Wed, 01 Foo 2023 15:47:33 +0000", time.Time{}, true},
+		{"invalid day", "Wed, 30 Feb 2023 15:47:33 +0000", time.Time{}, true},
+		{"invalid day-of-week", "Foo, 01 Mar 2023 15:47:33 +0000", time.Time{}, true},
+		{"invalid time", "Wed, 01 Mar 2023 25:47:33 +0000", time.Time{}, true},
+		{"invalid offset", "Wed, 01 Mar 2023 15:47:33 +01", time.Time{}, true},
+		{"unterminated comment", "Wed, 01 Mar 2023 15:47:33 +0100 (CET", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDate(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseDate() got = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr && !got.IsZero() {
+				t.Errorf("ParseDate() got = %v, want zero time", got)
+			}
+		})
+	}
+}
+
+func TestParseDate_Zone(t *testing.T) {
+	got, err := ParseDate("Wed, 01 Mar 2023 15:47:33 EST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, offset := got.Zone()
+	if name != "EST" || offset != -5*3600 {
+		t.Errorf("Zone() = %q %d, want %q %d", name, offset, "EST", -5*3600)
+	}
+	if got.Hour() != 15 {
+		t.Errorf("Hour() = %d, want 15", got.Hour())
+	}
+}
@@ -0,0 +1,117 @@
+package milterutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// charsetEncoding returns the [encoding.Encoding] for the MIME charset name or nil when it is unknown.
+func charsetEncoding(charset string) encoding.Encoding {
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	switch charset {
+	case "", "us-ascii", "ascii", "utf-8", "utf8":
+		return unicode.UTF8
+	}
+	if enc, err := ianaindex.MIME.Encoding(charset); err == nil && enc != nil {
+		return enc
+	}
+	if enc, err := htmlindex.Get(charset); err == nil {
+		return enc
+	}
+	return nil
+}
+
+// DecodeText returns a reader that decodes r (the body of a MIME text part) according to contentTransferEncoding
+// (base64 and quoted-printable, everything else gets passed through) and converts it from charset to UTF-8.
+//
+// When charset is unknown the data gets treated as UTF-8. Invalid UTF-8 sequences get replaced with the
+// Unicode replacement character U+FFFD, so the returned reader always yields valid UTF-8.
+func DecodeText(r io.Reader, contentTransferEncoding, charset string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(contentTransferEncoding)) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	enc := charsetEncoding(charset)
+	if enc == nil {
+		enc = unicode.UTF8
+	}
+	// the UTF-8 decoder replaces invalid sequences, the output of the other decoder is always valid UTF-8
+	return transform.NewReader(r, enc.NewDecoder())
+}
+
+// PrimaryTextPart finds the primary text part of a message with the header field values contentType
+// and contentTransferEncoding and the body body. It returns the decoded text of this part (see [DecodeText])
+// or nil when the message has no text part.
+//
+// A message without Content-Type is text/plain. For multipart messages the first text/plain part
+// that is not an attachment gets used. When there is none, the first text/* part (e.g. text/html) gets used.
+// An error is only returned when the multipart structure cannot be parsed.
+func PrimaryTextPart(contentType, contentTransferEncoding string, body io.Reader) (io.Reader, error) {
+	r, _, err := primaryTextPart(contentType, contentTransferEncoding, body, 0)
+	return r, err
+}
+
+const maxMultipartDepth = 20
+
+func primaryTextPart(contentType, contentTransferEncoding string, body io.Reader, depth int) (r io.Reader, plain bool, err error) {
+	if strings.TrimSpace(contentType) == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// RFC 2045: default to text/plain for an invalid Content-Type
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return DecodeText(body, contentTransferEncoding, params["charset"]), mediaType == "text/plain", nil
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxMultipartDepth:
+		var fallback io.Reader
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return fallback, false, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			// multipart.Part already decodes quoted-printable and removes the Content-Transfer-Encoding header in that case
+			pr, plain, err := primaryTextPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err != nil {
+				return nil, false, err
+			}
+			if pr == nil {
+				continue
+			}
+			if plain {
+				return pr, true, nil
+			}
+			if fallback == nil {
+				// the part reader is only valid until the next NextPart call
+				b, err := io.ReadAll(pr)
+				if err != nil {
+					return nil, false, err
+				}
+				fallback = bytes.NewReader(b)
+			}
+		}
+	}
+	return nil, false, nil
+}
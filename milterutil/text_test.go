@@ -0,0 +1,107 @@
+package milterutil
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name                    string
+		input                   string
+		contentTransferEncoding string
+		charset                 string
+		want                    string
+	}{
+		{"plain", "Hello World", "7bit", "us-ascii", "Hello World"},
+		{"ISO-8859-1 quoted-printable", "Gr=FC=DFe aus K=F6ln=\r\n!", "quoted-printable", "ISO-8859-1", "Grüße aus Köln!"},
+		{"latin1 alias", "K=F6ln", "Quoted-Printable", "latin1", "Köln"},
+		{"UTF-8 base64", "R3LDvMOfZSBhdXMgS8O2bG4h\r\nIOKCrA==", "base64", "utf-8", "Grüße aus Köln! €"},
+		{"windows-1252", "\x80 5", "8bit", "windows-1252", "€ 5"},
+		{"quoted charset", "K\xf6ln", "8bit", `"iso-8859-15"`, "Köln"},
+		{"unknown charset", "Köln", "8bit", "x-unknown", "Köln"},
+		{"invalid UTF-8", "K\xf6ln", "8bit", "utf-8", "K�ln"},
+		{"unknown transfer encoding", "K=F6ln", "x-uuencode", "", "K=F6ln"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(DecodeText(strings.NewReader(tt.input), tt.contentTransferEncoding, tt.charset))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("DecodeText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrimaryTextPart(t *testing.T) {
+	alternative := "--b1\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n\r\n" +
+		"<p>HTML</p>\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		"Gr=FC=DFe\r\n" +
+		"--b1--\r\n"
+	mixed := "--b2\r\n" +
+		"Content-Type: multipart/alternative; boundary=b1\r\n\r\n" +
+		alternative +
+		"--b2\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=a.txt\r\n\r\n" +
+		"attachment\r\n" +
+		"--b2--\r\n"
+	htmlOnly := "--b3\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		"PHA+R3LDvMOfZTwvcD4=\r\n" +
+		"--b3\r\n" +
+		"Content-Type: application/pdf\r\n\r\n" +
+		"%PDF\r\n" +
+		"--b3--\r\n"
+	tests := []struct {
+		name                    string
+		contentType             string
+		contentTransferEncoding string
+		body                    string
+		want                    string
+		wantNil                 bool
+		wantErr                 bool
+	}{
+		{"no content type", "", "", "Hello", "Hello", false, false},
+		{"text", "text/plain; charset=iso-8859-1", "quoted-printable", "K=F6ln", "Köln", false, false},
+		{"base64 text", "text/plain; charset=utf-8", "base64", "S8O2bG4=", "Köln", false, false},
+		{"alternative", "multipart/alternative; boundary=b1", "", alternative, "Grüße", false, false},
+		{"nested", "multipart/mixed; boundary=\"b2\"", "", mixed, "Grüße", false, false},
+		{"html fallback", "multipart/mixed; boundary=b3", "", htmlOnly, "<p>Grüße</p>", false, false},
+		{"no text", "image/png", "base64", "iVBORw0KGgo=", "", true, false},
+		{"broken multipart", "multipart/mixed; boundary=b4", "", "garbage", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := PrimaryTextPart(tt.contentType, tt.contentTransferEncoding, strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrimaryTextPart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (r == nil) != tt.wantNil {
+				t.Fatalf("PrimaryTextPart() = %v, wantNil %v", r, tt.wantNil)
+			}
+			if r == nil {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimRight(string(got), "\r\n") != tt.want {
+				t.Errorf("PrimaryTextPart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
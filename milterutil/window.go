@@ -0,0 +1,100 @@
+package milterutil
+
+import (
+	"errors"
+	"io"
+)
+
+// WindowScanner reads an [io.Reader] (e.g. the Body of a mailfilter transaction) in windows of a fixed size
+// that overlap by a fixed number of bytes. When you search for patterns of at most overlap+1 bytes,
+// you do not miss matches that span the boundary of two windows.
+//
+//	s := milterutil.NewWindowScanner(trx.Body(), 64*1024, len(signature)-1)
+//	for s.Scan() {
+//		if bytes.Contains(s.Bytes(), signature) {
+//			// found
+//		}
+//	}
+//	if err := s.Err(); err != nil {
+//		// handle error
+//	}
+//
+// Matches that are completely inside the overlap get found twice.
+type WindowScanner struct {
+	r       io.Reader
+	buf     []byte
+	n       int
+	overlap int
+	offset  int64
+	started bool
+	eof     bool
+	done    bool
+	err     error
+}
+
+// NewWindowScanner returns a [WindowScanner] that reads r in windows of window bytes.
+// Consecutive windows share overlap bytes. NewWindowScanner panics when window is not positive
+// or overlap is not in the range 0 to window-1.
+func NewWindowScanner(r io.Reader, window, overlap int) *WindowScanner {
+	if window <= 0 {
+		panic("milterutil: window must be positive")
+	}
+	if overlap < 0 || overlap >= window {
+		panic("milterutil: overlap must be at least 0 and smaller than window")
+	}
+	return &WindowScanner{r: r, buf: make([]byte, window), overlap: overlap}
+}
+
+// Scan advances to the next window, which is then available through Bytes.
+// It returns false when there are no more windows or when an error occurred (see Err).
+// Only the last window can be shorter than the window size.
+func (s *WindowScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+	keep := 0
+	if s.started {
+		if s.eof {
+			s.done = true
+			return false
+		}
+		keep = s.overlap
+		copy(s.buf, s.buf[s.n-keep:s.n])
+		s.offset += int64(s.n - keep)
+	}
+	n, err := io.ReadFull(s.r, s.buf[keep:])
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.err = err
+			s.done = true
+			return false
+		}
+		s.eof = true
+		if n == 0 {
+			// only the overlap of the previous window (or an empty reader) is left
+			s.done = true
+			return false
+		}
+	}
+	s.n = keep + n
+	s.started = true
+	return true
+}
+
+// Bytes returns the current window. The returned slice is only valid until the next call to Scan.
+func (s *WindowScanner) Bytes() []byte {
+	if s.done {
+		return nil
+	}
+	return s.buf[:s.n]
+}
+
+// Offset returns the position of the first byte of the current window in the input.
+func (s *WindowScanner) Offset() int64 {
+	return s.offset
+}
+
+// Err returns the first non-EOF error that was encountered by the WindowScanner.
+func (s *WindowScanner) Err() error {
+	return s.err
+}
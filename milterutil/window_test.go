@@ -0,0 +1,118 @@
+package milterutil_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/d--j/go-milter/milterutil"
+)
+
+func TestWindowScanner(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		input       string
+		window      int
+		overlap     int
+		want        []string
+		wantOffsets []int64
+	}{
+		{"empty", "", 4, 1, nil, nil},
+		{"short", "abc", 4, 1, []string{"abc"}, []int64{0}},
+		{"exact", "abcd", 4, 1, []string{"abcd"}, []int64{0}},
+		{"no overlap", "abcdefghij", 4, 0, []string{"abcd", "efgh", "ij"}, []int64{0, 4, 8}},
+		{"overlap", "abcdefghij", 4, 2, []string{"abcd", "cdef", "efgh", "ghij"}, []int64{0, 2, 4, 6}},
+		{"overlap last short", "abcdefghijk", 4, 1, []string{"abcd", "defg", "ghij", "jk"}, []int64{0, 3, 6, 9}},
+		{"overlap only left", "abcdefg", 4, 1, []string{"abcd", "defg"}, []int64{0, 3}},
+	}
+	for _, tt_ := range tests {
+		t.Run(tt_.name, func(t *testing.T) {
+			tt := tt_
+			t.Parallel()
+			s := milterutil.NewWindowScanner(iotest.OneByteReader(strings.NewReader(tt.input)), tt.window, tt.overlap)
+			var got []string
+			var gotOffsets []int64
+			for s.Scan() {
+				got = append(got, string(s.Bytes()))
+				gotOffsets = append(gotOffsets, s.Offset())
+			}
+			if err := s.Err(); err != nil {
+				t.Fatalf("Err() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("windows = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(gotOffsets, tt.wantOffsets) {
+				t.Errorf("offsets = %v, want %v", gotOffsets, tt.wantOffsets)
+			}
+			if s.Scan() {
+				t.Errorf("Scan() = true after end")
+			}
+		})
+	}
+}
+
+func TestWindowScanner_SignatureOnBoundary(t *testing.T) {
+	t.Parallel()
+	signature := []byte("EICAR-TEST-SIGNATURE")
+	const window = 1024
+	// the signature starts 5 bytes before the end of the first window
+	body := append(bytes.Repeat([]byte("x"), window-5), signature...)
+	body = append(body, bytes.Repeat([]byte("y"), 3000)...)
+	find := func(overlap int) (found bool, offset int64) {
+		s := milterutil.NewWindowScanner(bytes.NewReader(body), window, overlap)
+		for s.Scan() {
+			if i := bytes.Index(s.Bytes(), signature); i >= 0 {
+				return true, s.Offset() + int64(i)
+			}
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return false, 0
+	}
+	if found, _ := find(0); found {
+		t.Fatal("found signature without overlap, the test body is wrong")
+	}
+	found, offset := find(len(signature) - 1)
+	if !found {
+		t.Fatal("signature on window boundary not found")
+	}
+	if offset != window-5 {
+		t.Errorf("signature found at %d, want %d", offset, window-5)
+	}
+}
+
+func TestWindowScanner_Err(t *testing.T) {
+	t.Parallel()
+	errTest := errors.New("test")
+	s := milterutil.NewWindowScanner(io.MultiReader(strings.NewReader("abcdef"), iotest.ErrReader(errTest)), 4, 1)
+	if !s.Scan() || string(s.Bytes()) != "abcd" {
+		t.Fatalf("first Scan() did not return first window")
+	}
+	if s.Scan() {
+		t.Fatalf("Scan() = true, want false")
+	}
+	if !errors.Is(s.Err(), errTest) {
+		t.Errorf("Err() = %v, want %v", s.Err(), errTest)
+	}
+}
+
+func TestNewWindowScanner_Panics(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][2]int{{0, 0}, {4, 4}, {4, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewWindowScanner(%d, %d) did not panic", args[0], args[1])
+				}
+			}()
+			milterutil.NewWindowScanner(strings.NewReader(""), args[0], args[1])
+		}()
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/textproto"
+	"strings"
 
 	"github.com/d--j/go-milter/internal/wire"
 	"github.com/d--j/go-milter/milterutil"
@@ -58,20 +60,20 @@ func parseAction(msg *wire.Message) (*Action, error) {
 		act.Type = ActionSkip
 	case wire.ActReplyCode:
 		if len(msg.Data) <= 4 {
-			return nil, fmt.Errorf("action read: unexpected data length: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "action read: unexpected data length: %d", len(msg.Data))
 		}
 		checker := textproto.NewReader(bufio.NewReader(bytes.NewReader(msg.Data)))
 		// this also accepts FTP style multi-line responses as valid
 		// It's highly unlikely that milter sends one of those, so we ignore this false positive
 		code, _, err := checker.ReadResponse(0)
 		if err != nil {
-			return nil, fmt.Errorf("action read: malformed SMTP response: %q", msg.Data)
+			return nil, errorf(ErrProtocol, "action read: malformed SMTP response: %q", msg.Data)
 		}
 		act.Type = ActionRejectWithCode
 		act.SMTPCode = uint16(code)
 		act.SMTPReply = wire.ReadCString(msg.Data) // use raw response as it was formatted by milter
 	default:
-		return nil, fmt.Errorf("action read: unexpected code: %c", msg.Code)
+		return nil, errorf(ErrProtocol, "action read: unexpected code: %c", msg.Code)
 	}
 
 	return act, nil
@@ -145,14 +147,14 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 	case wire.ActAddRcpt:
 		argv := bytes.Split(msg.Data, []byte{0x00})
 		if len(argv) != 2 {
-			return nil, fmt.Errorf("read modify action: wrong number of arguments %d for ActAddRcpt action", len(argv))
+			return nil, errorf(ErrProtocol, "read modify action: wrong number of arguments %d for ActAddRcpt action", len(argv))
 		}
 		act.Type = ActionAddRcpt
 		act.Rcpt = string(argv[0])
 	case wire.ActAddRcptPar:
 		argv := bytes.Split(msg.Data, []byte{0x00})
 		if len(argv) > 3 || len(argv) < 2 {
-			return nil, fmt.Errorf("read modify action: wrong number of arguments %d for ActAddRcpt action", len(argv))
+			return nil, errorf(ErrProtocol, "read modify action: wrong number of arguments %d for ActAddRcpt action", len(argv))
 		}
 		act.Type = ActionAddRcpt
 		act.Rcpt = string(argv[0])
@@ -171,7 +173,7 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 	case wire.ActChangeFrom:
 		argv := bytes.Split(msg.Data, []byte{0x00})
 		if len(argv) > 3 || len(argv) < 2 {
-			return nil, fmt.Errorf("read modify action: wrong number of arguments %d for ActChangeFrom action", len(argv))
+			return nil, errorf(ErrProtocol, "read modify action: wrong number of arguments %d for ActChangeFrom action", len(argv))
 		}
 		act.Type = ActionChangeFrom
 		act.From = string(argv[0])
@@ -180,7 +182,7 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 		}
 	case wire.ActChangeHeader, wire.ActInsertHeader:
 		if len(msg.Data) < 4 {
-			return nil, fmt.Errorf("read modify action: missing header index")
+			return nil, errorf(ErrProtocol, "read modify action: missing header index")
 		}
 		if wire.ModifyActCode(msg.Code) == wire.ActChangeHeader {
 			act.Type = ActionChangeHeader
@@ -199,7 +201,7 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 	case wire.ActAddHeader:
 		argv := bytes.Split(msg.Data, []byte{0x00})
 		if len(argv) != 3 {
-			return nil, fmt.Errorf("read modify action: wrong number of arguments %d for header action: %v", len(argv), argv)
+			return nil, errorf(ErrProtocol, "read modify action: wrong number of arguments %d for header action: %v", len(argv), argv)
 		}
 		if wire.ModifyActCode(msg.Code) == wire.ActAddHeader {
 			act.Type = ActionAddHeader
@@ -207,7 +209,7 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 		act.HeaderName = string(argv[0])
 		act.HeaderValue = string(argv[1])
 	default:
-		return nil, fmt.Errorf("read modify action: unexpected message code: %v", msg.Code)
+		return nil, errorf(ErrProtocol, "read modify action: unexpected message code: %v", msg.Code)
 	}
 
 	return act, nil
@@ -222,6 +224,167 @@ type Modifier struct {
 	writePacket         func(*wire.Message) error
 	actions             OptAction
 	maxDataSize         DataSize
+	sender              string
+	helo                string
+	heloAnnotation      *string
+	headerSize          int64
+	bodySize            int64
+	headerCount         map[string]int
+	messageID           string
+	negotiation         *NegotiationResult
+	localAddr           net.Addr
+	listenerLabel       string
+	done                <-chan struct{}
+	modificationCount   [ActionInsertHeader + 1]int
+	maxAddedRecipients  int
+	maxAddedHeaders     int
+	replaceChunkSize    int // see WithReplaceBodyChunkSize, 0 means maxDataSize
+	rcptTos             []string
+	checkRcptTos        bool
+}
+
+// Sender returns the envelope sender (without angle brackets) of the current message.
+// It is available in all callbacks after [Milter.MailFrom] was called (including MailFrom itself).
+// Before the MAIL FROM command, after an abort and after the message got accepted or rejected this returns the empty string.
+//
+// The null sender <> of bounce messages is also returned as the empty string.
+func (m *Modifier) Sender() string {
+	return m.sender
+}
+
+// EnvelopeSender returns [Modifier.Sender] normalized to lowercase.
+// Use it to compare the envelope sender with other addresses (e.g. in the Header or EndOfMessage callback).
+// Use [Modifier.Sender] when you need the address exactly as the client sent it.
+func (m *Modifier) EnvelopeSender() string {
+	return strings.ToLower(m.sender)
+}
+
+// Helo returns the HELO/EHLO hostname that the MTA sent for the current SMTP session.
+// It is available in all callbacks after [Milter.Helo] was called (including Helo itself).
+// It returns the empty string when the MTA did not send the HELO hostname (e.g. because of [OptNoHelo]).
+func (m *Modifier) Helo() string {
+	return m.helo
+}
+
+// AnnotateHelo attaches note to the HELO/EHLO hostname of the current SMTP session.
+// The library itself does not use note. It is meant for your logging: a filter can e.g. note
+// in the Helo callback that the hostname looked forged and a later callback can log it together with its own decision.
+// Later callbacks get the note with [Modifier.HeloAnnotation]. A new HELO/EHLO resets the note.
+//
+// Unlike the message modification functions you can call AnnotateHelo in every callback.
+func (m *Modifier) AnnotateHelo(note string) {
+	if m.heloAnnotation != nil {
+		*m.heloAnnotation = note
+	}
+}
+
+// HeloAnnotation returns the note that a filter attached with [Modifier.AnnotateHelo] in the current SMTP session.
+func (m *Modifier) HeloAnnotation() string {
+	if m.heloAnnotation == nil {
+		return ""
+	}
+	return *m.heloAnnotation
+}
+
+// MessageID returns the value of the Message-ID header field of the current message without the angle brackets.
+// When the message has multiple Message-ID header fields the first one is returned.
+// It returns the empty string when the message does not have a Message-ID header field or the MTA did not send it yet
+// (use it in the EndOfMessage or Headers callback).
+func (m *Modifier) MessageID() string {
+	return m.messageID
+}
+
+// Negotiation returns the result of the protocol negotiation of this connection.
+// You can use it to e.g. log why a modification failed with [ErrModificationNotAllowed].
+// The returned value must not be modified.
+// It returns nil when the [Modifier] was not created by a [Server].
+func (m *Modifier) Negotiation() *NegotiationResult {
+	return m.negotiation
+}
+
+// LocalAddr returns the network address of the [Server] side of the connection (see [Session.LocalAddr]).
+// You can use it to apply different policies depending on which listener accepted the connection.
+// It returns nil when the [Modifier] was not created by a [Server].
+func (m *Modifier) LocalAddr() net.Addr {
+	return m.localAddr
+}
+
+// ListenerLabel returns the label of the listener that accepted the connection (see [Server.ServeAll]).
+// It returns the empty string when the connection was not accepted by a listener of [Server.ServeAll].
+func (m *Modifier) ListenerLabel() string {
+	return m.listenerLabel
+}
+
+// Done returns a channel that gets closed when the connection to the MTA ended (see [Session.Done]).
+// It returns nil when the [Modifier] was not created by a [Server].
+func (m *Modifier) Done() <-chan struct{} {
+	return m.done
+}
+
+// HeaderSize returns the number of bytes of all header fields that the MTA sent for the current message so far.
+// Every header field is counted as it would appear in the SMTP message ("Name: value" and the trailing CR LF).
+// The empty line that separates the header from the body is not part of this count.
+//
+// The value is only complete in the EndOfMessage callback (or the Headers callback when you do not need the body).
+// If you negotiated [OptNoHeaders] or responded with [RespSkip] this value does not include the header fields the MTA did not send.
+// [WithMaxMessageHeaderSize] lets the [Server] enforce a limit on this value.
+func (m *Modifier) HeaderSize() int64 {
+	return m.headerSize
+}
+
+// BodySize returns the number of body bytes that the MTA sent for the current message so far.
+//
+// The value is only complete in the EndOfMessage callback.
+// If you negotiated [OptNoBody] or responded with [RespSkip] this value does not include the body chunks the MTA did not send.
+func (m *Modifier) BodySize() int64 {
+	return m.bodySize
+}
+
+// MessageSize returns the size of the current message (header, separator line and body) in bytes.
+// See [Modifier.HeaderSize] and [Modifier.BodySize] for caveats.
+func (m *Modifier) MessageSize() int64 {
+	if m.headerSize == 0 && m.bodySize == 0 {
+		return 0
+	}
+	return m.headerSize + 2 + m.bodySize
+}
+
+// ModificationCount returns how many modification actions of type t this [Modifier] sent to the MTA.
+// Only successfully sent modifications get counted. Every chunk of [Modifier.ReplaceBody] counts as one [ActionReplaceBody].
+//
+// Modifications can only be sent in the EndOfMessage callback, so the counts are per message.
+func (m *Modifier) ModificationCount(t ModifyActionType) int {
+	if t < ActionAddRcpt || t > ActionInsertHeader {
+		return 0
+	}
+	return m.modificationCount[t]
+}
+
+// write sends the modification action msg of type t to the MTA and counts it.
+func (m *Modifier) write(t ModifyActionType, msg *wire.Message) error {
+	if err := m.writePacket(msg); err != nil {
+		return err
+	}
+	m.modificationCount[t]++
+	return nil
+}
+
+// hasRcptTo returns true when r is one of the accepted recipients of the current message.
+func (m *Modifier) hasRcptTo(r string) bool {
+	for _, rcptTo := range m.rcptTos {
+		if strings.EqualFold(rcptTo, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddedHeaders returns an error when m cannot add another header field because of [WithMaxAddedHeaders].
+func (m *Modifier) checkAddedHeaders() error {
+	if m.maxAddedHeaders > 0 && m.modificationCount[ActionAddHeader]+m.modificationCount[ActionInsertHeader] >= m.maxAddedHeaders {
+		return fmt.Errorf("%w: cannot add more than %d header fields", ErrModificationLimitExceeded, m.maxAddedHeaders)
+	}
+	return nil
 }
 
 func hasAngle(str string) bool {
@@ -248,6 +411,16 @@ func RemoveAngle(str string) string {
 
 var ErrModificationNotAllowed = errors.New("milter: modification not allowed via milter protocol negotiation")
 
+// ErrModificationLimitExceeded gets wrapped in the error that modification methods of [Modifier] return
+// when a limit set with [WithMaxAddedRecipients] or [WithMaxAddedHeaders] would be exceeded.
+var ErrModificationLimitExceeded = errors.New("milter: modification limit exceeded")
+
+// ErrRecipientNotFound is returned by [Modifier.DeleteRecipient] when the address is not one of the accepted recipients of the current message.
+var ErrRecipientNotFound = errors.New("milter: recipient not found")
+
+// ErrHeaderIndexOutOfRange is returned by [Modifier.ChangeHeaderN] when the MTA did not send enough header fields with the requested name.
+var ErrHeaderIndexOutOfRange = errors.New("milter: header index out of range")
+
 // AddRecipient appends a new envelope recipient for current message.
 // You can optionally specify esmtpArgs to pass along. You need to negotiate this via [OptAddRcptWithArgs] with the MTA.
 //
@@ -259,6 +432,9 @@ func (m *Modifier) AddRecipient(r string, esmtpArgs string) error {
 	if esmtpArgs != "" && m.actions&OptAddRcptWithArgs == 0 {
 		return ErrModificationNotAllowed
 	}
+	if m.maxAddedRecipients > 0 && m.modificationCount[ActionAddRcpt] >= m.maxAddedRecipients {
+		return fmt.Errorf("%w: cannot add more than %d recipients", ErrModificationLimitExceeded, m.maxAddedRecipients)
+	}
 	code := wire.ActAddRcpt
 	var buffer bytes.Buffer
 	buffer.WriteString(AddAngle(r))
@@ -269,19 +445,27 @@ func (m *Modifier) AddRecipient(r string, esmtpArgs string) error {
 		buffer.WriteByte(0)
 		code = wire.ActAddRcptPar
 	}
-	return m.writePacket(newResponse(wire.Code(code), buffer.Bytes()).Response())
+	return m.write(ActionAddRcpt, newResponse(wire.Code(code), buffer.Bytes()).Response())
 }
 
-// DeleteRecipient removes an envelope recipient address from message
+// DeleteRecipient removes an envelope recipient address from message.
+//
+// r needs to match (case-insensitive) one of the RCPT TO addresses that your [Milter] did not reject.
+// Otherwise, ErrRecipientNotFound gets returned and nothing is sent to the MTA.
+// This check is skipped when you negotiated [OptNoRcptTo] or your [Milter] responded with [RespSkip] or [RespAccept]
+// to a RCPT TO since this library then does not know (all) the recipients of the message.
 func (m *Modifier) DeleteRecipient(r string) error {
 	if m.actions&OptRemoveRcpt == 0 {
 		return ErrModificationNotAllowed
 	}
+	if m.checkRcptTos && !m.hasRcptTo(RemoveAngle(r)) {
+		return ErrRecipientNotFound
+	}
 	resp, err := newResponseStr(wire.Code(wire.ActDelRcpt), AddAngle(r))
 	if err != nil {
 		return err
 	}
-	return m.writePacket(resp.Response())
+	return m.write(ActionDelRcpt, resp.Response())
 }
 
 // ReplaceBodyRawChunk sends one chunk of the body replacement.
@@ -297,7 +481,7 @@ func (m *Modifier) ReplaceBodyRawChunk(chunk []byte) error {
 	if len(chunk) > int(m.maxDataSize) {
 		return fmt.Errorf("milter: body chunk too large: %d > %d", len(chunk), m.maxDataSize)
 	}
-	return m.writePacket(newResponse(wire.Code(wire.ActReplBody), chunk).Response())
+	return m.write(ActionReplaceBody, newResponse(wire.Code(wire.ActReplBody), chunk).Response())
 }
 
 // ReplaceBody reads from r and send its contents in the least amount of chunks to the MTA.
@@ -310,13 +494,18 @@ func (m *Modifier) ReplaceBodyRawChunk(chunk []byte) error {
 //	m.ReplaceBody(wrappedR)
 //
 // This function tries to use as few calls to [Modifier.ReplaceBodyRawChunk] as possible.
+// Use [WithReplaceBodyChunkSize] to send smaller chunks.
 //
 // You can call ReplaceBody multiple times. The MTA will combine all those calls into one message.
 //
 // You should do the ReplaceBody calls all in one go without intersecting it with other modification actions.
 // MTAs like Postfix do not allow that.
 func (m *Modifier) ReplaceBody(r io.Reader) error {
-	scanner := milterutil.GetFixedBufferScanner(uint32(m.maxDataSize), r)
+	chunkSize := uint32(m.maxDataSize)
+	if m.replaceChunkSize > 0 && m.replaceChunkSize < int(chunkSize) {
+		chunkSize = uint32(m.replaceChunkSize)
+	}
+	scanner := milterutil.GetFixedBufferScanner(chunkSize, r)
 	defer scanner.Close()
 	for scanner.Scan() {
 		err := m.ReplaceBodyRawChunk(scanner.Bytes())
@@ -332,7 +521,7 @@ func (m *Modifier) Quarantine(reason string) error {
 	if m.actions&OptQuarantine == 0 {
 		return ErrModificationNotAllowed
 	}
-	return m.writePacket(newResponse(wire.Code(wire.ActQuarantine), []byte(reason+"\x00")).Response())
+	return m.write(ActionQuarantine, newResponse(wire.Code(wire.ActQuarantine), []byte(reason+"\x00")).Response())
 }
 
 // AddHeader appends a new email message header to the message
@@ -348,12 +537,15 @@ func (m *Modifier) AddHeader(name, value string) error {
 	if m.actions&OptAddHeader == 0 {
 		return ErrModificationNotAllowed
 	}
+	if err := m.checkAddedHeaders(); err != nil {
+		return err
+	}
 	var buffer bytes.Buffer
 	buffer.WriteString(name)
 	buffer.WriteByte(0)
 	buffer.WriteString(milterutil.CrLfToLf(value))
 	buffer.WriteByte(0)
-	return m.writePacket(newResponse(wire.Code(wire.ActAddHeader), buffer.Bytes()).Response())
+	return m.write(ActionAddHeader, newResponse(wire.Code(wire.ActAddHeader), buffer.Bytes()).Response())
 }
 
 // ChangeHeader replaces the header at the specified position with a new one.
@@ -372,7 +564,23 @@ func (m *Modifier) ChangeHeader(index int, name, value string) error {
 	buffer.WriteByte(0)
 	buffer.WriteString(milterutil.CrLfToLf(value))
 	buffer.WriteByte(0)
-	return m.writePacket(newResponse(wire.Code(wire.ActChangeHeader), buffer.Bytes()).Response())
+	return m.write(ActionChangeHeader, newResponse(wire.Code(wire.ActChangeHeader), buffer.Bytes()).Response())
+}
+
+// ChangeHeaderN replaces the value of the nth (one-based) occurrence of the header field name.
+// To delete this header field pass an empty value.
+//
+// Other than [Modifier.ChangeHeader] this method checks n against the header fields the MTA sent for the current message.
+// If there are less than n header fields with the (canonical) name name, ErrHeaderIndexOutOfRange gets returned and nothing is sent to the MTA.
+// You cannot use this method when you negotiated [OptNoHeaders] since this library then does not know the header fields of the message.
+func (m *Modifier) ChangeHeaderN(name string, n int, value string) error {
+	if m.actions&OptChangeHeader == 0 {
+		return ErrModificationNotAllowed
+	}
+	if n < 1 || n > m.headerCount[textproto.CanonicalMIMEHeaderKey(name)] {
+		return ErrHeaderIndexOutOfRange
+	}
+	return m.ChangeHeader(n, name, value)
 }
 
 // InsertHeader inserts the header at the specified position.
@@ -386,6 +594,9 @@ func (m *Modifier) InsertHeader(index int, name, value string) error {
 	if m.actions&OptChangeHeader == 0 && m.actions&OptAddHeader == 0 {
 		return ErrModificationNotAllowed
 	}
+	if err := m.checkAddedHeaders(); err != nil {
+		return err
+	}
 	var buffer bytes.Buffer
 	if err := binary.Write(&buffer, binary.BigEndian, uint32(index)); err != nil {
 		return err
@@ -394,7 +605,7 @@ func (m *Modifier) InsertHeader(index int, name, value string) error {
 	buffer.WriteByte(0)
 	buffer.WriteString(milterutil.CrLfToLf(value))
 	buffer.WriteByte(0)
-	return m.writePacket(newResponse(wire.Code(wire.ActInsertHeader), buffer.Bytes()).Response())
+	return m.write(ActionInsertHeader, newResponse(wire.Code(wire.ActInsertHeader), buffer.Bytes()).Response())
 }
 
 // ChangeFrom replaces the FROM envelope header with value.
@@ -417,12 +628,18 @@ func (m *Modifier) ChangeFrom(value string, esmtpArgs string) error {
 		buffer.WriteString(esmtpArgs)
 		buffer.WriteByte(0)
 	}
-	return m.writePacket(newResponse(wire.Code(wire.ActChangeFrom), buffer.Bytes()).Response())
+	return m.write(ActionChangeFrom, newResponse(wire.Code(wire.ActChangeFrom), buffer.Bytes()).Response())
 }
 
 var respProgress = &Response{code: wire.Code(wire.ActProgress)}
 
-// Progress tells the client that there is progress in a long operation
+// Progress sends a SMFIR_PROGRESS packet to the MTA. This tells the MTA that there is progress in a long operation
+// and resets the timeout the MTA waits for the response of the current command.
+// Call it regularly in a slow EndOfMessage callback (e.g. during a virus scan).
+// The packet only consists of the response code, it does not end the current command.
+//
+// SMFIR_PROGRESS is a feature of milter protocol version 6. Do not call Progress when the MTA negotiated an older version.
+// Unlike the other modification methods, Progress can be called in every callback.
 func (m *Modifier) Progress() error {
 	return m.writeProgressPacket(respProgress.Response())
 }
@@ -431,11 +648,41 @@ func errorWriteReadOnly(m *wire.Message) error {
 	return fmt.Errorf("tried to send action %c in read-only state", m.Code)
 }
 
+// writeDryRun logs the modification action msg with [LogDryRun] instead of sending it to the MTA.
+func writeDryRun(msg *wire.Message) error {
+	act, err := parseModifyAct(msg)
+	if err != nil {
+		return err
+	}
+	LogDryRun(act)
+	return nil
+}
+
 // newModifier creates a new [Modifier] instance from s. If it is readOnly then all modification actions will throw an error.
+// If s is in dry-run mode all modification actions get logged instead of sent to the MTA.
 func newModifier(s *serverSession, readOnly bool) *Modifier {
 	writePacket := s.writePacket
 	if readOnly {
 		writePacket = errorWriteReadOnly
+	} else if s.server != nil && s.server.options.dryRun {
+		writePacket = writeDryRun
+	} else if s.server != nil && s.server.options.eventHook != nil {
+		writePacket = func(msg *wire.Message) error {
+			if err := s.writePacket(msg); err != nil {
+				return err
+			}
+			s.emitModification(msg)
+			return nil
+		}
+	}
+	var localAddr net.Addr
+	if s.conn != nil {
+		localAddr = s.conn.LocalAddr()
+	}
+	var maxAddedRecipients, maxAddedHeaders, replaceChunkSize int
+	if s.server != nil {
+		maxAddedRecipients, maxAddedHeaders = s.server.options.maxAddedRecipients, s.server.options.maxAddedHeaders
+		replaceChunkSize = s.server.options.replaceBodyChunkSize
 	}
 	return &Modifier{
 		Macros:              &macroReader{macrosStages: s.macros},
@@ -443,6 +690,22 @@ func newModifier(s *serverSession, readOnly bool) *Modifier {
 		writeProgressPacket: s.writePacket,
 		actions:             s.actions,
 		maxDataSize:         s.maxDataSize,
+		sender:              s.sender,
+		helo:                s.helo,
+		heloAnnotation:      &s.heloAnnotation,
+		headerSize:          s.headerSize,
+		bodySize:            s.bodySize,
+		headerCount:         s.headerCount,
+		messageID:           s.messageID,
+		negotiation:         s.negotiation,
+		localAddr:           localAddr,
+		listenerLabel:       s.listenerLabel,
+		done:                s.done,
+		maxAddedRecipients:  maxAddedRecipients,
+		maxAddedHeaders:     maxAddedHeaders,
+		replaceChunkSize:    replaceChunkSize,
+		rcptTos:             s.rcptTos,
+		checkRcptTos:        s.protocol&OptNoRcptTo == 0 && !s.rcptTosIncomplete,
 	}
 }
 
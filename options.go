@@ -1,6 +1,7 @@
 package milter
 
 import (
+	"net"
 	"time"
 )
 
@@ -18,10 +19,36 @@ type options struct {
 	protocol                    OptProtocol
 	dialer                      Dialer
 	readTimeout, writeTimeout   time.Duration
+	shutdownTimeout             time.Duration
+	readDelay, writeDelay       time.Duration
 	offeredMaxData, usedMaxData DataSize
 	macrosByStage               macroRequests
 	newMilter                   NewMilterFunc
 	negotiationCallback         NegotiationCallbackFunc
+	negotiationHook             func(actions OptAction, protocol OptProtocol) (OptAction, OptProtocol)
+	onNegotiationDowngrade      func(missingActions OptAction, missingProtocol OptProtocol) error
+	dryRun                      bool
+	onConnectionOpen            func(conn net.Conn)
+	onConnectionClose           func(conn net.Conn, err error)
+	connectionLogger            func(event ConnectionEvent)
+	eventHook                   func(event Event)
+	eventQueueSize              int
+	packetLogger                PacketLogger
+	mtaFlavor                   MTAFlavor
+	errorHandler                func(err error, session Session)
+	listenBacklog               int
+	readBufferSize              int
+	writeBufferSize             int
+	maxAddedRecipients          int
+	maxAddedHeaders             int
+	maxConnectionMessageBytes   int64
+	maxTransactions             int
+	maxHeaderValueBytes         int
+	maxMessageHeaderSize        int64
+	headerValueTooLongResp      *Response
+	unknownCommandResp          *Response
+	timingHeader                string
+	replaceBodyChunkSize        int
 }
 
 // Option can be used to configure [Client] and [Server].
@@ -101,14 +128,47 @@ func WithReadTimeout(timeout time.Duration) Option {
 	}
 }
 
-// WithWriteTimeout sets the write-timeout for all read operations of this [Client] or [Server].
+// WithWriteTimeout sets the write-timeout for all write operations of this [Client] or [Server].
 // The default is a write-timeout of 10 seconds.
+//
+// The timeout applies to every single packet, so a [Modifier.ReplaceBody] with many chunks can take longer in total.
+// When a write of the [Server] times out the connection ends with an error that wraps [ErrWriteTimeout]
+// (after [Milter.Cleanup] got called). The [Modifier] method that did the write also returns this error.
 func WithWriteTimeout(timeout time.Duration) Option {
 	return func(h *options) {
 		h.writeTimeout = timeout
 	}
 }
 
+// WithShutdownTimeout sets the time [Server.ServeContext] waits for the active sessions to end after its context is done.
+// When the sessions did not end in time, ServeContext closes their connections.
+// A timeout of zero or less closes the connections of the active sessions right away.
+// The default is a shutdown-timeout of 10 seconds.
+//
+// This is a [Server] only [Option].
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(h *options) {
+		if timeout < 0 {
+			timeout = 0
+		}
+		h.shutdownTimeout = timeout
+	}
+}
+
+// WithTrafficShaper simulates a slow network between the MTA and the [Server]:
+// the [Server] sleeps readDelay before it reads a packet and writeDelay before it writes a packet.
+// Use it to test how your milter and MTA behave with slow connections (e.g. together with [WithReadTimeout]).
+//
+// Do not use this in production! It slows down every single packet of every connection.
+//
+// This is a [Server] only [Option].
+func WithTrafficShaper(readDelay, writeDelay time.Duration) Option {
+	return func(h *options) {
+		h.readDelay = readDelay
+		h.writeDelay = writeDelay
+	}
+}
+
 // WithOfferedMaxData sets the [DataSize] that your MTA wants to offer to milters.
 // The milter needs to accept this offer in protocol negotiation for it to become effective.
 // This is just an indication to the milter that it can send bigger packages.
@@ -159,6 +219,20 @@ func WithMacroRequest(stage MacroStage, macros []MacroName) Option {
 	}
 }
 
+// WithMTAFlavor makes the [Client] negotiate like the MTA flavor: it sets the milter protocol version,
+// the offered actions and protocol options, the offered data size and the macros that the [Client] sends by default
+// (see [MTAFlavorPostfix] and [MTAFlavorSendmail]). Use it to test milters that behave differently depending on the MTA.
+//
+// WithMTAFlavor overwrites the values of [WithMaximumVersion], [WithActions], [WithProtocols], [WithOfferedMaxData],
+// [WithUsedMaxData] and [WithMacroRequest] that come before it. Options that come after it change the profile.
+//
+// This is a [Client] only [Option].
+func WithMTAFlavor(flavor MTAFlavor) Option {
+	return func(h *options) {
+		flavor.apply(h)
+	}
+}
+
 // WithMilter sets the [Milter] backend this [Server] uses.
 //
 // This is a [Server] only [Option].
@@ -181,6 +255,307 @@ func WithDynamicMilter(newMilter NewMilterFunc) Option {
 	}
 }
 
+// WithDryRun enables or disables the dry-run mode of the [Server].
+// In dry-run mode all message modifications of your [Milter] (add/change/delete header, change body etc.) are not sent to the MTA.
+// They get logged with [LogDryRun] instead. The accept/reject decisions (and progress notifications) still get sent to the MTA.
+// You can use this to safely observe what a new [Milter] would do to your production mail traffic.
+//
+// This is a [Server] only [Option].
+func WithDryRun(dryRun bool) Option {
+	return func(h *options) {
+		h.dryRun = dryRun
+	}
+}
+
+// WithOnConnectionOpen sets a function that the [Server] calls for every new connection of an MTA.
+// It gets called before any milter data is read from conn – so before protocol negotiation and before [Milter.Connect].
+// You can use it for accounting or to set up per-connection resources.
+//
+// The function gets called in the goroutine of the connection, it should not block for long.
+//
+// This is a [Server] only [Option].
+func WithOnConnectionOpen(onConnectionOpen func(conn net.Conn)) Option {
+	return func(h *options) {
+		h.onConnectionOpen = onConnectionOpen
+	}
+}
+
+// WithOnConnectionClose sets a function that the [Server] calls once the connection of an MTA ended.
+// It gets called after the last [Milter.Cleanup] call and after conn got closed.
+// err is the error that ended the connection or nil when the MTA closed the connection normally (or the [Milter] requested the close).
+//
+// For every call of the [WithOnConnectionOpen] function there is exactly one call of this function.
+//
+// This is a [Server] only [Option].
+func WithOnConnectionClose(onConnectionClose func(conn net.Conn, err error)) Option {
+	return func(h *options) {
+		h.onConnectionClose = onConnectionClose
+	}
+}
+
+// WithConnectionLogger sets a function that the [Server] calls for every lifecycle event of an MTA connection:
+// when the connection got opened, when the MTA re-uses it for another SMTP session and when it got closed.
+// See [ConnectionEvent] for the details that fn gets. [LoggingConnectionLogger] logs the events with [log/slog].
+//
+// fn gets called in the goroutine of the connection, it should not block for long.
+//
+// This is a [Server] only [Option].
+func WithConnectionLogger(fn func(event ConnectionEvent)) Option {
+	return func(h *options) {
+		h.connectionLogger = fn
+	}
+}
+
+// WithEventHook sets a function that gets every [Event] of the [Server]: accepted connections, completed
+// [Milter] callbacks with their duration and decision, modifications sent to the MTA and ended connections.
+// Use it to feed your own metrics, logs or traces.
+//
+// The events of a connection get queued and fn gets called in a separate goroutine per connection
+// (in the order the events happened). When fn is too slow and the queue is full, events get dropped
+// so fn never slows down the milter connection. See [WithEventQueueSize] to change this.
+//
+// This is a [Server] only [Option].
+func WithEventHook(fn func(event Event)) Option {
+	return func(h *options) {
+		h.eventHook = fn
+	}
+}
+
+// WithEventQueueSize sets the number of events of one connection that get queued for the function of [WithEventHook].
+// The default is 128. 0 disables the queue: fn gets called directly in the goroutine of the connection,
+// it never drops events but the connection waits for fn.
+//
+// This is a [Server] only [Option].
+func WithEventQueueSize(n int) Option {
+	return func(h *options) {
+		if n < 0 {
+			n = 0
+		}
+		h.eventQueueSize = n
+	}
+}
+
+// WithPacketLogger sets a [PacketLogger] that gets every milter packet that the [Server] receives from or sends to the MTA.
+// Use it to debug the milter protocol, e.g. with [HexPacketLogger] or [JSONPacketLogger].
+// A sent packet gets logged after it was written successfully, a packet whose write failed (e.g. because of
+// [WithWriteTimeout]) does not get logged.
+// Logging every packet is slow and the packets include the whole messages, you should not use this in production.
+//
+// This is a [Server] only [Option].
+func WithPacketLogger(logger PacketLogger) Option {
+	return func(h *options) {
+		h.packetLogger = logger
+	}
+}
+
+// WithErrorHandler sets a function that the [Server] calls when a connection of an MTA ends because of an error
+// (e.g. a malformed packet or a read timeout). You can use it to e.g. increment metrics or alert someone.
+// The error itself still gets logged with [LogWarning].
+//
+// fn gets called before the connection gets closed and before the last [Milter.Cleanup] call.
+// session is nil when the error happened before the protocol negotiation was completed.
+// The function does not get called when the MTA closed the connection normally.
+//
+// This is a [Server] only [Option].
+func WithErrorHandler(fn func(err error, session Session)) Option {
+	return func(h *options) {
+		h.errorHandler = fn
+	}
+}
+
+// WithListenBacklog sets the length of the accept queue (backlog) of the listeners that [Server.Listen] and
+// [Server.ListenAndServe] create. Raise it when your milter gets connection bursts that fill up the accept queue and the
+// OS refuses new connections of the MTA. 0 (the default) uses the default of the OS.
+//
+// The kernel silently caps values above its limit (SOMAXCONN, e.g. /proc/sys/net/core/somaxconn on Linux).
+// On non-Unix systems this option gets ignored. It does not change listeners you pass to [Server.Serve].
+//
+// This is a [Server] only [Option].
+func WithListenBacklog(n int) Option {
+	return func(h *options) {
+		if n < 0 {
+			n = 0
+		}
+		h.listenBacklog = n
+	}
+}
+
+// WithReadBufferSize sets the size of the receive buffer (SO_RCVBUF) of the listeners that [Server.Listen] and
+// [Server.ListenAndServe] create. The connections of the MTA inherit this size.
+// Raise it for high-throughput deployments where the default of the OS is a bottleneck.
+// 0 (the default) uses the default of the OS.
+//
+// The kernel caps (and on Linux doubles) the value. On non-Unix systems this option gets ignored.
+// It does not change listeners you pass to [Server.Serve].
+//
+// This is a [Server] only [Option].
+func WithReadBufferSize(bytes int) Option {
+	return func(h *options) {
+		if bytes < 0 {
+			bytes = 0
+		}
+		h.readBufferSize = bytes
+	}
+}
+
+// WithWriteBufferSize sets the size of the send buffer (SO_SNDBUF) of the listeners that [Server.Listen] and
+// [Server.ListenAndServe] create. See [WithReadBufferSize] for the details.
+//
+// This is a [Server] only [Option].
+func WithWriteBufferSize(bytes int) Option {
+	return func(h *options) {
+		if bytes < 0 {
+			bytes = 0
+		}
+		h.writeBufferSize = bytes
+	}
+}
+
+// WithReplaceBodyChunkSize sets the size of the chunks in which [Modifier.ReplaceBody] sends the new body to the MTA.
+// Smaller chunks mean more packets, larger chunks mean fewer but bigger packets.
+// The negotiated maximum data size (see [WithUsedMaxData]) caps bytes.
+// 0 (the default) uses the negotiated maximum data size.
+//
+// This is a [Server] only [Option].
+func WithReplaceBodyChunkSize(bytes int) Option {
+	return func(h *options) {
+		if bytes < 0 {
+			bytes = 0
+		}
+		h.replaceBodyChunkSize = bytes
+	}
+}
+
+// WithMaxAddedRecipients limits the number of recipients a [Milter] can add to one message with [Modifier.AddRecipient].
+// When the limit is reached AddRecipient returns an error that wraps [ErrModificationLimitExceeded]
+// and does not send the recipient to the MTA. Use it to fail cleanly before hitting the (opaque) limit of your MTA.
+// 0 (the default) means no limit.
+//
+// This is a [Server] only [Option].
+func WithMaxAddedRecipients(n int) Option {
+	return func(h *options) {
+		if n < 0 {
+			n = 0
+		}
+		h.maxAddedRecipients = n
+	}
+}
+
+// WithMaxAddedHeaders limits the number of header fields a [Milter] can add to one message with
+// [Modifier.AddHeader] and [Modifier.InsertHeader].
+// When the limit is reached these methods return an error that wraps [ErrModificationLimitExceeded]
+// and do not send the header field to the MTA. 0 (the default) means no limit.
+//
+// This is a [Server] only [Option].
+func WithMaxAddedHeaders(n int) Option {
+	return func(h *options) {
+		if n < 0 {
+			n = 0
+		}
+		h.maxAddedHeaders = n
+	}
+}
+
+// WithMaxConnectionMessageBytes limits the total number of body bytes that the MTA can send in all messages of one
+// SMTP session. A limit per message does not stop an SMTP client that sends many small messages over one connection,
+// this limit does. When the limit is exceeded the [Server] responds with [RespTempFail] and closes the milter connection.
+// The error that ended the connection (see [WithErrorHandler]) wraps [ErrConnectionMessageBytesExceeded].
+// 0 (the default) means no limit.
+//
+// Only body bytes that the MTA actually sent count, see [Modifier.BodySize].
+//
+// This is a [Server] only [Option].
+func WithMaxConnectionMessageBytes(total int64) Option {
+	return func(h *options) {
+		if total < 0 {
+			total = 0
+		}
+		h.maxConnectionMessageBytes = total
+	}
+}
+
+// WithMaxTransactionsPerConnection limits the number of SMTP transactions (messages) that the [Server] handles
+// in one milter connection. After the nth transaction ended (with EOM or Abort) the [Server] closes the connection
+// instead of waiting for the next MAIL FROM, so the MTA needs to open a new connection.
+// Use this to periodically cycle long-lived connections.
+// 0 (the default) means no limit.
+//
+// This is a [Server] only [Option].
+func WithMaxTransactionsPerConnection(n int) Option {
+	return func(h *options) {
+		if n < 0 {
+			n = 0
+		}
+		h.maxTransactions = n
+	}
+}
+
+// WithMaxHeaderValueBytes limits the size of the value of a single header field to n bytes.
+// This is distinct from the size of the whole header: one pathological header field value of some megabytes
+// can be as harmful as many header fields.
+//
+// The [Server] checks every header field as it arrives. When a value is longer than n bytes the [Milter] does not get
+// the Header call and the MTA gets resp as response. A nil resp means a 552 5.3.4 rejection.
+// 0 (the default) means no limit.
+//
+// This is a [Server] only [Option].
+func WithMaxHeaderValueBytes(n int, resp *Response) Option {
+	return func(h *options) {
+		if n < 0 {
+			n = 0
+		}
+		h.maxHeaderValueBytes = n
+		h.headerValueTooLongResp = resp
+	}
+}
+
+// WithMaxMessageHeaderSize limits the size of the whole header of a message to bytes.
+// The size gets counted like [Modifier.HeaderSize] does. RFC 5322 does not limit the header size, but some MTAs
+// and mail clients have problems with headers bigger than some kilobytes.
+//
+// The [Server] checks the size as the header fields arrive. When the header gets bigger than bytes the [Milter] does not
+// get the Header call and the MTA gets a 552 5.3.4 rejection. 0 (the default) means no limit.
+//
+// This is a [Server] only [Option].
+func WithMaxMessageHeaderSize(bytes int) Option {
+	return func(h *options) {
+		if bytes < 0 {
+			bytes = 0
+		}
+		h.maxMessageHeaderSize = int64(bytes)
+	}
+}
+
+// WithAcceptUnknownCommands makes the [Server] answer commands it does not know with resp
+// instead of closing the connection. A future MTA version might send new SMFIC_* commands,
+// with this [Option] your [Milter] keeps working with it. A nil resp means [RespAccept].
+// The [Milter] does not get called for unknown commands.
+//
+// This is a [Server] only [Option].
+func WithAcceptUnknownCommands(resp *Response) Option {
+	return func(h *options) {
+		if resp == nil {
+			resp = RespAccept
+		}
+		h.unknownCommandResp = resp
+	}
+}
+
+// WithTimingHeader makes the [Server] add the header field name (e.g. "X-Milter-Time") to every message that
+// the [Milter] accepts or lets through at the end of the message. Its value is the time the [Milter] spent in the
+// callbacks of the message (from [Milter.MailFrom] up to and including [Milter.EndOfMessage]) in milliseconds, e.g. "42.125ms".
+// The time the MTA needs between the callbacks does not count.
+//
+// The [Server] needs the [OptAddHeader] action to add the header field, when it cannot add it a warning gets logged.
+// An empty name (the default) does not add a header field.
+//
+// This is a [Server] only [Option].
+func WithTimingHeader(name string) Option {
+	return func(h *options) {
+		h.timingHeader = name
+	}
+}
+
 // WithNegotiationCallback is an expert [Option] with which you can overwrite the negotiation process.
 //
 // You should not need to use this. You might easily break things. You are responsible to adhere to
@@ -192,3 +567,36 @@ func WithNegotiationCallback(negotiationCallback NegotiationCallbackFunc) Option
 		h.negotiationCallback = negotiationCallback
 	}
 }
+
+// WithNegotiationHook is an expert [Option] with which you can change the actions and protocol options
+// that the [Server] sends to the MTA as the result of the negotiation.
+// fn gets the actions and protocol options that the negotiation (or the [WithNegotiationCallback] function) came up with
+// and returns the values that get used for the connection. Use it to test unusual MTA behaviour or to enable
+// milter capabilities that this library does not support yet.
+//
+// Unlike [WithNegotiationCallback] you do not need to re-implement the whole negotiation process.
+// But you are still responsible to only return actions and protocol options that the MTA offered.
+//
+// This is a [Server] only [Option].
+func WithNegotiationHook(fn func(actions OptAction, protocol OptProtocol) (OptAction, OptProtocol)) Option {
+	return func(h *options) {
+		h.negotiationHook = fn
+	}
+}
+
+// WithOnNegotiationDowngrade registers fn to get called at the end of the negotiation when the MTA did not grant
+// all actions (see [WithAction]) and protocol options (see [WithProtocol]) that the milter requested.
+// missingActions and missingProtocol are the requested flags that the MTA did not grant.
+// fn does not get called when the MTA granted everything.
+//
+// Without this option the [Server] refuses connections of MTAs that do not offer all requested actions and protocol options.
+// With this option the [Server] uses what the MTA offers and fn decides: return nil to accept the connection
+// (the milter has to adapt, e.g. not call [Modifier.ReplaceBody] when missingActions contains [OptChangeBody]),
+// return an error to refuse it.
+//
+// This is a [Server] only [Option].
+func WithOnNegotiationDowngrade(fn func(missingActions OptAction, missingProtocol OptProtocol) error) Option {
+	return func(h *options) {
+		h.onNegotiationDowngrade = fn
+	}
+}
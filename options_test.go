@@ -159,3 +159,141 @@ func TestWithNegotiationCallback(t *testing.T) {
 		t.Fatalf("did not set the correct negotiationCallback")
 	}
 }
+
+func TestWithDryRun(t *testing.T) {
+	opt := options{}
+	WithDryRun(true)(&opt)
+	if !opt.dryRun {
+		t.Fatalf("did not set dryRun")
+	}
+	WithDryRun(false)(&opt)
+	if opt.dryRun {
+		t.Fatalf("did not unset dryRun")
+	}
+}
+
+func TestWithListenBacklog(t *testing.T) {
+	opt := options{}
+	WithListenBacklog(512)(&opt)
+	if opt.listenBacklog != 512 {
+		t.Fatalf("got listenBacklog %d, want 512", opt.listenBacklog)
+	}
+	WithListenBacklog(-1)(&opt)
+	if opt.listenBacklog != 0 {
+		t.Fatalf("got listenBacklog %d, want 0", opt.listenBacklog)
+	}
+}
+
+func TestWithReadBufferSize(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithReadBufferSize(1 << 20)}, options{readBufferSize: 1 << 20}},
+		{"negative", options{readBufferSize: 1 << 20}, []Option{WithReadBufferSize(-1)}, options{}},
+	})
+}
+
+func TestWithWriteBufferSize(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithWriteBufferSize(1 << 20)}, options{writeBufferSize: 1 << 20}},
+		{"negative", options{writeBufferSize: 1 << 20}, []Option{WithWriteBufferSize(-1)}, options{}},
+	})
+}
+
+func TestWithMaxAddedRecipients(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxAddedRecipients(10)}, options{maxAddedRecipients: 10}},
+		{"negative", options{maxAddedRecipients: 10}, []Option{WithMaxAddedRecipients(-1)}, options{}},
+	})
+}
+
+func TestWithMaxAddedHeaders(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxAddedHeaders(10)}, options{maxAddedHeaders: 10}},
+		{"negative", options{maxAddedHeaders: 10}, []Option{WithMaxAddedHeaders(-1)}, options{}},
+	})
+}
+
+func TestWithMaxConnectionMessageBytes(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxConnectionMessageBytes(1024)}, options{maxConnectionMessageBytes: 1024}},
+		{"negative", options{maxConnectionMessageBytes: 1024}, []Option{WithMaxConnectionMessageBytes(-1)}, options{}},
+	})
+}
+
+func TestWithMaxTransactionsPerConnection(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxTransactionsPerConnection(3)}, options{maxTransactions: 3}},
+		{"negative", options{maxTransactions: 3}, []Option{WithMaxTransactionsPerConnection(-1)}, options{}},
+	})
+}
+
+func TestWithTrafficShaper(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithTrafficShaper(time.Millisecond, 2*time.Millisecond)}, options{readDelay: time.Millisecond, writeDelay: 2 * time.Millisecond}},
+		{"reset", options{readDelay: time.Millisecond, writeDelay: time.Millisecond}, []Option{WithTrafficShaper(0, 0)}, options{}},
+	})
+}
+
+func TestWithEventQueueSize(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithEventQueueSize(10)}, options{eventQueueSize: 10}},
+		{"negative", options{eventQueueSize: 10}, []Option{WithEventQueueSize(-1)}, options{}},
+	})
+}
+
+func TestWithNegotiationHook(t *testing.T) {
+	opt := options{}
+	WithNegotiationHook(func(actions OptAction, protocol OptProtocol) (OptAction, OptProtocol) {
+		return actions | OptQuarantine, protocol
+	})(&opt)
+	if opt.negotiationHook == nil {
+		t.Fatalf("did not set negotiationHook")
+	}
+	if actions, _ := opt.negotiationHook(OptAddHeader, 0); actions != OptAddHeader|OptQuarantine {
+		t.Fatalf("did not set the correct negotiationHook")
+	}
+}
+
+func TestWithOnNegotiationDowngrade(t *testing.T) {
+	opt := options{}
+	WithOnNegotiationDowngrade(func(missingActions OptAction, missingProtocol OptProtocol) error {
+		return nil
+	})(&opt)
+	if opt.onNegotiationDowngrade == nil {
+		t.Fatalf("did not set onNegotiationDowngrade")
+	}
+}
+
+func TestWithMaxMessageHeaderSize(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxMessageHeaderSize(4096)}, options{maxMessageHeaderSize: 4096}},
+		{"negative", options{maxMessageHeaderSize: 4096}, []Option{WithMaxMessageHeaderSize(-1)}, options{}},
+	})
+}
+
+func TestWithMaxHeaderValueBytes(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxHeaderValueBytes(1024, RespTempFail)}, options{maxHeaderValueBytes: 1024, headerValueTooLongResp: RespTempFail}},
+		{"negative", options{maxHeaderValueBytes: 1024}, []Option{WithMaxHeaderValueBytes(-1, nil)}, options{}},
+	})
+}
+
+func TestWithShutdownTimeout(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithShutdownTimeout(time.Second)}, options{shutdownTimeout: time.Second}},
+		{"negative", options{shutdownTimeout: time.Second}, []Option{WithShutdownTimeout(-1)}, options{}},
+	})
+}
+
+func TestWithTimingHeader(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithTimingHeader("X-Milter-Time")}, options{timingHeader: "X-Milter-Time"}},
+		{"unset", options{timingHeader: "X-Milter-Time"}, []Option{WithTimingHeader("")}, options{}},
+	})
+}
+
+func TestWithReplaceBodyChunkSize(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithReplaceBodyChunkSize(1024)}, options{replaceBodyChunkSize: 1024}},
+		{"negative", options{replaceBodyChunkSize: 1024}, []Option{WithReplaceBodyChunkSize(-1)}, options{}},
+	})
+}
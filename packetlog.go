@@ -0,0 +1,96 @@
+package milter
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Direction is the direction of a milter packet. See [PacketLogger].
+type Direction int
+
+const (
+	// DirectionRecv is a packet that the [Server] received from the MTA.
+	DirectionRecv Direction = iota + 1
+	// DirectionSend is a packet that the [Server] sent to the MTA.
+	DirectionSend
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionRecv:
+		return "recv"
+	case DirectionSend:
+		return "send"
+	default:
+		return "unknown"
+	}
+}
+
+// PacketLogger gets every milter packet that the [Server] receives or sends. See [WithPacketLogger].
+//
+// LogPacket gets called concurrently by all connections of the [Server].
+// session identifies the MTA connection of the packet: every connection of the [Server] gets its own ID (starting with 1),
+// so you can tell apart the packets of concurrent connections.
+// data must not be modified or retained after LogPacket returns.
+type PacketLogger interface {
+	LogPacket(session uint64, direction Direction, cmd byte, data []byte)
+}
+
+type hexPacketLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *hexPacketLogger) LogPacket(session uint64, direction Direction, cmd byte, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = fmt.Fprintf(l.w, "%s #%d %s %q %d bytes\n%s", time.Now().Format(time.RFC3339Nano), session, direction, cmd, len(data), hex.Dump(data))
+}
+
+// HexPacketLogger returns a [PacketLogger] that writes a human-readable hex dump of every packet to w.
+func HexPacketLogger(w io.Writer) PacketLogger {
+	return &hexPacketLogger{w: w}
+}
+
+type jsonPacketLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type jsonPacket struct {
+	Session uint64 `json:"session"`
+	Dir     string `json:"dir"`
+	Cmd     string `json:"cmd"`
+	DataHex string `json:"data_hex"`
+	Ts      string `json:"ts"`
+}
+
+func (l *jsonPacketLogger) LogPacket(session uint64, direction Direction, cmd byte, data []byte) {
+	p := jsonPacket{
+		Session: session,
+		Dir:     direction.String(),
+		Cmd:     string(rune(cmd)),
+		DataHex: hex.EncodeToString(data),
+		Ts:      time.Now().Format(time.RFC3339Nano),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.enc.Encode(&p)
+}
+
+// JSONPacketLogger returns a [PacketLogger] that writes one JSON object per packet to w, e.g.
+//
+//	{"session":1,"dir":"recv","cmd":"H","data_hex":"6d782e6578616d706c652e636f6d00","ts":"2023-03-01T15:47:33.123456789+01:00"}
+//
+// session is the ID of the MTA connection (see [PacketLogger]), dir is "recv" for packets of the MTA and "send" for
+// packets of the [Server], cmd is the command or response code and data_hex the hex encoded payload of the packet.
+// You can pipe the output to tools like jq to filter and analyse the milter traffic
+// (e.g. jq -c 'select(.session == 3)' to get the packets of one connection).
+// The cmd/milter-replay tool replays the sessions of this output against a milter.
+func JSONPacketLogger(w io.Writer) PacketLogger {
+	return &jsonPacketLogger{enc: json.NewEncoder(w)}
+}
@@ -0,0 +1,117 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJSONPacketLogger(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	l := JSONPacketLogger(&buf)
+	l.LogPacket(1, DirectionRecv, 'H', []byte("mx\x00"))
+	l.LogPacket(2, DirectionSend, 'c', nil)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+	for i, want := range []jsonPacket{{Session: 1, Dir: "recv", Cmd: "H", DataHex: "6d7800"}, {Session: 2, Dir: "send", Cmd: "c", DataHex: ""}} {
+		var got jsonPacket
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, got.Ts); err != nil {
+			t.Errorf("line %d: invalid ts: %v", i, err)
+		}
+		got.Ts = ""
+		if got != want {
+			t.Errorf("line %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if !strings.HasPrefix(lines[0], `{"session":1,"dir":"recv","cmd":"H","data_hex":"6d7800","ts":"`) {
+		t.Errorf("unexpected field order in %q", lines[0])
+	}
+}
+
+func TestHexPacketLogger(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	HexPacketLogger(&buf).LogPacket(3, DirectionSend, 'h', []byte("Subject\x00test\x00"))
+	got := buf.String()
+	for _, s := range []string{" #3 send 'h' 13 bytes\n", "00000000  53 75 62 6a 65 63 74 00  74 65 73 74 00", "|Subject.test.|"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("output %q does not contain %q", got, s)
+		}
+	}
+}
+
+type recordingPacketLogger struct {
+	mu       sync.Mutex
+	sessions []uint64
+	packets  []string
+}
+
+func (r *recordingPacketLogger) LogPacket(session uint64, direction Direction, cmd byte, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions = append(r.sessions, session)
+	r.packets = append(r.packets, direction.String()+" "+string(rune(cmd)))
+}
+
+func TestServer_PacketLogger(t *testing.T) {
+	t.Parallel()
+	logger := &recordingPacketLogger{}
+	w := newServerClient(t, nil, []Option{WithPacketLogger(logger), WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	// a second connection gets its own session ID
+	second, err := w.client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	act, err = second.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	logger.mu.Lock()
+	got := strings.Join(logger.packets, ",")
+	sessions := logger.sessions
+	logger.mu.Unlock()
+	if want := "recv O,send O,recv C,send c,recv O,send O,recv C,send c"; got != want {
+		t.Errorf("logged packets %q, want %q", got, want)
+	}
+	if want := []uint64{1, 1, 1, 1, 2, 2, 2, 2}; !reflect.DeepEqual(sessions, want) {
+		t.Errorf("logged sessions %v, want %v", sessions, want)
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithPacketLogger did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithPacketLogger(logger))
+}
+
+func TestServer_PacketLogger_afterWriteError(t *testing.T) {
+	t.Parallel()
+	logger := &recordingPacketLogger{}
+	m := &serverSession{
+		server: NewServer(WithPacketLogger(logger), WithMilter(func() Milter {
+			return NoOpMilter{}
+		})),
+		writeErr: ErrWriteTimeout,
+	}
+	if err := m.writePacket(RespContinue.Response()); !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("writePacket() = %v, want ErrWriteTimeout", err)
+	}
+	if len(logger.packets) != 0 {
+		t.Errorf("logged packets %q, want none since the packet was not sent", logger.packets)
+	}
+}
@@ -0,0 +1,421 @@
+package milter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// ProxyMilter is a [Middleware] that chains a downstream milter behind the wrapped [Milter].
+// Every callback first goes to the wrapped [Milter]. When it lets the MTA continue, the [ProxyMilter] forwards
+// the same command with a [ClientSession] of its [Client] to the downstream milter and relays its decision to the MTA.
+// The modifications of the downstream milter get applied with the [Modifier] at [Milter.EndOfMessage] – after
+// the modifications of the wrapped [Milter]. Use [NoOpMilter] as wrapped [Milter] when you only want to proxy.
+//
+//	proxy := milter.NewProxyMilter(milter.NewClient("tcp", "127.0.0.1:10025"))
+//	server := milter.NewServer(
+//		milter.WithActions(milter.OptAddHeader|milter.OptChangeHeader),
+//		milter.WithMilter(func() milter.Milter {
+//			return proxy.Wrap(&MyMilter{})
+//		}),
+//	)
+//
+// Decisions get merged like this: a reject, discard or temporary failure of either side ends the transaction.
+// When the downstream milter accepts the message (or connection) it does not get any more commands of this message
+// (or connection), but the wrapped [Milter] still decides. [RespSkip] only gets sent to the MTA when both sides
+// do not want more events of that kind.
+//
+// The two legs get negotiated independently. The downstream milter only gets offered the actions that the MTA
+// granted the [Server] (and that the [Client] offers), so all its modifications can be relayed.
+// Commands that the MTA does not send (because of the protocol options of the [Server]) get
+// synthesized for the downstream milter from the macros and the [Modifier], so it always sees a complete SMTP transaction.
+// Body chunks get split to the data size the downstream milter negotiated.
+// The downstream milter gets the macros the MTA sent, so request the macros it needs with [WithMacroRequest] on the [Server].
+type ProxyMilter struct {
+	client *Client
+}
+
+// NewProxyMilter creates a new [ProxyMilter] that forwards to the milter of client.
+// Every SMTP connection opens its own [ClientSession].
+func NewProxyMilter(client *Client) *ProxyMilter {
+	return &ProxyMilter{client: client}
+}
+
+// Wrap wraps next so that all commands next lets pass get forwarded to the downstream milter.
+func (p *ProxyMilter) Wrap(next Milter) Milter {
+	return &proxyMilter{next: next, client: p.client}
+}
+
+// proxyMacros lets the ClientSession always read the macros of the current callback
+type proxyMacros struct {
+	macros Macros
+}
+
+func (p *proxyMacros) Get(name MacroName) string {
+	if p.macros == nil {
+		return ""
+	}
+	return p.macros.Get(name)
+}
+
+func (p *proxyMacros) GetEx(name MacroName) (string, bool) {
+	if p.macros == nil {
+		return "", false
+	}
+	return p.macros.GetEx(name)
+}
+
+type proxyMilter struct {
+	next    Milter
+	client  *Client
+	macros  proxyMacros
+	session *ClientSession
+	// connDone is true when the downstream milter does not want any more commands of this connection
+	connDone bool
+	// msgDone is true when the downstream milter does not want any more commands of this message
+	msgDone bool
+	// localSkip is the callback next responded to with RespSkip
+	localSkip Callback
+}
+
+// open opens the downstream session when it is not open yet
+func (p *proxyMilter) open(m *Modifier) error {
+	p.macros.macros = m.Macros
+	if p.session != nil {
+		return nil
+	}
+	actions := p.client.options.actions
+	if n := m.Negotiation(); n != nil {
+		actions &= n.Actions
+	}
+	conn, err := p.client.options.dialer.Dial(p.client.network, p.client.address)
+	if err != nil {
+		return fmt.Errorf("milter: proxy: %w", err)
+	}
+	session, err := p.client.sessionWithActions(conn, &p.macros, actions)
+	if err != nil {
+		return err
+	}
+	p.session = session
+	return nil
+}
+
+// local calls fn unless next already responded with RespSkip to callback
+func (p *proxyMilter) local(callback Callback, fn func() (*Response, error)) (*Response, error) {
+	if p.localSkip == callback {
+		return RespSkip, nil
+	}
+	resp, err := fn()
+	if err == nil && resp != nil && resp.code == wire.Code(wire.ActSkip) {
+		p.localSkip = callback
+	}
+	return resp, err
+}
+
+// forward sends the command of callback with fn to the downstream milter when resp lets the MTA continue
+// and returns the merged response.
+func (p *proxyMilter) forward(callback Callback, resp *Response, err error, m *Modifier, fn func(s *ClientSession) (*Action, error)) (*Response, error) {
+	if err != nil || resp == nil || !resp.Continue() || p.connDone || p.msgDone {
+		return resp, err
+	}
+	if err := p.open(m); err != nil {
+		return nil, err
+	}
+	act, err := p.catchUp(callback, m)
+	if err == nil && act.Type == ActionContinue {
+		act, err = fn(p.session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch act.Type {
+	case ActionContinue, ActionSkip:
+	case ActionAccept:
+		if callback == CallbackConnect || callback == CallbackHelo {
+			p.connDone = true
+		} else {
+			p.msgDone = true
+		}
+	default:
+		return actionResponse(act)
+	}
+	if resp.code == wire.Code(wire.ActSkip) && !p.msgDone && !p.session.Skip() {
+		// the downstream milter still wants these events
+		return RespContinue, nil
+	}
+	return resp, nil
+}
+
+// catchUp sends the commands that the MTA did not send to the downstream milter,
+// so that the command of callback is valid in the current state of the downstream session.
+func (p *proxyMilter) catchUp(callback Callback, m *Modifier) (*Action, error) {
+	s := p.session
+	var want clientSessionState
+	switch callback {
+	case CallbackHelo, CallbackMailFrom:
+		if s.state > clientStateHeloCalled && s.state != clientStateError {
+			// the previous message did not end with End
+			if err := s.Abort(nil); err != nil {
+				return nil, err
+			}
+		}
+		want = clientStateConnectCalled
+		if callback == CallbackMailFrom {
+			want = clientStateHeloCalled
+		}
+	case CallbackRcptTo:
+		want = clientStateMailCalled
+	case CallbackData:
+		want = clientStateRcptCalled
+	case CallbackHeader, CallbackHeaders:
+		want = clientStateDataCalled
+	case CallbackBodyChunk:
+		want = clientStateHeaderEndCalled
+	case CallbackEndOfMessage:
+		want = clientStateBodyChunkCalled
+	}
+	for s.state < want {
+		var act *Action
+		var err error
+		switch s.state {
+		case clientStateNegotiated:
+			act, err = s.Conn(p.connectArgs(m))
+		case clientStateConnectCalled:
+			act, err = s.Helo(m.Helo())
+		case clientStateHeloCalled:
+			act, err = s.Mail(m.Sender(), "")
+		case clientStateMailCalled:
+			// the MTA did not send us the recipients, so we cannot send them either
+			s.state = clientStateRcptCalled
+			continue
+		case clientStateRcptCalled:
+			act, err = s.DataStart()
+		case clientStateDataCalled, clientStateHeaderFieldCalled:
+			act, err = s.HeaderEnd()
+		default:
+			// empty body
+			s.state = clientStateBodyChunkCalled
+			continue
+		}
+		if err != nil || act.Type != ActionContinue {
+			return act, err
+		}
+	}
+	return &Action{Type: ActionContinue}, nil
+}
+
+// connectArgs returns the arguments for [ClientSession.Conn] when the MTA did not call Connect on this [Milter].
+// This happens when the MTA does not send the connect event or this [Milter] instance replaced another one
+// after a decision of the previous message.
+func (p *proxyMilter) connectArgs(m *Modifier) (string, ProtoFamily, uint16, string) {
+	hostname := m.Macros.Get(MacroClientName)
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	addr := m.Macros.Get(MacroClientAddr)
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return hostname, FamilyUnknown, 0, ""
+	}
+	port, _ := strconv.ParseUint(m.Macros.Get(MacroClientPort), 10, 16)
+	if ip.To4() != nil {
+		return hostname, FamilyInet, uint16(port), addr
+	}
+	return hostname, FamilyInet6, uint16(port), addr
+}
+
+// actionResponse converts the action of the downstream milter into the response for the MTA
+func actionResponse(act *Action) (*Response, error) {
+	switch act.Type {
+	case ActionAccept:
+		return RespAccept, nil
+	case ActionContinue:
+		return RespContinue, nil
+	case ActionDiscard:
+		return RespDiscard, nil
+	case ActionReject:
+		return RespReject, nil
+	case ActionTempFail:
+		return RespTempFail, nil
+	case ActionSkip:
+		return RespSkip, nil
+	case ActionRejectWithCode:
+		return newResponseStr(wire.Code(wire.ActReplyCode), act.SMTPReply)
+	default:
+		return nil, fmt.Errorf("milter: proxy: unexpected action %d", act.Type)
+	}
+}
+
+func protoFamily(family string) ProtoFamily {
+	switch family {
+	case "tcp4":
+		return FamilyInet
+	case "tcp6":
+		return FamilyInet6
+	case "unix":
+		return FamilyUnix
+	default:
+		return FamilyUnknown
+	}
+}
+
+// modify applies the modification of the downstream milter
+func modify(act ModifyAction, m *Modifier) error {
+	switch act.Type {
+	case ActionAddRcpt:
+		return m.AddRecipient(act.Rcpt, act.RcptArgs)
+	case ActionDelRcpt:
+		return m.DeleteRecipient(act.Rcpt)
+	case ActionQuarantine:
+		return m.Quarantine(act.Reason)
+	case ActionReplaceBody:
+		// the downstream milter might have negotiated a bigger data size than the MTA
+		return m.ReplaceBody(bytes.NewReader(act.Body))
+	case ActionChangeFrom:
+		return m.ChangeFrom(act.From, act.FromArgs)
+	case ActionAddHeader:
+		return m.AddHeader(act.HeaderName, act.HeaderValue)
+	case ActionChangeHeader:
+		return m.ChangeHeader(int(act.HeaderIndex), act.HeaderName, act.HeaderValue)
+	case ActionInsertHeader:
+		return m.InsertHeader(int(act.HeaderIndex), act.HeaderName, act.HeaderValue)
+	default:
+		return fmt.Errorf("milter: proxy: unexpected modification %d", act.Type)
+	}
+}
+
+func (p *proxyMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	resp, err := p.next.Connect(host, family, port, addr, m)
+	return p.forward(CallbackConnect, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.Conn(host, protoFamily(family), port, addr)
+	})
+}
+
+func (p *proxyMilter) Helo(name string, m *Modifier) (*Response, error) {
+	resp, err := p.next.Helo(name, m)
+	return p.forward(CallbackHelo, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.Helo(name)
+	})
+}
+
+func (p *proxyMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	p.msgDone, p.localSkip = false, 0
+	resp, err := p.next.MailFrom(from, esmtpArgs, m)
+	return p.forward(CallbackMailFrom, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.Mail(from, esmtpArgs)
+	})
+}
+
+func (p *proxyMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	resp, err := p.local(CallbackRcptTo, func() (*Response, error) {
+		return p.next.RcptTo(rcptTo, esmtpArgs, m)
+	})
+	return p.forward(CallbackRcptTo, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.Rcpt(rcptTo, esmtpArgs)
+	})
+}
+
+func (p *proxyMilter) Data(m *Modifier) (*Response, error) {
+	resp, err := p.next.Data(m)
+	return p.forward(CallbackData, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.DataStart()
+	})
+}
+
+func (p *proxyMilter) Header(name string, value string, m *Modifier) (*Response, error) {
+	resp, err := p.local(CallbackHeader, func() (*Response, error) {
+		return p.next.Header(name, value, m)
+	})
+	return p.forward(CallbackHeader, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.HeaderField(name, value, nil)
+	})
+}
+
+func (p *proxyMilter) Headers(m *Modifier) (*Response, error) {
+	resp, err := p.next.Headers(m)
+	return p.forward(CallbackHeaders, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.HeaderEnd()
+	})
+}
+
+func (p *proxyMilter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	resp, err := p.local(CallbackBodyChunk, func() (*Response, error) {
+		return p.next.BodyChunk(chunk, m)
+	})
+	return p.forward(CallbackBodyChunk, resp, err, m, func(s *ClientSession) (*Action, error) {
+		// the MTA might send bigger chunks than the downstream milter negotiated
+		for len(chunk) > int(s.maxBodySize) {
+			act, err := s.BodyChunk(chunk[:s.maxBodySize])
+			if err != nil || act.Type != ActionContinue || s.Skip() {
+				return act, err
+			}
+			chunk = chunk[s.maxBodySize:]
+		}
+		return s.BodyChunk(chunk)
+	})
+}
+
+func (p *proxyMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	p.localSkip = 0
+	resp, err := p.next.EndOfMessage(m)
+	if err != nil || resp == nil || p.connDone || p.msgDone {
+		return resp, err
+	}
+	if resp.code != wire.Code(wire.ActAccept) && resp.code != wire.Code(wire.ActContinue) {
+		// next already decided, the downstream message gets aborted at the next MailFrom or Cleanup
+		return resp, nil
+	}
+	var mods []ModifyAction
+	act, err := p.forward(CallbackEndOfMessage, RespContinue, nil, m, func(s *ClientSession) (*Action, error) {
+		var act *Action
+		var err error
+		mods, act, err = s.End()
+		return act, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, mod := range mods {
+		if err := modify(mod, m); err != nil {
+			return nil, fmt.Errorf("milter: proxy: %w", err)
+		}
+	}
+	if act.Continue() {
+		// the downstream milter accepted the message, next decides
+		return resp, nil
+	}
+	return act, nil
+}
+
+func (p *proxyMilter) Abort(m *Modifier) error {
+	p.msgDone, p.localSkip = false, 0
+	err := p.next.Abort(m)
+	if p.session != nil && !p.connDone && p.session.state > clientStateHeloCalled && p.session.state != clientStateError {
+		p.macros.macros = m.Macros
+		if abortErr := p.session.Abort(nil); err == nil {
+			err = abortErr
+		}
+	}
+	return err
+}
+
+func (p *proxyMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	resp, err := p.next.Unknown(cmd, m)
+	return p.forward(CallbackUnknown, resp, err, m, func(s *ClientSession) (*Action, error) {
+		return s.Unknown(cmd, nil)
+	})
+}
+
+func (p *proxyMilter) Cleanup() {
+	p.next.Cleanup()
+	if p.session != nil {
+		_ = p.session.Close()
+		p.session = nil
+	}
+	p.connDone, p.msgDone, p.localSkip = false, false, 0
+}
+
+var _ Middleware = (*ProxyMilter)(nil)
@@ -0,0 +1,202 @@
+package milter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+func newProxyDownstream(t *testing.T, mm *MockMilter, opts ...Option) *Client {
+	t.Helper()
+	s := NewServer(append([]Option{WithMilter(func() Milter { return mm })}, opts...)...)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return NewClient("tcp", ln.Addr().String())
+}
+
+func newDownstreamMock() *MockMilter {
+	return &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+}
+
+func TestProxyMilter(t *testing.T) {
+	t.Parallel()
+	downstream := newDownstreamMock()
+	var offeredActions OptAction
+	downstream.ConnMod = func(m *Modifier) {
+		offeredActions = m.Negotiation().MTAActions
+	}
+	downstream.BodyMod = func(m *Modifier) {
+		if err := m.AddHeader("X-Downstream", "yes"); err != nil {
+			t.Error(err)
+		}
+		if err := m.ChangeFrom("new@example.com", ""); err != nil {
+			t.Error(err)
+		}
+	}
+	client := newProxyDownstream(t, downstream, WithActions(OptAddHeader|OptChangeFrom))
+	local := newDownstreamMock()
+	local.BodyMod = func(m *Modifier) {
+		if err := m.AddHeader("X-Local", "yes"); err != nil {
+			t.Error(err)
+		}
+	}
+	local.BodyResp = RespContinue
+	w := newServerClient(t, NewMacroBag(), []Option{
+		WithActions(OptAddHeader | OptChangeFrom),
+		WithMilter(func() Milter {
+			return NewProxyMilter(client).Wrap(local)
+		}),
+	}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("mx.example.com", FamilyInet, 2525, "192.0.2.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("mx.example.com")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	hdr := textproto.Header{}
+	hdr.Add("Subject", "test")
+	act, err = w.session.Header(hdr)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("body\r\n"))
+	assertAction(t, act, err, ActionContinue)
+	mods, act, err := w.session.End()
+	assertAction(t, act, err, ActionContinue)
+
+	if len(mods) != 3 || mods[0].HeaderName != "X-Local" || mods[1].HeaderName != "X-Downstream" || mods[2].Type != ActionChangeFrom || mods[2].From != "<new@example.com>" {
+		t.Errorf("modifications = %+v", mods)
+	}
+	if offeredActions != OptAddHeader|OptChangeFrom {
+		t.Errorf("downstream got offered actions %032b", offeredActions)
+	}
+	if downstream.Host != "mx.example.com" || downstream.Family != "tcp4" || downstream.Port != 2525 || downstream.Addr != "192.0.2.1" {
+		t.Errorf("downstream connect = %q %q %d %q", downstream.Host, downstream.Family, downstream.Port, downstream.Addr)
+	}
+	if downstream.HeloValue != "mx.example.com" || downstream.From != "from@example.com" || len(downstream.Rcpt) != 1 || downstream.Rcpt[0] != "to@example.com" {
+		t.Errorf("downstream envelope = %q %q %v", downstream.HeloValue, downstream.From, downstream.Rcpt)
+	}
+	if downstream.Hdr.Get("Subject") != "test" || len(downstream.Chunks) != 1 || string(downstream.Chunks[0]) != "body\r\n" {
+		t.Errorf("downstream message = %v %q", downstream.Hdr, downstream.Chunks)
+	}
+}
+
+func TestProxyMilter_Decisions(t *testing.T) {
+	t.Parallel()
+	customReject, err := RejectWithCodeAndReason(550, "5.7.1 downstream says no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		local        func(mm *MockMilter)
+		downstream   func(mm *MockMilter)
+		mail, rcpt   ActionType
+		downstreamTo bool
+	}{
+		{"continue", nil, nil, ActionContinue, ActionContinue, true},
+		{"downstream rejects mail", nil, func(mm *MockMilter) { mm.MailResp = customReject }, ActionRejectWithCode, 0, false},
+		{"downstream rejects rcpt", nil, func(mm *MockMilter) { mm.RcptResp = RespReject }, ActionContinue, ActionReject, true},
+		{"downstream accepts mail", nil, func(mm *MockMilter) { mm.MailResp = RespAccept }, ActionContinue, ActionContinue, false},
+		{"local rejects mail", func(mm *MockMilter) { mm.MailResp = RespTempFail }, nil, ActionTempFail, 0, false},
+		{"local skips rcpt", func(mm *MockMilter) { mm.RcptResp = RespSkip }, nil, ActionContinue, ActionContinue, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			downstream := newDownstreamMock()
+			if tt.downstream != nil {
+				tt.downstream(downstream)
+			}
+			client := newProxyDownstream(t, downstream, WithProtocol(OptSkip))
+			local := newDownstreamMock()
+			if tt.local != nil {
+				tt.local(local)
+			}
+			w := newServerClient(t, NewMacroBag(), []Option{
+				WithProtocol(OptSkip),
+				WithMilter(func() Milter {
+					return NewProxyMilter(client).Wrap(local)
+				}),
+			}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("localhost")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail("from@example.com", "")
+			assertAction(t, act, err, tt.mail)
+			if act.Type == ActionRejectWithCode && act.SMTPReply != "550 5.7.1 downstream says no" {
+				t.Errorf("SMTPReply = %q", act.SMTPReply)
+			}
+			if tt.rcpt != 0 {
+				act, err = w.session.Rcpt("to@example.com", "")
+				assertAction(t, act, err, tt.rcpt)
+				act, err = w.session.Rcpt("to2@example.com", "")
+				assertAction(t, act, err, tt.rcpt)
+			}
+			if got := len(downstream.Rcpt) > 0; got != tt.downstreamTo {
+				t.Errorf("downstream got RcptTo = %v", got)
+			}
+		})
+	}
+}
+
+func TestProxyMilter_CatchUp(t *testing.T) {
+	t.Parallel()
+	downstream := newDownstreamMock()
+	dataCalled := false
+	downstream.DataMod = func(m *Modifier) {
+		dataCalled = true
+	}
+	client := newProxyDownstream(t, downstream)
+	w := newServerClient(t, NewMacroBag(), []Option{
+		WithProtocol(OptNoData | OptNoBody),
+		WithMilter(func() Milter {
+			return NewProxyMilter(client).Wrap(NoOpMilter{})
+		}),
+	}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("localhost")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	hdr := textproto.Header{}
+	hdr.Add("Subject", "test")
+	act, err = w.session.Header(hdr)
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(nil)
+	assertAction(t, act, err, ActionAccept)
+	if !dataCalled {
+		t.Error("downstream did not get DATA")
+	}
+	if downstream.Hdr.Get("Subject") != "test" {
+		t.Errorf("downstream header = %v", downstream.Hdr)
+	}
+}
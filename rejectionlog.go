@@ -0,0 +1,217 @@
+package milter
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// rejectionLogColumns are the columns NewRejectionLog expects in the rejection log table (in this order).
+var rejectionLogColumns = []string{"created_at", "phase", "sender", "recipients", "message_id", "code", "reason"}
+
+var validTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func checkTableName(tableName string) error {
+	if !validTableName.MatchString(tableName) {
+		return fmt.Errorf("milter: invalid table name %q", tableName)
+	}
+	return nil
+}
+
+// CreateRejectionLogTable creates the table tableName that [NewRejectionLog] needs.
+// tableName may only contain ASCII letters, digits and underscores.
+//
+// The table has the columns
+//
+//	created_at  TIMESTAMP    -- time of the rejection (UTC)
+//	phase       VARCHAR(16)  -- SMTP phase of the rejection: connect, helo, mail, rcpt, data, header, eoh, body, eom or unknown
+//	sender      TEXT         -- envelope sender (empty before MAIL FROM or for bounces)
+//	recipients  TEXT         -- comma separated list of envelope recipients
+//	message_id  TEXT         -- Message-ID of the message (without angle brackets)
+//	code        INTEGER      -- SMTP code of the rejection
+//	reason      TEXT         -- SMTP reply text of the rejection
+//
+// You can also create the table yourself (e.g. to add indexes or an auto-increment primary key).
+func CreateRejectionLogTable(db *sql.DB, tableName string) error {
+	if err := checkTableName(tableName); err != nil {
+		return err
+	}
+	// table names cannot be query parameters, checkTableName ensures that the name cannot inject SQL
+	_, err := db.Exec("CREATE TABLE " + tableName + " (" +
+		"created_at TIMESTAMP NOT NULL, " +
+		"phase VARCHAR(16) NOT NULL, " +
+		"sender TEXT NOT NULL, " +
+		"recipients TEXT NOT NULL, " +
+		"message_id TEXT NOT NULL, " +
+		"code INTEGER NOT NULL, " +
+		"reason TEXT NOT NULL)")
+	return err
+}
+
+// RejectionLog is a [Middleware] that records every rejection (reject and temporary failure) of the wrapped [Milter] in a database table.
+// Use [NewRejectionLog] to create it.
+type RejectionLog struct {
+	db          *sql.DB
+	insertQuery string
+}
+
+// NewRejectionLog creates a new [RejectionLog] [Middleware] that stores the rejections in the table tableName of db.
+// Use [CreateRejectionLogTable] to create the table.
+//
+// NewRejectionLog checks that the table has all the columns [CreateRejectionLogTable] creates and returns an error if it does not.
+//
+// All values get passed as query parameters with the "?" placeholder syntax (supported by e.g. the SQLite and MySQL drivers).
+// Errors while storing a rejection get logged with [LogWarning], they do not change the response of the wrapped [Milter].
+func NewRejectionLog(db *sql.DB, tableName string) (Middleware, error) {
+	if err := checkTableName(tableName); err != nil {
+		return nil, err
+	}
+	columns := strings.Join(rejectionLogColumns, ", ")
+	rows, err := db.Query("SELECT " + columns + " FROM " + tableName + " WHERE 1 = 0")
+	if err != nil {
+		return nil, fmt.Errorf("milter: rejection log table %s does not have the expected schema: %w", tableName, err)
+	}
+	got, err := rows.Columns()
+	_ = rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("milter: rejection log table %s does not have the expected schema: %w", tableName, err)
+	}
+	if len(got) != len(rejectionLogColumns) {
+		return nil, fmt.Errorf("milter: rejection log table %s does not have the expected schema: got columns %v, expected %v", tableName, got, rejectionLogColumns)
+	}
+	return &RejectionLog{
+		db:          db,
+		insertQuery: "INSERT INTO " + tableName + " (" + columns + ") VALUES (?" + strings.Repeat(", ?", len(rejectionLogColumns)-1) + ")",
+	}, nil
+}
+
+// Wrap wraps next so that its rejections get recorded.
+func (l *RejectionLog) Wrap(next Milter) Milter {
+	return &rejectionLogMilter{log: l, next: next}
+}
+
+func (l *RejectionLog) record(phase string, code uint16, reason string, sender string, recipients []string, messageID string) {
+	_, err := l.db.Exec(l.insertQuery, time.Now().UTC(), phase, sender, strings.Join(recipients, ", "), messageID, int64(code), reason)
+	if err != nil {
+		LogWarning("could not record rejection in rejection log: %v", err)
+	}
+}
+
+// rejection returns the SMTP code and reason of resp when it is a rejection.
+func rejection(resp *Response) (code uint16, reason string, ok bool) {
+	if resp == nil {
+		return 0, "", false
+	}
+	switch wire.ActionCode(resp.code) {
+	case wire.ActReject:
+		return 550, "reject", true
+	case wire.ActTempFail:
+		return 451, "temp_fail", true
+	case wire.ActReplyCode:
+		act, err := parseAction(resp.Response())
+		if err != nil || act.SMTPCode < 400 {
+			return 0, "", false
+		}
+		return act.SMTPCode, act.SMTPReply, true
+	}
+	return 0, "", false
+}
+
+type rejectionLogMilter struct {
+	log        *RejectionLog
+	next       Milter
+	recipients []string
+}
+
+func (r *rejectionLogMilter) check(phase string, m *Modifier, resp *Response, err error) (*Response, error) {
+	if code, reason, ok := rejection(resp); ok {
+		var sender, messageID string
+		if m != nil {
+			sender, messageID = m.Sender(), m.MessageID()
+		}
+		r.log.record(phase, code, reason, sender, r.recipients, messageID)
+	}
+	if resp != nil && !resp.Continue() {
+		r.recipients = nil
+	}
+	return resp, err
+}
+
+func (r *rejectionLogMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	resp, err := r.next.Connect(host, family, port, addr, m)
+	return r.check("connect", m, resp, err)
+}
+
+func (r *rejectionLogMilter) Helo(name string, m *Modifier) (*Response, error) {
+	resp, err := r.next.Helo(name, m)
+	return r.check("helo", m, resp, err)
+}
+
+func (r *rejectionLogMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	r.recipients = nil
+	resp, err := r.next.MailFrom(from, esmtpArgs, m)
+	return r.check("mail", m, resp, err)
+}
+
+func (r *rejectionLogMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	r.recipients = append(r.recipients, rcptTo)
+	resp, err := r.next.RcptTo(rcptTo, esmtpArgs, m)
+	if code, reason, ok := rejection(resp); ok {
+		// a rejected recipient does not end the transaction
+		var sender string
+		if m != nil {
+			sender = m.Sender()
+		}
+		r.log.record("rcpt", code, reason, sender, []string{rcptTo}, "")
+		r.recipients = r.recipients[:len(r.recipients)-1]
+	}
+	return resp, err
+}
+
+func (r *rejectionLogMilter) Data(m *Modifier) (*Response, error) {
+	resp, err := r.next.Data(m)
+	return r.check("data", m, resp, err)
+}
+
+func (r *rejectionLogMilter) Header(name string, value string, m *Modifier) (*Response, error) {
+	resp, err := r.next.Header(name, value, m)
+	return r.check("header", m, resp, err)
+}
+
+func (r *rejectionLogMilter) Headers(m *Modifier) (*Response, error) {
+	resp, err := r.next.Headers(m)
+	return r.check("eoh", m, resp, err)
+}
+
+func (r *rejectionLogMilter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	resp, err := r.next.BodyChunk(chunk, m)
+	return r.check("body", m, resp, err)
+}
+
+func (r *rejectionLogMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	resp, err := r.next.EndOfMessage(m)
+	resp, err = r.check("eom", m, resp, err)
+	r.recipients = nil
+	return resp, err
+}
+
+func (r *rejectionLogMilter) Abort(m *Modifier) error {
+	r.recipients = nil
+	return r.next.Abort(m)
+}
+
+func (r *rejectionLogMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	resp, err := r.next.Unknown(cmd, m)
+	return r.check("unknown", m, resp, err)
+}
+
+func (r *rejectionLogMilter) Cleanup() {
+	r.next.Cleanup()
+}
+
+var _ Middleware = (*RejectionLog)(nil)
+var _ Milter = (*rejectionLogMilter)(nil)
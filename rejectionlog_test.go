@@ -0,0 +1,265 @@
+package milter
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// fakeSQL is a minimal database/sql driver that understands exactly the queries of RejectionLog
+type fakeSQL struct {
+	mu      sync.Mutex
+	columns map[string][]string
+	rows    map[string][][]driver.Value
+}
+
+var fakeSQLDatabases = struct {
+	sync.Mutex
+	dbs map[string]*fakeSQL
+}{dbs: map[string]*fakeSQL{}}
+
+func init() {
+	sql.Register("milter-fake-sql", fakeSQLDriver{})
+}
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	fakeSQLDatabases.Lock()
+	defer fakeSQLDatabases.Unlock()
+	db, ok := fakeSQLDatabases.dbs[name]
+	if !ok {
+		db = &fakeSQL{columns: map[string][]string{}, rows: map[string][][]driver.Value{}}
+		fakeSQLDatabases.dbs[name] = db
+	}
+	return &fakeSQLConn{db: db}, nil
+}
+
+type fakeSQLConn struct {
+	db *fakeSQL
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeSQLConn) Close() error {
+	return nil
+}
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+var (
+	fakeCreate = regexp.MustCompile(`^CREATE TABLE (\w+) \((.*)\)$`)
+	fakeSelect = regexp.MustCompile(`^SELECT (.*) FROM (\w+) WHERE 1 = 0$`)
+	fakeInsert = regexp.MustCompile(`^INSERT INTO (\w+) \((.*)\) VALUES \((.*)\)$`)
+)
+
+type fakeSQLStmt struct {
+	db    *fakeSQL
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error {
+	return nil
+}
+
+func (s *fakeSQLStmt) NumInput() int {
+	return strings.Count(s.query, "?")
+}
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if m := fakeCreate.FindStringSubmatch(s.query); m != nil {
+		if _, ok := s.db.columns[m[1]]; ok {
+			return nil, fmt.Errorf("table %s already exists", m[1])
+		}
+		var columns []string
+		for _, def := range strings.Split(m[2], ",") {
+			columns = append(columns, strings.Fields(def)[0])
+		}
+		s.db.columns[m[1]] = columns
+		return driver.RowsAffected(0), nil
+	}
+	if m := fakeInsert.FindStringSubmatch(s.query); m != nil {
+		columns, ok := s.db.columns[m[1]]
+		if !ok {
+			return nil, fmt.Errorf("no such table %s", m[1])
+		}
+		if strings.Join(columns, ", ") != m[2] || strings.Contains(m[3], "'") {
+			return nil, fmt.Errorf("unexpected insert %q", s.query)
+		}
+		s.db.rows[m[1]] = append(s.db.rows[m[1]], args)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected exec %q", s.query)
+}
+
+func (s *fakeSQLStmt) Query(_ []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	m := fakeSelect.FindStringSubmatch(s.query)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected query %q", s.query)
+	}
+	columns, ok := s.db.columns[m[2]]
+	if !ok {
+		return nil, fmt.Errorf("no such table %s", m[2])
+	}
+	selected := strings.Split(m[1], ", ")
+	for _, sel := range selected {
+		found := false
+		for _, c := range columns {
+			found = found || c == sel
+		}
+		if !found {
+			return nil, fmt.Errorf("no such column %s", sel)
+		}
+	}
+	return &fakeSQLRows{columns: selected}, nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+}
+
+func (r *fakeSQLRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeSQLRows) Close() error {
+	return nil
+}
+
+func (r *fakeSQLRows) Next(_ []driver.Value) error {
+	return io.EOF
+}
+
+func openFakeSQL(t *testing.T) (*sql.DB, *fakeSQL) {
+	t.Helper()
+	db, err := sql.Open("milter-fake-sql", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	fakeSQLDatabases.Lock()
+	defer fakeSQLDatabases.Unlock()
+	return db, fakeSQLDatabases.dbs[t.Name()]
+}
+
+func TestNewRejectionLog(t *testing.T) {
+	t.Parallel()
+	db, fake := openFakeSQL(t)
+	if _, err := NewRejectionLog(db, "rejections"); err == nil {
+		t.Errorf("NewRejectionLog() without table did not return an error")
+	}
+	if err := CreateRejectionLogTable(db, "rejections; DROP TABLE users"); err == nil {
+		t.Errorf("CreateRejectionLogTable() accepted invalid table name")
+	}
+	if _, err := NewRejectionLog(db, "rejections; DROP TABLE users"); err == nil {
+		t.Errorf("NewRejectionLog() accepted invalid table name")
+	}
+	if err := CreateRejectionLogTable(db, "rejections"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRejectionLog(db, "rejections"); err != nil {
+		t.Errorf("NewRejectionLog() error = %v", err)
+	}
+	fake.mu.Lock()
+	fake.columns["wrong"] = []string{"created_at", "phase", "sender", "reason"}
+	fake.mu.Unlock()
+	if _, err := NewRejectionLog(db, "wrong"); err == nil {
+		t.Errorf("NewRejectionLog() with wrong schema did not return an error")
+	}
+}
+
+func TestRejectionLog(t *testing.T) {
+	t.Parallel()
+	db, fake := openFakeSQL(t)
+	if err := CreateRejectionLogTable(db, "rejections"); err != nil {
+		t.Fatal(err)
+	}
+	mw, err := NewRejectionLog(db, "rejections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcptResp, _ := RejectWithCodeAndReason(550, "5.1.1 unknown user")
+	eomResp, _ := RejectWithCodeAndReason(554, "5.7.1 spam")
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      eomResp,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mw.Wrap(mm)
+	})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("localhost")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to1@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	mm.RcptResp = rcptResp
+	act, err = w.session.Rcpt("unknown@example.com", "")
+	assertAction(t, act, err, ActionRejectWithCode)
+	mm.RcptResp = RespContinue
+	act, err = w.session.Rcpt("to2@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	hdrs := textproto.Header{}
+	hdrs.Add("Message-ID", "<1234@example.com>")
+	act, err = w.session.Header(hdrs)
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(strings.NewReader("test\r\n"))
+	assertAction(t, act, err, ActionRejectWithCode)
+	// second message without rejection
+	mm.BodyResp = RespAccept
+	act, err = w.session.Mail("other@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to1@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(hdrs)
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(strings.NewReader("test\r\n"))
+	assertAction(t, act, err, ActionAccept)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	rows := fake.rows["rejections"]
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2: %v", len(rows), rows)
+	}
+	expected := [][]driver.Value{
+		{"rcpt", "from@example.com", "unknown@example.com", "", int64(550), "550 5.1.1 unknown user"},
+		{"eom", "from@example.com", "to1@example.com, to2@example.com", "1234@example.com", int64(554), "554 5.7.1 spam"},
+	}
+	for i, row := range rows {
+		if got := fmt.Sprint(row[1:]); got != fmt.Sprint(expected[i]) {
+			t.Errorf("row %d = %s, want %s", i, got, fmt.Sprint(expected[i]))
+		}
+	}
+}
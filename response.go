@@ -119,18 +119,26 @@ func newResponseStr(code wire.Code, data string) (*Response, error) {
 // RejectWithCodeAndReason stops processing and tells client the error code and reason to sent
 //
 // smtpCode must be between 400 and 599, otherwise this method will return an error.
+// When reason starts with an enhanced status code (e.g. "5.7.1 Rejected") its class needs to match the class of smtpCode,
+// otherwise this method will return an error, too. See [RejectWithEnhancedCode].
 //
-// The reason can contain new-lines. Line ending canonicalization is done automatically.
+// The reason can contain new-lines. Line ending canonicalization is done automatically and every line
+// gets its own SMTP code, so a reason cannot inject a reply of its own.
+// Other control characters (except horizontal tabs) get replaced with their xtext encoding (RFC 3461, e.g. "+1B" for ESC).
+// NUL bytes are not allowed in reason.
 // This function returns an error when the resulting SMTP text has a length of more than [DataSize64K] - 1
 func RejectWithCodeAndReason(smtpCode uint16, reason string) (*Response, error) {
 	if smtpCode < 400 || smtpCode > 599 {
 		return nil, fmt.Errorf("milter: invalid code %d", smtpCode)
 	}
+	if err := checkEnhancedStatusCode(smtpCode, reason); err != nil {
+		return nil, err
+	}
 	if len(reason) > int(DataSize64K)-5 {
 		return nil, fmt.Errorf("milter: reason too long: %d > %d", len(reason), int(DataSize64K)-5)
 	}
 	escapeAndNormalize := transform.Chain(&milterutil.DoublePercentTransformer{}, &milterutil.CrLfCanonicalizationTransformer{})
-	data, _, err := transform.String(escapeAndNormalize, strings.TrimRight(reason, "\r\n"))
+	data, _, err := transform.String(escapeAndNormalize, escapeControlChars(strings.TrimRight(reason, "\r\n")))
 	if err != nil {
 		return nil, err
 	}
@@ -145,6 +153,31 @@ func RejectWithCodeAndReason(smtpCode uint16, reason string) (*Response, error)
 	return newResponseStr(wire.Code(wire.ActReplyCode), data)
 }
 
+// escapeControlChars replaces the control characters of s with their xtext encoding "+XX".
+// HT, CR and LF stay as-is and NUL bytes get rejected later.
+func escapeControlChars(s string) string {
+	isControl := func(c byte) bool {
+		return (c < ' ' && c != 0 && c != '\t' && c != '\r' && c != '\n') || c == 0x7f
+	}
+	i := 0
+	for i < len(s) && !isControl(s[i]) {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(s[:i])
+	for ; i < len(s); i++ {
+		if isControl(s[i]) {
+			_, _ = fmt.Fprintf(&b, "+%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
 // Define standard responses with no data
 var (
 	// RespAccept signals to the MTA that the current transaction should be accepted.
@@ -170,6 +203,7 @@ var (
 	// RespSkip signals to the MTA that transaction should continue and that the MTA
 	// does not need to send more events of the same type. This response one makes sense/is possible as
 	// return value of [Milter.RcptTo], [Milter.Header] and [Milter.BodyChunk].
-	// No more events get send to the milter after this response.
+	// Other than the other responses it does not end the transaction, e.g. [Milter.EndOfMessage] still gets called
+	// after you responded with RespSkip to a body chunk.
 	RespSkip = &Response{code: wire.Code(wire.ActSkip)}
 )
@@ -30,6 +30,10 @@ func TestRejectWithCodeAndReason(t *testing.T) {
 		{"Newline3", args{400, "\r\n"}, "400 ", false},
 		{"Newline4", args{400, "\n\r"}, "400 ", false},
 		{"%", args{400, "%"}, "400 %%", false},
+		{"injection", args{550, "go away\r\n250 2.0.0 Ok: queued"}, "550-go away\r\n550 250 2.0.0 Ok: queued", false},
+		{"injection-LF", args{550, "go away\n250 Ok\n"}, "550-go away\r\n550 250 Ok", false},
+		{"injection-CR", args{550, "go away\r250 Ok"}, "550-go away\r\n550 250 Ok", false},
+		{"control-chars", args{550, "go\x1b[31m away\x7f\ttab\x08"}, "550 go+1B[31m away+7F\ttab+08", false},
 		{"null-bytes", args{400, "bogus\x00reason"}, "", true},
 		{"invalid-code1", args{200, ""}, "", true},
 		{"invalid-code2", args{999, ""}, "", true},
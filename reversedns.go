@@ -0,0 +1,116 @@
+package milter
+
+import "strings"
+
+// ReverseDNSStatus is the verification result of the reverse DNS hostname of the SMTP client. See [ReverseDNS].
+type ReverseDNSStatus int
+
+const (
+	// ReverseDNSUnknown means that the MTA did not send the macros to determine the status.
+	ReverseDNSUnknown ReverseDNSStatus = iota
+	// ReverseDNSOK means that the PTR record of the client address and the A/AAAA record of this name match.
+	ReverseDNSOK
+	// ReverseDNSForged means that the client address has a PTR record, but the name does not resolve back to the address.
+	ReverseDNSForged
+	// ReverseDNSTemp means that the lookup failed temporarily.
+	ReverseDNSTemp
+	// ReverseDNSFail means that the client address has no PTR record.
+	ReverseDNSFail
+)
+
+func (s ReverseDNSStatus) String() string {
+	switch s {
+	case ReverseDNSOK:
+		return "OK"
+	case ReverseDNSForged:
+		return "FORGED"
+	case ReverseDNSTemp:
+		return "TEMP"
+	case ReverseDNSFail:
+		return "FAIL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ReverseDNS is the reverse DNS hostname of the SMTP client and its verification result.
+type ReverseDNS struct {
+	// Hostname is the verified hostname of the client. It is only set when Status is [ReverseDNSOK].
+	Hostname string
+	// PTR is the (unverified) name of the PTR record of the client address. It is empty when the MTA did not send it
+	// or the client address has no PTR record. Do not trust it when Status is not [ReverseDNSOK].
+	PTR string
+	// Status is the verification result.
+	Status ReverseDNSStatus
+}
+
+// Verified returns true when the reverse DNS hostname of the client got verified.
+func (r ReverseDNS) Verified() bool {
+	return r.Status == ReverseDNSOK
+}
+
+// unknownHostname returns true when name is not a hostname but a placeholder of the MTA
+// (Postfix uses "unknown", sendmail the client address in brackets).
+func unknownHostname(name string) bool {
+	return name == "" || name == "unknown" || name[0] == '['
+}
+
+// ParseClientReverseDNS returns the reverse DNS information of the SMTP client that it parses from macros.
+// You need to request [MacroClientName], [MacroClientPTR] and (for sendmail) [MacroClientResolve] at [StageConnect]
+// with [WithMacroRequest] (sendmail and Postfix send the first two by default).
+//
+// Sendmail sends the verification result in [MacroClientResolve]. Postfix does not have this macro, the result gets
+// derived from [MacroClientName] (the verified hostname or "unknown") and [MacroClientPTR].
+// Postfix does not report temporary lookup errors in the macros, they show up as [ReverseDNSFail] or [ReverseDNSForged].
+func ParseClientReverseDNS(macros Macros) ReverseDNS {
+	if macros == nil {
+		return ReverseDNS{}
+	}
+	name, hasName := macros.GetEx(MacroClientName)
+	ptr, hasPtr := macros.GetEx(MacroClientPTR)
+	name, ptr = strings.TrimSuffix(strings.TrimSpace(name), "."), strings.TrimSuffix(strings.TrimSpace(ptr), ".")
+	if unknownHostname(name) {
+		name = ""
+	}
+	if unknownHostname(ptr) {
+		ptr = ""
+	}
+	r := ReverseDNS{PTR: ptr}
+	if resolve, ok := macros.GetEx(MacroClientResolve); ok {
+		switch strings.ToUpper(strings.TrimSpace(resolve)) {
+		case "OK":
+			r.Status = ReverseDNSOK
+		case "FORGED":
+			r.Status = ReverseDNSForged
+		case "TEMP":
+			r.Status = ReverseDNSTemp
+		case "FAIL":
+			r.Status = ReverseDNSFail
+		}
+	} else if hasName || hasPtr {
+		switch {
+		case name != "":
+			r.Status = ReverseDNSOK
+		case ptr != "":
+			r.Status = ReverseDNSForged
+		default:
+			r.Status = ReverseDNSFail
+		}
+	}
+	if r.Status == ReverseDNSOK {
+		r.Hostname = name
+		if r.Hostname == "" {
+			r.Hostname = ptr
+		}
+		if r.Hostname == "" {
+			// OK without any name does not make sense
+			r.Status = ReverseDNSUnknown
+		}
+	}
+	return r
+}
+
+// ClientReverseDNS returns the reverse DNS information of the SMTP client. See [ParseClientReverseDNS].
+func (m *Modifier) ClientReverseDNS() ReverseDNS {
+	return ParseClientReverseDNS(m.Macros)
+}
@@ -0,0 +1,61 @@
+package milter
+
+import "testing"
+
+func TestParseClientReverseDNS(t *testing.T) {
+	tests := []struct {
+		name   string
+		macros map[MacroName]string
+		want   ReverseDNS
+	}{
+		{"no macros", nil, ReverseDNS{}},
+		{"sendmail OK", map[MacroName]string{MacroClientName: "mail.example.com", MacroClientPTR: "mail.example.com", MacroClientResolve: "OK"}, ReverseDNS{"mail.example.com", "mail.example.com", ReverseDNSOK}},
+		{"sendmail OK trailing dot", map[MacroName]string{MacroClientName: "mail.example.com.", MacroClientResolve: "OK"}, ReverseDNS{"mail.example.com", "", ReverseDNSOK}},
+		{"sendmail FORGED", map[MacroName]string{MacroClientName: "[192.0.2.1]", MacroClientPTR: "forged.example.com", MacroClientResolve: "FORGED"}, ReverseDNS{"", "forged.example.com", ReverseDNSForged}},
+		{"sendmail TEMP", map[MacroName]string{MacroClientName: "[192.0.2.1]", MacroClientResolve: "TEMP"}, ReverseDNS{"", "", ReverseDNSTemp}},
+		{"sendmail FAIL", map[MacroName]string{MacroClientName: "[192.0.2.1]", MacroClientResolve: "fail"}, ReverseDNS{"", "", ReverseDNSFail}},
+		{"sendmail invalid", map[MacroName]string{MacroClientName: "mail.example.com", MacroClientResolve: "MAYBE"}, ReverseDNS{"", "", ReverseDNSUnknown}},
+		{"postfix OK", map[MacroName]string{MacroClientName: "mail.example.com", MacroClientPTR: "mail.example.com"}, ReverseDNS{"mail.example.com", "mail.example.com", ReverseDNSOK}},
+		{"postfix FORGED", map[MacroName]string{MacroClientName: "unknown", MacroClientPTR: "forged.example.com"}, ReverseDNS{"", "forged.example.com", ReverseDNSForged}},
+		{"postfix FAIL", map[MacroName]string{MacroClientName: "unknown", MacroClientPTR: "unknown"}, ReverseDNS{"", "", ReverseDNSFail}},
+		{"OK without name", map[MacroName]string{MacroClientResolve: "OK"}, ReverseDNS{"", "", ReverseDNSUnknown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			macros := NewMacroBag()
+			for k, v := range tt.macros {
+				macros.Set(k, v)
+			}
+			got := ParseClientReverseDNS(macros)
+			if got != tt.want {
+				t.Errorf("ParseClientReverseDNS() = %+v, want %+v", got, tt.want)
+			}
+			if got.Verified() != (tt.want.Status == ReverseDNSOK) {
+				t.Errorf("Verified() = %v", got.Verified())
+			}
+		})
+	}
+	if got := ParseClientReverseDNS(nil); got != (ReverseDNS{}) {
+		t.Errorf("ParseClientReverseDNS(nil) = %+v", got)
+	}
+}
+
+func TestReverseDNSStatus_String(t *testing.T) {
+	for s, want := range map[ReverseDNSStatus]string{
+		ReverseDNSUnknown: "UNKNOWN", ReverseDNSOK: "OK", ReverseDNSForged: "FORGED", ReverseDNSTemp: "TEMP", ReverseDNSFail: "FAIL", 99: "UNKNOWN",
+	} {
+		if got := s.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestModifier_ClientReverseDNS(t *testing.T) {
+	macros := NewMacroBag()
+	macros.Set(MacroClientName, "mail.example.com")
+	macros.Set(MacroClientResolve, "OK")
+	m := NewTestModifier(macros, nil, nil, AllClientSupportedActionMasks, DataSize64K)
+	if got := m.ClientReverseDNS(); got.Hostname != "mail.example.com" || !got.Verified() {
+		t.Errorf("ClientReverseDNS() = %+v", got)
+	}
+}
@@ -0,0 +1,237 @@
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	nettextproto "net/textproto"
+	"strings"
+
+	"github.com/d--j/go-milter/milterutil"
+	"github.com/emersion/go-message/textproto"
+	"golang.org/x/text/transform"
+)
+
+// Envelope is the SMTP connection and envelope data that [Client.SendMessage] sends to the milter.
+type Envelope struct {
+	// Hostname, Family, Port and Addr are the arguments of [ClientSession.Conn].
+	Hostname string
+	Family   ProtoFamily
+	Port     uint16
+	Addr     string
+	// Helo is the argument of [ClientSession.Helo].
+	Helo string
+	// From and FromArgs are the arguments of [ClientSession.Mail].
+	From     string
+	FromArgs string
+	// Rcpts are the recipients that get sent with [ClientSession.Rcpt] (without ESMTP arguments).
+	Rcpts []string
+	// Macros are the macros of the [ClientSession] (see [Client.Session]). Can be nil.
+	Macros Macros
+}
+
+// SendMessage runs one complete SMTP transaction with the milter: it opens a new [ClientSession] and calls
+// Conn, Helo, Mail, Rcpt (for every recipient of env), Header and BodyReadFrom with the RFC 822 message
+// that it reads from r. Line endings of the body get converted to CR LF like an MTA would do.
+//
+// SendMessage returns the modification actions of the milter and its final decision.
+// When the milter decided the transaction before the end of the message (e.g. it rejected the sender) SendMessage
+// returns this decision without modification actions. A recipient that the milter rejects gets dropped like an MTA
+// would do, the transaction only ends early when the milter rejected all recipients.
+//
+// When ctx gets canceled the connection to the milter gets closed and SendMessage returns the error of ctx.
+func (c *Client) SendMessage(ctx context.Context, env *Envelope, r io.Reader) ([]ModifyAction, *Action, error) {
+	if len(env.Rcpts) == 0 {
+		return nil, nil, errors.New("milter: send message: no recipients")
+	}
+	br := bufio.NewReader(r)
+	hdr, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("milter: send message: read header: %w", err)
+	}
+
+	session, err := c.Session(env.Macros)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer session.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = session.conn.Close()
+		case <-done:
+		}
+	}()
+
+	modifyActs, act, err := sendMessage(session, env, hdr, br)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, ctxErr
+	}
+	return modifyActs, act, err
+}
+
+func sendMessage(session *ClientSession, env *Envelope, hdr textproto.Header, body io.Reader) ([]ModifyAction, *Action, error) {
+	act, err := session.Conn(env.Hostname, env.Family, env.Port, env.Addr)
+	if err != nil || act.Type != ActionContinue {
+		return nil, act, err
+	}
+	act, err = session.Helo(env.Helo)
+	if err != nil || act.Type != ActionContinue {
+		return nil, act, err
+	}
+	act, err = session.Mail(env.From, env.FromArgs)
+	if err != nil || act.Type != ActionContinue {
+		return nil, act, err
+	}
+	var rejected *Action
+	accepted := 0
+	for _, rcpt := range env.Rcpts {
+		act, err = session.Rcpt(rcpt, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		switch act.Type {
+		case ActionContinue:
+			accepted++
+		case ActionReject, ActionTempFail, ActionRejectWithCode:
+			rejected = act
+		default:
+			return nil, act, nil
+		}
+	}
+	if accepted == 0 {
+		return nil, rejected, nil
+	}
+	act, err = session.Header(hdr)
+	if err != nil || act.Type != ActionContinue {
+		return nil, act, err
+	}
+	return session.BodyReadFrom(transform.NewReader(body, &milterutil.CrLfCanonicalizationTransformer{}))
+}
+
+// ApplyModifications applies modifyActs (the result of e.g. [Client.SendMessage] or [ClientSession.End])
+// in their order to a copy of env and to the RFC 822 message that it reads from r, like an MTA would do.
+// It returns the modified envelope and the modified message with CR LF line endings.
+// env can be nil, then the returned envelope is nil and envelope modifications get ignored.
+//
+//   - [ActionAddHeader] appends the header field at the end of the header.
+//   - [ActionInsertHeader] inserts the header field after the HeaderIndex-th field of the current header (0 means at the very beginning).
+//   - [ActionChangeHeader] changes the HeaderIndex-th field with the name HeaderName. An empty value removes the field
+//     (the indexes of the following fields change like in Postfix). When there is no such field a new field gets appended.
+//   - [ActionReplaceBody] replaces the body. Multiple ReplaceBody actions get concatenated.
+//   - [ActionChangeFrom], [ActionAddRcpt] and [ActionDelRcpt] change the envelope.
+//   - [ActionQuarantine] gets ignored.
+func ApplyModifications(env *Envelope, r io.Reader, modifyActs []ModifyAction) (*Envelope, []byte, error) {
+	br := bufio.NewReader(transform.NewReader(r, &milterutil.CrLfCanonicalizationTransformer{}))
+	hdr, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("milter: apply modifications: read header: %w", err)
+	}
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("milter: apply modifications: read body: %w", err)
+	}
+	type field struct {
+		key string
+		raw []byte
+	}
+	var fields []field
+	for f := hdr.Fields(); f.Next(); {
+		raw, err := f.Raw()
+		if err != nil {
+			return nil, nil, fmt.Errorf("milter: apply modifications: %w", err)
+		}
+		fields = append(fields, field{nettextproto.CanonicalMIMEHeaderKey(f.Key()), raw})
+	}
+	newField := func(act *ModifyAction) field {
+		var raw bytes.Buffer
+		raw.WriteString(act.HeaderName)
+		raw.WriteByte(':')
+		if act.HeaderValue == "" || (act.HeaderValue[0] != ' ' && act.HeaderValue[0] != '\t') {
+			raw.WriteByte(' ')
+		}
+		value, _, _ := transform.String(&milterutil.CrLfCanonicalizationTransformer{}, act.HeaderValue)
+		raw.WriteString(value)
+		raw.WriteString("\r\n")
+		return field{nettextproto.CanonicalMIMEHeaderKey(act.HeaderName), raw.Bytes()}
+	}
+
+	var newEnv *Envelope
+	if env != nil {
+		c := *env
+		c.Rcpts = append([]string(nil), env.Rcpts...)
+		newEnv = &c
+	}
+	bodyReplaced := false
+	for i := range modifyActs {
+		act := &modifyActs[i]
+		switch act.Type {
+		case ActionAddHeader:
+			fields = append(fields, newField(act))
+		case ActionInsertHeader:
+			idx := int(act.HeaderIndex)
+			if idx > len(fields) {
+				idx = len(fields)
+			}
+			fields = append(fields[:idx], append([]field{newField(act)}, fields[idx:]...)...)
+		case ActionChangeHeader:
+			f := newField(act)
+			n, changed := 0, false
+			for j := range fields {
+				if fields[j].key != f.key {
+					continue
+				}
+				n++
+				if n == int(act.HeaderIndex) {
+					if act.HeaderValue == "" {
+						fields = append(fields[:j], fields[j+1:]...)
+					} else {
+						fields[j] = f
+					}
+					changed = true
+					break
+				}
+			}
+			if !changed && act.HeaderValue != "" {
+				fields = append(fields, f)
+			}
+		case ActionReplaceBody:
+			if !bodyReplaced {
+				body, bodyReplaced = nil, true
+			}
+			body = append(body, act.Body...)
+		case ActionChangeFrom:
+			if newEnv != nil {
+				newEnv.From, newEnv.FromArgs = RemoveAngle(act.From), act.FromArgs
+			}
+		case ActionAddRcpt:
+			if newEnv != nil {
+				newEnv.Rcpts = append(newEnv.Rcpts, RemoveAngle(act.Rcpt))
+			}
+		case ActionDelRcpt:
+			if newEnv != nil {
+				rcpt := RemoveAngle(act.Rcpt)
+				rcpts := newEnv.Rcpts[:0]
+				for _, r := range newEnv.Rcpts {
+					if !strings.EqualFold(RemoveAngle(r), rcpt) {
+						rcpts = append(rcpts, r)
+					}
+				}
+				newEnv.Rcpts = rcpts
+			}
+		}
+	}
+
+	var message bytes.Buffer
+	for _, f := range fields {
+		message.Write(f.raw)
+	}
+	message.WriteString("\r\n")
+	message.Write(body)
+	return newEnv, message.Bytes(), nil
+}
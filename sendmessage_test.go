@@ -0,0 +1,129 @@
+package milter
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClient_SendMessage(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			_ = m.AddHeader("X-Message-Id", m.MessageID())
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithAction(OptAddHeader), WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithAction(OptAddHeader)})
+	defer w.Cleanup()
+
+	eml, err := os.ReadFile("testdata/sample.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Envelope{
+		Hostname: "mx.example.com",
+		Family:   FamilyInet,
+		Port:     25,
+		Addr:     "192.0.2.1",
+		Helo:     "mx.example.com",
+		From:     "sender@example.com",
+		Rcpts:    []string{"rcpt@example.com", "other@example.com"},
+	}
+	modifyActs, act, err := w.client.SendMessage(context.Background(), env, bytes.NewReader(eml))
+	assertAction(t, act, err, ActionAccept)
+	expected := []ModifyAction{{Type: ActionAddHeader, HeaderName: "X-Message-Id", HeaderValue: "sample@example.com"}}
+	if !reflect.DeepEqual(modifyActs, expected) {
+		t.Errorf("got modification actions %+v, want %+v", modifyActs, expected)
+	}
+	if mm.HeloValue != "mx.example.com" || mm.From != "sender@example.com" || !reflect.DeepEqual(mm.Rcpt, env.Rcpts) {
+		t.Errorf("milter got envelope %q %q %q", mm.HeloValue, mm.From, mm.Rcpt)
+	}
+	if got := mm.Hdr.Get("Subject"); got != "Test message" {
+		t.Errorf("milter got Subject %q", got)
+	}
+	var body []byte
+	for _, chunk := range mm.Chunks {
+		body = append(body, chunk...)
+	}
+	if string(body) != "Hello,\r\nthis is a sample message.\r\n" {
+		t.Errorf("milter got body %q", body)
+	}
+
+	mm.RcptResp = RespReject
+	_, act, err = w.client.SendMessage(context.Background(), env, bytes.NewReader(eml))
+	assertAction(t, act, err, ActionReject)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := w.client.SendMessage(ctx, env, bytes.NewReader(eml)); err != context.Canceled {
+		t.Errorf("SendMessage() with canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestApplyModifications(t *testing.T) {
+	t.Parallel()
+	message := "From: <sender@example.com>\nTo: <rcpt@example.com>\nReceived: one\nReceived: two\nSubject: test\n\nbody\n"
+	env := &Envelope{From: "sender@example.com", Rcpts: []string{"rcpt@example.com", "other@example.com"}}
+	tests := []struct {
+		name     string
+		acts     []ModifyAction
+		wantEnv  *Envelope
+		wantData string
+	}{
+		{"none", nil, env, "From: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nReceived: one\r\nReceived: two\r\nSubject: test\r\n\r\nbody\r\n"},
+		{"add header", []ModifyAction{{Type: ActionAddHeader, HeaderName: "X-Test", HeaderValue: "1\n 2"}}, env, "From: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nReceived: one\r\nReceived: two\r\nSubject: test\r\nX-Test: 1\r\n 2\r\n\r\nbody\r\n"},
+		{"insert header", []ModifyAction{
+			{Type: ActionInsertHeader, HeaderIndex: 0, HeaderName: "X-First", HeaderValue: "1"},
+			{Type: ActionInsertHeader, HeaderIndex: 3, HeaderName: "X-Third", HeaderValue: " 3"},
+			{Type: ActionInsertHeader, HeaderIndex: 99, HeaderName: "X-Last", HeaderValue: "4"},
+		}, env, "X-First: 1\r\nFrom: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nX-Third: 3\r\nReceived: one\r\nReceived: two\r\nSubject: test\r\nX-Last: 4\r\n\r\nbody\r\n"},
+		{"change header", []ModifyAction{
+			{Type: ActionChangeHeader, HeaderIndex: 2, HeaderName: "received", HeaderValue: "changed"},
+			{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "Subject", HeaderValue: ""},
+			{Type: ActionChangeHeader, HeaderIndex: 2, HeaderName: "To", HeaderValue: "<new@example.com>"},
+		}, env, "From: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nReceived: one\r\nreceived: changed\r\nTo: <new@example.com>\r\n\r\nbody\r\n"},
+		{"replace body", []ModifyAction{{Type: ActionReplaceBody, Body: []byte("new\r\n")}, {Type: ActionReplaceBody, Body: []byte("body\r\n")}}, env, "From: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nReceived: one\r\nReceived: two\r\nSubject: test\r\n\r\nnew\r\nbody\r\n"},
+		{"replace body empty", []ModifyAction{{Type: ActionReplaceBody}}, env, "From: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nReceived: one\r\nReceived: two\r\nSubject: test\r\n\r\n"},
+		{"envelope", []ModifyAction{
+			{Type: ActionChangeFrom, From: "<new@example.com>", FromArgs: "A=B"},
+			{Type: ActionDelRcpt, Rcpt: "<RCPT@example.com>"},
+			{Type: ActionAddRcpt, Rcpt: "<added@example.com>"},
+			{Type: ActionQuarantine, Reason: "test"},
+		}, &Envelope{From: "new@example.com", FromArgs: "A=B", Rcpts: []string{"other@example.com", "added@example.com"}}, "From: <sender@example.com>\r\nTo: <rcpt@example.com>\r\nReceived: one\r\nReceived: two\r\nSubject: test\r\n\r\nbody\r\n"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gotEnv, gotData, err := ApplyModifications(env, strings.NewReader(message), tt.acts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotEnv, tt.wantEnv) {
+				t.Errorf("ApplyModifications() envelope = %+v, want %+v", gotEnv, tt.wantEnv)
+			}
+			if string(gotData) != tt.wantData {
+				t.Errorf("ApplyModifications() message = %q, want %q", gotData, tt.wantData)
+			}
+		})
+	}
+	if !reflect.DeepEqual(env.Rcpts, []string{"rcpt@example.com", "other@example.com"}) {
+		t.Errorf("ApplyModifications() changed the input envelope: %+v", env)
+	}
+	if gotEnv, _, err := ApplyModifications(nil, strings.NewReader(message), []ModifyAction{{Type: ActionAddRcpt, Rcpt: "<a@example.com>"}}); err != nil || gotEnv != nil {
+		t.Errorf("ApplyModifications(nil) = %+v, %v", gotEnv, err)
+	}
+}
@@ -1,15 +1,19 @@
 package milter
 
 import (
+	"context"
 	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // MaxServerProtocolVersion is the maximum Milter protocol version implemented by the server.
 const MaxServerProtocolVersion uint32 = 6
 
-// ErrServerClosed is returned by the [Server]'s [Server.Serve] method after a call to [Server.Close].
+// ErrServerClosed is returned by the [Server]'s [Server.Serve] method after a call to [Server.Close] or [Server.Shutdown].
 var ErrServerClosed = errors.New("milter: server closed")
 
 // Milter is an interface for milter callback handlers.
@@ -17,6 +21,11 @@ type Milter interface {
 	// Connect is called to provide SMTP connection data for incoming message.
 	// Suppress with OptNoConnect.
 	//
+	// You can reject the SMTP client with a custom reply by returning a [Response] created with [RejectWithCodeAndReason].
+	// The SMTP client does not see the reply immediately: Postfix still sends its greeting and then replies with
+	// your code and text to every command of the client (except QUIT), starting with HELO/EHLO.
+	// Sendmail behaves similarly.
+	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoConnReply]) this response will be sent before closing the connection.
 	Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error)
@@ -40,6 +49,9 @@ type Milter interface {
 	RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error)
 
 	// Data is called at the beginning of the DATA command (after all RCPT TO commands). Suppress with [OptNoData].
+	// The MTA sends a rejecting [Response] (e.g. created with [RejectWithCodeAndReason]) as reply to the DATA command,
+	// so the SMTP client does not send the message at all. When you do not need the message to decide, this is more
+	// efficient than rejecting at [Milter.EndOfMessage].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoDataReply]) this response will be sent before closing the connection.
@@ -58,9 +70,12 @@ type Milter interface {
 	Headers(m *Modifier) (*Response, error)
 
 	// BodyChunk is called to process next message body chunk data (up to 64KB
-	// in size). Suppress with [OptNoBody]. If you return [RespSkip] the MTA will stop
-	// sending more body chunks. But older MTAs do not support this and in this case there are more calls to BodyChunk.
-	// Your code should be able to handle this.
+	// in size). Suppress with [OptNoBody].
+	//
+	// Return [RespSkip] when you have seen enough of the body (e.g. a signature matched) but do not want to reject the message.
+	// Other than [RespReject] the transaction continues: BodyChunk does not get called again for the current message
+	// and EndOfMessage gets called as usual. When you negotiated [OptSkip] the MTA stops sending the body. Otherwise,
+	// the MTA still sends the rest of the body and the [Server] acknowledges these chunks without calling BodyChunk.
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoBodyReply]) this response will be sent before closing the connection.
@@ -145,9 +160,14 @@ func (NoOpMilter) Cleanup() {
 
 // Server is a milter server.
 type Server struct {
-	options   options
-	listeners []net.Listener
-	closed    bool
+	// lastSessionID is the ID of the last session, see newSession. It is the first field so that it is 64-bit aligned.
+	lastSessionID uint64
+	options       options
+	mu            sync.Mutex // protects listeners, closed, conns and the Add calls of sessions
+	listeners     []net.Listener
+	closed        bool
+	conns         map[net.Conn]struct{}
+	sessions      sync.WaitGroup
 }
 
 // NewServer creates a new milter server.
@@ -159,11 +179,13 @@ type Server struct {
 // This function will panic when you provide invalid options.
 func NewServer(opts ...Option) *Server {
 	options := options{
-		maxVersion:   MaxServerProtocolVersion,
-		actions:      0,
-		protocol:     0,
-		readTimeout:  10 * time.Second,
-		writeTimeout: 10 * time.Second,
+		maxVersion:      MaxServerProtocolVersion,
+		actions:         0,
+		protocol:        0,
+		readTimeout:     10 * time.Second,
+		writeTimeout:    10 * time.Second,
+		shutdownTimeout: 10 * time.Second,
+		eventQueueSize:  defaultEventQueueSize,
 	}
 	if len(opts) > 0 {
 		for _, o := range opts {
@@ -185,6 +207,9 @@ func NewServer(opts ...Option) *Server {
 	if options.offeredMaxData > 0 {
 		panic("milter: WithOfferedMaxData is a client only option")
 	}
+	if options.mtaFlavor != 0 {
+		panic("milter: WithMTAFlavor is a client only option")
+	}
 	if options.macrosByStage != nil {
 		options.actions = options.actions | OptSetMacros
 	}
@@ -194,39 +219,243 @@ func NewServer(opts ...Option) *Server {
 
 // Serve starts the server.
 func (s *Server) Serve(ln net.Listener) error {
+	return s.serve(ln, "")
+}
+
+// ServeAll serves all listeners at the same time. The keys of listeners are labels that the milter can get with
+// [Modifier.ListenerLabel] to distinguish the entry points (e.g. "submission" for a unix socket and "mx" for a TCP listener).
+//
+// ServeAll blocks until all listeners stopped. When one listener fails, ServeAll closes the server
+// and returns the error of this listener. Otherwise, it returns [ErrServerClosed].
+func (s *Server) ServeAll(listeners map[string]net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for label, ln := range listeners {
+		go func(ln net.Listener, label string) {
+			errs <- s.serve(ln, label)
+		}(ln, label)
+	}
+	var firstErr error
+	for range listeners {
+		err := <-errs
+		if firstErr == nil || (firstErr == ErrServerClosed && err != ErrServerClosed) {
+			firstErr = err
+		}
+		if err != ErrServerClosed {
+			_ = s.Close()
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) serve(ln net.Listener, label string) error {
+	s.mu.Lock()
 	s.listeners = append(s.listeners, ln)
 	defer func(ln net.Listener, len int) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
 		if s.listeners[len-1] != nil {
 			_ = ln.Close()
 			s.listeners[len-1] = nil
 		}
 	}(ln, len(s.listeners))
+	s.mu.Unlock()
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			if s.closed {
+			if s.isClosed() {
 				return ErrServerClosed
 			}
 			return err
 		}
 
-		session := serverSession{
-			server:   s,
-			version:  s.options.maxVersion,
-			actions:  s.options.actions,
-			protocol: s.options.protocol,
-			conn:     conn,
-			macros:   newMacroStages(),
+		if !s.addSession(conn) {
+			_ = conn.Close()
+			return ErrServerClosed
 		}
-		go session.HandleMilterCommands()
+		go func() {
+			defer s.removeSession(conn)
+			session := s.newSession(conn)
+			session.listenerLabel = label
+			_ = session.HandleMilterCommands()
+		}()
+	}
+}
+
+// ServeContext is like [Server.Serve] but additionally shuts down the server when ctx is done.
+// It then stops accepting new connections and waits for the active sessions to end like [Server.Shutdown]
+// but at most for the timeout of [WithShutdownTimeout]. When all sessions ended in time ServeContext returns nil.
+// Otherwise, it closes the connections of the remaining sessions and returns [context.DeadlineExceeded]
+// without waiting for these sessions to end.
+// In all other cases it returns the error of [Server.Serve].
+func (s *Server) ServeContext(ctx context.Context, ln net.Listener) error {
+	shutdown := make(chan error, 1)
+	served := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), s.options.shutdownTimeout)
+			err := s.Shutdown(shutdownCtx)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) {
+				s.closeSessions()
+			}
+			shutdown <- err
+		case <-served:
+			close(shutdown)
+		}
+	}()
+	err := s.Serve(ln)
+	close(served)
+	if shutdownErr, ok := <-shutdown; ok {
+		return shutdownErr
+	}
+	return err
+}
+
+// Listen creates a listener on the network address like [net.Listen] and applies the backlog of [WithListenBacklog]
+// and the buffer sizes of [WithReadBufferSize] and [WithWriteBufferSize] to it.
+// Pass the listener to [Server.Serve] to start the server.
+func (s *Server) Listen(network, address string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if s.options.readBufferSize > 0 || s.options.writeBufferSize > 0 {
+		lc.Control = func(_, _ string, c syscall.RawConn) error {
+			var setErr error
+			if err := c.Control(func(fd uintptr) {
+				setErr = setSocketBuffers(fd, s.options.readBufferSize, s.options.writeBufferSize)
+			}); err != nil {
+				return err
+			}
+			return setErr
+		}
+	}
+	ln, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	if s.options.listenBacklog > 0 {
+		if err := setListenBacklog(ln, s.options.listenBacklog); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// ListenAndServe creates a listener with [Server.Listen] and calls [Server.Serve] with it.
+func (s *Server) ListenAndServe(network, address string) error {
+	ln, err := s.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// ServeConn handles the milter session of the MTA on the already established connection conn.
+// Use this instead of [Server.Serve] when you want to use the milter protocol over a transport
+// that does not have a [net.Listener] (e.g. a multiplexed stream).
+//
+// ServeConn blocks until the session ended and conn got closed.
+// It returns the error that ended the session or nil when the MTA closed the connection normally.
+func (s *Server) ServeConn(conn net.Conn) error {
+	if !s.addSession(conn) {
+		_ = conn.Close()
+		return ErrServerClosed
 	}
+	defer s.removeSession(conn)
+	return s.newSession(conn).HandleMilterCommands()
 }
 
+func (s *Server) newSession(conn net.Conn) *serverSession {
+	return &serverSession{
+		id:       atomic.AddUint64(&s.lastSessionID, 1),
+		server:   s,
+		version:  s.options.maxVersion,
+		actions:  s.options.actions,
+		protocol: s.options.protocol,
+		conn:     conn,
+		macros:   newMacroStages(),
+		done:     make(chan struct{}),
+	}
+}
+
+// Close stops the server by closing all its listeners.
+// Sessions that are still active do not get stopped, use [Server.Shutdown] to wait for them.
 func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.closed {
 		return ErrServerClosed
 	}
+	return s.closeListeners()
+}
+
+// Shutdown gracefully shuts down the server: it closes all listeners and then waits for all active sessions to end.
+// When ctx is done before all sessions ended, Shutdown returns the error of ctx. The remaining sessions keep running.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		if err := s.closeListeners(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	// s.closed is true now, so addSession does not add sessions while we wait
+	s.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addSession registers a new session on conn with s.sessions. It returns false when the server is already closed.
+// The check and the registration happen under s.mu, so Shutdown cannot start waiting for the sessions in between.
+func (s *Server) addSession(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.sessions.Add(1)
+	return true
+}
+
+// removeSession marks the session on conn as ended.
+func (s *Server) removeSession(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.sessions.Done()
+}
+
+// closeSessions closes the connections of all active sessions. The sessions end with a read or write error after that.
+func (s *Server) closeSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// closeListeners closes all listeners. s.mu must be held.
+func (s *Server) closeListeners() error {
 	s.closed = true
 	for _, ln := range s.listeners {
 		if ln != nil {
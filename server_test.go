@@ -2,7 +2,19 @@ package milter
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/d--j/go-milter/internal/wire"
 	"github.com/emersion/go-message/textproto"
@@ -113,3 +125,1530 @@ func TestServer_NoOpMilter(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestServer_Sender(t *testing.T) {
+	t.Parallel()
+	senders := map[string]string{}
+	record := func(stage string) func(m *Modifier) {
+		return func(m *Modifier) {
+			senders[stage] = m.Sender()
+		}
+	}
+	mm := newAcceptMilter()
+	mm.ConnMod = record("connect")
+	mm.HeloMod = record("helo")
+	mm.MailMod = record("mail")
+	mm.RcptMod = record("rcpt")
+	mm.DataMod = record("data")
+	mm.HdrMod = record("header")
+	mm.HdrsMod = record("headers")
+	mm.BodyChunkMod = record("body")
+	mm.BodyMod = record("eom")
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mm
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	// the null sender <> is the empty string
+	for _, tt := range []struct{ from, want string }{{"from@example.com", "from@example.com"}, {"other@example.com", "other@example.com"}, {"<>", ""}, {"", ""}} {
+		hdrs := textproto.Header{}
+		hdrs.Add("Subject", "test")
+		_, act, err := sendTransaction(t, w.session, tt.from, hdrs, "test\r\n")
+		assertAction(t, act, err, ActionAccept)
+		for _, stage := range []string{"mail", "rcpt", "data", "header", "headers", "body", "eom"} {
+			if got := senders[stage]; got != tt.want {
+				t.Errorf("Sender() in %s = %q, want %q", stage, got, tt.want)
+			}
+		}
+	}
+	for _, stage := range []string{"connect", "helo"} {
+		if got := senders[stage]; got != "" {
+			t.Errorf("Sender() in %s = %q, want empty string", stage, got)
+		}
+	}
+}
+
+func TestServer_EnvelopeSender(t *testing.T) {
+	t.Parallel()
+	senders := map[string]string{}
+	record := func(stage string) func(m *Modifier) {
+		return func(m *Modifier) {
+			senders[stage] = m.EnvelopeSender()
+		}
+	}
+	mm := newAcceptMilter()
+	mm.HdrMod = record("header")
+	mm.HdrsMod = record("headers")
+	mm.BodyChunkMod = record("body")
+	mm.BodyMod = record("eom")
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mm
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	hdrs := textproto.Header{}
+	hdrs.Add("Subject", "test")
+	_, act, err := sendTransaction(t, w.session, "From@Example.COM", hdrs, "test\r\n")
+	assertAction(t, act, err, ActionAccept)
+	for _, stage := range []string{"header", "headers", "body", "eom"} {
+		if got := senders[stage]; got != "from@example.com" {
+			t.Errorf("EnvelopeSender() in %s = %q, want %q", stage, got, "from@example.com")
+		}
+	}
+}
+
+func TestServer_DryRun(t *testing.T) {
+	var logged []ModifyAction
+	oldLogDryRun := LogDryRun
+	LogDryRun = func(act *ModifyAction) {
+		logged = append(logged, *act)
+	}
+	defer func() {
+		LogDryRun = oldLogDryRun
+	}()
+	mm := newAcceptMilter()
+	mm.BodyResp = RespReject
+	mm.BodyMod = func(m *Modifier) {
+		if err := m.AddHeader("X-Test", "1"); err != nil {
+			t.Errorf("AddHeader() error = %v", err)
+		}
+		if err := m.ChangeFrom("other@example.com", ""); err != nil {
+			t.Errorf("ChangeFrom() error = %v", err)
+		}
+	}
+	modifyActs, act, err := runTransaction(t, []Option{WithDryRun(true), WithAction(OptAddHeader | OptChangeFrom)}, mm)
+	assertAction(t, act, err, ActionReject)
+	if len(modifyActs) != 0 {
+		t.Errorf("got %d modification actions in dry-run mode: %+v", len(modifyActs), modifyActs)
+	}
+	expected := []ModifyAction{
+		{Type: ActionAddHeader, HeaderName: "X-Test", HeaderValue: "1"},
+		{Type: ActionChangeFrom, From: "<other@example.com>"},
+	}
+	if !reflect.DeepEqual(logged, expected) {
+		t.Errorf("LogDryRun got %+v, want %+v", logged, expected)
+	}
+}
+
+func TestServer_ConnectionHooks(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var events []string
+	closed := make(chan struct{}, 2)
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			mu.Lock()
+			events = append(events, "connect")
+			mu.Unlock()
+		},
+	}
+	s := NewServer(WithMilter(func() Milter {
+		return &mm
+	}), WithOnConnectionOpen(func(conn net.Conn) {
+		mu.Lock()
+		events = append(events, "open")
+		mu.Unlock()
+	}), WithOnConnectionClose(func(conn net.Conn, err error) {
+		if err != nil {
+			t.Errorf("OnConnectionClose got error %v", err)
+		}
+		mu.Lock()
+		events = append(events, "close")
+		mu.Unlock()
+		closed <- struct{}{}
+	}))
+	defer s.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	c := NewClient("tcp", ln.Addr().String())
+	for i := 0; i < 2; i++ {
+		session, err := c.Session(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		if err := session.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("OnConnectionClose was not called")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"open", "connect", "close", "open", "connect", "close"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %v, want %v", events, expected)
+	}
+}
+
+func TestServer_ChangeHeaderN(t *testing.T) {
+	t.Parallel()
+	mm := newAcceptMilter()
+	mm.BodyMod = func(m *Modifier) {
+		if err := m.ChangeHeaderN("X-Foo", 2, "changed"); err != nil {
+			t.Errorf("ChangeHeaderN(2) error = %v", err)
+		}
+		if err := m.ChangeHeaderN("x-foo", 4, "changed"); err != ErrHeaderIndexOutOfRange {
+			t.Errorf("ChangeHeaderN(4) error = %v, want %v", err, ErrHeaderIndexOutOfRange)
+		}
+		if err := m.ChangeHeaderN("X-Foo", 0, "changed"); err != ErrHeaderIndexOutOfRange {
+			t.Errorf("ChangeHeaderN(0) error = %v, want %v", err, ErrHeaderIndexOutOfRange)
+		}
+		if err := m.ChangeHeaderN("X-Bar", 1, "changed"); err != ErrHeaderIndexOutOfRange {
+			t.Errorf("ChangeHeaderN(X-Bar) error = %v, want %v", err, ErrHeaderIndexOutOfRange)
+		}
+	}
+	w := newServerClient(t, nil, []Option{WithAction(OptChangeHeader), WithMilter(func() Milter {
+		return mm
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	hdrs := textproto.Header{}
+	hdrs.AddRaw([]byte("X-Foo: one\r\n"))
+	hdrs.AddRaw([]byte("Subject: test\r\n"))
+	hdrs.AddRaw([]byte("X-Foo: two\r\n"))
+	hdrs.AddRaw([]byte("X-Foo: three\r\n"))
+	modifyActs, act, err := sendTransaction(t, w.session, "from@example.com", hdrs, "test\r\n")
+	assertAction(t, act, err, ActionAccept)
+	expected := []ModifyAction{
+		{Type: ActionChangeHeader, HeaderIndex: 2, HeaderName: "X-Foo", HeaderValue: "changed"},
+	}
+	if !reflect.DeepEqual(modifyActs, expected) {
+		t.Errorf("got modification actions %+v, want %+v", modifyActs, expected)
+	}
+}
+
+func TestServer_MessageID(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		headers []string
+		want    string
+	}{
+		{"absent", []string{"Subject: test\r\n"}, ""},
+		{"single", []string{"Subject: test\r\n", "Message-ID: <1234@example.com>\r\n"}, "1234@example.com"},
+		{"folded", []string{"Message-Id:\r\n <1234@example.com>\r\n"}, "1234@example.com"},
+		{"multiple", []string{"Message-ID: <first@example.com>\r\n", "Subject: test\r\n", "Message-ID: <second@example.com>\r\n"}, "first@example.com"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got string
+			mm := newAcceptMilter()
+			mm.BodyMod = func(m *Modifier) {
+				got = m.MessageID()
+			}
+			w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+				return mm
+			})}, nil)
+			defer w.Cleanup()
+			connect(t, w.session)
+			act, err := w.session.Mail("from@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Rcpt("to@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.DataStart()
+			assertAction(t, act, err, ActionContinue)
+			for _, h := range tt.headers {
+				parts := strings.SplitN(strings.TrimSuffix(h, "\r\n"), ":", 2)
+				act, err = w.session.HeaderField(parts[0], strings.TrimPrefix(parts[1], " "), nil)
+				assertAction(t, act, err, ActionContinue)
+			}
+			act, err = w.session.HeaderEnd()
+			assertAction(t, act, err, ActionContinue)
+			_, act, err = w.session.BodyReadFrom(strings.NewReader("test\r\n"))
+			assertAction(t, act, err, ActionAccept)
+			if got != tt.want {
+				t.Errorf("MessageID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func startPipeSession(t *testing.T, s *Server, milterConn, mtaConn net.Conn, negotiate bool) {
+	t.Helper()
+	session := &serverSession{
+		server:   s,
+		version:  s.options.maxVersion,
+		actions:  s.options.actions,
+		protocol: s.options.protocol,
+		conn:     milterConn,
+		macros:   newMacroStages(),
+	}
+	go session.HandleMilterCommands()
+	if !negotiate {
+		return
+	}
+	optNeg := &wire.Message{Code: wire.CodeOptNeg, Data: []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0}}
+	if err := wire.WritePacket(mtaConn, optNeg, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wire.ReadPacket(mtaConn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_ErrorHandler(t *testing.T) {
+	t.Parallel()
+	type handled struct {
+		err     error
+		session Session
+	}
+	tests := []struct {
+		name        string
+		negotiate   bool
+		msg         *wire.Message
+		wantSession bool
+	}{
+		{"negotiation error", false, &wire.Message{Code: wire.CodeOptNeg, Data: []byte{0, 0, 0, 6}}, false},
+		{"malformed packet", true, &wire.Message{Code: wire.CodeHeader, Data: []byte{0}}, true},
+		{"normal close", true, &wire.Message{Code: wire.CodeQuit}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mtaConn, milterConn := net.Pipe()
+			defer mtaConn.Close()
+			handlerCalls := make(chan handled, 1)
+			closed := make(chan struct{})
+			s := NewServer(WithMilter(func() Milter {
+				return &MockMilter{}
+			}), WithErrorHandler(func(err error, session Session) {
+				handlerCalls <- handled{err, session}
+			}), WithOnConnectionClose(func(conn net.Conn, err error) {
+				close(closed)
+			}))
+			startPipeSession(t, s, milterConn, mtaConn, tt.negotiate)
+			if err := wire.WritePacket(mtaConn, tt.msg, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-closed:
+			case <-time.After(time.Second):
+				t.Fatal("session did not end")
+			}
+			select {
+			case h := <-handlerCalls:
+				if tt.msg.Code == wire.CodeQuit {
+					t.Fatalf("error handler called for normal close: %v", h.err)
+				}
+				if h.err == nil {
+					t.Errorf("error handler got nil error")
+				}
+				if (h.session != nil) != tt.wantSession {
+					t.Errorf("error handler got session %v, want session %v", h.session, tt.wantSession)
+				}
+				if h.session != nil && h.session.Version() != 6 {
+					t.Errorf("session.Version() = %d, want 6", h.session.Version())
+				}
+			default:
+				if tt.msg.Code != wire.CodeQuit {
+					t.Fatal("error handler not called")
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ClosedConnection(t *testing.T) {
+	t.Parallel()
+	mtaConn, milterConn := net.Pipe()
+	eomCalled := make(chan struct{})
+	aborted := make(chan struct{}, 1)
+	closeErr := make(chan error, 1)
+	mm := MockMilter{
+		BodyResp: RespAccept,
+		BodyMod: func(m *Modifier) {
+			// the MTA closes the connection just before we send the EOM response
+			close(eomCalled)
+			_ = mtaConn.Close()
+		},
+		AbortMod: func(m *Modifier) {
+			aborted <- struct{}{}
+		},
+	}
+	s := NewServer(WithMilter(func() Milter {
+		return &mm
+	}), WithOnConnectionClose(func(conn net.Conn, err error) {
+		closeErr <- err
+	}))
+	startPipeSession(t, s, milterConn, mtaConn, true)
+	if err := wire.WritePacket(mtaConn, &wire.Message{Code: wire.CodeEOB}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	<-eomCalled
+	select {
+	case err := <-closeErr:
+		if err != nil {
+			t.Errorf("session ended with error %v, expected clean exit", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session did not end")
+	}
+	select {
+	case <-aborted:
+	default:
+		t.Error("Abort was not called")
+	}
+}
+
+func TestServer_Progress(t *testing.T) {
+	t.Parallel()
+	const mtaTimeout = 100 * time.Millisecond
+	for _, sendProgress := range []bool{false, true} {
+		sendProgress := sendProgress
+		t.Run(fmt.Sprintf("progress=%v", sendProgress), func(t *testing.T) {
+			t.Parallel()
+			mm := newAcceptMilter()
+			mm.BodyMod = func(m *Modifier) {
+				// slow processing that takes longer than the MTA waits
+				for i := 0; i < 6; i++ {
+					time.Sleep(mtaTimeout / 2)
+					if sendProgress {
+						if err := m.Progress(); err != nil {
+							t.Errorf("Progress() error = %v", err)
+						}
+					}
+				}
+			}
+			w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+				return mm
+			})}, []Option{WithReadTimeout(mtaTimeout)})
+			defer w.Cleanup()
+			connect(t, w.session)
+			_, act, err := sendTransaction(t, w.session, "from@example.com", textproto.Header{}, "test\r\n")
+			if sendProgress {
+				assertAction(t, act, err, ActionAccept)
+			} else if err == nil || !strings.Contains(err.Error(), "timeout") {
+				t.Errorf("BodyReadFrom() = %v, %v; want timeout error", act, err)
+			}
+		})
+	}
+}
+
+func TestServer_Negotiation(t *testing.T) {
+	t.Parallel()
+	var got *NegotiationResult
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			got = m.Negotiation()
+		},
+	}
+	w := newServerClient(t, nil, []Option{
+		WithMilter(func() Milter {
+			return &mm
+		}),
+		WithActions(OptAddHeader | OptChangeHeader),
+		WithProtocols(OptNoBody | OptNoUnknown),
+		WithMacroRequest(StageHelo, []MacroName{MacroTlsVersion}),
+	}, []Option{
+		WithMaximumVersion(4),
+		WithActions(OptAddHeader | OptChangeHeader | OptChangeBody | OptSetMacros),
+		WithProtocols(OptNoBody | OptNoUnknown | OptNoHelo),
+	})
+	defer w.Cleanup()
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	if got == nil {
+		t.Fatal("Negotiation() = nil")
+	}
+	want := NegotiationResult{
+		MTAVersion:    4,
+		MTAActions:    OptAddHeader | OptChangeHeader | OptChangeBody | OptSetMacros,
+		MTAProtocol:   OptNoBody | OptNoUnknown | OptNoHelo,
+		Version:       4,
+		Actions:       OptAddHeader | OptChangeHeader | OptSetMacros,
+		Protocol:      OptNoBody | OptNoUnknown,
+		MaxDataSize:   DataSize64K,
+		MacroRequests: make([][]MacroName, StageEndMarker),
+	}
+	want.MacroRequests[StageHelo] = []MacroName{MacroTlsVersion}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("Negotiation() = %+v, want %+v", *got, want)
+	}
+	if (&Modifier{}).Negotiation() != nil {
+		t.Errorf("Negotiation() of zero Modifier is not nil")
+	}
+}
+
+func TestServer_ServeConn(t *testing.T) {
+	t.Parallel()
+	mtaConn, milterConn := net.Pipe()
+	mm := newAcceptMilter()
+	mm.BodyMod = func(m *Modifier) {
+		_ = m.AddHeader("X-Test", "1")
+	}
+	s := NewServer(WithMilter(func() Milter {
+		return mm
+	}), WithAction(OptAddHeader))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeConn(milterConn)
+	}()
+	session, err := NewClientConn(mtaConn, nil, WithActions(AllClientSupportedActionMasks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect(t, session)
+	mActs, act, err := sendTransaction(t, session, "from@example.com", textproto.Header{}, "test\r\n")
+	assertAction(t, act, err, ActionAccept)
+	if len(mActs) != 1 || mActs[0].Type != ActionAddHeader || mActs[0].HeaderName != "X-Test" {
+		t.Errorf("got modifications %+v, want one added X-Test header", mActs)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeConn() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn() did not return")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeConn(milterConn); err != ErrServerClosed {
+		t.Errorf("ServeConn() on closed server = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServer_Listen(t *testing.T) {
+	t.Parallel()
+	s := NewServer(WithMilter(func() Milter {
+		return NoOpMilter{}
+	}), WithListenBacklog(1024))
+	defer s.Close()
+	ln, err := s.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	session, err := NewClient("tcp", ln.Addr().String()).Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+
+	if _, err := s.Listen("tcp", "not an address"); err == nil {
+		t.Error("Listen() with invalid address did not return an error")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithListenBacklog did not panic")
+		}
+	}()
+	NewClient("tcp", ln.Addr().String(), WithListenBacklog(1024))
+}
+
+func TestServer_Listen_BufferSizes(t *testing.T) {
+	t.Parallel()
+	s := NewServer(WithMilter(func() Milter {
+		return NoOpMilter{}
+	}), WithReadBufferSize(256*1024), WithWriteBufferSize(256*1024))
+	defer s.Close()
+	ln, err := s.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	session, err := NewClient("tcp", ln.Addr().String()).Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithReadBufferSize did not panic")
+		}
+	}()
+	NewClient("tcp", ln.Addr().String(), WithReadBufferSize(1024))
+}
+
+func BenchmarkServer_BufferSizes(b *testing.B) {
+	message := []byte("From: <from@example.com>\r\nTo: <to@example.com>\r\nSubject: benchmark\r\n\r\n" +
+		strings.Repeat("0123456789012345678901234567890123456789012345678901234567890123456789012345678\r\n", 16*1024))
+	env := &Envelope{Hostname: "localhost", Family: FamilyInet, Port: 2525, Addr: "127.0.0.1", Helo: "localhost", From: "from@example.com", Rcpts: []string{"to@example.com"}}
+	for _, size := range []int{0, 16 * 1024, 64 * 1024, 256 * 1024, 1024 * 1024} {
+		name := "default"
+		if size > 0 {
+			name = fmt.Sprintf("%dKiB", size/1024)
+		}
+		b.Run(name, func(b *testing.B) {
+			s := NewServer(WithMilter(func() Milter {
+				return NoOpMilter{}
+			}), WithReadBufferSize(size), WithWriteBufferSize(size))
+			defer s.Close()
+			ln, err := s.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			go func() {
+				_ = s.Serve(ln)
+			}()
+			client := NewClient("tcp", ln.Addr().String())
+			b.SetBytes(int64(len(message)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := client.SendMessage(context.Background(), env, bytes.NewReader(message)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ServeAll(t *testing.T) {
+	t.Parallel()
+	labels := make(chan string, 1)
+	s := NewServer(WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod: func(m *Modifier) {
+				labels <- m.ListenerLabel()
+			},
+		}
+	}))
+	listeners := map[string]net.Listener{}
+	for _, label := range []string{"mx", "submission"} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[label] = ln
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeAll(listeners)
+	}()
+	for label, ln := range listeners {
+		session, err := NewClient("tcp", ln.Addr().String()).Session(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		if got := <-labels; got != label {
+			t.Errorf("ListenerLabel() = %q, want %q", got, label)
+		}
+		_ = session.Close()
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("ServeAll() = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServer_LocalAddr(t *testing.T) {
+	t.Parallel()
+	localAddrs := make(chan net.Addr, 1)
+	s := NewServer(WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod: func(m *Modifier) {
+				localAddrs <- m.LocalAddr()
+			},
+		}
+	}))
+	defer s.Close()
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixLn, err := net.Listen("unix", t.TempDir()+"/milter.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ln := range []net.Listener{tcpLn, unixLn} {
+		go func(ln net.Listener) {
+			_ = s.Serve(ln)
+		}(ln)
+	}
+	for _, ln := range []net.Listener{tcpLn, unixLn} {
+		session, err := NewClient(ln.Addr().Network(), ln.Addr().String()).Session(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		got := <-localAddrs
+		if got == nil || got.Network() != ln.Addr().Network() || got.String() != ln.Addr().String() {
+			t.Errorf("LocalAddr() = %v, want %s %s", got, ln.Addr().Network(), ln.Addr())
+		}
+		_ = session.Close()
+	}
+	if got := NewTestModifier(nil, nil, nil, 0, 0).LocalAddr(); got != nil {
+		t.Errorf("LocalAddr() of test modifier = %v, want nil", got)
+	}
+}
+
+func TestNewClientConn_NegotiationError(t *testing.T) {
+	t.Parallel()
+	mtaConn, milterConn := net.Pipe()
+	go func() {
+		_, _ = wire.ReadPacket(milterConn, time.Second)
+		_ = wire.WritePacket(milterConn, &wire.Message{Code: wire.CodeOptNeg, Data: []byte{0}}, time.Second)
+	}()
+	if _, err := NewClientConn(mtaConn, nil); err == nil {
+		t.Fatal("NewClientConn() did not return an error")
+	}
+	if _, err := mtaConn.Write([]byte{0}); err == nil {
+		t.Error("NewClientConn() did not close the connection")
+	}
+}
+
+func Test_isClosedConnError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"net.ErrClosed", &net.OpError{Op: "write", Err: net.ErrClosed}, true},
+		{"io.ErrClosedPipe", io.ErrClosedPipe, true},
+		{"EPIPE", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"ECONNRESET", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, true},
+		{"other", errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		if got := isClosedConnError(tt.err); got != tt.want {
+			t.Errorf("isClosedConnError(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServer_MessageSize(t *testing.T) {
+	t.Parallel()
+	var headerSize, bodySize, messageSize int64
+	mm := newAcceptMilter()
+	mm.BodyMod = func(m *Modifier) {
+		headerSize, bodySize, messageSize = m.HeaderSize(), m.BodySize(), m.MessageSize()
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mm
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	// send two messages to check that the counters get reset
+	for i := 0; i < 2; i++ {
+		hdrs := textproto.Header{}
+		hdrs.Add("From", "<from@example.com>")
+		hdrs.Add("Subject", "test")
+		body := strings.Repeat("0123456789\r\n", 10000)
+		_, act, err := sendTransaction(t, w.session, "from@example.com", hdrs, body)
+		assertAction(t, act, err, ActionAccept)
+		// "From: <from@example.com>\r\n" + "Subject: test\r\n"
+		if headerSize != 26+15 {
+			t.Errorf("HeaderSize() = %d, want %d", headerSize, 26+15)
+		}
+		if bodySize != int64(len(body)) {
+			t.Errorf("BodySize() = %d, want %d", bodySize, len(body))
+		}
+		if messageSize != headerSize+2+bodySize {
+			t.Errorf("MessageSize() = %d, want %d", messageSize, headerSize+2+bodySize)
+		}
+	}
+}
+
+func TestServer_ConnectionLogger(t *testing.T) {
+	t.Parallel()
+	events := make(chan ConnectionEvent, 10)
+	w := newServerClient(t, NewMacroBag(), []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	}), WithConnectionLogger(func(event ConnectionEvent) {
+		events <- event
+	})}, nil)
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	if err := w.session.Reset(NewMacroBag()); err != nil {
+		t.Fatal(err)
+	}
+	act, err = w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	w.Cleanup()
+	want := []struct {
+		typ          ConnectionEventType
+		sessionCount int
+	}{{ConnectionConnected, 1}, {ConnectionReused, 2}, {ConnectionDisconnected, 2}}
+	var connectedAt time.Time
+	for i, expected := range want {
+		select {
+		case event := <-events:
+			if event.Type != expected.typ || event.SessionCount != expected.sessionCount {
+				t.Errorf("event %d = %s/%d, want %s/%d", i, event.Type, event.SessionCount, expected.typ, expected.sessionCount)
+			}
+			if event.RemoteAddr == nil {
+				t.Errorf("event %d has no RemoteAddr", i)
+			}
+			if i == 0 {
+				connectedAt = event.ConnectedAt
+				if connectedAt.IsZero() {
+					t.Errorf("event %d has no ConnectedAt", i)
+				}
+			} else if !event.ConnectedAt.Equal(connectedAt) {
+				t.Errorf("event %d ConnectedAt = %v, want %v", i, event.ConnectedAt, connectedAt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d (%s) not emitted", i, expected.typ)
+		}
+	}
+}
+
+func TestServer_MaxAddedRecipients(t *testing.T) {
+	t.Parallel()
+	var addErr error
+	var counts [3]int
+	mm := newAcceptMilter()
+	mm.BodyMod = func(m *Modifier) {
+		for _, r := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+			if addErr = m.AddRecipient(r, ""); addErr != nil {
+				break
+			}
+		}
+		if err := m.AddHeader("X-Test", "1"); err != nil {
+			t.Errorf("AddHeader() error = %v", err)
+		}
+		if err := m.InsertHeader(0, "X-Test", "2"); !errors.Is(err, ErrModificationLimitExceeded) {
+			t.Errorf("InsertHeader() error = %v, want ErrModificationLimitExceeded", err)
+		}
+		counts = [3]int{m.ModificationCount(ActionAddRcpt), m.ModificationCount(ActionAddHeader), m.ModificationCount(ActionInsertHeader)}
+	}
+	modifyActs, act, err := runTransaction(t, []Option{WithAction(OptAddRcpt | OptAddHeader), WithMaxAddedRecipients(2), WithMaxAddedHeaders(1)}, mm)
+	assertAction(t, act, err, ActionAccept)
+	if !errors.Is(addErr, ErrModificationLimitExceeded) {
+		t.Errorf("AddRecipient() error = %v, want ErrModificationLimitExceeded", addErr)
+	}
+	expected := []ModifyAction{
+		{Type: ActionAddRcpt, Rcpt: "<a@example.com>"},
+		{Type: ActionAddRcpt, Rcpt: "<b@example.com>"},
+		{Type: ActionAddHeader, HeaderName: "X-Test", HeaderValue: "1"},
+	}
+	if !reflect.DeepEqual(modifyActs, expected) {
+		t.Errorf("got modification actions %+v, want %+v", modifyActs, expected)
+	}
+	if counts != [3]int{2, 1, 0} {
+		t.Errorf("ModificationCount() = %v, want [2 1 0]", counts)
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithMaxAddedRecipients did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:1", WithMaxAddedRecipients(1))
+}
+
+func TestServer_BodyChunkSkip(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		protocol OptProtocol
+		wantSkip bool
+	}{
+		{"MTA with SMFIP_SKIP", OptSkip, true},
+		{"MTA without SMFIP_SKIP", 0, false},
+	}
+	for _, tt_ := range tests {
+		tt := tt_
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mm := newAcceptMilter()
+			mm.BodyChunkResp = RespSkip
+			w := newServerClient(t, nil, []Option{WithProtocol(tt.protocol), WithMilter(func() Milter {
+				return mm
+			})}, []Option{WithProtocol(tt.protocol)})
+			defer w.Cleanup()
+			connect(t, w.session)
+			act, err := w.session.Mail("from@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Rcpt("to@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Header(textproto.Header{})
+			assertAction(t, act, err, ActionContinue)
+			for _, chunk := range []string{"first", "second", "third"} {
+				act, err = w.session.BodyChunk([]byte(chunk))
+				assertAction(t, act, err, ActionContinue)
+			}
+			if w.session.Skip() != tt.wantSkip {
+				t.Errorf("Skip() = %v, want %v", w.session.Skip(), tt.wantSkip)
+			}
+			_, act, err = w.session.End()
+			assertAction(t, act, err, ActionAccept)
+			if len(mm.Chunks) != 1 || string(mm.Chunks[0]) != "first" {
+				t.Errorf("BodyChunk got called with %q, want only the first chunk", mm.Chunks)
+			}
+		})
+	}
+}
+
+func TestServer_DeleteRecipientAfterRcptSkip(t *testing.T) {
+	t.Parallel()
+	var deleteErr error
+	mm := newAcceptMilter()
+	mm.RcptResp = RespSkip
+	mm.BodyMod = func(m *Modifier) {
+		// the MTA did not send the RCPT TO of this recipient after the RespSkip
+		deleteErr = m.DeleteRecipient("second@example.com")
+	}
+	w := newServerClient(t, nil, []Option{WithProtocol(OptSkip), WithAction(OptRemoveRcpt), WithMilter(func() Milter {
+		return mm
+	})}, []Option{WithProtocol(OptSkip)})
+	defer w.Cleanup()
+	connect(t, w.session)
+	modifyActs, act, err := sendTransaction(t, w.session, "from@example.com", textproto.Header{}, "test\r\n")
+	assertAction(t, act, err, ActionAccept)
+	if deleteErr != nil {
+		t.Errorf("DeleteRecipient() error = %v, want nil", deleteErr)
+	}
+	expected := []ModifyAction{{Type: ActionDelRcpt, Rcpt: "<second@example.com>"}}
+	if !reflect.DeepEqual(modifyActs, expected) {
+		t.Errorf("got modification actions %+v, want %+v", modifyActs, expected)
+	}
+}
+
+func TestServer_ServeContext(t *testing.T) {
+	t.Parallel()
+	connected := make(chan struct{})
+	s := NewServer(WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod: func(m *Modifier) {
+				close(connected)
+			},
+		}
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeContext(ctx, ln)
+	}()
+	session, err := NewClient("tcp", ln.Addr().String()).Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	<-connected
+
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("ServeContext() returned %v before the active session ended", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := NewClient("tcp", ln.Addr().String()).Session(nil); err == nil {
+		t.Error("Session() after shutdown did not return an error")
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeContext() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeContext() did not return after the active session ended")
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() after shutdown = %v, want nil", err)
+	}
+}
+
+func TestServer_ServeContext_hungSession(t *testing.T) {
+	t.Parallel()
+	connected := make(chan struct{})
+	cleanedUp := make(chan struct{})
+	s := NewServer(WithShutdownTimeout(100*time.Millisecond), WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod: func(m *Modifier) {
+				close(connected)
+			},
+			OnClose: func() {
+				close(cleanedUp)
+			},
+		}
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeContext(ctx, ln)
+	}()
+	// the MTA keeps the connection open and never sends another command
+	session, err := NewClient("tcp", ln.Addr().String(), WithReadTimeout(time.Minute)).Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	<-connected
+
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ServeContext() = %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeContext() did not return while a session hung")
+	}
+	select {
+	case <-cleanedUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hung session did not end after ServeContext closed its connection")
+	}
+}
+
+func TestServer_MaxConnectionMessageBytes(t *testing.T) {
+	t.Parallel()
+	handlerErr := make(chan error, 1)
+	w := newServerClient(t, nil, []Option{WithMaxConnectionMessageBytes(10), WithErrorHandler(func(err error, _ Session) {
+		handlerErr <- err
+	}), WithMilter(func() Milter {
+		return newAcceptMilter()
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	for _, want := range []ActionType{ActionAccept, ActionTempFail} {
+		_, act, err := sendTransaction(t, w.session, "from@example.com", textproto.Header{}, "test\r\n")
+		assertAction(t, act, err, want)
+	}
+	select {
+	case err := <-handlerErr:
+		if !errors.Is(err, ErrConnectionMessageBytesExceeded) {
+			t.Errorf("got error %v, want ErrConnectionMessageBytesExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the connection")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithMaxConnectionMessageBytes did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithMaxConnectionMessageBytes(10))
+}
+
+func TestServer_DuplicateNegotiation(t *testing.T) {
+	t.Parallel()
+	mtaConn, milterConn := net.Pipe()
+	defer mtaConn.Close()
+	handlerErr := make(chan error, 1)
+	closed := make(chan struct{})
+	s := NewServer(WithMilter(func() Milter {
+		return &MockMilter{}
+	}), WithErrorHandler(func(err error, _ Session) {
+		handlerErr <- err
+	}), WithOnConnectionClose(func(conn net.Conn, err error) {
+		close(closed)
+	}))
+	startPipeSession(t, s, milterConn, mtaConn, true)
+	optNeg := &wire.Message{Code: wire.CodeOptNeg, Data: []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0}}
+	if err := wire.WritePacket(mtaConn, optNeg, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := wire.ReadPacket(mtaConn, time.Second); err == nil {
+		t.Fatalf("got response %+v to second negotiation, want closed connection", msg)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session did not end")
+	}
+	if err := <-handlerErr; !errors.Is(err, ErrDuplicateNegotiation) {
+		t.Errorf("got error %v, want ErrDuplicateNegotiation", err)
+	}
+}
+
+func TestServer_NegotiationHook(t *testing.T) {
+	t.Parallel()
+	var gotActions OptAction
+	var gotProtocol OptProtocol
+	w := newServerClient(t, nil, []Option{WithAction(OptAddHeader), WithProtocol(OptNoUnknown), WithNegotiationHook(func(actions OptAction, protocol OptProtocol) (OptAction, OptProtocol) {
+		gotActions, gotProtocol = actions, protocol
+		return actions | OptChangeFrom, protocol | OptNoHelo
+	}), WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	})}, nil)
+	defer w.Cleanup()
+	if gotActions != OptAddHeader || gotProtocol != OptNoUnknown {
+		t.Errorf("hook got %v %v, want %v %v", gotActions, gotProtocol, OptAddHeader, OptNoUnknown)
+	}
+	if !w.session.ActionOption(OptChangeFrom) {
+		t.Errorf("hook did not add action OptChangeFrom")
+	}
+	if !w.session.ProtocolOption(OptNoHelo) {
+		t.Errorf("hook did not add protocol option OptNoHelo")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithNegotiationHook did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithNegotiationHook(func(actions OptAction, protocol OptProtocol) (OptAction, OptProtocol) {
+		return actions, protocol
+	}))
+}
+
+func TestServer_OnNegotiationDowngrade(t *testing.T) {
+	t.Parallel()
+	mtaActions := WithActions(AllClientSupportedActionMasks &^ OptChangeBody)
+	newMilter := WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	})
+	t.Run("accept", func(t *testing.T) {
+		t.Parallel()
+		var gotActions OptAction
+		var gotProtocol OptProtocol
+		called := 0
+		w := newServerClient(t, nil, []Option{WithActions(OptAddHeader | OptChangeBody), newMilter, WithOnNegotiationDowngrade(func(missingActions OptAction, missingProtocol OptProtocol) error {
+			called++
+			gotActions, gotProtocol = missingActions, missingProtocol
+			return nil
+		})}, []Option{mtaActions})
+		defer w.Cleanup()
+		if called != 1 || gotActions != OptChangeBody || gotProtocol != 0 {
+			t.Errorf("callback called %d times with %v %v, want 1 time with %v 0", called, gotActions, gotProtocol, OptChangeBody)
+		}
+		if !w.session.ActionOption(OptAddHeader) || w.session.ActionOption(OptChangeBody) {
+			t.Errorf("negotiated actions are not %v", OptAddHeader)
+		}
+		act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+	})
+	t.Run("refuse", func(t *testing.T) {
+		t.Parallel()
+		s := NewServer(WithActions(OptAddHeader|OptChangeBody), newMilter, WithOnNegotiationDowngrade(func(missingActions OptAction, missingProtocol OptProtocol) error {
+			return errors.New("need OptChangeBody")
+		}))
+		defer s.Close()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = s.Serve(ln)
+		}()
+		if _, err := NewClient("tcp", ln.Addr().String(), mtaActions).Session(nil); err == nil {
+			t.Error("Session() did not fail")
+		}
+	})
+	t.Run("not called", func(t *testing.T) {
+		t.Parallel()
+		called := false
+		w := newServerClient(t, nil, []Option{WithActions(OptAddHeader), newMilter, WithOnNegotiationDowngrade(func(missingActions OptAction, missingProtocol OptProtocol) error {
+			called = true
+			return nil
+		})}, []Option{mtaActions})
+		defer w.Cleanup()
+		if called {
+			t.Error("callback called although the MTA granted all actions")
+		}
+	})
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithOnNegotiationDowngrade did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithOnNegotiationDowngrade(func(missingActions OptAction, missingProtocol OptProtocol) error {
+		return nil
+	}))
+}
+
+func TestServer_MaxHeaderValueBytes(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name string
+		resp *Response
+		want ActionType
+	}{
+		{"default", nil, ActionRejectWithCode},
+		{"custom", RespTempFail, ActionTempFail},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mm := newAcceptMilter()
+			w := newServerClient(t, nil, []Option{WithMaxHeaderValueBytes(100, tt.resp), WithMilter(func() Milter {
+				return mm
+			})}, nil)
+			defer w.Cleanup()
+			connect(t, w.session)
+			act, err := w.session.Mail("from@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Rcpt("to@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.DataStart()
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.HeaderField("Subject", strings.Repeat("a", 100), nil)
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.HeaderField("X-Huge", strings.Repeat("a", 1024*1024), nil)
+			assertAction(t, act, err, tt.want)
+			if tt.want == ActionRejectWithCode && act.SMTPCode != 552 {
+				t.Errorf("got code %d, want 552", act.SMTPCode)
+			}
+			if got := mm.Hdr.Get("X-Huge"); got != "" || len(mm.Hdr) != 1 {
+				t.Errorf("milter got header %v, want only the Subject", mm.Hdr)
+			}
+		})
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithMaxHeaderValueBytes did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithMaxHeaderValueBytes(10, nil))
+}
+
+func TestServer_MaxMessageHeaderSize(t *testing.T) {
+	t.Parallel()
+	var headerSize int64
+	mm := newAcceptMilter()
+	mm.HdrMod = func(m *Modifier) {
+		headerSize = m.HeaderSize()
+	}
+	w := newServerClient(t, nil, []Option{WithMaxMessageHeaderSize(100), WithMilter(func() Milter {
+		return mm
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+	act, err := w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	// "Subject: " + 80 bytes + CR LF = 91 bytes
+	act, err = w.session.HeaderField("Subject", strings.Repeat("a", 80), nil)
+	assertAction(t, act, err, ActionContinue)
+	if headerSize != 91 {
+		t.Errorf("HeaderSize() = %d, want 91", headerSize)
+	}
+	act, err = w.session.HeaderField("To", "to@example.com", nil)
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 552 {
+		t.Errorf("got code %d, want 552", act.SMTPCode)
+	}
+	if got := mm.Hdr.Get("To"); got != "" {
+		t.Errorf("milter got header %v, want only the Subject", mm.Hdr)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithMaxMessageHeaderSize did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithMaxMessageHeaderSize(10))
+}
+
+func TestServer_WriteTimeout(t *testing.T) {
+	t.Parallel()
+	mtaConn, milterConn := net.Pipe()
+	defer mtaConn.Close()
+	var replaceErr error
+	cleanup := make(chan struct{}, 1)
+	logger := &recordingPacketLogger{}
+	mm := newAcceptMilter()
+	mm.BodyMod = func(m *Modifier) {
+		replaceErr = m.ReplaceBody(bytes.NewReader(bytes.Repeat([]byte("a"), 1024*1024)))
+	}
+	mm.OnClose = func() {
+		select {
+		case cleanup <- struct{}{}:
+		default:
+		}
+	}
+	s := NewServer(WithMilter(func() Milter {
+		return mm
+	}), WithAction(OptChangeBody), WithWriteTimeout(50*time.Millisecond), WithPacketLogger(logger))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeConn(milterConn)
+	}()
+	session, err := NewClientConn(mtaConn, nil, WithActions(AllClientSupportedActionMasks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect(t, session)
+	act, err := session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Rcpt("to@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.BodyChunk([]byte("test\r\n"))
+	assertAction(t, act, err, ActionContinue)
+	if err := session.writePacket(&wire.Message{Code: wire.CodeEOB}); err != nil {
+		t.Fatal(err)
+	}
+	// the MTA reads the first replacement chunk and then stalls
+	if _, err := wire.ReadPacket(mtaConn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrWriteTimeout) {
+			t.Errorf("ServeConn() = %v, want ErrWriteTimeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeConn() did not return")
+	}
+	if !errors.Is(replaceErr, ErrWriteTimeout) {
+		t.Errorf("ReplaceBody() = %v, want ErrWriteTimeout", replaceErr)
+	}
+	select {
+	case <-cleanup:
+	default:
+		t.Error("Cleanup() was not called")
+	}
+	// only the replacement chunk that the MTA read got sent, the one that timed out must not be in the log
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	sent := 0
+	for _, p := range logger.packets {
+		if p == "send b" {
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Errorf("logged %d sent body replacement packets, want 1: %q", sent, logger.packets)
+	}
+}
+
+func TestServer_Done(t *testing.T) {
+	t.Parallel()
+	dones := make(chan (<-chan struct{}), 1)
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			dones <- m.Done()
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return &mm })}, nil)
+	act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	done := <-dones
+	select {
+	case <-done:
+		t.Fatal("Done() is closed before the connection ended")
+	default:
+	}
+	w.Cleanup()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Done() did not get closed after the connection ended")
+	}
+}
+
+func TestServer_MaxTransactionsPerConnection(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMaxTransactionsPerConnection(2), WithMilter(func() Milter {
+		return newAcceptMilter()
+	})}, nil)
+	defer w.Cleanup()
+	connect(t, w.session)
+
+	// first transaction ends with EOM, the following Abort does not count as another transaction
+	_, act, err := sendTransaction(t, w.session, "from@example.com", textproto.Header{}, "test\r\n")
+	assertAction(t, act, err, ActionAccept)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// second transaction ends with Abort
+	act, err = w.session.Mail("from@example.com", "")
+	assertAction(t, act, err, ActionContinue)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.session.Mail("from@example.com", ""); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Mail() after the transaction limit = %v, want ErrConnectionClosed", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithMaxTransactionsPerConnection did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithMaxTransactionsPerConnection(2))
+}
+
+func TestServer_TrafficShaper(t *testing.T) {
+	t.Parallel()
+	newMilter := WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	})
+	t.Run("slow", func(t *testing.T) {
+		t.Parallel()
+		w := newServerClient(t, nil, []Option{newMilter, WithTrafficShaper(20*time.Millisecond, 30*time.Millisecond)}, nil)
+		defer w.Cleanup()
+		start := time.Now()
+		act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("Conn() took %v, want at least 50ms", d)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		s := NewServer(newMilter, WithTrafficShaper(0, 200*time.Millisecond))
+		defer s.Close()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = s.Serve(ln)
+		}()
+		if _, err := NewClient("tcp", ln.Addr().String(), WithReadTimeout(100*time.Millisecond)).Session(nil); !errors.Is(err, ErrTimeout) {
+			t.Errorf("Session() = %v, want ErrTimeout", err)
+		}
+	})
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithTrafficShaper did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithTrafficShaper(time.Millisecond, 0))
+}
+
+func TestServer_TimingHeader(t *testing.T) {
+	t.Parallel()
+	newMilter := func(bodyResp *Response) *MockMilter {
+		mm := newAcceptMilter()
+		mm.MailMod = func(m *Modifier) { time.Sleep(20 * time.Millisecond) }
+		mm.BodyResp = bodyResp
+		mm.BodyMod = func(m *Modifier) { time.Sleep(10 * time.Millisecond) }
+		return mm
+	}
+	t.Run("set", func(t *testing.T) {
+		t.Parallel()
+		modifyActs, act, err := runTransaction(t, []Option{WithAction(OptAddHeader), WithTimingHeader("X-Milter-Time")}, newMilter(RespAccept))
+		assertAction(t, act, err, ActionAccept)
+		if len(modifyActs) != 1 || modifyActs[0].Type != ActionAddHeader || modifyActs[0].HeaderName != "X-Milter-Time" {
+			t.Fatalf("got modification actions %+v, want one X-Milter-Time header", modifyActs)
+		}
+		value := modifyActs[0].HeaderValue
+		if !strings.HasSuffix(value, "ms") {
+			t.Fatalf("X-Milter-Time = %q, want a duration in ms", value)
+		}
+		ms, err := strconv.ParseFloat(strings.TrimSuffix(value, "ms"), 64)
+		if err != nil {
+			t.Fatalf("X-Milter-Time = %q: %v", value, err)
+		}
+		if ms < 30 || ms > 5000 {
+			t.Errorf("X-Milter-Time = %q, want at least the 30ms the milter slept", value)
+		}
+	})
+	t.Run("unset", func(t *testing.T) {
+		t.Parallel()
+		modifyActs, act, err := runTransaction(t, []Option{WithAction(OptAddHeader)}, newMilter(RespAccept))
+		assertAction(t, act, err, ActionAccept)
+		if len(modifyActs) != 0 {
+			t.Errorf("got modification actions %+v, want none", modifyActs)
+		}
+	})
+	t.Run("rejected", func(t *testing.T) {
+		t.Parallel()
+		modifyActs, act, err := runTransaction(t, []Option{WithAction(OptAddHeader), WithTimingHeader("X-Milter-Time")}, newMilter(RespReject))
+		assertAction(t, act, err, ActionReject)
+		if len(modifyActs) != 0 {
+			t.Errorf("got modification actions %+v, want none", modifyActs)
+		}
+	})
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithTimingHeader did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithTimingHeader("X-Milter-Time"))
+}
+
+func TestServer_ReplaceBodyChunkSize(t *testing.T) {
+	t.Parallel()
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		name       string
+		chunkSize  int
+		wantChunks int
+	}{
+		{"default", 0, 2},
+		{"custom", 30000, 4},
+		{"capped by max data size", int(DataSize1M), 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mm := newAcceptMilter()
+			mm.BodyMod = func(m *Modifier) {
+				if err := m.ReplaceBody(bytes.NewReader(body)); err != nil {
+					t.Errorf("ReplaceBody() error = %v", err)
+				}
+			}
+			modifyActs, act, err := runTransaction(t, []Option{WithAction(OptChangeBody), WithReplaceBodyChunkSize(tt.chunkSize)}, mm)
+			assertAction(t, act, err, ActionAccept)
+			var got []byte
+			for _, a := range modifyActs {
+				if a.Type != ActionReplaceBody {
+					t.Fatalf("got modification action %+v, want only body replacements", a)
+				}
+				if tt.chunkSize > 0 && len(a.Body) > tt.chunkSize {
+					t.Errorf("got chunk with %d bytes, want at most %d", len(a.Body), tt.chunkSize)
+				}
+				got = append(got, a.Body...)
+			}
+			if len(modifyActs) != tt.wantChunks {
+				t.Errorf("got %d body replacement packets, want %d", len(modifyActs), tt.wantChunks)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("replaced body has %d bytes, want %d", len(got), len(body))
+			}
+		})
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithReplaceBodyChunkSize did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithReplaceBodyChunkSize(1024))
+}
@@ -7,13 +7,79 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/textproto"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/d--j/go-milter/internal/wire"
 )
 
 var errCloseSession = errors.New("stop current milter processing")
 
+// ErrDuplicateNegotiation is the error that ends a connection when the MTA sends a second option negotiation (SMFIC_OPTNEG)
+// after the negotiation completed. The [Server] does not renegotiate a connection, it closes it without a response.
+var ErrDuplicateNegotiation = withCategory(ErrProtocol, errors.New("milter: negotiate: can only be called once in a connection"))
+
+// respHeaderValueTooLong is the default response of [WithMaxHeaderValueBytes]
+var respHeaderValueTooLong, _ = RejectWithCodeAndReason(552, "5.3.4 Header field value too long")
+
+// respMessageHeaderTooBig is the response of [WithMaxMessageHeaderSize]
+var respMessageHeaderTooBig, _ = RejectWithCodeAndReason(552, "5.3.4 Message header too big")
+
+// ErrConnectionMessageBytesExceeded gets wrapped in the error that ends a connection
+// because the limit of [WithMaxConnectionMessageBytes] was exceeded.
+var ErrConnectionMessageBytesExceeded = errors.New("milter: connection message bytes limit exceeded")
+
+// ErrWriteTimeout gets wrapped in the error that ends a connection because a write to the MTA
+// took longer than the timeout of [WithWriteTimeout].
+var ErrWriteTimeout = withCategory(ErrTimeout, errors.New("milter: write timeout exceeded"))
+
+// Session is the read-only view of the connection of one MTA to a [Server].
+type Session interface {
+	// Version returns the negotiated milter protocol version.
+	Version() uint32
+	// Actions returns the negotiated actions.
+	Actions() OptAction
+	// Protocol returns the negotiated protocol options.
+	Protocol() OptProtocol
+	// MaxDataSize returns the negotiated maximum data size.
+	MaxDataSize() DataSize
+	// RemoteAddr returns the network address of the MTA.
+	RemoteAddr() net.Addr
+	// LocalAddr returns the network address of the [Server] side of the connection.
+	// When the [Server] serves multiple listeners this tells you which listener accepted the connection.
+	LocalAddr() net.Addr
+	// Macros returns the macros the MTA sent so far.
+	Macros() Macros
+	// Done returns a channel that gets closed when the connection to the MTA ended,
+	// regardless of whether the MTA closed it, an error occurred or a timeout was hit.
+	// Goroutines that outlive a callback can use it to stop their work.
+	Done() <-chan struct{}
+}
+
+// NegotiationResult describes the outcome of the protocol negotiation between the MTA and the [Server].
+type NegotiationResult struct {
+	// MTAVersion is the milter protocol version the MTA offered.
+	MTAVersion uint32
+	// MTAActions are the actions the MTA offered.
+	MTAActions OptAction
+	// MTAProtocol are the protocol options the MTA offered.
+	MTAProtocol OptProtocol
+	// Version is the agreed milter protocol version.
+	Version uint32
+	// Actions are the agreed actions. Modifications that are not part of Actions
+	// fail with [ErrModificationNotAllowed].
+	Actions OptAction
+	// Protocol are the agreed protocol options.
+	Protocol OptProtocol
+	// MaxDataSize is the maximum data size of one packet.
+	MaxDataSize DataSize
+	// MacroRequests are the macros the [Server] requested for each [MacroStage] (index is the stage).
+	// It is nil when no macros were requested or the MTA does not support macro requests.
+	MacroRequests [][]MacroName
+}
+
 // serverSession keeps session state during MTA communication
 type serverSession struct {
 	server      *Server
@@ -24,24 +90,130 @@ type serverSession struct {
 	conn        net.Conn
 	macros      *macrosStages
 	backend     Milter
+	sender      string
+	headerSize  int64
+	bodySize    int64
+	// connectionBodySize is the sum of the body bytes of all messages of the current SMTP session
+	connectionBodySize int64
+	headerCount        map[string]int
+	messageID          string
+	rcptTos            []string
+	// rcptTosIncomplete is true when the backend responded with RespSkip or RespAccept to a RCPT TO of the current message.
+	// The MTA does not send the remaining RCPT TO commands in that case, so rcptTos does not contain all recipients.
+	rcptTosIncomplete bool
+	// helo and heloAnnotation are kept for the whole SMTP session
+	helo           string
+	heloAnnotation string
+	// bodySkipped is true when the backend responded with RespSkip to a body chunk of the current message
+	bodySkipped bool
+	// processingTime is the time the backend spent in the callbacks of the current message (see WithTimingHeader)
+	processingTime time.Duration
+	negotiation    *NegotiationResult
+	// listenerLabel is the label of the listener that accepted conn (see Server.ServeAll)
+	listenerLabel string
+	// id identifies this connection in the packets of WithPacketLogger
+	id uint64
+	// connectedAt and sessionCount are only used for the events of WithConnectionLogger
+	connectedAt  time.Time
+	sessionCount int
+	// writeErr is the error of a failed write, the connection cannot be used after it
+	writeErr error
+	// done gets closed when HandleMilterCommands closed conn
+	done chan struct{}
+	// transactions counts the SMTP transactions that ended in this connection,
+	// inTransaction is true between MAIL FROM and EOM/Abort
+	transactions  int
+	inTransaction bool
+	// events is the queue of WithEventHook, droppedEvents counts the events that did not fit into it
+	events        chan Event
+	droppedEvents int
 }
 
+func (m *serverSession) Version() uint32 {
+	return m.version
+}
+
+func (m *serverSession) Actions() OptAction {
+	return m.actions
+}
+
+func (m *serverSession) Protocol() OptProtocol {
+	return m.protocol
+}
+
+func (m *serverSession) MaxDataSize() DataSize {
+	return m.maxDataSize
+}
+
+func (m *serverSession) RemoteAddr() net.Addr {
+	return m.conn.RemoteAddr()
+}
+
+func (m *serverSession) LocalAddr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+func (m *serverSession) Macros() Macros {
+	return &macroReader{macrosStages: m.macros}
+}
+
+func (m *serverSession) Done() <-chan struct{} {
+	return m.done
+}
+
+var _ Session = (*serverSession)(nil)
+
 // readPacket reads incoming milter packet
 func (m *serverSession) readPacket() (*wire.Message, error) {
-	return wire.ReadPacket(m.conn, 0)
+	if m.server != nil && m.server.options.readDelay > 0 {
+		time.Sleep(m.server.options.readDelay)
+	}
+	msg, err := wire.ReadPacket(m.conn, 0)
+	if err != nil {
+		return nil, connError(err)
+	}
+	if m.server != nil && m.server.options.packetLogger != nil {
+		m.server.options.packetLogger.LogPacket(m.id, DirectionRecv, byte(msg.Code), msg.Data)
+	}
+	return msg, nil
 }
 
 // writePacket sends a milter response packet to socket stream
 func (m *serverSession) writePacket(msg *wire.Message) error {
-	return wire.WritePacket(m.conn, msg, 0)
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	var timeout time.Duration
+	if m.server != nil {
+		timeout = m.server.options.writeTimeout
+		if m.server.options.writeDelay > 0 {
+			time.Sleep(m.server.options.writeDelay)
+		}
+	}
+	if err := wire.WritePacket(m.conn, msg, timeout); err != nil {
+		// a packet might be half-written, so the MTA would not understand any further packet
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			err = fmt.Errorf("%w: %v", ErrWriteTimeout, err)
+		} else {
+			err = connError(err)
+		}
+		m.writeErr = err
+		return err
+	}
+	// only log packets that the MTA got
+	if m.server != nil && m.server.options.packetLogger != nil {
+		m.server.options.packetLogger.LogPacket(m.id, DirectionSend, byte(msg.Code), msg.Data)
+	}
+	return nil
 }
 
 func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milterActions OptAction, milterProtocol OptProtocol, callback NegotiationCallbackFunc, macroRequests macroRequests, usedMaxData DataSize) (*Response, error) {
 	if msg.Code != wire.CodeOptNeg {
-		return nil, fmt.Errorf("milter: negotiate: unexpected package with code %c", msg.Code)
+		return nil, errorf(ErrProtocol, "milter: negotiate: unexpected package with code %c", msg.Code)
 	}
 	if len(msg.Data) < 4*3 /* version + action mask + proto mask */ {
-		return nil, fmt.Errorf("milter: negotiate: unexpected data size: %d", len(msg.Data))
+		return nil, errorf(ErrProtocol, "milter: negotiate: unexpected data size: %d", len(msg.Data))
 	}
 	mtaVersion := binary.BigEndian.Uint32(msg.Data[:4])
 	mtaActionMask := OptAction(binary.BigEndian.Uint32(msg.Data[4:]))
@@ -54,29 +226,42 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 	}
 	mtaProtoMask = mtaProtoMask & (^OptProtocol(optInternal))
 
+	var onDowngrade func(missingActions OptAction, missingProtocol OptProtocol) error
+	if m.server != nil {
+		onDowngrade = m.server.options.onNegotiationDowngrade
+	}
+
 	var err error
 	var maxDataSize DataSize
 	if callback != nil {
 		if m.version, m.actions, m.protocol, maxDataSize, err = callback(mtaVersion, milterVersion, mtaActionMask, milterActions, mtaProtoMask, milterProtocol, offeredMaxDataSize); err != nil {
-			return nil, err
+			return nil, withCategory(ErrNegotiation, err)
 		}
 	} else {
 		if mtaVersion < 2 || mtaVersion > MaxServerProtocolVersion {
-			return nil, fmt.Errorf("milter: negotiate: unsupported protocol version: %d", mtaVersion)
+			return nil, errorf(ErrNegotiation, "milter: negotiate: unsupported protocol version: %d", mtaVersion)
 		}
 		m.version = mtaVersion
-		if milterActions&mtaActionMask != milterActions {
-			return nil, fmt.Errorf("milter: negotiate: MTA does not offer required actions. offered: %032b requested: %032b", mtaActionMask, milterActions)
+		if milterActions&mtaActionMask != milterActions && onDowngrade == nil {
+			return nil, errorf(ErrNegotiation, "milter: negotiate: MTA does not offer required actions. offered: %032b requested: %032b", mtaActionMask, milterActions)
 		}
 		m.actions = milterActions & mtaActionMask
-		if milterProtocol&mtaProtoMask != milterProtocol {
-			return nil, fmt.Errorf("milter: negotiate: MTA does not offer required protocol options. offered: %032b requested: %032b", mtaProtoMask, milterProtocol)
+		if milterProtocol&mtaProtoMask != milterProtocol && onDowngrade == nil {
+			return nil, errorf(ErrNegotiation, "milter: negotiate: MTA does not offer required protocol options. offered: %032b requested: %032b", mtaProtoMask, milterProtocol)
 		}
 		m.protocol = milterProtocol & mtaProtoMask
 		maxDataSize = offeredMaxDataSize
 	}
+	if m.server != nil && m.server.options.negotiationHook != nil {
+		m.actions, m.protocol = m.server.options.negotiationHook(m.actions, m.protocol)
+	}
+	if missingActions, missingProtocol := milterActions&^m.actions, milterProtocol&^m.protocol; onDowngrade != nil && (missingActions != 0 || missingProtocol != 0) {
+		if err := onDowngrade(missingActions, missingProtocol); err != nil {
+			return nil, errorf(ErrNegotiation, "milter: negotiate: %w", err)
+		}
+	}
 	if m.version < 2 || m.version > MaxServerProtocolVersion {
-		return nil, fmt.Errorf("milter: negotiate: unsupported protocol version: %d", m.version)
+		return nil, errorf(ErrNegotiation, "milter: negotiate: unsupported protocol version: %d", m.version)
 	}
 	if maxDataSize != DataSize64K && maxDataSize != DataSize256K && maxDataSize != DataSize1M {
 		maxDataSize = DataSize64K
@@ -102,8 +287,21 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 			return nil, fmt.Errorf("milter: negotiate: %w", err)
 		}
 	}
+	m.negotiation = &NegotiationResult{
+		MTAVersion:  mtaVersion,
+		MTAActions:  mtaActionMask,
+		MTAProtocol: mtaProtoMask,
+		Version:     m.version,
+		Actions:     m.actions,
+		Protocol:    m.protocol,
+		MaxDataSize: m.maxDataSize,
+	}
 	// send the macros we want to have in the response
 	if macroRequests != nil && mtaActionMask&OptSetMacros != 0 {
+		m.negotiation.MacroRequests = make([][]MacroName, len(macroRequests))
+		for st := range macroRequests {
+			m.negotiation.MacroRequests[st] = append([]MacroName(nil), macroRequests[st]...)
+		}
 		for st := 0; st < int(StageEndMarker) && st < len(macroRequests); st++ {
 			if macroRequests[st] != nil && len(macroRequests[st]) > 0 {
 				if err := binary.Write(&buffer, binary.BigEndian, uint32(st)); err != nil {
@@ -126,15 +324,19 @@ func (m *serverSession) newBackend() Milter {
 
 // Process processes incoming milter commands
 func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
+	if v := commandVersion(msg.Code); m.version < v {
+		return nil, errorf(ErrProtocol, "milter: command %q (0x%02x) is not valid in the negotiated protocol version %d (needs version %d)", byte(msg.Code), byte(msg.Code), m.version, v)
+	}
 	switch msg.Code {
 	case wire.CodeOptNeg:
-		return nil, fmt.Errorf("milter: negotiate: can only be called once in a connection")
+		return nil, ErrDuplicateNegotiation
 
 	case wire.CodeConn:
 		if len(msg.Data) == 0 {
-			return nil, fmt.Errorf("milter: conn: unexpected data size: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "milter: conn: unexpected data size: %d", len(msg.Data))
 		}
 		m.macros.DelStageAndAbove(StageHelo)
+		m.helo, m.heloAnnotation = "", ""
 		hostname := wire.ReadCString(msg.Data)
 		msg.Data = msg.Data[len(hostname)+1:]
 		// get protocol family
@@ -145,7 +347,7 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		var address string
 		if protocolFamily == 'L' || protocolFamily == '4' || protocolFamily == '6' {
 			if len(msg.Data) < 2 {
-				return nil, fmt.Errorf("milter: conn: unexpected data size: %d", len(msg.Data))
+				return nil, errorf(ErrProtocol, "milter: conn: unexpected data size: %d", len(msg.Data))
 			}
 			port = binary.BigEndian.Uint16(msg.Data)
 			msg.Data = msg.Data[2:]
@@ -163,7 +365,7 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 			family = "tcp4"
 			addr := net.ParseIP(address)
 			if addr == nil || addr.To4() == nil {
-				return nil, fmt.Errorf("milter: conn: unexpected ip4 address: %q", address)
+				return nil, errorf(ErrProtocol, "milter: conn: unexpected ip4 address: %q", address)
 			}
 		case '6':
 			family = "tcp6"
@@ -183,10 +385,10 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 				addr = net.ParseIP(address)
 			}
 			if addr == nil {
-				return nil, fmt.Errorf("milter: conn: unexpected ip6 address: %q", address)
+				return nil, errorf(ErrProtocol, "milter: conn: unexpected ip6 address: %q", address)
 			}
 		default:
-			return nil, fmt.Errorf("milter: conn: unexpected protocol family: %c", protocolFamily)
+			return nil, errorf(ErrProtocol, "milter: conn: unexpected protocol family: %c", protocolFamily)
 		}
 		// run handler and return
 		return m.backend.Connect(
@@ -198,28 +400,33 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 
 	case wire.CodeHelo:
 		if len(msg.Data) == 0 {
-			return nil, fmt.Errorf("milter: helo: unexpected data size: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "milter: helo: unexpected data size: %d", len(msg.Data))
 		}
 		m.macros.DelStageAndAbove(StageMail)
 		name := wire.ReadCString(msg.Data)
+		m.helo, m.heloAnnotation = name, ""
 		return m.backend.Helo(name, newModifier(m, true))
 
 	case wire.CodeMail:
 		if len(msg.Data) == 0 {
-			return nil, fmt.Errorf("milter: mail: unexpected data size: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "milter: mail: unexpected data size: %d", len(msg.Data))
 		}
 		m.macros.DelStageAndAbove(StageRcpt)
+		m.resetMessage()
 		from := wire.ReadCString(msg.Data)
 		msg.Data = msg.Data[len(from)+1:]
+		// the null sender <> becomes the empty string
+		m.sender = RemoveAngle(strings.TrimSpace(from))
+		m.inTransaction = true
 
 		// the rest of the data are ESMTP arguments, separated by a zero byte.
 		esmtpArgs := strings.Join(wire.DecodeCStrings(msg.Data), " ")
 
-		return m.backend.MailFrom(RemoveAngle(from), esmtpArgs, newModifier(m, true))
+		return m.backend.MailFrom(m.sender, esmtpArgs, newModifier(m, true))
 
 	case wire.CodeRcpt:
 		if len(msg.Data) == 0 {
-			return nil, fmt.Errorf("milter: rcpt: unexpected data size: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "milter: rcpt: unexpected data size: %d", len(msg.Data))
 		}
 		m.macros.DelStageAndAbove(StageData)
 		to := wire.ReadCString(msg.Data)
@@ -228,7 +435,15 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		// the rest of the data are ESMTP arguments, separated by a zero byte.
 		esmtpArgs := strings.Join(wire.DecodeCStrings(msg.Data), " ")
 
-		return m.backend.RcptTo(RemoveAngle(to), esmtpArgs, newModifier(m, true))
+		resp, err := m.backend.RcptTo(RemoveAngle(to), esmtpArgs, newModifier(m, true))
+		if err == nil && resp != nil && !rejectsOnlyRecipient(wire.CodeRcpt, resp) {
+			m.rcptTos = append(m.rcptTos, RemoveAngle(to))
+			switch wire.ActionCode(resp.code) {
+			case wire.ActSkip, wire.ActAccept:
+				m.rcptTosIncomplete = true
+			}
+		}
+		return resp, err
 
 	case wire.CodeData:
 		m.macros.DelStageAndAbove(StageEOH)
@@ -236,12 +451,32 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 
 	case wire.CodeHeader:
 		if len(msg.Data) < 2 {
-			return nil, fmt.Errorf("milter: header: unexpected data size: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "milter: header: unexpected data size: %d", len(msg.Data))
 		}
 		// add new header to headers map
 		headerData := wire.DecodeCStrings(msg.Data)
 		if len(headerData) != 2 {
-			return nil, fmt.Errorf("milter: header: unexpected number of strings: %d", len(headerData))
+			return nil, errorf(ErrProtocol, "milter: header: unexpected number of strings: %d", len(headerData))
+		}
+		m.headerSize += headerFieldSize(headerData[0], headerData[1], m.protocolOption(OptHeaderLeadingSpace))
+		if limit := m.server.options.maxHeaderValueBytes; limit > 0 && len(headerData[1]) > limit {
+			LogWarning("header field %s has a value of %d bytes, limit is %d", headerData[0], len(headerData[1]), limit)
+			if resp := m.server.options.headerValueTooLongResp; resp != nil {
+				return resp, nil
+			}
+			return respHeaderValueTooLong, nil
+		}
+		if limit := m.server.options.maxMessageHeaderSize; limit > 0 && m.headerSize > limit {
+			LogWarning("message header has %d bytes, limit is %d", m.headerSize, limit)
+			return respMessageHeaderTooBig, nil
+		}
+		if m.headerCount == nil {
+			m.headerCount = make(map[string]int)
+		}
+		canonicalName := textproto.CanonicalMIMEHeaderKey(headerData[0])
+		m.headerCount[canonicalName]++
+		if canonicalName == "Message-Id" && m.headerCount[canonicalName] == 1 {
+			m.messageID = RemoveAngle(strings.TrimSpace(headerData[1]))
 		}
 		// call and return milter handler
 		resp, err := m.backend.Header(headerData[0], headerData[1], newModifier(m, true))
@@ -253,12 +488,38 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		return m.backend.Headers(newModifier(m, true))
 
 	case wire.CodeBody:
+		m.bodySize += int64(len(msg.Data))
+		m.connectionBodySize += int64(len(msg.Data))
+		if limit := m.server.options.maxConnectionMessageBytes; limit > 0 && m.connectionBodySize > limit {
+			return RespTempFail, fmt.Errorf("%w: %d bytes received, limit is %d", ErrConnectionMessageBytesExceeded, m.connectionBodySize, limit)
+		}
+		if m.bodySkipped {
+			// the MTA does not support SMFIR_SKIP and still sends the rest of the body
+			return RespContinue, nil
+		}
 		resp, err := m.backend.BodyChunk(msg.Data, newModifier(m, true))
 		m.macros.DelStageAndAbove(StageEndMarker)
+		if err == nil && resp == RespSkip {
+			m.bodySkipped = true
+			if !m.protocolOption(OptSkip) {
+				// we cannot send SMFIR_SKIP to this MTA, so we swallow the remaining body chunks ourselves
+				return RespContinue, nil
+			}
+		}
 		return resp, err
 
 	case wire.CodeEOB:
-		return m.backend.EndOfMessage(newModifier(m, false))
+		start := time.Now()
+		mod := newModifier(m, false)
+		resp, err := m.backend.EndOfMessage(mod)
+		if name := m.server.options.timingHeader; name != "" && err == nil && resp != nil && (wire.ActionCode(resp.code) == wire.ActAccept || wire.ActionCode(resp.code) == wire.ActContinue) {
+			processingTime := m.processingTime + time.Since(start)
+			if hErr := mod.AddHeader(name, fmt.Sprintf("%.3fms", float64(processingTime.Microseconds())/1000)); hErr != nil {
+				LogWarning("could not add timing header %s: %v", name, hErr)
+			}
+		}
+		m.endTransaction()
+		return resp, err
 
 	case wire.CodeUnknown:
 		cmd := wire.ReadCString(msg.Data)
@@ -268,7 +529,7 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 
 	case wire.CodeMacro:
 		if len(msg.Data) == 0 {
-			return nil, fmt.Errorf("milter: macro: unexpected data size: %d", len(msg.Data))
+			return nil, errorf(ErrProtocol, "milter: macro: unexpected data size: %d", len(msg.Data))
 		}
 		code := wire.Code(msg.Data[0])
 		var stage MacroStage
@@ -309,13 +570,19 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		// abort current message and start over
 		err := m.backend.Abort(newModifier(m, true))
 		m.macros.DelStageAndAbove(StageHelo)
+		m.resetMessage()
+		m.endTransaction()
 		return nil, err
 
 	case wire.CodeQuitNewConn:
 		// abort current connection and start over
 		m.backend.Cleanup()
 		m.macros.DelStageAndAbove(StageConnect)
+		m.helo, m.heloAnnotation = "", ""
+		m.connectionBodySize = 0
 		m.backend = m.newBackend()
+		m.sessionCount++
+		m.emitConnectionEvent(ConnectionReused)
 		// do not send response
 		return nil, nil
 
@@ -325,15 +592,27 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		return nil, errCloseSession
 
 	default:
+		if resp := m.server.options.unknownCommandResp; resp != nil {
+			LogWarning("Unrecognized command code: %c, answering with %s", msg.Code, resp)
+			return resp, nil
+		}
 		// print error and close session
 		LogWarning("Unrecognized command code: %c", msg.Code)
 		return nil, errCloseSession
 	}
 }
 
-// HandleMilterCommands processes all milter commands in the same connection
-func (m *serverSession) HandleMilterCommands() {
+// HandleMilterCommands processes all milter commands in the same connection.
+// It returns the error that ended the session.
+func (m *serverSession) HandleMilterCommands() (err error) {
 	defer func() {
+		if err != nil && m.server.options.errorHandler != nil {
+			var session Session
+			if m.backend != nil {
+				session = m
+			}
+			m.server.options.errorHandler(err, session)
+		}
 		if m.backend != nil {
 			m.backend.Cleanup()
 		}
@@ -342,38 +621,81 @@ func (m *serverSession) HandleMilterCommands() {
 				LogWarning("Error closing connection: %v", err)
 			}
 		}
+		if m.done != nil {
+			close(m.done)
+		}
+		if m.server.options.onConnectionClose != nil {
+			m.server.options.onConnectionClose(m.conn, err)
+		}
+		m.emitConnectionEvent(ConnectionDisconnected)
+		m.stopEvents(err)
 	}()
 
+	m.connectedAt = time.Now()
+	m.sessionCount = 1
+	m.emitConnectionEvent(ConnectionConnected)
+	m.startEvents()
+	var localAddr net.Addr
+	if m.conn != nil {
+		localAddr = m.conn.LocalAddr()
+	}
+	m.emitEvent(ConnectionAccepted{EventBase: m.eventBase(), LocalAddr: localAddr})
+	if m.server.options.onConnectionOpen != nil {
+		m.server.options.onConnectionOpen(m.conn)
+	}
+	err = m.handleMilterCommands()
+	return err
+}
+
+// handleMilterCommands does the actual work of HandleMilterCommands.
+// It returns the error that ended the session or nil when the MTA closed the connection.
+func (m *serverSession) handleMilterCommands() error {
 	// first do the negotiation
 	msg, err := m.readPacket()
 	if err != nil {
-		if err != io.EOF {
+		if !errors.Is(err, io.EOF) {
 			LogWarning("Error reading milter command: %v", err)
+			return err
 		}
-		return
+		return nil
 	}
 	resp, err := m.negotiate(msg, m.server.options.maxVersion, m.server.options.actions, m.server.options.protocol, m.server.options.negotiationCallback, m.server.options.macrosByStage, 0)
 	if err != nil {
 		LogWarning("Error negotiating: %v", err)
-		return
+		return err
 	}
 	m.backend = m.newBackend()
 	if err = m.writePacket(resp.Response()); err != nil {
+		if isClosedConnError(err) {
+			LogDebug("MTA closed the connection during negotiation: %v", err)
+			return nil
+		}
 		LogWarning("Error writing packet: %v", err)
-		return
+		return err
 	}
 
 	// now we can process the events
 	for {
 		msg, err := m.readPacket()
 		if err != nil {
-			if err != io.EOF {
+			if !errors.Is(err, io.EOF) {
 				LogWarning("Error reading milter command: %v", err)
+				return err
 			}
-			return
+			return nil
 		}
 
+		start := time.Now()
 		resp, err := m.Process(msg)
+		m.processingTime += time.Since(start)
+		if stage := callbackForCode(msg.Code); stage != 0 && m.server.options.eventHook != nil {
+			m.emitEvent(StageCompleted{EventBase: m.eventBase(), Stage: stage, Duration: time.Since(start), Decision: resp, Err: err})
+		}
+		if m.writeErr != nil {
+			// a modification or progress packet of the backend could not be written
+			LogWarning("Error writing packet: %v", m.writeErr)
+			return m.writeErr
+		}
 		if err != nil {
 			if err != errCloseSession {
 				// log error condition
@@ -381,28 +703,123 @@ func (m *serverSession) HandleMilterCommands() {
 				if resp != nil && !m.skipResponse(msg.Code) {
 					_ = m.writePacket(resp.Response())
 				}
+				return err
 			}
-			return
+			return nil
 		}
 
 		// ignore empty responses or responses we indicated to not send
 		if resp == nil || m.skipResponse(msg.Code) {
+			if m.transactionLimitReached() {
+				return nil
+			}
 			continue
 		}
 
 		// send back response message
 		if err = m.writePacket(resp.Response()); err != nil {
+			if isClosedConnError(err) {
+				// the MTA went away, this is no error on our side
+				LogDebug("MTA closed the connection before we could send the response: %v", err)
+				_ = m.backend.Abort(newModifier(m, true))
+				return nil
+			}
 			LogWarning("Error writing packet: %v", err)
-			return
+			return err
 		}
 
-		if !resp.Continue() {
+		if !resp.Continue() && !rejectsOnlyRecipient(msg.Code, resp) {
 			m.backend.Cleanup()
 			// prepare backend for next message
 			m.backend = m.newBackend()
 			m.macros.DelStageAndAbove(StageMail)
+			m.resetMessage()
 		}
+
+		if m.transactionLimitReached() {
+			return nil
+		}
+	}
+}
+
+// endTransaction counts the end of the current SMTP transaction.
+// The MTA might send an Abort after EOM, this only counts once.
+func (m *serverSession) endTransaction() {
+	if m.inTransaction {
+		m.inTransaction = false
+		m.transactions++
+	}
+}
+
+// transactionLimitReached returns true when the connection handled the maximum number of transactions
+// of WithMaxTransactionsPerConnection and should get closed.
+func (m *serverSession) transactionLimitReached() bool {
+	limit := m.server.options.maxTransactions
+	if limit > 0 && m.transactions >= limit {
+		LogDebug("closing connection after %d transactions", m.transactions)
+		return true
+	}
+	return false
+}
+
+// isClosedConnError returns true when err indicates that the other side closed the connection.
+func isClosedConnError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// rejectsOnlyRecipient returns true when resp is the rejection of a single recipient.
+// This does not end the SMTP transaction, so the backend and the message state need to be kept.
+func rejectsOnlyRecipient(code wire.Code, resp *Response) bool {
+	if code != wire.CodeRcpt {
+		return false
+	}
+	switch wire.ActionCode(resp.code) {
+	case wire.ActReject, wire.ActTempFail, wire.ActReplyCode:
+		return true
+	default:
+		return false
+	}
+}
+
+// commandVersion returns the protocol version that introduced the command code.
+// Version 3 added SMFIC_UNKNOWN (see SMFIP_NOUNKNOWN), version 4 SMFIC_DATA (see SMFIP_NODATA)
+// and version 6 SMFIC_QUIT_NC. All other commands are part of version 2.
+func commandVersion(code wire.Code) uint32 {
+	switch code {
+	case wire.CodeUnknown:
+		return 3
+	case wire.CodeData:
+		return 4
+	case wire.CodeQuitNewConn:
+		return 6
+	default:
+		return 2
+	}
+}
+
+// resetMessage resets all per-message state of this session.
+func (m *serverSession) resetMessage() {
+	m.sender = ""
+	m.headerSize = 0
+	m.bodySize = 0
+	m.headerCount = nil
+	m.messageID = ""
+	m.rcptTos = nil
+	m.rcptTosIncomplete = false
+	m.bodySkipped = false
+	m.processingTime = 0
+}
+
+// headerFieldSize returns the size of the header field name: value in the wire format of an SMTP message
+// (including the trailing CR LF).
+func headerFieldSize(name, value string, leadingSpace bool) int64 {
+	size := int64(len(name) + 1 + len(value) + 2)
+	// without OptHeaderLeadingSpace the MTA swallows the first space of the value
+	if !leadingSpace && (len(value) == 0 || value[0] != '\t') {
+		size++
 	}
+	return size
 }
 
 // protocolOption checks whether the option is set in negotiated options, that
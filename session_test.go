@@ -3,8 +3,11 @@ package milter
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"net"
 	"net/textproto"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/d--j/go-milter/internal/wire"
@@ -549,3 +552,209 @@ func Test_milterSession_Process(t *testing.T) {
 		})
 	}
 }
+
+func Test_milterSession_Process_commandVersion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		version uint32
+		msg     *wire.Message
+		wantErr bool
+	}{
+		{"data v2", 2, &wire.Message{Code: wire.CodeData}, true},
+		{"data v4", 4, &wire.Message{Code: wire.CodeData}, false},
+		{"unknown v2", 2, &wire.Message{Code: wire.CodeUnknown, Data: []byte("CMD\x00")}, true},
+		{"unknown v3", 3, &wire.Message{Code: wire.CodeUnknown, Data: []byte("CMD\x00")}, false},
+		{"quit nc v4", 4, &wire.Message{Code: wire.CodeQuitNewConn}, true},
+		{"quit nc v6", 6, &wire.Message{Code: wire.CodeQuitNewConn}, false},
+		{"helo v2", 2, &wire.Message{Code: wire.CodeHelo, Data: []byte("localhost\x00")}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			backend := &processTestMilter{}
+			m := &serverSession{
+				server:  NewServer(WithMilter(func() Milter { return backend })),
+				version: tt.version,
+				macros:  newMacroStages(),
+				backend: backend,
+			}
+			_, err := m.Process(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrProtocol) {
+					t.Errorf("Process() error = %v, want ErrProtocol", err)
+				}
+				if want := fmt.Sprintf("%q (0x%02x) is not valid in the negotiated protocol version %d", byte(tt.msg.Code), byte(tt.msg.Code), tt.version); !strings.Contains(err.Error(), want) {
+					t.Errorf("Process() error = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_milterSession_Process_unknownCommand(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    *wire.Message
+		wantErr bool
+	}{
+		{"close", nil, nil, true},
+		{"accept", []Option{WithAcceptUnknownCommands(nil)}, &wire.Message{wire.Code(wire.ActAccept), nil}, false},
+		{"custom", []Option{WithAcceptUnknownCommands(RespTempFail)}, &wire.Message{wire.Code(wire.ActTempFail), nil}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			backend := &processTestMilter{}
+			m := &serverSession{
+				server:  NewServer(append([]Option{WithMilter(func() Milter { return backend })}, tt.opts...)...),
+				version: MaxServerProtocolVersion,
+				macros:  newMacroStages(),
+				backend: backend,
+			}
+			gotR, err := m.Process(&wire.Message{Code: 'z', Data: []byte("new")})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got *wire.Message
+			if gotR != nil {
+				got = gotR.Response()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewClient() with WithAcceptUnknownCommands did not panic")
+		}
+	}()
+	NewClient("tcp", "127.0.0.1:0", WithAcceptUnknownCommands(nil))
+}
+
+// rcptRejectMilter rejects the first recipient of every message with reject and accepts all others.
+type rcptRejectMilter struct {
+	NoOpMilter
+	reject     *Response
+	rcpts      []string
+	eomRcpts   []string
+	eomCalled  bool
+	cleanedUp  bool
+	deleteErrs []error
+}
+
+func (r *rcptRejectMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	r.rcpts = append(r.rcpts, rcptTo)
+	if len(r.rcpts) == 1 {
+		return r.reject, nil
+	}
+	return RespContinue, nil
+}
+
+func (r *rcptRejectMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	r.eomCalled = true
+	r.eomRcpts = append([]string(nil), r.rcpts...)
+	r.deleteErrs = []error{m.DeleteRecipient("rejected@example.com"), m.DeleteRecipient("accepted@example.com")}
+	return RespAccept, nil
+}
+
+func (r *rcptRejectMilter) Cleanup() {
+	r.cleanedUp = true
+}
+
+type pipeDialer struct {
+	conn net.Conn
+}
+
+func (p pipeDialer) Dial(_, _ string) (net.Conn, error) {
+	return p.conn, nil
+}
+
+func Test_milterSession_HandleMilterCommands_rejectedRecipient(t *testing.T) {
+	t.Parallel()
+	replyCode, err := RejectWithCodeAndReason(550, "5.1.1 No such user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		reject *Response
+		want   ActionType
+	}{
+		{"reject", RespReject, ActionReject},
+		{"tempfail", RespTempFail, ActionTempFail},
+		{"reply code", replyCode, ActionRejectWithCode},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var backends []*rcptRejectMilter
+			s := NewServer(WithAction(OptRemoveRcpt), WithMilter(func() Milter {
+				b := &rcptRejectMilter{reject: tt.reject}
+				backends = append(backends, b)
+				return b
+			}))
+			serverConn, clientConn := net.Pipe()
+			handled := make(chan error, 1)
+			go func() {
+				handled <- s.newSession(serverConn).HandleMilterCommands()
+			}()
+			session, err := NewClient("tcp", "pipe", WithDialer(pipeDialer{clientConn}), WithAction(OptRemoveRcpt)).Session(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			act, err := session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = session.Helo("localhost")
+			assertAction(t, act, err, ActionContinue)
+			act, err = session.Mail("from@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = session.Rcpt("rejected@example.com", "")
+			assertAction(t, act, err, tt.want)
+			act, err = session.Rcpt("accepted@example.com", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = session.DataStart()
+			assertAction(t, act, err, ActionContinue)
+			act, err = session.HeaderEnd()
+			assertAction(t, act, err, ActionContinue)
+			modifyActs, act, err := session.BodyReadFrom(strings.NewReader("test\r\n"))
+			assertAction(t, act, err, ActionAccept)
+			if err := session.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-handled; err != nil {
+				t.Fatalf("HandleMilterCommands() error = %v", err)
+			}
+
+			// one backend for the whole message and a fresh one for the next message after the EOM
+			if len(backends) != 2 {
+				t.Fatalf("got %d backends, want 2", len(backends))
+			}
+			b := backends[0]
+			if !b.eomCalled {
+				t.Fatal("EndOfMessage() did not get called")
+			}
+			if want := []string{"rejected@example.com", "accepted@example.com"}; !reflect.DeepEqual(b.eomRcpts, want) {
+				t.Errorf("backend saw recipients %v, want %v", b.eomRcpts, want)
+			}
+			if !errors.Is(b.deleteErrs[0], ErrRecipientNotFound) || b.deleteErrs[1] != nil {
+				t.Errorf("DeleteRecipient() errors = %v, want [ErrRecipientNotFound <nil>]", b.deleteErrs)
+			}
+			if want := []ModifyAction{{Type: ActionDelRcpt, Rcpt: "<accepted@example.com>"}}; !reflect.DeepEqual(modifyActs, want) {
+				t.Errorf("got modification actions %+v, want %+v", modifyActs, want)
+			}
+			if !b.cleanedUp {
+				t.Error("Cleanup() did not get called after the EOM")
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package milter
+
+// setSocketBuffers is a no-op on systems where we cannot change the socket buffer sizes.
+func setSocketBuffers(_ uintptr, _, _ int) error {
+	return nil
+}
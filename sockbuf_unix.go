@@ -0,0 +1,20 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package milter
+
+import "syscall"
+
+// setSocketBuffers sets SO_RCVBUF and SO_SNDBUF of the socket fd. A size of 0 keeps the default of the OS.
+func setSocketBuffers(fd uintptr, readSize, writeSize int) error {
+	if readSize > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, readSize); err != nil {
+			return err
+		}
+	}
+	if writeSize > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, writeSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
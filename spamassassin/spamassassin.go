@@ -0,0 +1,263 @@
+// Package spamassassin has a [milter.Middleware] that checks messages with a SpamAssassin spamd daemon.
+package spamassassin
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/milterutil"
+	"golang.org/x/text/transform"
+)
+
+// Middleware is a [milter.Middleware] that sends every message to a SpamAssassin spamd daemon (with the SPAMC protocol)
+// and adds the X-Spam-Status and X-Spam-Score header fields with the result of spamd.
+// Messages with a score above the threshold get rejected. Use [NewMiddleware] to create it.
+//
+// The middleware collects the header and the body of the message in memory and checks the message in the EndOfMessage callback,
+// before it calls the EndOfMessage callback of the wrapped [milter.Milter]. You need to negotiate [milter.OptAddHeader]
+// and must not negotiate [milter.OptNoHeaders] or [milter.OptNoBody].
+// When the wrapped [milter.Milter] responds with [milter.RespSkip] to a header field or a body chunk,
+// the middleware still collects the rest of the message but does not pass it to the wrapped [milter.Milter].
+//
+// When spamd cannot be reached or returns an error the message does not get checked:
+// the error gets logged with [milter.LogWarning] and the wrapped [milter.Milter] decides about the message.
+type Middleware struct {
+	socketPath string
+	threshold  float64
+	// Timeout is the timeout of one spamd request. It defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// NewMiddleware creates a new [Middleware] that connects to the spamd daemon at the Unix socket socketPath.
+// Messages with a spamd score greater than threshold get rejected.
+//
+// NewMiddleware sends a PING to spamd and returns an error if spamd does not respond.
+func NewMiddleware(socketPath string, threshold float64) (milter.Middleware, error) {
+	mw := &Middleware{socketPath: socketPath, threshold: threshold, Timeout: 30 * time.Second}
+	if err := mw.ping(); err != nil {
+		return nil, err
+	}
+	return mw, nil
+}
+
+// Wrap wraps next so that every message gets checked by spamd.
+func (s *Middleware) Wrap(next milter.Milter) milter.Milter {
+	return &spamMilter{Milter: next, mw: s}
+}
+
+// Result is the result of a spamd check.
+type Result struct {
+	// Spam is true when spamd considers the message spam.
+	Spam bool
+	// Score is the spam score of the message.
+	Score float64
+	// Required is the score that spamd is configured to consider as spam.
+	Required float64
+	// Symbols are the names of the SpamAssassin tests that matched.
+	Symbols []string
+}
+
+// Status returns the value of the X-Spam-Status header field for r (e.g. "Yes, score=7.1 required=5.0 tests=BAYES_99,URIBL_BLACK").
+func (r *Result) Status() string {
+	yes := "No"
+	if r.Spam {
+		yes = "Yes"
+	}
+	status := fmt.Sprintf("%s, score=%.1f required=%.1f", yes, r.Score, r.Required)
+	if len(r.Symbols) > 0 {
+		status += " tests=" + strings.Join(r.Symbols, ",")
+	}
+	return status
+}
+
+// dial connects to spamd, sends a request with command and body and returns the response headers and the response body.
+func (s *Middleware) dial(command string, body []byte) (textproto.MIMEHeader, []byte, error) {
+	conn, err := net.DialTimeout("unix", s.socketPath, s.Timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spamassassin: connect: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(s.Timeout))
+	var req bytes.Buffer
+	req.WriteString(command + " SPAMC/1.5\r\n")
+	if body != nil {
+		req.WriteString("Content-length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+	req.WriteString("\r\n")
+	req.Write(body)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, nil, fmt.Errorf("spamassassin: write request: %w", err)
+	}
+	if c, ok := conn.(*net.UnixConn); ok {
+		// spamd reads until EOF when there is no Content-length
+		_ = c.CloseWrite()
+	}
+	r := textproto.NewReader(bufio.NewReader(conn))
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, nil, fmt.Errorf("spamassassin: read response: %w", err)
+	}
+	// SPAMD/1.1 0 EX_OK
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "SPAMD/") {
+		return nil, nil, fmt.Errorf("spamassassin: malformed response %q", line)
+	}
+	if fields[1] != "0" {
+		return nil, nil, fmt.Errorf("spamassassin: spamd error %q", line)
+	}
+	if command == "PING" {
+		// SPAMD/1.5 0 PONG does not have a header
+		return nil, nil, nil
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("spamassassin: read response header: %w", err)
+	}
+	respBody, err := io.ReadAll(r.R)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spamassassin: read response body: %w", err)
+	}
+	return header, respBody, nil
+}
+
+func (s *Middleware) ping() error {
+	_, _, err := s.dial("PING", nil)
+	return err
+}
+
+// Check sends message (header and body in SMTP wire format) to spamd and returns its verdict.
+func (s *Middleware) Check(message []byte) (*Result, error) {
+	header, body, err := s.dial("SYMBOLS", message)
+	if err != nil {
+		return nil, err
+	}
+	// Spam: True ; 7.1 / 5.0
+	spam := header.Get("Spam")
+	verdict, scores, ok := strings.Cut(spam, ";")
+	if !ok {
+		return nil, fmt.Errorf("spamassassin: malformed Spam header %q", spam)
+	}
+	score, required, ok := strings.Cut(scores, "/")
+	if !ok {
+		return nil, fmt.Errorf("spamassassin: malformed Spam header %q", spam)
+	}
+	result := &Result{}
+	switch strings.ToLower(strings.TrimSpace(verdict)) {
+	case "true", "yes":
+		result.Spam = true
+	}
+	if result.Score, err = strconv.ParseFloat(strings.TrimSpace(score), 64); err != nil {
+		return nil, fmt.Errorf("spamassassin: malformed score in Spam header %q", spam)
+	}
+	if result.Required, err = strconv.ParseFloat(strings.TrimSpace(required), 64); err != nil {
+		return nil, fmt.Errorf("spamassassin: malformed required score in Spam header %q", spam)
+	}
+	for _, symbol := range strings.Split(string(body), ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			result.Symbols = append(result.Symbols, symbol)
+		}
+	}
+	return result, nil
+}
+
+type spamMilter struct {
+	milter.Milter
+	mw          *Middleware
+	message     bytes.Buffer
+	headerSkip  bool
+	bodySkip    bool
+	headersDone bool
+}
+
+func (s *spamMilter) reset() {
+	s.message.Reset()
+	s.headerSkip = false
+	s.bodySkip = false
+	s.headersDone = false
+}
+
+func (s *spamMilter) MailFrom(from string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+	s.reset()
+	return s.Milter.MailFrom(from, esmtpArgs, m)
+}
+
+func (s *spamMilter) Header(name string, value string, m *milter.Modifier) (*milter.Response, error) {
+	s.message.WriteString(name)
+	s.message.WriteByte(':')
+	if n := m.Negotiation(); (n == nil || n.Protocol&milter.OptHeaderLeadingSpace == 0) && value != "" {
+		// the MTA removed the space after the colon
+		s.message.WriteByte(' ')
+	}
+	canonical, _, err := transform.String(&milterutil.CrLfCanonicalizationTransformer{}, value)
+	if err != nil {
+		canonical = value
+	}
+	s.message.WriteString(canonical)
+	s.message.WriteString("\r\n")
+	if s.headerSkip {
+		return milter.RespContinue, nil
+	}
+	resp, err := s.Milter.Header(name, value, m)
+	if err == nil && resp == milter.RespSkip {
+		s.headerSkip = true
+		return milter.RespContinue, nil
+	}
+	return resp, err
+}
+
+func (s *spamMilter) Headers(m *milter.Modifier) (*milter.Response, error) {
+	s.message.WriteString("\r\n")
+	s.headersDone = true
+	return s.Milter.Headers(m)
+}
+
+func (s *spamMilter) BodyChunk(chunk []byte, m *milter.Modifier) (*milter.Response, error) {
+	s.message.Write(chunk)
+	if s.bodySkip {
+		return milter.RespContinue, nil
+	}
+	resp, err := s.Milter.BodyChunk(chunk, m)
+	if err == nil && resp == milter.RespSkip {
+		s.bodySkip = true
+		return milter.RespContinue, nil
+	}
+	return resp, err
+}
+
+func (s *spamMilter) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
+	defer s.reset()
+	if !s.headersDone {
+		// the MTA did not send the end of the header (OptNoEOH)
+		s.message.WriteString("\r\n")
+	}
+	result, err := s.mw.Check(s.message.Bytes())
+	if err != nil {
+		milter.LogWarning("could not check message with spamd: %v", err)
+		return s.Milter.EndOfMessage(m)
+	}
+	if result.Score > s.mw.threshold {
+		return milter.RejectWithCodeAndReason(550, fmt.Sprintf("5.7.1 Message rejected as spam (score %.1f)", result.Score))
+	}
+	if err := m.AddHeader("X-Spam-Status", result.Status()); err != nil {
+		return nil, err
+	}
+	if err := m.AddHeader("X-Spam-Score", strconv.FormatFloat(result.Score, 'f', 1, 64)); err != nil {
+		return nil, err
+	}
+	return s.Milter.EndOfMessage(m)
+}
+
+func (s *spamMilter) Abort(m *milter.Modifier) error {
+	s.reset()
+	return s.Milter.Abort(m)
+}
+
+var _ milter.Middleware = (*Middleware)(nil)
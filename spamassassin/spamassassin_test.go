@@ -0,0 +1,195 @@
+package spamassassin
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// fakeSpamd is a spamd that gives messages containing "viagra" the score 12.5 and all others 0.3
+type fakeSpamd struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	messages []string
+}
+
+func newFakeSpamd(t *testing.T) *fakeSpamd {
+	t.Helper()
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "spamd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSpamd{ln: ln}
+	go f.serve()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	return f
+}
+
+func (f *fakeSpamd) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeSpamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := textproto.NewReader(bufio.NewReader(conn))
+	line, err := r.ReadLine()
+	if err != nil {
+		return
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return
+	}
+	switch line {
+	case "PING SPAMC/1.5":
+		_, _ = conn.Write([]byte("SPAMD/1.5 0 PONG\r\n"))
+	case "SYMBOLS SPAMC/1.5":
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.messages = append(f.messages, string(body))
+		f.mu.Unlock()
+		spam, score, symbols := "False", "0.3", "MISSING_DATE"
+		if bytes.Contains(body, []byte("viagra")) {
+			spam, score, symbols = "True", "12.5", "BAYES_99,DRUGS_ERECTILE"
+		}
+		_, _ = conn.Write([]byte("SPAMD/1.1 0 EX_OK\r\nContent-length: " + strconv.Itoa(len(symbols)) + "\r\nSpam: " + spam + " ; " + score + " / 5.0\r\n\r\n" + symbols))
+	default:
+		_, _ = conn.Write([]byte("SPAMD/1.0 76 Bad header line\r\n"))
+	}
+}
+
+type recordingMilter struct {
+	milter.NoOpMilter
+	eomCalled bool
+	chunks    int
+}
+
+func (r *recordingMilter) BodyChunk(chunk []byte, m *milter.Modifier) (*milter.Response, error) {
+	r.chunks++
+	return milter.RespSkip, nil
+}
+
+func (r *recordingMilter) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
+	r.eomCalled = true
+	return milter.RespAccept, nil
+}
+
+func sendMessage(t *testing.T, m milter.Milter, body string) ([]*wire.Message, *milter.Response) {
+	t.Helper()
+	var packets []*wire.Message
+	mod := milter.NewTestModifier(milter.NewMacroBag(), func(msg *wire.Message) error {
+		packets = append(packets, msg)
+		return nil
+	}, func(msg *wire.Message) error {
+		return nil
+	}, milter.OptAddHeader, milter.DataSize64K)
+	if _, err := m.MailFrom("from@example.com", "", mod); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Header("Subject", "test\nfolded", mod); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Headers(mod); err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range strings.SplitAfter(body, "\n") {
+		if _, err := m.BodyChunk([]byte(chunk), mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := m.EndOfMessage(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packets, resp
+}
+
+func TestMiddleware(t *testing.T) {
+	spamd := newFakeSpamd(t)
+	mw, err := NewMiddleware(spamd.ln.Addr().String(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := &recordingMilter{}
+	packets, resp := sendMessage(t, mw.Wrap(next), "hello\r\nworld\r\n")
+	if resp != milter.RespAccept || !next.eomCalled {
+		t.Fatalf("got %v (EndOfMessage called: %v), want accept of the wrapped milter", resp, next.eomCalled)
+	}
+	if next.chunks != 1 {
+		t.Errorf("wrapped milter got %d body chunks after RespSkip, want 1", next.chunks)
+	}
+	spamd.mu.Lock()
+	if spamd.messages[0] != "Subject: test\r\nfolded\r\n\r\nhello\r\nworld\r\n" {
+		t.Errorf("spamd got message %q", spamd.messages[0])
+	}
+	spamd.mu.Unlock()
+	if len(packets) != 2 {
+		t.Fatalf("got %d modifications, want 2", len(packets))
+	}
+	if got := string(packets[0].Data); got != "X-Spam-Status\x00No, score=0.3 required=5.0 tests=MISSING_DATE\x00" {
+		t.Errorf("got X-Spam-Status modification %q", got)
+	}
+	if got := string(packets[1].Data); got != "X-Spam-Score\x000.3\x00" {
+		t.Errorf("got X-Spam-Score modification %q", got)
+	}
+
+	next = &recordingMilter{}
+	packets, resp = sendMessage(t, mw.Wrap(next), "buy viagra\r\n")
+	if next.eomCalled || len(packets) != 0 {
+		t.Errorf("spam message got passed to the wrapped milter or got modifications %v", packets)
+	}
+	if !strings.Contains(resp.String(), "code=550") {
+		t.Errorf("got %s, want a 550 rejection", resp)
+	}
+}
+
+func TestMiddleware_SpamdDown(t *testing.T) {
+	spamd := newFakeSpamd(t)
+	if _, err := NewMiddleware(filepath.Join(t.TempDir(), "missing.sock"), 5); err == nil {
+		t.Fatal("NewMiddleware() with missing socket did not return an error")
+	}
+	mw, err := NewMiddleware(spamd.ln.Addr().String(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = spamd.ln.Close()
+	next := &recordingMilter{}
+	packets, resp := sendMessage(t, mw.Wrap(next), "buy viagra\r\n")
+	if resp != milter.RespAccept || !next.eomCalled || len(packets) != 0 {
+		t.Errorf("got %v and %d modifications, want the unmodified decision of the wrapped milter", resp, len(packets))
+	}
+}
+
+func TestResult_Status(t *testing.T) {
+	r := Result{Spam: true, Score: 7.12, Required: 5, Symbols: []string{"A", "B"}}
+	if got := r.Status(); got != "Yes, score=7.1 required=5.0 tests=A,B" {
+		t.Errorf("Status() = %q", got)
+	}
+	r = Result{Score: -1}
+	if got := r.Status(); got != "No, score=-1.0 required=0.0" {
+		t.Errorf("Status() = %q", got)
+	}
+}
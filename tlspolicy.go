@@ -0,0 +1,132 @@
+package milter
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// versionSSL30 is the deprecated [tls.VersionSSL30]
+const versionSSL30 = 0x0300
+
+// TLSVersionPolicy is a [Middleware] that rejects SMTP transactions whose connection uses a TLS version below a minimum.
+// Use [NewTLSVersionPolicy] to create it.
+//
+// The check happens at MAIL FROM with the value of the [MacroTlsVersion] macro (e.g. "TLSv1.2").
+// When the transaction gets rejected the wrapped [Milter] does not get the MailFrom call.
+// When the macro is empty the client did not use TLS: by default plaintext connections are allowed,
+// use [WithPlaintextResponse] to reject them, too. Values that [ParseTLSVersion] does not understand count as too old.
+//
+// You need to request [MacroTlsVersion] at [StageHelo] or [StageMail] with [WithMacroRequest] for this middleware to work.
+// Sendmail and Postfix send it at [StageHelo] by default.
+type TLSVersionPolicy struct {
+	minVersion uint16
+	reject     *Response
+	plaintext  *Response
+}
+
+// TLSVersionOption configures a [TLSVersionPolicy].
+type TLSVersionOption func(p *TLSVersionPolicy)
+
+// WithTLSRejectResponse sets the response for transactions that use a TLS version below the minimum.
+// The default is a 550 5.7.10 rejection.
+func WithTLSRejectResponse(resp *Response) TLSVersionOption {
+	return func(p *TLSVersionPolicy) {
+		if resp != nil {
+			p.reject = resp
+		}
+	}
+}
+
+// WithPlaintextResponse sets the response for transactions that do not use TLS at all.
+// nil (the default) allows plaintext transactions.
+func WithPlaintextResponse(resp *Response) TLSVersionOption {
+	return func(p *TLSVersionPolicy) {
+		p.plaintext = resp
+	}
+}
+
+// NewTLSVersionPolicy creates a new [TLSVersionPolicy] that rejects TLS versions below minVersion
+// (one of the version constants of [crypto/tls], e.g. [tls.VersionTLS12]).
+func NewTLSVersionPolicy(minVersion uint16, opts ...TLSVersionOption) *TLSVersionPolicy {
+	reject, _ := RejectWithCodeAndReason(550, fmt.Sprintf("5.7.10 %s or higher required", tlsVersionName(minVersion)))
+	p := &TLSVersionPolicy{minVersion: minVersion, reject: reject}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+// Wrap wraps next so that the TLS version gets checked before next gets the MailFrom call.
+func (p *TLSVersionPolicy) Wrap(next Milter) Milter {
+	return &tlsVersionMilter{Milter: next, policy: p}
+}
+
+// Check returns the response for a transaction with the [MacroTlsVersion] value tlsVersion
+// or nil when the transaction is allowed.
+func (p *TLSVersionPolicy) Check(tlsVersion string) *Response {
+	if strings.TrimSpace(tlsVersion) == "" {
+		return p.plaintext
+	}
+	if version, ok := ParseTLSVersion(tlsVersion); !ok || version < p.minVersion {
+		return p.reject
+	}
+	return nil
+}
+
+// ParseTLSVersion parses the value of the [MacroTlsVersion] macro (e.g. "TLSv1.2", "TLSv1" or "SSLv3")
+// and returns the matching version constant of [crypto/tls]. ok is false when s is not a known TLS version.
+func ParseTLSVersion(s string) (version uint16, ok bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	// OpenSSL reports TLS 1.0 sometimes as "TLSv1/SSLv3"
+	if before, _, found := strings.Cut(s, "/"); found {
+		s = before
+	}
+	switch s {
+	case "SSLV3", "SSLV3.0":
+		return versionSSL30, true
+	case "TLSV1", "TLSV1.0":
+		return tls.VersionTLS10, true
+	case "TLSV1.1":
+		return tls.VersionTLS11, true
+	case "TLSV1.2":
+		return tls.VersionTLS12, true
+	case "TLSV1.3":
+		return tls.VersionTLS13, true
+	default:
+		return 0, false
+	}
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case versionSSL30:
+		return "SSL 3.0"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("TLS version 0x%04x", version)
+	}
+}
+
+type tlsVersionMilter struct {
+	Milter
+	policy *TLSVersionPolicy
+}
+
+func (t *tlsVersionMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if m != nil {
+		if resp := t.policy.Check(m.Macros.Get(MacroTlsVersion)); resp != nil {
+			return resp, nil
+		}
+	}
+	return t.Milter.MailFrom(from, esmtpArgs, m)
+}
+
+var _ Middleware = (*TLSVersionPolicy)(nil)
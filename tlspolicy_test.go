@@ -0,0 +1,87 @@
+package milter
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint16
+		ok   bool
+	}{
+		{"TLSv1.3", tls.VersionTLS13, true},
+		{"TLSv1.2", tls.VersionTLS12, true},
+		{"tlsv1.1", tls.VersionTLS11, true},
+		{"TLSv1", tls.VersionTLS10, true},
+		{"TLSv1.0", tls.VersionTLS10, true},
+		{"TLSv1/SSLv3", tls.VersionTLS10, true},
+		{"SSLv3", versionSSL30, true},
+		{" TLSv1.2 ", tls.VersionTLS12, true},
+		{"", 0, false},
+		{"TLSv2", 0, false},
+		{"QUIC", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseTLSVersion(tt.s)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseTLSVersion(%q) = %04x, %v, want %04x, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTLSVersionPolicy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		opts       []TLSVersionOption
+		tlsVersion string
+		want       ActionType
+	}{
+		{"TLS 1.3", nil, "TLSv1.3", ActionContinue},
+		{"TLS 1.2", nil, "TLSv1.2", ActionContinue},
+		{"TLS 1.1", nil, "TLSv1.1", ActionRejectWithCode},
+		{"TLS 1.0", nil, "TLSv1", ActionRejectWithCode},
+		{"unknown version", nil, "TLSv9", ActionRejectWithCode},
+		{"TLS 1.0 custom response", []TLSVersionOption{WithTLSRejectResponse(RespTempFail)}, "TLSv1", ActionTempFail},
+		{"plaintext", nil, "", ActionContinue},
+		{"plaintext rejected", []TLSVersionOption{WithPlaintextResponse(RespReject)}, "", ActionReject},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			macros := NewMacroBag()
+			if tt.tlsVersion != "" {
+				macros.Set(MacroTlsVersion, tt.tlsVersion)
+			}
+			policy := NewTLSVersionPolicy(tls.VersionTLS12, tt.opts...)
+			mailCalled := false
+			mm := MockMilter{
+				ConnResp: RespContinue,
+				HeloResp: RespContinue,
+				MailResp: RespContinue,
+				MailMod: func(m *Modifier) {
+					mailCalled = true
+				},
+			}
+			w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+				return policy.Wrap(&mm)
+			})}, []Option{WithMacroRequest(StageHelo, []MacroName{MacroTlsVersion})})
+			defer w.Cleanup()
+			act, err := w.session.Conn("localhost", FamilyInet, 2525, "127.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("localhost")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail("from@example.com", "")
+			assertAction(t, act, err, tt.want)
+			if act.Type == ActionRejectWithCode && act.SMTPReply != "550 5.7.10 TLS 1.2 or higher required" {
+				t.Errorf("SMTPReply = %q", act.SMTPReply)
+			}
+			if mailCalled != (tt.want == ActionContinue) {
+				t.Errorf("wrapped MailFrom called = %v", mailCalled)
+			}
+		})
+	}
+}